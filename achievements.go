@@ -10,7 +10,20 @@ import (
 func DownloadAchievements(hashJson *ankabuffer.Manifest, bin int, version int, dir string, indent string, headless bool) error {
 	outPath := dir
 
-	if version == 3 {
+	switch version {
+	case 2:
+		fileNames := []HashFile{
+			{Filename: "data/common/AchievementCategories.d2o", FriendlyName: "achievement_categories.d2o"},
+			{Filename: "data/common/AchievementObjectives.d2o", FriendlyName: "achievement_objectives.d2o"},
+			{Filename: "data/common/AchievementRewards.d2o", FriendlyName: "achievement_rewards.d2o"},
+			{Filename: "data/common/Achievements.d2o", FriendlyName: "achievements.d2o"},
+			{Filename: "data/common/AchievementProgressSteps.d2o", FriendlyName: "achievement_progress_steps.d2o"},
+			{Filename: "data/common/AchievementProgress.d2o", FriendlyName: "achievement_progress.d2o"},
+		}
+
+		err := DownloadUnpackFiles("Achievements", bin, hashJson, "main", fileNames, dir, outPath, true, indent, headless, false)
+		return err
+	case 3:
 		fileNames := []HashFile{
 			{Filename: "Dofus_Data/StreamingAssets/Content/Data/data_assets_achievementcategoriesdataroot.asset.bundle", FriendlyName: "achievement_categories.asset.bundle"},
 			{Filename: "Dofus_Data/StreamingAssets/Content/Data/data_assets_achievementobjectivesdataroot.asset.bundle", FriendlyName: "achievement_objectives.asset.bundle"},
@@ -22,7 +35,7 @@ func DownloadAchievements(hashJson *ankabuffer.Manifest, bin int, version int, d
 
 		err := DownloadUnpackFiles("Achievements", bin, hashJson, "data", fileNames, dir, outPath, true, indent, headless, false)
 		return err
-	} else {
+	default:
 		return errors.New("unsupported version: " + strconv.Itoa(version))
 	}
 }
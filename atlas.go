@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"charm.land/log/v2"
+)
+
+// atlasOptions configure writeAtlas. Name prefixes the sheets, the index and
+// the CSS classes.
+type atlasOptions struct {
+	Name        string
+	MaxSize     int
+	Padding     int
+	CSS         bool
+	Indentation string
+}
+
+// atlasIndex is the <name>.json that writeAtlas writes next to the sheets.
+type atlasIndex struct {
+	Sheets []atlasSheet           `json:"sheets"`
+	Images map[string]atlasSprite `json:"images"`
+}
+
+type atlasSheet struct {
+	File   string `json:"file"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type atlasSprite struct {
+	Sheet  string `json:"sheet"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"w"`
+	Height int    `json:"h"`
+}
+
+type atlasImage struct {
+	id  string
+	img image.Image
+}
+
+// writeAtlas packs the PNG and WebP files of inputDir into sheets of at most
+// MaxSize x MaxSize pixels and writes them to outputDir as <name>-<n> in the
+// image output format, together with <name>.json and, with CSS, <name>.css.
+// Images are keyed by their name without resolution suffix, like the cleaned
+// category folders name them.
+func writeAtlas(inputDir string, outputDir string, opts atlasOptions) (*atlasIndex, error) {
+	if opts.MaxSize < 1 {
+		return nil, fmt.Errorf("invalid maximum atlas size %d", opts.MaxSize)
+	}
+	if opts.Padding < 0 {
+		return nil, fmt.Errorf("invalid atlas padding %d", opts.Padding)
+	}
+
+	images, err := readAtlasImages(inputDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no png or webp images in %s", inputDir)
+	}
+
+	// Tall images first keeps the skyline flat.
+	sort.SliceStable(images, func(i, j int) bool {
+		bi, bj := images[i].img.Bounds(), images[j].img.Bounds()
+		if bi.Dy() != bj.Dy() {
+			return bi.Dy() > bj.Dy()
+		}
+		return bi.Dx() > bj.Dx()
+	})
+
+	index := &atlasIndex{Images: make(map[string]atlasSprite)}
+	var packers []*skylinePacker
+	var placed [][]int
+	positions := make([]image.Point, len(images))
+	for i, entry := range images {
+		size := entry.img.Bounds().Size()
+		if size.X > opts.MaxSize || size.Y > opts.MaxSize {
+			log.Warnf("%s is %dx%d and does not fit into a %d pixel atlas, skipping it", entry.id, size.X, size.Y, opts.MaxSize)
+			continue
+		}
+		// Padding goes to the right and bottom and may be cut at the border.
+		w, h := min(size.X+opts.Padding, opts.MaxSize), min(size.Y+opts.Padding, opts.MaxSize)
+		sheet := -1
+		for s, packer := range packers {
+			if p, ok := packer.insert(w, h); ok {
+				sheet, positions[i] = s, p
+				break
+			}
+		}
+		if sheet < 0 {
+			packer := newSkylinePacker(opts.MaxSize, opts.MaxSize)
+			positions[i], _ = packer.insert(w, h)
+			packers = append(packers, packer)
+			placed = append(placed, nil)
+			sheet = len(packers) - 1
+		}
+		placed[sheet] = append(placed[sheet], i)
+	}
+
+	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	for s, members := range placed {
+		// Sheets are cut to the images they hold.
+		var bounds image.Rectangle
+		for _, i := range members {
+			bounds = bounds.Union(image.Rectangle{positions[i], positions[i].Add(images[i].img.Bounds().Size())})
+		}
+		file := fmt.Sprintf("%s-%d%s", opts.Name, s, imageExt())
+		sheet := image.NewNRGBA(image.Rect(0, 0, bounds.Max.X, bounds.Max.Y))
+		for _, i := range members {
+			b := images[i].img.Bounds()
+			r := image.Rectangle{positions[i], positions[i].Add(b.Size())}
+			draw.Draw(sheet, r, images[i].img, b.Min, draw.Src)
+			index.Images[images[i].id] = atlasSprite{Sheet: file, X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy()}
+		}
+		if err := writeImage(filepath.Join(outputDir, file), sheet); err != nil {
+			return nil, err
+		}
+		index.Sheets = append(index.Sheets, atlasSheet{File: file, Width: bounds.Max.X, Height: bounds.Max.Y})
+	}
+
+	var encoded []byte
+	if opts.Indentation != "" {
+		encoded, err = json.MarshalIndent(index, "", opts.Indentation)
+	} else {
+		encoded, err = json.Marshal(index)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, opts.Name+".json"), encoded, os.ModePerm); err != nil {
+		return nil, err
+	}
+	if opts.CSS {
+		if err := os.WriteFile(filepath.Join(outputDir, opts.Name+".css"), []byte(atlasCSS(opts.Name, index)), os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	return index, nil
+}
+
+// readAtlasImages decodes the PNG and WebP files of dir. Images whose names collide
+// once the resolution suffix is removed keep their full name.
+func readAtlasImages(dir string) ([]atlasImage, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []atlasImage
+	ids := make(map[string]int)
+	for _, file := range files {
+		if file.IsDir() || !isImageFile(file.Name()) {
+			continue
+		}
+		img, err := decodeImage(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Name(), err)
+		}
+		id := imageID(file.Name())
+		ids[id]++
+		images = append(images, atlasImage{id: file.Name(), img: img})
+	}
+
+	for i, entry := range images {
+		id := imageID(entry.id)
+		if ids[id] > 1 {
+			id = strings.TrimSuffix(entry.id, filepath.Ext(entry.id))
+		}
+		images[i].id = id
+	}
+	return images, nil
+}
+
+var cssClassInvalid = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// atlasCSS returns a class <name>-<id> for every image that shows it as
+// background.
+func atlasCSS(name string, index *atlasIndex) string {
+	ids := make([]string, 0, len(index.Images))
+	for id := range index.Images {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var css strings.Builder
+	for _, id := range ids {
+		sprite := index.Images[id]
+		fmt.Fprintf(&css, ".%s-%s { background: url(%q) -%dpx -%dpx no-repeat; width: %dpx; height: %dpx; }\n",
+			cssClassInvalid.ReplaceAllString(name, "_"), cssClassInvalid.ReplaceAllString(id, "_"),
+			sprite.Sheet, sprite.X, sprite.Y, sprite.Width, sprite.Height)
+	}
+	return css.String()
+}
+
+// skylinePacker places rectangles bottom-left on the skyline of the ones
+// placed before.
+type skylinePacker struct {
+	width, height int
+	// segments cover the full width from left to right. y is the top of the
+	// free space above them.
+	segments []skylineSegment
+}
+
+type skylineSegment struct {
+	x, y, width int
+}
+
+func newSkylinePacker(width int, height int) *skylinePacker {
+	return &skylinePacker{width: width, height: height, segments: []skylineSegment{{0, 0, width}}}
+}
+
+// insert returns the top left corner of a w x h rectangle, or false if it
+// does not fit anymore.
+func (p *skylinePacker) insert(w int, h int) (image.Point, bool) {
+	best, bestY := -1, 0
+	for i, segment := range p.segments {
+		y, ok := p.fits(i, w, h)
+		if ok && (best < 0 || y < bestY || (y == bestY && segment.x < p.segments[best].x)) {
+			best, bestY = i, y
+		}
+	}
+	if best < 0 {
+		return image.Point{}, false
+	}
+
+	x := p.segments[best].x
+	next := []skylineSegment{{x, bestY + h, w}}
+	for _, segment := range p.segments[best:] {
+		end := segment.x + segment.width
+		if end <= x+w {
+			continue
+		}
+		if segment.x < x+w {
+			segment.width = end - (x + w)
+			segment.x = x + w
+		}
+		next = append(next, segment)
+	}
+	segments := append(p.segments[:best:best], next...)
+
+	// Merge neighbours of the same height.
+	merged := segments[:1]
+	for _, segment := range segments[1:] {
+		if last := &merged[len(merged)-1]; last.y == segment.y {
+			last.width += segment.width
+		} else {
+			merged = append(merged, segment)
+		}
+	}
+	p.segments = merged
+	return image.Point{x, bestY}, true
+}
+
+// fits returns the y a w x h rectangle gets at the left of segment i.
+func (p *skylinePacker) fits(i int, w int, h int) (int, bool) {
+	x := p.segments[i].x
+	if x+w > p.width {
+		return 0, false
+	}
+	y := 0
+	for _, segment := range p.segments[i:] {
+		if segment.x >= x+w {
+			break
+		}
+		y = max(y, segment.y)
+	}
+	return y, y+h <= p.height
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dofusdude/doduda/webp"
+)
+
+func TestSkylinePackerDoesNotOverlap(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	packer := newSkylinePacker(100, 100)
+	var placed []image.Rectangle
+	for range 200 {
+		w, h := 1+rng.Intn(20), 1+rng.Intn(20)
+		p, ok := packer.insert(w, h)
+		if !ok {
+			continue
+		}
+		r := image.Rect(p.X, p.Y, p.X+w, p.Y+h)
+		if !r.In(image.Rect(0, 0, 100, 100)) {
+			t.Fatalf("%v is outside the sheet", r)
+		}
+		for _, other := range placed {
+			if r.Overlaps(other) {
+				t.Fatalf("%v overlaps %v", r, other)
+			}
+		}
+		placed = append(placed, r)
+	}
+	if len(placed) < 30 {
+		t.Errorf("only %d rectangles fit", len(placed))
+	}
+}
+
+func TestWriteAtlas(t *testing.T) {
+	inputDir, outputDir := t.TempDir(), t.TempDir()
+	colors := map[string]color.NRGBA{
+		"10-64.png": {255, 0, 0, 255},
+		"11-64.png": {0, 255, 0, 255},
+		"12-64.png": {0, 0, 255, 128},
+	}
+	for name, c := range colors {
+		img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+		}
+		if err := writeImage(filepath.Join(inputDir, name), img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	index, err := writeAtlas(inputDir, outputDir, atlasOptions{Name: "item-1x", MaxSize: 130, Padding: 1, CSS: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Sheets) != 1 || len(index.Images) != 3 {
+		t.Fatalf("index = %+v", index)
+	}
+
+	encoded, err := os.ReadFile(filepath.Join(outputDir, "item-1x.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written atlasIndex
+	if err := json.Unmarshal(encoded, &written); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(filepath.Join(outputDir, written.Sheets[0].File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	sheet, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range colors {
+		sprite := written.Images[imageID(name)]
+		if sprite.Width != 64 || sprite.Height != 64 {
+			t.Errorf("%s: %+v", name, sprite)
+		}
+		got := color.NRGBAModel.Convert(sheet.At(sprite.X+63, sprite.Y+63)).(color.NRGBA)
+		if got != c {
+			t.Errorf("%s: corner %v, want %v", name, got, c)
+		}
+	}
+
+	css, err := os.ReadFile(filepath.Join(outputDir, "item-1x.css"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(css), ".item-1x-10 {") {
+		t.Errorf("css = %s", css)
+	}
+}
+
+func TestWriteAtlasWebPInput(t *testing.T) {
+	inputDir, outputDir := t.TempDir(), t.TempDir()
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = 10, 20, 30, 200
+	}
+	if err := writeImage(filepath.Join(inputDir, "1-64.png"), img); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(filepath.Join(inputDir, "2-64.webp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := webp.Encode(file, img, &webp.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	index, err := writeAtlas(inputDir, outputDir, atlasOptions{Name: "item", MaxSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sprite, ok := index.Images["2"]
+	if len(index.Images) != 2 || !ok {
+		t.Fatalf("index = %+v", index)
+	}
+	sheet, err := decodeImage(filepath.Join(outputDir, sprite.Sheet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := color.NRGBA{10, 20, 30, 200}
+	if got := color.NRGBAModel.Convert(sheet.At(sprite.X+7, sprite.Y+7)).(color.NRGBA); got != want {
+		t.Errorf("corner %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const codegenHeader = "Code generated by doduda codegen. DO NOT EDIT."
+
+// codegenGenericTypes are Unity container types whose name says nothing about
+// their content, so the generated type is named after the field instead.
+var codegenGenericTypes = map[string]bool{
+	"base":          true,
+	"vector":        true,
+	"staticvector":  true,
+	"map":           true,
+	"pair":          true,
+	"array":         true,
+	"monobehaviour": true,
+}
+
+// codegenRef is the type of a single field in the generated code.
+type codegenRef struct {
+	kind      string // scalar, array, object, union or any
+	jsonType  string
+	unityType string
+	elem      *codegenRef
+	object    string
+	union     []string
+}
+
+type codegenField struct {
+	key string
+	ref codegenRef
+}
+
+type codegenType struct {
+	name      string
+	unityType string
+	fields    []codegenField
+}
+
+// codegenModel collects the object types of all schema files. Types with the
+// same Unity name and shape are shared between data roots.
+type codegenModel struct {
+	types      map[string]*codegenType
+	signatures map[string]string
+	order      []string
+}
+
+type codegenSchemaFile struct {
+	model      *codegenModel
+	rootName   string
+	defs       map[string]any
+	defNames   map[string]string
+	inProgress map[string]bool
+}
+
+func newCodegenModel() *codegenModel {
+	return &codegenModel{
+		types:      make(map[string]*codegenType),
+		signatures: make(map[string]string),
+	}
+}
+
+func loadCodegenModel(schemaDir string) (*codegenModel, error) {
+	entries, err := os.ReadDir(schemaDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".schema.json") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no *.schema.json files in %s, run doduda with --emit-schema first", schemaDir)
+	}
+
+	model := newCodegenModel()
+	for _, name := range names {
+		content, err := os.ReadFile(filepath.Join(schemaDir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		var schema map[string]any
+		if err := json.Unmarshal(content, &schema); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", name, err)
+		}
+
+		file := &codegenSchemaFile{
+			model:      model,
+			rootName:   codegenIdentifier(strings.TrimSuffix(name, ".schema.json")),
+			defNames:   make(map[string]string),
+			inProgress: make(map[string]bool),
+		}
+		file.defs, _ = schema["$defs"].(map[string]any)
+
+		// Every type tree root is a MonoBehaviour, so the root object is
+		// always named after its data root.
+		if jsonType, _ := schema["type"].(string); jsonType == "object" {
+			file.object(schema, file.rootName, true)
+		} else {
+			file.ref(schema, file.rootName)
+		}
+	}
+
+	return model, nil
+}
+
+func (f *codegenSchemaFile) ref(schema map[string]any, fallbackName string) codegenRef {
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		var union []string
+		for _, entry := range anyOf {
+			entryMap, _ := entry.(map[string]any)
+			ref, _ := entryMap["$ref"].(string)
+			if name := f.def(strings.TrimPrefix(ref, "#/$defs/")); name != "" {
+				union = append(union, name)
+			}
+		}
+		return codegenRef{kind: "union", union: union}
+	}
+
+	jsonType, _ := schema["type"].(string)
+	unityType, _ := schema["x-unityType"].(string)
+	switch jsonType {
+	case "object":
+		return codegenRef{kind: "object", object: f.object(schema, fallbackName, false)}
+	case "array":
+		items, _ := schema["items"].(map[string]any)
+		elem := f.ref(items, fallbackName+"Item")
+		return codegenRef{kind: "array", elem: &elem}
+	case "string", "integer", "number", "boolean":
+		return codegenRef{kind: "scalar", jsonType: jsonType, unityType: unityType}
+	default:
+		return codegenRef{kind: "any"}
+	}
+}
+
+func (f *codegenSchemaFile) def(name string) string {
+	if generated, ok := f.defNames[name]; ok {
+		return generated
+	}
+	schema, ok := f.defs[name].(map[string]any)
+	if !ok || f.inProgress[name] {
+		return ""
+	}
+
+	f.inProgress[name] = true
+	ref := f.ref(schema, codegenIdentifier(name))
+	delete(f.inProgress, name)
+	if ref.kind != "object" {
+		return ""
+	}
+	f.defNames[name] = ref.object
+	return ref.object
+}
+
+func (f *codegenSchemaFile) object(schema map[string]any, fallbackName string, root bool) string {
+	unityType, _ := schema["x-unityType"].(string)
+	properties, _ := schema["properties"].(map[string]any)
+
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	baseName := codegenIdentifier(unityType)
+	if root || codegenGenericTypes[strings.ToLower(unityType)] || baseName == "" {
+		baseName = fallbackName
+	}
+
+	fields := make([]codegenField, 0, len(keys))
+	for _, key := range keys {
+		propertySchema, _ := properties[key].(map[string]any)
+		fields = append(fields, codegenField{key: key, ref: f.ref(propertySchema, baseName+codegenIdentifier(key))})
+	}
+
+	return f.model.register(&codegenType{name: baseName, unityType: unityType, fields: fields}, f.rootName)
+}
+
+// register stores t under its Unity name. A different shape with the same
+// name is prefixed with the data root it came from.
+func (m *codegenModel) register(t *codegenType, rootName string) string {
+	signature := t.signature()
+	for i := 0; ; i++ {
+		var candidate string
+		switch i {
+		case 0:
+			candidate = t.name
+		case 1:
+			candidate = rootName + t.name
+		default:
+			candidate = fmt.Sprintf("%s%s%d", rootName, t.name, i)
+		}
+
+		existing, ok := m.signatures[candidate]
+		if ok && existing == signature {
+			return candidate
+		}
+		if !ok {
+			t.name = candidate
+			m.types[candidate] = t
+			m.signatures[candidate] = signature
+			m.order = append(m.order, candidate)
+			return candidate
+		}
+	}
+}
+
+func (t *codegenType) signature() string {
+	var sb strings.Builder
+	for _, field := range t.fields {
+		sb.WriteString(field.key)
+		sb.WriteString(":")
+		sb.WriteString(field.ref.signature())
+		sb.WriteString(";")
+	}
+	return sb.String()
+}
+
+func (r codegenRef) signature() string {
+	switch r.kind {
+	case "scalar":
+		return r.jsonType + "(" + r.unityType + ")"
+	case "array":
+		return "[]" + r.elem.signature()
+	case "object":
+		return r.object
+	case "union":
+		return "anyOf(" + strings.Join(r.union, "|") + ")"
+	default:
+		return "any"
+	}
+}
+
+func (m *codegenModel) sortedTypes() []*codegenType {
+	names := append([]string(nil), m.order...)
+	sort.Strings(names)
+	types := make([]*codegenType, 0, len(names))
+	for _, name := range names {
+		types = append(types, m.types[name])
+	}
+	return types
+}
+
+func generateGoTypes(model *codegenModel, packageName string) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// %s\n\n", codegenHeader)
+	fmt.Fprintf(&buf, "package %s\n\n", packageName)
+
+	types := model.sortedTypes()
+	usesRawMessage := false
+	for _, t := range types {
+		for _, field := range t.fields {
+			if strings.Contains(goTypeName(field.ref), "json.RawMessage") {
+				usesRawMessage = true
+			}
+		}
+	}
+	if usesRawMessage {
+		buf.WriteString("import \"encoding/json\"\n\n")
+	}
+
+	for _, t := range types {
+		if t.unityType != "" {
+			fmt.Fprintf(&buf, "// %s mirrors the Unity type %s.\n", t.name, t.unityType)
+		}
+		fmt.Fprintf(&buf, "type %s struct {\n", t.name)
+		usedNames := make(map[string]int)
+		for _, field := range t.fields {
+			fieldName := codegenIdentifier(field.key)
+			if fieldName == "" || !unicode.IsLetter([]rune(fieldName)[0]) {
+				fieldName = "F" + fieldName
+			}
+			usedNames[fieldName]++
+			if usedNames[fieldName] > 1 {
+				fieldName = fmt.Sprintf("%s%d", fieldName, usedNames[fieldName])
+			}
+			fmt.Fprintf(&buf, "\t%s %s `json:%q`\n", fieldName, goTypeName(field.ref), field.key)
+		}
+		buf.WriteString("}\n\n")
+	}
+
+	return format.Source(buf.Bytes())
+}
+
+func goTypeName(ref codegenRef) string {
+	switch ref.kind {
+	case "scalar":
+		return goScalarType(ref.jsonType, ref.unityType)
+	case "array":
+		return "[]" + goTypeName(*ref.elem)
+	case "object":
+		return ref.object
+	case "union":
+		return "json.RawMessage"
+	default:
+		return "any"
+	}
+}
+
+func goScalarType(jsonType string, unityType string) string {
+	switch jsonType {
+	case "string":
+		return "string"
+	case "boolean":
+		return "bool"
+	case "number":
+		if strings.EqualFold(unityType, "float") || strings.EqualFold(unityType, "single") {
+			return "float32"
+		}
+		return "float64"
+	}
+
+	switch strings.ToLower(unityType) {
+	case "sint8", "int8":
+		return "int8"
+	case "uint8", "unsigned char", "char":
+		return "uint8"
+	case "sint16", "int16", "short":
+		return "int16"
+	case "uint16", "unsigned short":
+		return "uint16"
+	case "uint32", "unsigned int":
+		return "uint32"
+	case "sint64", "int64", "long", "long long":
+		return "int64"
+	case "uint64", "unsigned long long":
+		return "uint64"
+	default:
+		return "int32"
+	}
+}
+
+func generateTypeScriptTypes(model *codegenModel) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// %s\n\n", codegenHeader)
+
+	for _, t := range model.sortedTypes() {
+		if t.unityType != "" {
+			fmt.Fprintf(&buf, "/** Mirrors the Unity type %s. */\n", t.unityType)
+		}
+		fmt.Fprintf(&buf, "export interface %s {\n", t.name)
+		for _, field := range t.fields {
+			fmt.Fprintf(&buf, "  %s: %s;\n", typeScriptKey(field.key), typeScriptTypeName(field.ref))
+		}
+		buf.WriteString("}\n\n")
+	}
+
+	return bytes.TrimRight(buf.Bytes(), "\n")
+}
+
+func typeScriptTypeName(ref codegenRef) string {
+	switch ref.kind {
+	case "scalar":
+		switch ref.jsonType {
+		case "string":
+			return "string"
+		case "boolean":
+			return "boolean"
+		default:
+			return "number"
+		}
+	case "array":
+		elem := typeScriptTypeName(*ref.elem)
+		if ref.elem.kind == "union" {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case "object":
+		return ref.object
+	case "union":
+		if len(ref.union) == 0 {
+			return "unknown"
+		}
+		return strings.Join(ref.union, " | ")
+	default:
+		return "unknown"
+	}
+}
+
+func typeScriptKey(key string) string {
+	for i, r := range key {
+		if !(unicode.IsLetter(r) || r == '_' || r == '$' || (i > 0 && unicode.IsDigit(r))) {
+			return fmt.Sprintf("%q", key)
+		}
+	}
+	if key == "" {
+		return `""`
+	}
+	return key
+}
+
+// codegenIdentifier turns names like "m_Name", "item_sets" or
+// "Core.DataCenter.Item" into exported CamelCase identifiers.
+func codegenIdentifier(name string) string {
+	var sb strings.Builder
+	upperNext := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upperNext = true
+			continue
+		}
+		if upperNext {
+			r = unicode.ToUpper(r)
+			upperNext = false
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// codegenPackageName derives a valid Go package name from the output folder.
+func codegenPackageName(outDir string) string {
+	name := strings.ToLower(filepath.Base(outDir))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return r
+		}
+		return -1
+	}, name)
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		return "types"
+	}
+	return name
+}
+
+func Codegen(schemaDir string, lang string, outDir string) error {
+	model, err := loadCodegenModel(schemaDir)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(outDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	switch lang {
+	case "go":
+		source, err := generateGoTypes(model, codegenPackageName(outDir))
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(outDir, "doduda_types.go"), source, os.ModePerm)
+	case "ts", "typescript":
+		return os.WriteFile(filepath.Join(outDir, "doduda_types.ts"), generateTypeScriptTypes(model), os.ModePerm)
+	default:
+		return fmt.Errorf("unsupported codegen language %q, available: go, ts", lang)
+	}
+}
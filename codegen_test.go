@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dofusdude/doduda/unity/bundle"
+	"github.com/kvarenzn/ssm/uni"
+)
+
+func TestCodegenFromSchema(t *testing.T) {
+	dir := t.TempDir()
+	// Every real type tree root is a MonoBehaviour, the generated root types
+	// must still be named after their data root.
+	roots := map[string][]*uni.TypeTreeNode{
+		"items": {
+			{Name: "Base", Type: "MonoBehaviour", Level: 0},
+			{Name: "m_Name", Type: "string", Level: 1},
+			{Name: "objects", Type: "vector", Level: 1},
+			{Name: "Array", Type: "Array", Level: 2},
+			{Name: "size", Type: "int", Level: 3},
+			{Name: "data", Type: "Item", Level: 3},
+			{Name: "id", Type: "int", Level: 4},
+			{Name: "realWeight", Type: "float", Level: 4},
+		},
+		"alignments": {
+			{Name: "Base", Type: "MonoBehaviour", Level: 0},
+			{Name: "m_Name", Type: "string", Level: 1},
+		},
+	}
+	for name, nodes := range roots {
+		builder := bundle.NewSchemaBuilder()
+		root := builder.TypeTree(nodes, nil)
+		if err := writeUnitySchema(filepath.Join(dir, name+".json"), builder.Defs(), root); err != nil {
+			t.Fatalf("writeUnitySchema returned error: %v", err)
+		}
+	}
+
+	outDir := filepath.Join(dir, "types")
+	for _, lang := range []string{"go", "ts"} {
+		if err := Codegen(unitySchemaDir(dir), lang, outDir); err != nil {
+			t.Fatalf("Codegen(%s) returned error: %v", lang, err)
+		}
+	}
+
+	goSource, err := os.ReadFile(filepath.Join(outDir, "doduda_types.go"))
+	if err != nil {
+		t.Fatalf("failed reading generated go: %v", err)
+	}
+	if strings.Contains(string(goSource), "MonoBehaviour struct") {
+		t.Fatalf("generated go names a type after MonoBehaviour:\n%s", goSource)
+	}
+	for _, want := range []string{"package types", "type Item struct", "RealWeight float32 `json:\"realWeight\"`", "type Items struct", "type Alignments struct", "Objects ItemsObjects `json:\"objects\"`"} {
+		if !strings.Contains(string(goSource), want) {
+			t.Fatalf("generated go is missing %q:\n%s", want, goSource)
+		}
+	}
+
+	tsSource, err := os.ReadFile(filepath.Join(outDir, "doduda_types.ts"))
+	if err != nil {
+		t.Fatalf("failed reading generated typescript: %v", err)
+	}
+	if !strings.Contains(string(tsSource), "export interface Items {") || !strings.Contains(string(tsSource), "Array: Item[];") {
+		t.Fatalf("unexpected typescript output:\n%s", tsSource)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"charm.land/log/v2"
+
+	"github.com/dofusdude/doduda/ui"
+	"github.com/dofusdude/doduda/webp"
+)
+
+const (
+	ImageFormatPNG  = "png"
+	ImageFormatWebP = "webp"
+)
+
+// imageFormat and imageQuality are set by --image-format and
+// --image-quality. Images are extracted, cleaned and upscaled as PNG and
+// converted to imageFormat at the end.
+var (
+	imageFormat  = ImageFormatPNG
+	imageQuality = 100
+)
+
+// setImageFormat validates and sets the image output format. Quality 100 is
+// lossless.
+func setImageFormat(format string, quality int) error {
+	format = strings.ToLower(strings.TrimSpace(format))
+	switch format {
+	case ImageFormatPNG, ImageFormatWebP:
+	default:
+		return fmt.Errorf("unknown image format %q, available: png, webp", format)
+	}
+	if quality < 0 || quality > 100 {
+		return fmt.Errorf("image quality %d is not between 0 and 100", quality)
+	}
+	imageFormat = format
+	imageQuality = quality
+	return nil
+}
+
+// imageExt returns the file extension of the image output format.
+func imageExt() string {
+	return "." + imageFormat
+}
+
+func isImageFile(path string) bool {
+	switch filepath.Ext(path) {
+	case ".png", ".webp":
+		return true
+	}
+	return false
+}
+
+// encodeImage writes img in the image output format. PNG ignores the
+// quality.
+func encodeImage(w io.Writer, img image.Image) error {
+	if imageFormat == ImageFormatWebP {
+		return webp.Encode(w, img, &webp.Options{Quality: imageQuality})
+	}
+	return png.Encode(w, img)
+}
+
+// writeImage encodes img to path and removes the file again on errors.
+func writeImage(path string, img image.Image) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := encodeImage(out, img); err != nil {
+		out.Close()
+		os.Remove(path)
+		return err
+	}
+	return out.Close()
+}
+
+// decodeImage reads a PNG or WebP file.
+func decodeImage(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if filepath.Ext(path) == ".webp" {
+		return webp.Decode(file)
+	}
+	return png.Decode(file)
+}
+
+// decodeImageConfig returns the size of a PNG or WebP file.
+func decodeImageConfig(path string) (image.Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer file.Close()
+
+	if filepath.Ext(path) == ".webp" {
+		return webp.DecodeConfig(file)
+	}
+	return png.DecodeConfig(file)
+}
+
+// convertImages converts the PNG files below dir to the image output format
+// with the given number of workers. names.json and sprites.json follow the
+// renamed files.
+func convertImages(dir string, workers int, headless bool) error {
+	if imageFormat == ImageFormatPNG {
+		return nil
+	}
+
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && filepath.Ext(path) == ".png" {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	sidecars, err := openUnityImageSidecars(dir)
+	if err != nil {
+		return err
+	}
+
+	updateProgress := make(chan bool, len(files))
+	var progressWg sync.WaitGroup
+	progressWg.Add(1)
+	go func() {
+		defer progressWg.Done()
+		ui.Progress("Convert to "+imageFormat, len(files), updateProgress, 0, true, headless)
+	}()
+
+	jobs := make(chan int)
+	converted := make([]bool, len(files))
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				ok, err := convertImage(files[i], strings.TrimSuffix(files[i], ".png")+imageExt())
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("%s: %w", files[i], err)
+				}
+				converted[i] = ok
+				mu.Unlock()
+				updateProgress <- true
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	progressWg.Wait()
+
+	for i, file := range files {
+		if converted[i] {
+			sidecars.rename(file, strings.TrimSuffix(file, ".png")+imageExt())
+		}
+	}
+	if err := sidecars.write(); err != nil {
+		return err
+	}
+	return firstErr
+}
+
+// convertImage re-encodes the PNG file src to dst and removes src. Images
+// too large for WebP stay PNG files.
+func convertImage(src string, dst string) (bool, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+	img, err := png.Decode(file)
+	file.Close()
+	if err != nil {
+		return false, err
+	}
+	if size := img.Bounds().Size(); imageFormat == ImageFormatWebP && (size.X > webp.MaxSize || size.Y > webp.MaxSize) {
+		log.Warnf("%s is %dx%d, keeping it as png", src, size.X, size.Y)
+		return false, nil
+	}
+	if err := writeImage(dst, img); err != nil {
+		return false, err
+	}
+	return true, os.Remove(src)
+}
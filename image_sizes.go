@@ -0,0 +1,420 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// imageSizes is set by --image-sizes. Every image category then gets each
+// of these sizes, see deriveImageSizes.
+var imageSizes []int
+
+const (
+	imageProvenanceFile = "provenance.json"
+
+	imageFilterCatmullRom = "catmull-rom"
+	imageFilterNearest    = "nearest"
+)
+
+// imageProvenance tells whether an image comes from the game files as is or
+// was resampled from another size.
+type imageProvenance struct {
+	Size   int  `json:"size"`
+	Native bool `json:"native"`
+	// Source is the size the image was resampled from.
+	Source int    `json:"source,omitempty"`
+	Filter string `json:"filter,omitempty"`
+}
+
+// parseImageSizes parses a comma separated list of sizes like
+// "32,64,128,256".
+func parseImageSizes(value string) ([]int, error) {
+	var sizes []int
+	for field := range strings.SplitSeq(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		size, err := strconv.Atoi(field)
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("invalid image size %q", field)
+		}
+		if !slices.Contains(sizes, size) {
+			sizes = append(sizes, size)
+		}
+	}
+	slices.Sort(sizes)
+	return sizes, nil
+}
+
+// resolutionSuffix is the suffix cleanImages gives an image: its size if it
+// is square, <width>x<height> otherwise.
+func resolutionSuffix(width int, height int) string {
+	if width == height {
+		return strconv.Itoa(width)
+	}
+	return fmt.Sprintf("%dx%d", width, height)
+}
+
+// imageID returns the name of a cleaned image without resolution suffix and
+// extension.
+func imageID(name string) string {
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if i := strings.LastIndex(name, "-"); i > 0 {
+		return name[:i]
+	}
+	return name
+}
+
+type sizedImage struct {
+	path          string
+	width, height int
+}
+
+func (s sizedImage) size() int {
+	return max(s.width, s.height)
+}
+
+// upscaledImage is a file fillMissingHighResFromTruncatedIDs upscaled from
+// source pixels.
+type upscaledImage struct {
+	sizedImage
+	dir    string
+	source int
+}
+
+// deriveImageSizes makes sure that every image of root exists in each of
+// imageSizes. resolutionMap lists the resolution folders below root. A size
+// that one of them holds is written into it, every other size into
+// root/<size>px. Missing sizes are resampled from the smallest native image
+// that is at least as large, or else from the largest one. upscaled maps
+// the files fillMissingHighResFromTruncatedIDs wrote to the size they were
+// upscaled from. They do not count as native and are resampled again, unless
+// there is no native image of their ID. fileID returns the ID of a file name,
+// imageID for cleaned folders. Every folder gets a provenance.json.
+func deriveImageSizes(root string, resolutionMap map[string]*int, upscaled map[string]int, fileID func(name string) string, workers int) error {
+	sizeDirs := make(map[int]string)
+	provenance := make(map[string]map[string]imageProvenance)
+	sources := make(map[string][]sizedImage)
+	have := make(map[string]map[int]bool)
+	upscaledImages := make(map[string][]upscaledImage)
+
+	resNames := make([]string, 0, len(resolutionMap))
+	for res := range resolutionMap {
+		resNames = append(resNames, res)
+	}
+	slices.Sort(resNames)
+	for _, res := range resNames {
+		dir := filepath.Join(root, res)
+		if size := resolutionMap[res]; size != nil {
+			sizeDirs[*size] = dir
+		}
+		files, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		provenance[dir] = make(map[string]imageProvenance)
+		for _, file := range files {
+			path := filepath.Join(dir, file.Name())
+			if file.IsDir() || filepath.Ext(path) != ".png" {
+				continue
+			}
+			config, err := decodeImageConfig(path)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			img := sizedImage{path, config.Width, config.Height}
+			id := fileID(file.Name())
+			if source, ok := upscaled[path]; ok {
+				upscaledImages[id] = append(upscaledImages[id], upscaledImage{img, dir, source})
+				continue
+			}
+			if have[id] == nil {
+				have[id] = make(map[int]bool)
+			}
+			have[id][img.size()] = true
+			provenance[dir][file.Name()] = imageProvenance{Size: img.size(), Native: true}
+			sources[id] = append(sources[id], img)
+		}
+	}
+
+	type job struct {
+		source sizedImage
+		size   int
+		dir    string
+		id     string
+	}
+	var jobs []job
+	// Upscaled images are replaced by ones resampled from the native images.
+	// Without native image, they are all there is and the source of the
+	// other sizes.
+	for id, images := range upscaledImages {
+		if len(sources[id]) == 0 {
+			have[id] = make(map[int]bool)
+			for _, img := range images {
+				have[id][img.size()] = true
+				provenance[img.dir][filepath.Base(img.path)] = imageProvenance{Size: img.size(), Source: img.source, Filter: imageFilterNearest}
+				sources[id] = append(sources[id], img.sizedImage)
+			}
+			continue
+		}
+		for _, img := range images {
+			if err := os.Remove(img.path); err != nil {
+				return err
+			}
+			if !have[id][img.size()] && !slices.Contains(imageSizes, img.size()) {
+				jobs = append(jobs, job{resampleSource(sources[id], img.size()), img.size(), img.dir, id})
+			}
+		}
+	}
+	for _, size := range imageSizes {
+		dir, ok := sizeDirs[size]
+		if !ok {
+			dir = filepath.Join(root, fmt.Sprintf("%dpx", size))
+		}
+		for id, images := range sources {
+			if have[id][size] {
+				continue
+			}
+			jobs = append(jobs, job{resampleSource(images, size), size, dir, id})
+		}
+		if provenance[dir] == nil {
+			provenance[dir] = make(map[string]imageProvenance)
+		}
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	queue := make(chan job)
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				name, err := resampleImageFile(j.source, j.size, j.dir, j.id)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				if err == nil {
+					provenance[j.dir][name] = imageProvenance{Size: j.size, Source: j.source.size(), Filter: imageFilterCatmullRom}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, j := range jobs {
+		queue <- j
+	}
+	close(queue)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+
+	for dir, entries := range provenance {
+		if len(entries) == 0 {
+			continue
+		}
+		encoded, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, imageProvenanceFile), append(encoded, '\n'), os.ModePerm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deriveFolderImageSizes is deriveImageSizes for the categories without
+// resolution folders. Sizes an image already has stay in root, every other
+// size goes to root/<size>px.
+func deriveFolderImageSizes(root string, fileID func(name string) string) error {
+	if len(imageSizes) == 0 {
+		return nil
+	}
+	return deriveImageSizes(root, map[string]*int{"": nil}, nil, fileID, runtime.NumCPU())
+}
+
+// imageName returns name without extension, the ID of the images in folders
+// that are not cleaned and have no resolution suffix.
+func imageName(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// resampleSource returns the smallest of images that is at least size pixels
+// large, or else the largest one.
+func resampleSource(images []sizedImage, size int) sizedImage {
+	source := images[0]
+	for _, img := range images[1:] {
+		if largeEnough := img.size() >= size; largeEnough != (source.size() >= size) {
+			if largeEnough {
+				source = img
+			}
+		} else if largeEnough == (img.size() < source.size()) {
+			source = img
+		}
+	}
+	return source
+}
+
+// resampleImageFile scales source so that its longer side is size pixels
+// and writes it to dir as <id>-<resolution>.png. It returns the file name.
+func resampleImageFile(source sizedImage, size int, dir string, id string) (string, error) {
+	file, err := os.Open(source.path)
+	if err != nil {
+		return "", err
+	}
+	src, err := png.Decode(file)
+	file.Close()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", source.path, err)
+	}
+
+	scale := float64(size) / float64(source.size())
+	width := max(int(math.Round(float64(source.width)*scale)), 1)
+	height := max(int(math.Round(float64(source.height)*scale)), 1)
+	name := fmt.Sprintf("%s-%s.png", id, resolutionSuffix(width, height))
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	out, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return "", err
+	}
+	if err := png.Encode(out, resample(src, width, height)); err != nil {
+		out.Close()
+		return "", err
+	}
+	return name, out.Close()
+}
+
+// resample scales img to width x height with a Catmull-Rom filter. The
+// colors are premultiplied by alpha while filtering, so that the color of
+// transparent pixels does not bleed into the visible ones.
+func resample(img image.Image, width int, height int) *image.NRGBA {
+	b := img.Bounds()
+	srcWidth, srcHeight := b.Dx(), b.Dy()
+	pixels := make([]float32, 0, 4*srcWidth*srcHeight)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			a := float32(c.A) / 255
+			pixels = append(pixels, float32(c.R)*a, float32(c.G)*a, float32(c.B)*a, float32(c.A))
+		}
+	}
+
+	pixels = resampleAxis(pixels, srcWidth, srcHeight, width, true)
+	pixels = resampleAxis(pixels, srcHeight, width, height, false)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(pixels); i += 4 {
+		a := min(max(pixels[i+3], 0), 255)
+		if a < 0.5 {
+			continue
+		}
+		for c := range 3 {
+			dst.Pix[i+c] = uint8(min(max(pixels[i+c]*255/a, 0), 255) + 0.5)
+		}
+		dst.Pix[i+3] = uint8(a + 0.5)
+	}
+	return dst
+}
+
+// resampleAxis scales the rows or, if not horizontal, the columns of pixels
+// from length to newLength. lines is the number of rows or columns. Pixels
+// are stored row by row.
+func resampleAxis(pixels []float32, length int, lines int, newLength int, horizontal bool) []float32 {
+	step, lineStep := 4, 4*length
+	if !horizontal {
+		step, lineStep = 4*lines, 4
+	}
+	taps := filterTaps(length, newLength)
+	out := make([]float32, 4*lines*newLength)
+	for line := range lines {
+		for i, tap := range taps {
+			var sum [4]float32
+			for k, w := range tap.weights {
+				p := pixels[line*lineStep+(tap.start+k)*step:]
+				sum[0] += p[0] * w
+				sum[1] += p[1] * w
+				sum[2] += p[2] * w
+				sum[3] += p[3] * w
+			}
+			var o int
+			if horizontal {
+				o = 4 * (line*newLength + i)
+			} else {
+				o = 4 * (i*lines + line)
+			}
+			copy(out[o:o+4], sum[:])
+		}
+	}
+	return out
+}
+
+// filterTap holds the weights of the source pixels start, start+1, ... for
+// one destination pixel.
+type filterTap struct {
+	start   int
+	weights []float32
+}
+
+// filterTaps returns the Catmull-Rom weights to scale a line of length
+// pixels to newLength pixels. When shrinking, the filter is widened so that
+// every source pixel contributes.
+func filterTaps(length int, newLength int) []filterTap {
+	scale := float64(length) / float64(newLength)
+	filterScale := max(scale, 1)
+	radius := 2 * filterScale
+
+	taps := make([]filterTap, newLength)
+	for i := range taps {
+		center := (float64(i) + 0.5) * scale
+		first := int(math.Floor(center - radius))
+		last := int(math.Ceil(center + radius))
+		start := min(max(first, 0), length-1)
+		end := min(max(last, 0), length-1)
+		weights := make([]float64, end-start+1)
+		var total float64
+		for j := first; j <= last; j++ {
+			w := catmullRom((float64(j) + 0.5 - center) / filterScale)
+			weights[min(max(j, 0), length-1)-start] += w
+			total += w
+		}
+		tap := filterTap{start: start, weights: make([]float32, len(weights))}
+		for k, w := range weights {
+			tap.weights[k] = float32(w / total)
+		}
+		taps[i] = tap
+	}
+	return taps
+}
+
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return (1.5*x-2.5)*x*x + 1
+	case x < 2:
+		return ((-0.5*x+2.5)*x-4)*x + 2
+	}
+	return 0
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResampleKeepsTransparentColorsOut(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 9, 7))
+	for y := range 7 {
+		for x := range 9 {
+			if x < 4 {
+				src.SetNRGBA(x, y, color.NRGBA{255, 0, 0, 255})
+			} else {
+				src.SetNRGBA(x, y, color.NRGBA{0, 255, 0, 0})
+			}
+		}
+	}
+
+	for _, size := range [][2]int{{4, 3}, {20, 15}, {1, 1}} {
+		dst := resample(src, size[0], size[1])
+		for y := range size[1] {
+			for x := range size[0] {
+				c := dst.NRGBAAt(x, y)
+				if c.A != 0 && (c.R != 255 || c.G != 0 || c.B != 0) {
+					t.Fatalf("%v: pixel %d,%d = %v", size, x, y, c)
+				}
+			}
+		}
+		if size[0] > 1 && dst.NRGBAAt(0, 0).A != 255 {
+			t.Errorf("%v: opaque corner became %v", size, dst.NRGBAAt(0, 0))
+		}
+	}
+}
+
+func TestDeriveImageSizes(t *testing.T) {
+	defer func() { imageSizes = nil }()
+	imageSizes = []int{4, 8, 16}
+
+	root := t.TempDir()
+	for path, size := range map[string]int{"1x/5-4.png": 4, "2x/5-8.png": 8, "1x/6-4.png": 4, "1x/7-4.png": 4, "2x/7-8.png": 8, "2x/9-8.png": 8} {
+		path = filepath.Join(root, path)
+		os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err := writeImage(path, image.NewNRGBA(image.Rect(0, 0, size, size))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	low, high := 4, 8
+	upscaled := map[string]int{filepath.Join(root, "2x", "7-8.png"): 4, filepath.Join(root, "2x", "9-8.png"): 4}
+	if err := deriveImageSizes(root, map[string]*int{"1x": &low, "2x": &high}, upscaled, imageID, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]imageProvenance{
+		"1x/5-4.png":    {Size: 4, Native: true},
+		"1x/6-4.png":    {Size: 4, Native: true},
+		"2x/5-8.png":    {Size: 8, Native: true},
+		"2x/6-8.png":    {Size: 8, Source: 4, Filter: imageFilterCatmullRom},
+		"16px/5-16.png": {Size: 16, Source: 8, Filter: imageFilterCatmullRom},
+		"16px/6-16.png": {Size: 16, Source: 4, Filter: imageFilterCatmullRom},
+		"2x/7-8.png":    {Size: 8, Source: 4, Filter: imageFilterCatmullRom},
+		"2x/9-8.png":    {Size: 8, Source: 4, Filter: imageFilterNearest},
+		"16px/9-16.png": {Size: 16, Source: 8, Filter: imageFilterCatmullRom},
+	}
+	for path, entry := range want {
+		dir, name := filepath.Split(filepath.Join(root, path))
+		encoded, err := os.ReadFile(filepath.Join(dir, imageProvenanceFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var provenance map[string]imageProvenance
+		if err := json.Unmarshal(encoded, &provenance); err != nil {
+			t.Fatal(err)
+		}
+		if provenance[name] != entry {
+			t.Errorf("%s: provenance %+v, want %+v", path, provenance[name], entry)
+		}
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestDeriveFolderImageSizes(t *testing.T) {
+	defer func() { imageSizes = nil }()
+	imageSizes = []int{8, 16}
+
+	root := t.TempDir()
+	for name, size := range map[string]int{"12-8.png": 8, "13-32.png": 32} {
+		if err := writeImage(filepath.Join(root, name), image.NewNRGBA(image.Rect(0, 0, size, size))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := deriveFolderImageSizes(root, imageID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"12-8.png", "13-32.png", "8px/13-8.png", "16px/12-16.png", "16px/13-16.png"} {
+		if _, err := os.Stat(filepath.Join(root, path)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "8px", "12-8.png")); err == nil {
+		t.Error("12-8.png was copied although the folder has it in that size")
+	}
+}
@@ -4,9 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"image"
+	"image/draw"
+	_ "image/jpeg"
 	"image/png"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -40,56 +46,306 @@ func unpackD2pFolder(title string, inPath string, outPath string, headless bool)
 		ui.Progress("Unpack "+title, len(files), updateProgress, 0, true, headless)
 	}()
 
-	for _, file := range files {
-		f, err := os.Open(file)
-		if err != nil {
-			log.Fatal(err)
+	err := unpack.WalkD2P(files, func(_ string, archive *unpack.D2P) error {
+		defer func() { updateProgress <- true }()
+		if archive == nil {
+			return nil
 		}
-		defer f.Close()
+		for _, entry := range archive.Entries {
+			if !filepath.IsLocal(entry.Name) {
+				log.Warnf("skipping %s in %s: path leaves the output folder", entry.Name, entry.Archive)
+				continue
+			}
+			outFile := filepath.Join(outPath, entry.Name)
 
-		converted := unpack.NewD2P(f).GetFiles()
-		for filename, specs := range converted {
-			outFile := filepath.Join(outPath, filename)
+			if filepath.Ext(entry.Name) == ".swl" {
+				swfFile := strings.TrimSuffix(outFile, ".swl") + ".swf"
+				err := writeSWLEntry(entry, swfFile)
+				if err == nil {
+					continue
+				}
+				log.Warnf("can not unpack swl file %s, writing it as is: %v", entry.Name, err)
+			}
 
-			if filepath.Ext(filename) == ".swl" {
-				log.Warnf("can not unpack swl file %s", filename)
+			if err := writeD2PEntry(entry, outFile); err != nil {
+				return err
 			}
+			if isChannelClosed(updateProgress) {
+				os.Exit(1)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	wg.Wait()
+}
+
+// dofus2PictoCategories maps the folders below content/gfx of the Dofus 2
+// client to the image folders of Dofus 3 and their ignore categories. low and
+// high are the sizes of the 1x and 2x folders of the Dofus 3 category.
+// Categories without high have a single folder like in Dofus 3, low is then
+// the size vectors are rendered at. Dofus 3 keeps the alignment images at
+// their own sizes, the Dofus 2 ones get the sizes of the monsters.
+var dofus2PictoCategories = []struct {
+	ignore    string
+	title     string
+	gfx       string
+	out       string
+	low, high int
+}{
+	{"images-monsters", "Monsters", "monsters", "monster", 64, 128},
+	{"images-spells", "Spells", "spells", "spell", 48, 96},
+	{"images-spell_states", "Spell States", "spellStates", "spell_state", 32, 0},
+	{"images-emotes", "Emotes", "emotes", "emote", 32, 64},
+	{"images-smileys", "Smileys", "smilies", "smiley", 32, 64},
+	{"images-achievements", "Achievements", "achievements", "achievement", 64, 0},
+	{"images-alignment", "Alignment", "alignments", "alignment", 64, 128},
+	{"images-challenges", "Challenges", "challenges", "challenge", 32, 64},
+	{"images-companions", "Companions", "companions", "companion", 84, 168},
+	{"images-jobs", "Jobs", "jobs", "job", 32, 64},
+	{"images-emblems", "Emblems", "emblems", "emblem", 64, 128},
+}
+
+// downloadDofus2Picto downloads the files below content/gfx/<gfx>/ of the
+// Dofus 2 client and sorts them into img/<out> and vector/<out> with
+// sortDofus2Picto. Archives are unpacked and SWL libraries written as SWF
+// files. Categories the client does not have are skipped.
+func downloadDofus2Picto(hashJson *ankabuffer.Manifest, bin int, dir string, title string, gfx string, out string, low int, high int, headless bool) error {
+	prefix := "content/gfx/" + gfx + "/"
+	var fileNames []HashFile
+	for name := range hashJson.Fragments["main"].Files {
+		if rel, ok := strings.CutPrefix(name, prefix); ok && filepath.IsLocal(rel) {
+			fileNames = append(fileNames, HashFile{Filename: name, FriendlyName: rel})
+		}
+	}
+	if len(fileNames) == 0 {
+		log.Infof("%s: no %s in the Dofus 2 client", title, prefix)
+		return nil
+	}
+	sort.Slice(fileNames, func(i, j int) bool { return fileNames[i].Filename < fileNames[j].Filename })
+
+	inPath := filepath.Join(dir, "tmp", "picto", out)
+	if err := DownloadUnpackFiles(title, bin, hashJson, "main", fileNames, dir, inPath, false, "", headless, false); err != nil {
+		return err
+	}
+	if slices.ContainsFunc(fileNames, func(file HashFile) bool { return filepath.Ext(file.Filename) == ".d2p" }) {
+		unpackD2pFolder(title, inPath, inPath, headless)
+	}
+
+	return sortDofus2Picto(inPath, filepath.Join(dir, "img", out), filepath.Join(dir, "vector", out), low, high)
+}
+
+// sortDofus2Picto lays out the pictos of inPath like the Dofus 3 category
+// folders. Vectors are moved to vectorPath, keeping their relative paths, and
+// rendered with the native renderer. With high, every folder of inPath gets
+// a 1x and a 2x folder below imgPath with square images of low and high
+// pixels. Bitmaps are resampled to fit. Without high, the images stay in the
+// folder itself, vectors rendered with a longer side of low and bitmaps at
+// their own size. cleanImages then names them <name>-<resolution>.png. A
+// vector and a bitmap of the same name give the rendered vector.
+func sortDofus2Picto(inPath string, imgPath string, vectorPath string, low int, high int) error {
+	var vectors, bitmaps []string
+	err := filepath.WalkDir(inPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(inPath, path)
+		if err != nil {
+			return err
+		}
 
-			f, err := os.Create(outFile)
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".png", ".jpg", ".jpeg":
+			bitmaps = append(bitmaps, rel)
+			return nil
+		case ".swf":
+			outFile := filepath.Join(vectorPath, rel)
+			if err := os.MkdirAll(filepath.Dir(outFile), os.ModePerm); err != nil {
+				return err
+			}
+			vectors = append(vectors, rel)
+			return os.Rename(path, outFile)
+		case ".swl":
+			rel = strings.TrimSuffix(rel, filepath.Ext(rel)) + ".swf"
+			outFile := filepath.Join(vectorPath, rel)
+			if err := os.MkdirAll(filepath.Dir(outFile), os.ModePerm); err != nil {
+				return err
+			}
+			f, err := os.Open(path)
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
 			defer f.Close()
+			swl, err := unpack.ReadSWL(f)
+			if err != nil {
+				log.Warnf("can not unpack swl file %s: %v", rel, err)
+				return nil
+			}
+			vectors = append(vectors, rel)
+			return os.WriteFile(outFile, swl.SWF, os.ModePerm)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// size 0 keeps bitmaps at their own size.
+	type resolution struct {
+		dir  string
+		size int
+	}
+	resolutions := []resolution{{"", 0}}
+	if high > 0 {
+		resolutions = []resolution{{"1x", low}, {"2x", high}}
+	}
+	outFile := func(rel string, res resolution) string {
+		name := strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel)) + ".png"
+		return filepath.Join(imgPath, filepath.Dir(rel), res.dir, name)
+	}
 
-			_, err = f.Write(specs["binary"].([]byte))
+	// cleanImages would add a second suffix to the files of an earlier run.
+	if err := os.RemoveAll(imgPath); err != nil {
+		return err
+	}
+	written := make(map[string]bool)
+	folders := make(map[string]bool)
+	for _, rel := range vectors {
+		for _, res := range resolutions {
+			img, err := renderSWFFile(filepath.Join(vectorPath, rel), max(res.size, low))
 			if err != nil {
-				log.Fatal(err)
+				log.Warnf("can not render %s, it stays in %s: %v", rel, vectorPath, err)
+				break
 			}
-			if isChannelClosed(updateProgress) {
-				os.Exit(1)
+			path := outFile(rel, res)
+			if err := unityWritePNG(path, squareImage(img, res.size)); err != nil {
+				return err
+			}
+			written[path] = true
+			folders[filepath.Dir(rel)] = true
+		}
+	}
+	for _, rel := range bitmaps {
+		img, err := decodeDofus2Bitmap(filepath.Join(inPath, rel))
+		if err != nil {
+			log.Warnf("can not decode %s: %v", rel, err)
+			continue
+		}
+		for _, res := range resolutions {
+			path := outFile(rel, res)
+			if written[path] {
+				continue
 			}
+			if err := unityWritePNG(path, squareImage(img, res.size)); err != nil {
+				return err
+			}
+			written[path] = true
+			folders[filepath.Dir(rel)] = true
 		}
-		updateProgress <- true
 	}
 
-	wg.Wait()
+	if high == 0 {
+		if len(written) == 0 {
+			return nil
+		}
+		if err := cleanImages(imgPath, nil); err != nil {
+			return err
+		}
+		for folder := range folders {
+			if err := deriveFolderImageSizes(filepath.Join(imgPath, folder), imageID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	resolutionMap := map[string]*int{"1x": &low, "2x": &high}
+	for folder := range folders {
+		for _, res := range resolutions {
+			if err := cleanImages(filepath.Join(imgPath, folder, res.dir), &res.size); err != nil {
+				return err
+			}
+		}
+		if len(imageSizes) != 0 {
+			if err := deriveImageSizes(filepath.Join(imgPath, folder), resolutionMap, nil, imageID, runtime.NumCPU()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decodeDofus2Bitmap decodes a PNG or JPEG picto.
+func decodeDofus2Bitmap(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// squareImage scales img so that its longer side is size pixels and centers
+// it on a transparent size x size image. Size 0 returns img as is.
+func squareImage(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	if size == 0 || (b.Dx() == size && b.Dy() == size) {
+		return img
+	}
+	if max(b.Dx(), b.Dy()) != size {
+		scale := float64(size) / float64(max(b.Dx(), b.Dy()))
+		img = resample(img, max(int(math.Round(float64(b.Dx())*scale)), 1), max(int(math.Round(float64(b.Dy())*scale)), 1))
+		b = img.Bounds()
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	offset := image.Pt((size-b.Dx())/2, (size-b.Dy())/2)
+	draw.Draw(dst, image.Rectangle{offset, offset.Add(b.Size())}, img, b.Min, draw.Src)
+	return dst
+}
+
+func writeD2PEntry(entry *unpack.D2PEntry, outFile string) error {
+	f, err := os.Create(outFile)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, entry.Open()); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", entry.Name, err)
+	}
+	return f.Close()
+}
+
+// writeSWLEntry writes the SWF file embedded in a SWL library.
+func writeSWLEntry(entry *unpack.D2PEntry, outFile string) error {
+	swl, err := unpack.ReadSWL(entry.Open())
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outFile, swl.SWF, os.ModePerm)
 }
 
 func removeNumberSuffix(path string, f os.FileInfo, ending string) string {
 	fileBase := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
-	cleanedBase := fileBase
-	if suffixStart := strings.LastIndex(fileBase, "_#"); suffixStart != -1 {
-		base := fileBase[:suffixStart]
-		suffix := fileBase[suffixStart+2:]
+	return filepath.Join(filepath.Dir(path), cleanNumberSuffix(fileBase)+ending)
+}
 
-		if isOnlyDigits(base) && isOnlyDigits(suffix) && len(suffix) == 1 {
-			cleanedBase = base + suffix
-		} else {
-			cleanedBase = base
-		}
+// cleanNumberSuffix removes the _#<number> suffix AssetStudio adds to
+// duplicate names from a file name without extension.
+func cleanNumberSuffix(fileBase string) string {
+	suffixStart := strings.LastIndex(fileBase, "_#")
+	if suffixStart == -1 {
+		return fileBase
 	}
-
-	return filepath.Join(filepath.Dir(path), cleanedBase+ending)
+	base := fileBase[:suffixStart]
+	suffix := fileBase[suffixStart+2:]
+	if isOnlyDigits(base) && isOnlyDigits(suffix) && len(suffix) == 1 {
+		return base + suffix
+	}
+	return base
 }
 
 func isOnlyDigits(s string) bool {
@@ -115,25 +371,25 @@ func cleanImages(dir string, resolution *int) error {
 
 	files := make(map[string][]fileStuff) // buffer for files
 
+	// names.json and sprites.json of the native backend follow the files
+	sidecars, err := openUnityImageSidecars(dir)
+	if err != nil {
+		return err
+	}
+
 	// populate files
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if info.IsDir() || filepath.Ext(path) != ".png" {
+		if info.IsDir() || !isImageFile(path) {
 			return nil
 		}
 
-		file, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-
-		img, err := png.DecodeConfig(file)
+		img, err := decodeImageConfig(path)
 		if err != nil {
-			return nil // probably not a png, don't care
+			return nil // probably not an image, don't care
 		}
 
 		// hard filter for resolution
@@ -143,11 +399,12 @@ func cleanImages(dir string, resolution *int) error {
 				if err != nil {
 					return err
 				}
+				sidecars.remove(path)
 				return nil
 			}
 		}
 
-		cleanedName := removeNumberSuffix(path, info, ".png")
+		cleanedName := removeNumberSuffix(path, info, filepath.Ext(path))
 
 		if _, ok := files[cleanedName]; !ok {
 			files[cleanedName] = make([]fileStuff, 0)
@@ -177,6 +434,7 @@ func cleanImages(dir string, resolution *int) error {
 				if err != nil {
 					return err
 				}
+				sidecars.merge(other.path, f[higestResIdx].path)
 			}
 		}
 	}
@@ -187,45 +445,38 @@ func cleanImages(dir string, resolution *int) error {
 			return err
 		}
 
-		if info.IsDir() || filepath.Ext(path) != ".png" {
+		if info.IsDir() || !isImageFile(path) {
 			return nil
 		}
 
-		file, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-
-		img, err := png.DecodeConfig(file)
+		img, err := decodeImageConfig(path)
 		if err != nil {
 			return err
 		}
 
 		var finalResStr string
 		if resolution == nil {
-			var resStr string
-			if img.Width == img.Height {
-				resStr = strconv.Itoa(img.Width)
-			} else {
-				resStr = fmt.Sprintf("%dx%d", img.Width, img.Height)
-			}
-			finalResStr = resStr
+			finalResStr = resolutionSuffix(img.Width, img.Height)
 		} else {
 			finalResStr = fmt.Sprintf("%d", *resolution)
 		}
 
-		cleaned := removeNumberSuffix(path, info, ".png")
-		sdPath := strings.Replace(cleaned, ".png", fmt.Sprintf("-%s.png", finalResStr), 1)
+		ext := filepath.Ext(path)
+		cleaned := removeNumberSuffix(path, info, ext)
+		sdPath := strings.TrimSuffix(cleaned, ext) + "-" + finalResStr + ext
 		err = os.Rename(path, sdPath)
 		if err != nil {
 			return err
 		}
+		sidecars.rename(path, sdPath)
 
 		return nil
 	})
+	if err != nil {
+		return err
+	}
 
-	return err
+	return sidecars.write()
 }
 
 func rename_or_rmfirst(from string, to string) error {
@@ -298,7 +549,10 @@ func upscalePngNearest(srcPath string, dstPath string, dstWidth int, dstHeight i
 	return nil
 }
 
-func fillMissingHighResFromTruncatedIDs(outPath string, resolutionMap map[string]*int, ressubdirs []string) error {
+// fillMissingHighResFromTruncatedIDs upscales low resolution images that
+// have no high resolution copy because AssetStudio truncated their ID. It
+// returns the written files with the size they were upscaled from.
+func fillMissingHighResFromTruncatedIDs(outPath string, resolutionMap map[string]*int, ressubdirs []string) (map[string]int, error) {
 	type resEntry struct {
 		name string
 		size int
@@ -313,7 +567,7 @@ func fillMissingHighResFromTruncatedIDs(outPath string, resolutionMap map[string
 	}
 
 	if len(entries) < 2 {
-		return nil
+		return nil, nil
 	}
 
 	sort.Slice(entries, func(i, j int) bool {
@@ -323,6 +577,7 @@ func fillMissingHighResFromTruncatedIDs(outPath string, resolutionMap map[string
 	lowRes := entries[0]
 	highRes := entries[len(entries)-1]
 
+	upscaled := make(map[string]int)
 	lowSuffix := fmt.Sprintf("-%d.png", lowRes.size)
 	highSuffix := fmt.Sprintf("-%d.png", highRes.size)
 
@@ -363,12 +618,13 @@ func fillMissingHighResFromTruncatedIDs(outPath string, resolutionMap map[string
 
 			lowPath := filepath.Join(lowDir, lowFile.Name())
 			if err := upscalePngNearest(lowPath, expectedHighPath, highRes.size, highRes.size); err != nil {
-				return err
+				return nil, err
 			}
+			upscaled[expectedHighPath] = lowRes.size
 		}
 	}
 
-	return nil
+	return upscaled, nil
 }
 
 func download_unpack_clean_dedup_multires(errorChan chan error, topic string, bin int, hashJson *ankabuffer.Manifest, dir string, outPath string, fileNames []HashFile, semaphore chan struct{}, feedbacks chan string, innerTopicPlural string, resolutionMap map[string]*int, ressubdirs ...string) {
@@ -440,8 +696,20 @@ func download_unpack_clean_dedup_multires(errorChan chan error, topic string, bi
 	}
 
 	innerWg.Wait()
-	if err := fillMissingHighResFromTruncatedIDs(outPath, resolutionMap, ressubdirs); err != nil {
-		errorChan <- err
+	// The native backend writes the real sprite names, only AssetStudio output
+	// has truncated IDs.
+	var upscaled map[string]int
+	if backend, err := CurrentUnityUnpackBackend(); err != nil || backend.Name() != UnityBackendNative {
+		if upscaled, err = fillMissingHighResFromTruncatedIDs(outPath, resolutionMap, ressubdirs); err != nil {
+			errorChan <- err
+		}
+	}
+	if len(imageSizes) != 0 {
+		for _, ressubdir := range ressubdirs {
+			if err := deriveImageSizes(filepath.Join(outPath, ressubdir), resolutionMap, upscaled, imageID, runtime.NumCPU()); err != nil {
+				errorChan <- err
+			}
+		}
 	}
 	err = os.RemoveAll(filepath.Join(outPath, "Assets"))
 	if err != nil {
@@ -453,14 +721,18 @@ func download_unpack_clean_dedup_multires(errorChan chan error, topic string, bi
 func DownloadImagesLauncher(hashJson *ankabuffer.Manifest, bin int, maxConcurrentDownloads int, version int, dir string, ignore []string, headless bool) error {
 	switch version {
 	case 2:
+		// The archives keep their names so that the "link" property of one
+		// archive finds the next one.
 		fileNames := []HashFile{
-			{Filename: "content/gfx/items/bitmap0.d2p", FriendlyName: "bitmaps_0.d2p"},
-			{Filename: "content/gfx/items/bitmap0_1.d2p", FriendlyName: "bitmaps_1.d2p"},
-			{Filename: "content/gfx/items/bitmap1.d2p", FriendlyName: "bitmaps_2.d2p"},
-			{Filename: "content/gfx/items/bitmap1_1.d2p", FriendlyName: "bitmaps_3.d2p"},
-			{Filename: "content/gfx/items/bitmap1_2.d2p", FriendlyName: "bitmaps_4.d2p"},
+			{Filename: "content/gfx/items/bitmap0.d2p", FriendlyName: "bitmap0.d2p"},
+			{Filename: "content/gfx/items/bitmap0_1.d2p", FriendlyName: "bitmap0_1.d2p"},
+			{Filename: "content/gfx/items/bitmap1.d2p", FriendlyName: "bitmap1.d2p"},
+			{Filename: "content/gfx/items/bitmap1_1.d2p", FriendlyName: "bitmap1_1.d2p"},
+			{Filename: "content/gfx/items/bitmap1_2.d2p", FriendlyName: "bitmap1_2.d2p"},
 		}
-		inPath := filepath.Join(dir, "tmp")
+		// Like the vectors and pictos, the bitmaps get their own folder, the
+		// maps archives are still in tmp/maps at this point.
+		inPath := filepath.Join(dir, "tmp", "bitmap")
 
 		if !ignoresRegex(ignore, "images-items") {
 			if err := DownloadUnpackFiles("Item Bitmaps", bin, hashJson, "main", fileNames, dir, inPath, false, "", headless, false); err != nil {
@@ -468,13 +740,16 @@ func DownloadImagesLauncher(hashJson *ankabuffer.Manifest, bin int, maxConcurren
 			}
 			outPath := filepath.Join(dir, "img", "item")
 			unpackD2pFolder("Item Bitmaps", inPath, outPath, headless)
+			if err := deriveFolderImageSizes(outPath, imageName); err != nil {
+				return err
+			}
 
 			fileNames = []HashFile{
-				{Filename: "content/gfx/items/vector0.d2p", FriendlyName: "vector_0.d2p"},
-				{Filename: "content/gfx/items/vector0_1.d2p", FriendlyName: "vector_1.d2p"},
-				{Filename: "content/gfx/items/vector1.d2p", FriendlyName: "vector_2.d2p"},
-				{Filename: "content/gfx/items/vector1_1.d2p", FriendlyName: "vector_3.d2p"},
-				{Filename: "content/gfx/items/vector1_2.d2p", FriendlyName: "vector_4.d2p"},
+				{Filename: "content/gfx/items/vector0.d2p", FriendlyName: "vector0.d2p"},
+				{Filename: "content/gfx/items/vector0_1.d2p", FriendlyName: "vector0_1.d2p"},
+				{Filename: "content/gfx/items/vector1.d2p", FriendlyName: "vector1.d2p"},
+				{Filename: "content/gfx/items/vector1_1.d2p", FriendlyName: "vector1_1.d2p"},
+				{Filename: "content/gfx/items/vector1_2.d2p", FriendlyName: "vector1_2.d2p"},
 			}
 
 			inPath = filepath.Join(dir, "tmp", "vector")
@@ -486,6 +761,15 @@ func DownloadImagesLauncher(hashJson *ankabuffer.Manifest, bin int, maxConcurren
 			unpackD2pFolder("Item Vectors", inPath, outPath, headless)
 		}
 
+		for _, category := range dofus2PictoCategories {
+			if ignoresRegex(ignore, category.ignore) {
+				continue
+			}
+			if err := downloadDofus2Picto(hashJson, bin, dir, category.title, category.gfx, category.out, category.low, category.high, headless); err != nil {
+				return err
+			}
+		}
+
 		return nil
 	case 3:
 
@@ -501,6 +785,9 @@ func DownloadImagesLauncher(hashJson *ankabuffer.Manifest, bin int, maxConcurren
 			}
 
 			// not cleaning since names are not unique enough without #number
+			if err := deriveFolderImageSizes(outPath, imageName); err != nil {
+				return err
+			}
 		}
 
 		// -- UI Ornaments --
@@ -517,6 +804,9 @@ func DownloadImagesLauncher(hashJson *ankabuffer.Manifest, bin int, maxConcurren
 			if err := cleanImages(outPath, nil); err != nil {
 				return err
 			}
+			if err := deriveFolderImageSizes(outPath, imageID); err != nil {
+				return err
+			}
 		}
 
 		// -- UI Documents --
@@ -533,6 +823,9 @@ func DownloadImagesLauncher(hashJson *ankabuffer.Manifest, bin int, maxConcurren
 			if err := cleanImages(outPath, nil); err != nil {
 				return err
 			}
+			if err := deriveFolderImageSizes(outPath, imageID); err != nil {
+				return err
+			}
 		}
 
 		// -- UI Guidebook --
@@ -549,6 +842,9 @@ func DownloadImagesLauncher(hashJson *ankabuffer.Manifest, bin int, maxConcurren
 			if err := cleanImages(outPath, nil); err != nil {
 				return err
 			}
+			if err := deriveFolderImageSizes(outPath, imageID); err != nil {
+				return err
+			}
 		}
 
 		// -- UI house --
@@ -565,6 +861,9 @@ func DownloadImagesLauncher(hashJson *ankabuffer.Manifest, bin int, maxConcurren
 			if err := cleanImages(outPath, nil); err != nil {
 				return err
 			}
+			if err := deriveFolderImageSizes(outPath, imageID); err != nil {
+				return err
+			}
 		}
 
 		// -- UI illustrations --
@@ -577,6 +876,9 @@ func DownloadImagesLauncher(hashJson *ankabuffer.Manifest, bin int, maxConcurren
 			if err := DownloadUnpackFiles("UI Illustration 🖼️", bin, hashJson, "picto", fileNames, dir, outPath, true, "", headless, false); err != nil {
 				return err
 			}
+			if err := deriveFolderImageSizes(outPath, imageName); err != nil {
+				return err
+			}
 		}
 
 		// -- Suggestion --
@@ -593,6 +895,9 @@ func DownloadImagesLauncher(hashJson *ankabuffer.Manifest, bin int, maxConcurren
 			if err := cleanImages(outPath, nil); err != nil {
 				return err
 			}
+			if err := deriveFolderImageSizes(outPath, imageID); err != nil {
+				return err
+			}
 		}
 
 		// -- Icons --
@@ -609,6 +914,9 @@ func DownloadImagesLauncher(hashJson *ankabuffer.Manifest, bin int, maxConcurren
 			if err := cleanImages(outPath, nil); err != nil {
 				return err
 			}
+			if err := deriveFolderImageSizes(outPath, imageID); err != nil {
+				return err
+			}
 		}
 
 		// -- Flag --
@@ -625,6 +933,9 @@ func DownloadImagesLauncher(hashJson *ankabuffer.Manifest, bin int, maxConcurren
 			if err := cleanImages(outPath, nil); err != nil {
 				return err
 			}
+			if err := deriveFolderImageSizes(outPath, imageID); err != nil {
+				return err
+			}
 		}
 
 		// -- Guildrank --
@@ -641,6 +952,9 @@ func DownloadImagesLauncher(hashJson *ankabuffer.Manifest, bin int, maxConcurren
 			if err := cleanImages(outPath, nil); err != nil {
 				return err
 			}
+			if err := deriveFolderImageSizes(outPath, imageID); err != nil {
+				return err
+			}
 
 		}
 
@@ -658,6 +972,9 @@ func DownloadImagesLauncher(hashJson *ankabuffer.Manifest, bin int, maxConcurren
 			if err := cleanImages(outPath, nil); err != nil {
 				return err
 			}
+			if err := deriveFolderImageSizes(outPath, imageID); err != nil {
+				return err
+			}
 		}
 
 		// -- Achievement Categories --
@@ -674,6 +991,9 @@ func DownloadImagesLauncher(hashJson *ankabuffer.Manifest, bin int, maxConcurren
 			if err := cleanImages(outPath, nil); err != nil {
 				return err
 			}
+			if err := deriveFolderImageSizes(outPath, imageID); err != nil {
+				return err
+			}
 		}
 
 		// -- Achievements --
@@ -690,6 +1010,9 @@ func DownloadImagesLauncher(hashJson *ankabuffer.Manifest, bin int, maxConcurren
 			if err := cleanImages(outPath, nil); err != nil {
 				return err
 			}
+			if err := deriveFolderImageSizes(outPath, imageID); err != nil {
+				return err
+			}
 		}
 
 		// -- spell states --
@@ -705,6 +1028,9 @@ func DownloadImagesLauncher(hashJson *ankabuffer.Manifest, bin int, maxConcurren
 			if err := cleanImages(outPath, nil); err != nil {
 				return err
 			}
+			if err := deriveFolderImageSizes(outPath, imageID); err != nil {
+				return err
+			}
 		}
 
 		const totalDownloads = 13 // just to buffer, must be at least the number of go routines started below
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// testSWF is a 100x100 pixel movie whose upper half is red.
+const testSWF = "FWS\nd\x00\x00\x00\x88\x00\x00\x0f\xa0\x00\x00\x03\xe8\x00\x00\x18\x01\x00?\b/\x00\x00\x00\x01\x00\x88\x00\x00\x0f\xa0\x00\x00\x01\xf4\x00\x01\x00\xff\x00\x00\xff\x00\x10\x16 \x00\x00\x00\a\xf8\x1f@\x00\x01\xfc\x00\x00\x03\xe8\xff\xf80\x00\x00\x7f\x00\x00\x7f\x06\x00\xbf\x06\v\x00\x00\x00\x06\x01\x00\x01\x00\"\x00\x00\x00\x00\x00\x7f\x00\x00\x00\x00\x00\x00\x00"
+
+func TestSortDofus2Picto(t *testing.T) {
+	dir := t.TempDir()
+	inPath := filepath.Join(dir, "in")
+	var swl bytes.Buffer
+	swl.WriteByte(76)
+	for _, v := range []any{int8(1), uint32(25), int32(0)} {
+		binary.Write(&swl, binary.BigEndian, v)
+	}
+	swl.WriteString("FWS\x0a")
+	var bitmap bytes.Buffer
+	png.Encode(&bitmap, image.NewNRGBA(image.Rect(0, 0, 10, 5)))
+	files := map[string][]byte{
+		"12_#02.png":     bitmap.Bytes(),
+		"7.png":          bitmap.Bytes(),
+		"icons/3.swf":    []byte(testSWF),
+		"icons/3.png":    bitmap.Bytes(),
+		"anim.swl":       swl.Bytes(),
+		"monsters0.d2p":  []byte("d2p"),
+		"readme.unknown": nil,
+	}
+	for name, data := range files {
+		path := filepath.Join(inPath, name)
+		os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err := os.WriteFile(path, data, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	imgPath, vectorPath := filepath.Join(dir, "img", "monster"), filepath.Join(dir, "vector", "monster")
+	if err := sortDofus2Picto(inPath, imgPath, vectorPath, 64, 128); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		filepath.Join(vectorPath, "icons/3.swf"): testSWF,
+		filepath.Join(vectorPath, "anim.swf"):    "FWS\x0a",
+	} {
+		if got, err := os.ReadFile(path); err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v", path, got, err)
+		}
+	}
+
+	var names []string
+	filepath.WalkDir(imgPath, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(imgPath, path)
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	want := []string{"1x/12-64.png", "1x/7-64.png", "2x/12-128.png", "2x/7-128.png", "icons/1x/3-64.png", "icons/2x/3-128.png"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("files = %v, want %v", names, want)
+	}
+
+	// The vector wins over the bitmap of the same name.
+	rendered, err := os.Open(filepath.Join(imgPath, "icons", "2x", "3-128.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rendered.Close()
+	img, err := png.Decode(rendered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, a := img.At(10, 10).RGBA(); r != 0xffff || a != 0xffff {
+		t.Errorf("rendered vector is %v at 10,10", img.At(10, 10))
+	}
+}
+
+func TestSortDofus2PictoSingleFolder(t *testing.T) {
+	dir := t.TempDir()
+	inPath := filepath.Join(dir, "in")
+	os.MkdirAll(inPath, os.ModePerm)
+	if err := os.WriteFile(filepath.Join(inPath, "4.swf"), []byte(testSWF), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeImage(filepath.Join(inPath, "5.png"), image.NewNRGBA(image.Rect(0, 0, 20, 10))); err != nil {
+		t.Fatal(err)
+	}
+
+	imgPath := filepath.Join(dir, "img", "achievement")
+	if err := sortDofus2Picto(inPath, imgPath, filepath.Join(dir, "vector", "achievement"), 32, 0); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(imgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"4-32.png", "5-20x10.png"}; !slices.Equal(names, want) {
+		t.Errorf("files = %v, want %v", names, want)
+	}
+}
+
+func TestCleanConvertedImages(t *testing.T) {
+	dir := t.TempDir()
+	for name, size := range map[string]int{"5.png": 16, "5_#02.png": 32, "7.png": 16} {
+		if err := writeImage(filepath.Join(dir, name), image.NewNRGBA(image.Rect(0, 0, size, size))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer setImageFormat(ImageFormatPNG, 100)
+	if err := setImageFormat(ImageFormatWebP, 80); err != nil {
+		t.Fatal(err)
+	}
+	if err := convertImages(dir, 2, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := cleanImages(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if len(names) != 2 || names[0] != "5-32.webp" || names[1] != "7-16.webp" {
+		t.Errorf("files = %v", names)
+	}
+}
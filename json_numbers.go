@@ -0,0 +1,32 @@
+package main
+
+import "math"
+
+// jsonFiniteValue replaces NaN and infinite floats with nil, which is written
+// as null. JSON has no representation for them. Maps and slices are updated in
+// place.
+func jsonFiniteValue(value any) any {
+	switch v := value.(type) {
+	case float32:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return nil
+		}
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
+		}
+	case []any:
+		for i := range v {
+			v[i] = jsonFiniteValue(v[i])
+		}
+	case map[string]any:
+		for key, entry := range v {
+			v[key] = jsonFiniteValue(entry)
+		}
+	case map[string]map[string]any:
+		for _, entry := range v {
+			jsonFiniteValue(entry)
+		}
+	}
+	return value
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestJSONFiniteValue(t *testing.T) {
+	value := map[string]any{
+		"name":   "NaNa",
+		"ratio":  math.NaN(),
+		"scale":  float32(0.25),
+		"values": []any{math.Inf(1), 1.5, map[string]any{"x": float32(math.Inf(-1))}},
+	}
+
+	encoded, err := json.Marshal(jsonFiniteValue(value))
+	if err != nil {
+		t.Fatalf("marshal returned error: %v", err)
+	}
+	want := `{"name":"NaNa","ratio":null,"scale":0.25,"values":[null,1.5,{"x":null}]}`
+	if string(encoded) != want {
+		t.Fatalf("expected %s, got %s", want, encoded)
+	}
+}
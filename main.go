@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"runtime"
@@ -60,10 +61,68 @@ var (
 		Run:           watchdogCommand,
 	}
 
+	schemaCmd = &cobra.Command{
+		Use:           "schema",
+		Short:         "Work with the JSON Schemas written by --emit-schema.",
+		Long:          ``,
+		SilenceErrors: true,
+		SilenceUsage:  false,
+	}
+
+	schemaDiffCmd = &cobra.Command{
+		Use:           "diff <old-dir> <new-dir>",
+		Short:         "Report added, removed and retyped fields between two schema exports.",
+		Long:          `Compares the *.schema.json files of two directories. Each directory can be the schema folder itself or an output folder containing it.`,
+		SilenceErrors: true,
+		SilenceUsage:  false,
+		Run:           schemaDiffCommand,
+		Args:          cobra.ExactArgs(2),
+	}
+
+	codegenCmd = &cobra.Command{
+		Use:           "codegen",
+		Short:         "Generate typed structs from the Dofus 3 data root schemas.",
+		Long:          `Generates Go structs or TypeScript interfaces with JSON keys matching the raw doduda output. Reads the schemas written by --emit-schema.`,
+		SilenceErrors: true,
+		SilenceUsage:  false,
+		Run:           codegenCommand,
+		Args:          cobra.NoArgs,
+	}
+
+	backendDiffCmd = &cobra.Command{
+		Use:           "backend-diff <input-dir> <output-dir>",
+		Short:         "Compare the native Unity backend with another backend on the same files.",
+		Long:          `Unpacks every .bundle, .bin and .imagebundle file in the input directory with the native backend and the one selected by --unity-backend (Docker if unset or native) and reports which outputs differ and where. JSON files are compared structurally, images pixel by pixel. The full report is written to <output-dir>/report.json. Exits with status 1 when differences are found.`,
+		SilenceErrors: true,
+		SilenceUsage:  false,
+		Run:           backendDiffCommand,
+		Args:          cobra.ExactArgs(2),
+	}
+
+	packCmd = &cobra.Command{
+		Use:           "pack <input.json> <output>",
+		Short:         "Turn doduda's JSON of a Dofus 2 .d2o or .d2i file back into that file.",
+		Long:          `Encodes the JSON that doduda writes for Dofus 2 game data back into a binary file. The extension of <output> selects the format: .d2o or .d2i. D2O JSON has no class definitions, so --classes has to name the original .d2o file or a JSON file with the class definitions. Each object is written with the class whose fields match its keys. The search tables of the original .d2o are not written.`,
+		SilenceErrors: true,
+		SilenceUsage:  false,
+		Run:           packCommand,
+		Args:          cobra.ExactArgs(2),
+	}
+
+	atlasCmd = &cobra.Command{
+		Use:           "atlas <image-dir> <output-dir>",
+		Short:         "Pack the images of a category folder into sprite sheets.",
+		Long:          `Packs the PNG and WebP images of <image-dir>, for example data/img/item/1x, into sheets of at most --max-size pixels per side and writes them to <output-dir> as <name>-<n>.png, or .webp with --image-format webp. <name>.json maps the name of every image without resolution suffix to its sheet, x, y, w and h. --css also writes <name>.css with a background class <name>-<id> for every image.`,
+		SilenceErrors: true,
+		SilenceUsage:  false,
+		Run:           atlasCommand,
+		Args:          cobra.ExactArgs(2),
+	}
+
 	renderCmd = &cobra.Command{
 		Use:           "render <input-dir> <output-dir> <resolution>",
 		Short:         "Renders .swf files to specific resolutions.",
-		Long:          ``,
+		Long:          `Renders the first frame of every .swf file in <input-dir> to <output-dir>/<name>-<resolution>.png (or .webp with --image-format webp), the longer side being <resolution> pixels. Files that already have an image are skipped. The native backend needs no other software and supports the shapes, gradients, bitmaps, sprites and masks of Dofus 2 vectors. Files it can not render are passed on to the Docker backend if Docker is available.`,
 		SilenceErrors: true,
 		SilenceUsage:  false,
 		Run:           renderCommand,
@@ -107,12 +166,24 @@ Join them with a '-'. Example: --i images-items --i data-language.
 For Dofus 2
   - images
     - items
+    - monsters
+    - spells
+    - spell_states
+    - emotes
+    - smileys
+    - achievements
+    - alignment
+    - challenges
+    - companions
+    - jobs
+    - emblems
 
   - data
     - languages
     - items
     - quests
     - achievements
+    - maps
 
   - images
     - mounts
@@ -153,6 +224,17 @@ Regex example:
 	-i 'images-*' -> downloads and unpacks everything except images.
 	-i '^(data-|images-(?!ui-ornaments)).*' -> downloads and unpacks *only* the images-ui-ornaments.`)
 
+	rootCmd.PersistentFlags().String("unity-backend", "", "Dofus 3 Unity unpacking backend. Available: 'native', 'docker', 'exec'. Defaults to $DODUDA_UNITY_BACKEND or 'native'.")
+	rootCmd.PersistentFlags().String("unity-exec", "", `Command template for the 'exec' Unity backend. Defaults to $DODUDA_UNITY_EXEC.
+The placeholders {job} (bundle, images or i18n), {input} and {output} are replaced in every argument. Exit code 0 means success, 3 lets the native backend handle the job, everything else is an error.
+Example: --unity-exec 'python3 extract.py {job} {input} {output}'`)
+	rootCmd.PersistentFlags().String("image-format", ImageFormatPNG, "Format of the image output. Available: 'png', 'webp'.")
+	rootCmd.PersistentFlags().Int("image-quality", 100, "Quality of lossy image formats from 0 to 100. 100 writes lossless WebP files. Ignored for png.")
+	rootCmd.Flags().String("image-sizes", "", "Comma separated image sizes like '32,64,128,256' that every image category gets. Sizes the game does not have are resampled with a Catmull-Rom filter into <category>/<size>px. Each folder gets a provenance.json that tells native and derived images apart.")
+	rootCmd.Flags().Bool("emit-schema", false, "Write a JSON Schema for every unpacked Dofus 3 data root to <output>/schema. Only supported by the native Unity backend.")
+	rootCmd.Flags().Bool("sprites-json", false, "Write a sprites.json with name, path ID, texture, rect, pivot and 9-slice border of every exported Dofus 3 sprite into each image folder. Only supported by the native Unity backend.")
+	rootCmd.Flags().Int("mip-level", 0, "Mip level of the Dofus 3 textures to write, 0 being the full resolution. Sprites always use the full resolution. Only supported by the native Unity backend.")
+	rootCmd.PersistentFlags().Bool("legacy-floats", false, "Round Dofus 3 experience floats to one decimal like older doduda versions instead of writing them losslessly.")
 	rootCmd.PersistentFlags().BoolP("indent", "I", false, "Indent the JSON output (increases file size)")
 	rootCmd.PersistentFlags().String("dofus-version", "latest", "Specify Dofus version to download. Example: 2.60.0")
 
@@ -169,6 +251,30 @@ Regex example:
 	watchdogCmd.Flags().Uint32("interval", 5, "Interval in minutes to check for new versions. 0 will tick once immediately and then exit.")
 	rootCmd.AddCommand(watchdogCmd)
 
+	schemaDiffCmd.Flags().Bool("json", false, "Print the report as JSON.")
+	schemaCmd.AddCommand(schemaDiffCmd)
+	rootCmd.AddCommand(schemaCmd)
+
+	codegenCmd.Flags().String("lang", "go", "Target language. Available: 'go', 'ts'.")
+	codegenCmd.Flags().String("out", "./types", "Output folder for the generated code. For Go, its name is used as package name.")
+	codegenCmd.Flags().String("schema", "", "Folder with the *.schema.json files. Defaults to `${output}/schema`.")
+	rootCmd.AddCommand(codegenCmd)
+
+	backendDiffCmd.Flags().Bool("skip-pull", false, "Do not prepare the backends, for example pulling the Docker images. Use this to test against locally built images with the same tags.")
+	backendDiffCmd.Flags().Bool("json", false, "Print the report as JSON.")
+	rootCmd.AddCommand(backendDiffCmd)
+
+	packCmd.Flags().String("classes", "", "The .d2o file or JSON file with the class definitions for packing a .d2o file.")
+	rootCmd.AddCommand(packCmd)
+
+	atlasCmd.Flags().Int("max-size", 2048, "Maximum width and height of a sheet in pixels.")
+	atlasCmd.Flags().Int("padding", 1, "Transparent pixels between the images.")
+	atlasCmd.Flags().String("name", "", "Name of the sheets, the index and the CSS classes. Defaults to the last two folders of <image-dir>, like item-1x.")
+	atlasCmd.Flags().Bool("css", false, "Also write <name>.css with a class for every image.")
+	rootCmd.AddCommand(atlasCmd)
+
+	renderCmd.Flags().String("backend", RenderBackendNative, "Rendering backend. Available: 'native', 'docker'.")
+	renderCmd.Flags().Bool("allow-skip", false, "With the native backend, skip the files it does not support when Docker is not available instead of failing.")
 	renderCmd.Flags().String("incremental", "", "Start from the last version and only render missing images. The format must be <owner>/<repo>/<filename>")
 	rootCmd.AddCommand(renderCmd)
 
@@ -224,12 +330,246 @@ func renderCommand(ccmd *cobra.Command, args []string) {
 		}
 	}
 
-	err = Render(inputDir, outputDir, incrementalParts, resolution, headless)
+	backend, err := ccmd.Flags().GetString("backend")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	workers, err := ccmd.Flags().GetInt("jobs")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if workers == 0 {
+		workers = runtime.NumCPU()
+	}
+
+	allowSkip, err := ccmd.Flags().GetBool("allow-skip")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	parseImageFormatFlags(ccmd)
+
+	err = Render(inputDir, outputDir, incrementalParts, resolution, backend, workers, allowSkip, headless)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
+func schemaDiffCommand(ccmd *cobra.Command, args []string) {
+	asJSON, err := ccmd.Flags().GetBool("json")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	diffs, err := diffUnitySchemaDirs(args[0], args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if asJSON {
+		if diffs == nil {
+			diffs = []unitySchemaFileDiff{}
+		}
+		encoded, err := json.MarshalIndent(diffs, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(encoded))
+		return
+	}
+
+	printUnitySchemaDiff(os.Stdout, diffs)
+}
+
+func codegenCommand(ccmd *cobra.Command, args []string) {
+	lang, err := ccmd.Flags().GetString("lang")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	outDir, err := ccmd.Flags().GetString("out")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	outDir, err = filepath.Abs(outDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	schemaDir, err := ccmd.Flags().GetString("schema")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if schemaDir == "" {
+		dir, err := ccmd.Flags().GetString("output")
+		if err != nil {
+			log.Fatal(err)
+		}
+		schemaDir = unitySchemaDir(dir)
+	}
+
+	if err := Codegen(schemaDir, strings.ToLower(lang), outDir); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func packCommand(ccmd *cobra.Command, args []string) {
+	classesPath, err := ccmd.Flags().GetString("classes")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := packFile(args[0], args[1], classesPath); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func atlasCommand(ccmd *cobra.Command, args []string) {
+	inputDir, err := filepath.Abs(args[0])
+	if err != nil {
+		log.Fatal("Invalid input directory")
+	}
+
+	outputDir, err := filepath.Abs(args[1])
+	if err != nil {
+		log.Fatal("Invalid output directory")
+	}
+
+	var opts atlasOptions
+	opts.MaxSize, err = ccmd.Flags().GetInt("max-size")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts.Padding, err = ccmd.Flags().GetInt("padding")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts.CSS, err = ccmd.Flags().GetBool("css")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts.Name, err = ccmd.Flags().GetString("name")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if opts.Name == "" {
+		opts.Name = filepath.Base(filepath.Dir(inputDir)) + "-" + filepath.Base(inputDir)
+	}
+
+	indent, err := ccmd.Flags().GetBool("indent")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if indent {
+		opts.Indentation = "  "
+	}
+
+	parseImageFormatFlags(ccmd)
+
+	index, err := writeAtlas(inputDir, outputDir, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("Packed %d images into %d sheets", len(index.Images), len(index.Sheets))
+}
+
+func backendDiffCommand(ccmd *cobra.Command, args []string) {
+	inputDir, err := filepath.Abs(args[0])
+	if err != nil {
+		log.Fatal("Invalid input directory")
+	}
+
+	outputDir, err := filepath.Abs(args[1])
+	if err != nil {
+		log.Fatal("Invalid output directory")
+	}
+
+	err = os.MkdirAll(outputDir, os.ModePerm)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	skipPull, err := ccmd.Flags().GetBool("skip-pull")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	asJSON, err := ccmd.Flags().GetBool("json")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	headless, err := ccmd.Flags().GetBool("headless")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	parseUnityBackendFlags(ccmd)
+	var other UnityUnpackBackend = dockerUnityUnpackBackend{}
+	if selectedUnityBackend() != UnityBackendNative {
+		other, err = CurrentUnityUnpackBackend()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	report, err := BackendDiff(nativeUnityUnpackBackend{}, other, inputDir, outputDir, !skipPull, headless)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if asJSON {
+		encoded, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(encoded))
+	} else {
+		printUnityBackendDiff(os.Stdout, report)
+	}
+
+	if len(report.Files) > 0 {
+		os.Exit(1)
+	}
+}
+
+func parseUnityBackendFlags(ccmd *cobra.Command) {
+	var err error
+
+	unityBackendFlag, err = ccmd.Flags().GetString("unity-backend")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	unityExecFlag, err = ccmd.Flags().GetString("unity-exec")
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parseImageFormatFlags(ccmd *cobra.Command) {
+	format, err := ccmd.Flags().GetString("image-format")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	quality, err := ccmd.Flags().GetInt("image-quality")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := setImageFormat(format, quality); err != nil {
+		log.Fatal(err)
+	}
+}
+
 func parseWd(dir string) string {
 	var err error
 
@@ -519,6 +859,59 @@ func rootCommand(ccmd *cobra.Command, args []string) {
 		log.Fatal(err)
 	}
 
+	emitUnitySchema, err = ccmd.Flags().GetBool("emit-schema")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	emitUnitySpriteSidecar, err = ccmd.Flags().GetBool("sprites-json")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	unityTextureMipLevel, err = ccmd.Flags().GetInt("mip-level")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if unityTextureMipLevel < 0 {
+		log.Fatalf("invalid mip level %d", unityTextureMipLevel)
+	}
+
+	parseUnityBackendFlags(ccmd)
+	backend, err := CurrentUnityUnpackBackend()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if backend.Name() != UnityBackendNative {
+		for _, option := range []struct {
+			flag string
+			set  bool
+		}{
+			{"--emit-schema", emitUnitySchema},
+			{"--sprites-json", emitUnitySpriteSidecar},
+			{"--mip-level", unityTextureMipLevel != 0},
+		} {
+			if option.set {
+				log.Fatalf("%s is only supported by the native Unity backend, not %s", option.flag, backend.Name())
+			}
+		}
+	}
+
+	parseImageFormatFlags(ccmd)
+
+	sizes, err := ccmd.Flags().GetString("image-sizes")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if imageSizes, err = parseImageSizes(sizes); err != nil {
+		log.Fatal(err)
+	}
+
+	unityLegacyFloats, err = ccmd.Flags().GetBool("legacy-floats")
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	var indentation string
 	if indent {
 		indentation = "  "
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dofusdude/ankabuffer"
+	"github.com/dofusdude/doduda/unpack"
+)
+
+// DownloadMaps downloads the Dofus 2 maps archives and writes every map as
+// <dir>/maps/<id>.json.
+func DownloadMaps(hashJson *ankabuffer.Manifest, bin int, version int, dir string, indent string, headless bool) error {
+	if version != 2 {
+		return errors.New("unsupported version: " + strconv.Itoa(version))
+	}
+
+	// The archives keep their names so that the "link" property of one
+	// archive finds the next one.
+	var fileNames []HashFile
+	for name := range hashJson.Fragments["main"].Files {
+		if strings.HasPrefix(name, "content/maps/") && path.Ext(name) == ".d2p" {
+			fileNames = append(fileNames, HashFile{Filename: name, FriendlyName: path.Base(name)})
+		}
+	}
+	sort.Slice(fileNames, func(i, j int) bool { return fileNames[i].Filename < fileNames[j].Filename })
+
+	inPath := filepath.Join(dir, "tmp", "maps")
+	if err := DownloadUnpackFiles("Maps", bin, hashJson, "main", fileNames, dir, inPath, false, "", headless, false); err != nil {
+		return err
+	}
+
+	return unpackMapsFolder(inPath, filepath.Join(dir, "maps"), indent)
+}
+
+// unpackMapsFolder decodes the .dlm files of all .d2p archives in inPath.
+func unpackMapsFolder(inPath string, outPath string, indent string) error {
+	archives, err := filepath.Glob(filepath.Join(inPath, "*.d2p"))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outPath, os.ModePerm); err != nil {
+		return err
+	}
+
+	return unpack.WalkD2P(archives, func(_ string, archive *unpack.D2P) error {
+		if archive == nil {
+			return nil
+		}
+		for _, entry := range archive.Entries {
+			if filepath.Ext(entry.Name) != ".dlm" {
+				continue
+			}
+			if err := unpackMapEntry(entry, outPath, indent); err != nil {
+				return fmt.Errorf("%s in %s: %w", entry.Name, entry.Archive, err)
+			}
+		}
+		return nil
+	})
+}
+
+func unpackMapEntry(entry *unpack.D2PEntry, outPath string, indent string) error {
+	data, err := entry.ReadAll()
+	if err != nil {
+		return err
+	}
+	dlm, err := unpack.ReadDLM(data, []byte(unpack.DefaultDLMKey))
+	if err != nil {
+		return err
+	}
+
+	var encoded []byte
+	if indent != "" {
+		encoded, err = json.MarshalIndent(dlm, "", indent)
+	} else {
+		encoded, err = json.Marshal(dlm)
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outPath, strconv.FormatUint(uint64(dlm.ID), 10)+".json"), encoded, os.ModePerm)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dofusdude/doduda/unpack"
+)
+
+// packFile turns the JSON doduda writes for a Dofus 2 .d2o or .d2i file back
+// into that file. The extension of outputPath selects the format. D2O JSON
+// has no class definitions, so classesPath must point to a .d2o file to take
+// them from or to a JSON file with the output of D2OReader.Classes.
+func packFile(inputPath string, outputPath string, classesPath string) error {
+	input, err := os.ReadFile(inputPath)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	switch strings.ToLower(filepath.Ext(outputPath)) {
+	case ".d2o":
+		if classesPath == "" {
+			return fmt.Errorf("packing a .d2o file needs --classes")
+		}
+		classes, err := readPackClasses(classesPath)
+		if err != nil {
+			return err
+		}
+		var objects []map[string]interface{}
+		if err := decodePackJSON(input, &objects); err != nil {
+			return fmt.Errorf("read %s: %w", inputPath, err)
+		}
+		if err := unpack.WriteD2O(&out, classes, objects); err != nil {
+			return err
+		}
+	case ".d2i":
+		var data struct {
+			Texts    map[string]string      `json:"texts"`
+			NameText map[string]json.Number `json:"nameText"`
+			IDText   map[string]json.Number `json:"idText"`
+		}
+		if err := decodePackJSON(input, &data); err != nil {
+			return fmt.Errorf("read %s: %w", inputPath, err)
+		}
+		texts, err := packD2ITexts(data.Texts, data.NameText, data.IDText)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", inputPath, err)
+		}
+		if err := unpack.WriteD2I(&out, texts); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported output %s, use a .d2o or .d2i file", outputPath)
+	}
+
+	return os.WriteFile(outputPath, out.Bytes(), os.ModePerm)
+}
+
+func decodePackJSON(input []byte, value any) error {
+	decoder := json.NewDecoder(bytes.NewReader(input))
+	decoder.UseNumber()
+	return decoder.Decode(value)
+}
+
+func readPackClasses(path string) ([]*unpack.GameDataClassDefinition, error) {
+	if strings.ToLower(filepath.Ext(path)) == ".d2o" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		reader, err := unpack.NewD2OReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		return reader.Classes(), nil
+	}
+
+	encoded, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var classes []*unpack.GameDataClassDefinition
+	if err := json.Unmarshal(encoded, &classes); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return classes, nil
+}
+
+// packD2ITexts converts the texts, nameText and idText maps of the D2I JSON.
+// idText maps text IDs to their position in the sort index.
+func packD2ITexts(texts map[string]string, nameText map[string]json.Number, idText map[string]json.Number) (unpack.D2ITexts, error) {
+	result := unpack.D2ITexts{
+		Texts:     make(map[int]string, len(texts)),
+		NameTexts: make(map[string]int, len(nameText)),
+	}
+	for key, text := range texts {
+		id, err := strconv.Atoi(key)
+		if err != nil {
+			return result, fmt.Errorf("text id %q: %w", key, err)
+		}
+		result.Texts[id] = text
+	}
+	for name, key := range nameText {
+		id, err := strconv.Atoi(key.String())
+		if err != nil {
+			return result, fmt.Errorf("named text %s: %w", name, err)
+		}
+		result.NameTexts[name] = id
+	}
+
+	if idText != nil {
+		positions := make(map[int]int, len(idText))
+		result.SortedIDs = make([]int, 0, len(idText))
+		for key, position := range idText {
+			id, err := strconv.Atoi(key)
+			if err != nil {
+				return result, fmt.Errorf("sorted text id %q: %w", key, err)
+			}
+			positions[id], err = strconv.Atoi(position.String())
+			if err != nil {
+				return result, fmt.Errorf("sorted text %d: %w", id, err)
+			}
+			result.SortedIDs = append(result.SortedIDs, id)
+		}
+		sort.Slice(result.SortedIDs, func(i, j int) bool {
+			return positions[result.SortedIDs[i]] < positions[result.SortedIDs[j]]
+		})
+	}
+	return result, nil
+}
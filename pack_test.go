@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/dofusdude/doduda/unpack"
+)
+
+func TestPackD2I(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "i18n_fr.json")
+	input := `{"texts": {"1": "Oui", "2": "Non"}, "nameText": {"ui.common.no": 2}, "idText": {"2": 1, "1": 2}}`
+	if err := os.WriteFile(inputPath, []byte(input), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	outputPath := filepath.Join(dir, "i18n_fr.d2i")
+	if err := packFile(inputPath, outputPath, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	decoded, err := unpack.NewD2I(f).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := jsonFiniteValue(decoded)
+	want := map[string]map[string]interface{}{
+		"texts":    {"1": "Oui", "2": "Non"},
+		"nameText": {"ui.common.no": 2},
+		"idText":   {"2": 1, "1": 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unpacked %v, want %v", got, want)
+	}
+
+	if err := packFile(inputPath, filepath.Join(dir, "items.d2o"), ""); err == nil {
+		t.Fatal("d2o without classes: no error")
+	}
+}
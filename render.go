@@ -2,14 +2,18 @@ package main
 
 import (
 	"archive/tar"
+	"bufio"
 	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -17,207 +21,322 @@ import (
 	"charm.land/log/v2"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
+	"github.com/dofusdude/doduda/swf"
 	"github.com/dofusdude/doduda/ui"
 )
 
-func Render(inputDir string, outputDir string, incrementalParts []string, resolution int, headless bool) error {
-	err := PullImages([]string{"stelzo/swf-to-svg", "stelzo/svg-to-png"}, false, headless)
-	if err != nil {
-		return err
-	}
-
-	updateChan := make(chan string)
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		ui.Spinner("Incremental", updateChan, false, headless)
-		if !isChannelClosed(updateChan) {
-			close(updateChan)
-		}
-	}()
+const (
+	RenderBackendNative = "native"
+	RenderBackendDocker = "docker"
+)
 
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		log.Fatal(err)
+// errDockerUnavailable is returned by renderDocker when it can not reach
+// Docker at all, as opposed to a container that failed.
+var errDockerUnavailable = errors.New("docker is not available")
+
+// Render renders every .swf file in inputDir that has no image in outputDir
+// yet to <name>-<resolution>.<format>. The native backend renders the files in
+// process and passes the ones it does not support on to the Docker backend.
+// Without Docker, Render fails with the list of these files, or skips them
+// with a warning if allowSkip is set.
+func Render(inputDir string, outputDir string, incrementalParts []string, resolution int, backend string, workers int, allowSkip bool, headless bool) error {
+	if backend != RenderBackendNative && backend != RenderBackendDocker {
+		return fmt.Errorf("unknown render backend %q, available: native, docker", backend)
 	}
-	defer cli.Close()
 
-	if isChannelClosed(updateChan) {
-		os.Exit(1)
+	if len(incrementalParts) != 0 {
+		if err := downloadLatestRender(incrementalParts, headless); err != nil {
+			return err
+		}
 	}
-	updateChan <- "Pulling image"
 
 	swfFiles, err := os.ReadDir(inputDir)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	if len(incrementalParts) != 0 {
-		owner := incrementalParts[0]
-		repo := incrementalParts[1]
-		filename := incrementalParts[2] + ".tar.gz"
-
-		if isChannelClosed(updateChan) {
-			os.Exit(1)
+	var pending []string
+	for _, swfFile := range swfFiles {
+		if swfFile.IsDir() || !strings.HasSuffix(swfFile.Name(), ".swf") {
+			continue
 		}
-		updateChan <- "Checking latest release"
-
-		releaseApiResponse, err := http.Get(fmt.Sprintf("https://api.github.com/repos/%s/%s/releases/latest", owner, repo))
-		if err != nil {
-			return err
+		if _, err := os.Stat(filepath.Join(outputDir, renderFileName(swfFile.Name(), resolution))); err == nil {
+			continue // skip already rendered files
 		}
+		pending = append(pending, swfFile.Name())
+	}
 
-		releaseApiResponseBody, err := io.ReadAll(releaseApiResponse.Body)
-		if err != nil {
-			return err
+	var nativeErrors map[string]error
+	if backend == RenderBackendNative {
+		nativeErrors = renderNative(inputDir, outputDir, pending, resolution, workers, headless)
+		if len(nativeErrors) == 0 {
+			return nil
 		}
-
-		var v map[string]interface{}
-		err = json.Unmarshal(releaseApiResponseBody, &v)
-		if err != nil {
-			return err
+		pending = pending[:0]
+		for name := range nativeErrors {
+			pending = append(pending, name)
 		}
+		sort.Strings(pending)
+		log.Infof("Rendering %d files the native renderer does not support with Docker", len(pending))
+	}
+	if len(pending) == 0 {
+		return nil
+	}
 
-		assets := v["assets"].([]interface{})
-		found := false
-		for _, asset := range assets {
-			assetMap := asset.(map[string]interface{})
-			if assetMap["name"].(string) == filename {
-				found = true
-				assetUrl := assetMap["browser_download_url"].(string)
+	err = renderDocker(inputDir, outputDir, pending, resolution, headless)
+	if errors.Is(err, errDockerUnavailable) && backend == RenderBackendNative {
+		for _, name := range pending {
+			log.Warnf("%s: %v", name, nativeErrors[name])
+		}
+		if !allowSkip {
+			return fmt.Errorf("%d files need Docker, but %w. Use --allow-skip to skip them: %s", len(pending), err, strings.Join(pending, ", "))
+		}
+		log.Warnf("%v, skipping %d files", err, len(pending))
+		return nil
+	}
+	return err
+}
 
-				if isChannelClosed(updateChan) {
-					os.Exit(1)
-				}
-				updateChan <- "loading latest " + filename
+func renderFileName(swfName string, resolution int) string {
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(swfName, ".swf"), resolution, imageExt())
+}
 
-				imagesResponse, err := http.Get(assetUrl)
-				if err != nil {
-					log.Fatal(err)
-				}
+// renderNative renders the files with the swf package and returns the
+// files it could not render with the reason.
+func renderNative(inputDir string, outputDir string, names []string, resolution int, workers int, headless bool) map[string]error {
+	progressChan := make(chan bool, len(names))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ui.Progress("Rendering", len(names), progressChan, 0, true, headless)
+	}()
 
-				err = ExtractTarGz("", imagesResponse.Body)
+	jobs := make(chan string)
+	failed := make(map[string]error)
+	var mu sync.Mutex
+	var workerWg sync.WaitGroup
+	for range max(workers, 1) {
+		workerWg.Add(1)
+		go func() {
+			defer workerWg.Done()
+			for name := range jobs {
+				err := renderSWFNative(filepath.Join(inputDir, name), filepath.Join(outputDir, renderFileName(name, resolution)), resolution)
 				if err != nil {
-					return err
+					mu.Lock()
+					failed[name] = err
+					mu.Unlock()
 				}
+				progressChan <- true
 			}
-		}
+		}()
+	}
+	for _, name := range names {
+		jobs <- name
+	}
+	close(jobs)
+	workerWg.Wait()
+	wg.Wait()
 
-		if !found {
-			log.Fatal("Could not find the specified file in the latest release")
-		}
+	return failed
+}
+
+func renderSWFNative(inputPath string, outputPath string, resolution int) error {
+	img, err := renderSWFFile(inputPath, resolution)
+	if err != nil {
+		return err
 	}
 
-	if !isChannelClosed(updateChan) {
-		close(updateChan)
+	return writeImage(outputPath, img)
+}
+
+// renderSWFFile draws the first frame of an SWF file so that its longer side
+// is resolution pixels.
+func renderSWFFile(path string, resolution int) (*image.RGBA, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
+	defer f.Close()
 
-	wg.Wait()
+	movie, err := swf.Decode(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	return movie.Render(resolution)
+}
+
+// renderDocker renders the files with the stelzo/swf-to-svg and
+// stelzo/svg-to-png images.
+func renderDocker(inputDir string, outputDir string, names []string, resolution int, headless bool) error {
+	err := PullImages([]string{"stelzo/swf-to-svg", "stelzo/svg-to-png"}, false, headless)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errDockerUnavailable, err)
+	}
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return fmt.Errorf("%w: %v", errDockerUnavailable, err)
+	}
+	defer cli.Close()
 
-	progressChan := make(chan bool, len(swfFiles))
+	progressChan := make(chan bool, len(names))
+	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		ui.Progress("Rendering", len(swfFiles), progressChan, 0, true, headless)
+		ui.Progress("Rendering", len(names), progressChan, 0, true, headless)
 	}()
+	defer wg.Wait()
 
-	for _, swfFile := range swfFiles {
-		if swfFile.IsDir() || !strings.HasSuffix(swfFile.Name(), ".swf") {
-			progressChan <- true
-			continue
-		}
-
-		rawFileName := strings.TrimSuffix(swfFile.Name(), ".swf")
+	for _, name := range names {
+		rawFileName := strings.TrimSuffix(name, ".swf")
 		svgFileName := fmt.Sprintf("%s.svg", rawFileName)
-		resultFileName := fmt.Sprintf("%s-%d.png", rawFileName, resolution)
+		resultFileName := renderFileName(name, resolution)
+		// The container always writes PNG files.
+		pngFileName := strings.TrimSuffix(resultFileName, imageExt()) + ".png"
 
-		absInputPath := filepath.Join(inputDir, swfFile.Name())
-		tmpOutputPath := filepath.Join(inputDir, resultFileName)
+		tmpOutputPath := filepath.Join(inputDir, pngFileName)
 		absOutputPath := filepath.Join(outputDir, resultFileName)
 
-		if _, err := os.Stat(absOutputPath); err == nil {
-			progressChan <- true
-			continue // skip already rendered files
-		}
-
-		mountPath := filepath.Dir(absInputPath)
+		mountPath := inputDir
 
 		cmd := []string{
-			filepath.Join("data", swfFile.Name()),
+			filepath.Join("data", name),
 			filepath.Join("data", svgFileName),
 		}
-
-		ctx := context.Background()
-		resp, err := cli.ContainerCreate(ctx, &container.Config{
-			Image: "stelzo/swf-to-svg",
-			Cmd:   cmd,
-			Volumes: map[string]struct{}{
-				"/app/data": {},
-			},
-		}, &container.HostConfig{
-			Binds:      []string{fmt.Sprintf("%s:/app/data", mountPath)},
-			AutoRemove: true,
-		}, nil, nil, "")
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-			return err
-		}
-
-		statusCh, errCh := cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
-		select {
-		case err := <-errCh:
-			if err != nil {
-				return err
-			}
-		case <-statusCh:
+		if err := runRenderContainer(cli, "stelzo/swf-to-svg", cmd, mountPath); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
 		}
 
 		cmd = []string{
 			filepath.Join("data", svgFileName),
-			filepath.Join("data", resultFileName),
+			filepath.Join("data", pngFileName),
 			strconv.Itoa(resolution),
 		}
+		if err := runRenderContainer(cli, "stelzo/svg-to-png", cmd, mountPath); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
 
-		resp, err = cli.ContainerCreate(ctx, &container.Config{
-			Image: "stelzo/svg-to-png",
-			Cmd:   cmd,
-			Volumes: map[string]struct{}{
-				"/app/data": {},
-			},
-		}, &container.HostConfig{
-			Binds:      []string{fmt.Sprintf("%s:/app/data", mountPath)},
-			AutoRemove: true,
-		}, nil, nil, "")
+		if imageFormat == ImageFormatPNG {
+			err = os.Rename(tmpOutputPath, absOutputPath)
+		} else {
+			_, err = convertImage(tmpOutputPath, absOutputPath)
+		}
 		if err != nil {
-			log.Fatal(err)
+			log.Warn("File " + name + " could not be converted")
 		}
+		progressChan <- true
+	}
+
+	return nil
+}
+
+func runRenderContainer(cli *client.Client, image string, cmd []string, mountPath string) error {
+	ctx := context.Background()
+	resp, err := cli.ContainerCreate(ctx, &container.Config{
+		Image: image,
+		Cmd:   cmd,
+		Volumes: map[string]struct{}{
+			"/app/data": {},
+		},
+	}, &container.HostConfig{
+		Binds:      []string{fmt.Sprintf("%s:/app/data", mountPath)},
+		AutoRemove: true,
+	}, nil, nil, "")
+	if err != nil {
+		return err
+	}
 
-		if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return err
+	}
+
+	statusCh, errCh := cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		if err != nil {
 			return err
 		}
+	case <-statusCh:
+	}
+	return nil
+}
 
-		statusCh, errCh = cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
-		select {
-		case err := <-errCh:
+// downloadLatestRender extracts the images of the latest release of
+// <owner>/<repo> from the asset <filename>.tar.gz, so that only new files are
+// rendered.
+func downloadLatestRender(incrementalParts []string, headless bool) error {
+	updateChan := make(chan string)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ui.Spinner("Incremental", updateChan, false, headless)
+		if !isChannelClosed(updateChan) {
+			close(updateChan)
+		}
+	}()
+
+	owner := incrementalParts[0]
+	repo := incrementalParts[1]
+	filename := incrementalParts[2] + ".tar.gz"
+
+	if isChannelClosed(updateChan) {
+		os.Exit(1)
+	}
+	updateChan <- "Checking latest release"
+
+	releaseApiResponse, err := http.Get(fmt.Sprintf("https://api.github.com/repos/%s/%s/releases/latest", owner, repo))
+	if err != nil {
+		return err
+	}
+
+	releaseApiResponseBody, err := io.ReadAll(releaseApiResponse.Body)
+	if err != nil {
+		return err
+	}
+
+	var v map[string]interface{}
+	err = json.Unmarshal(releaseApiResponseBody, &v)
+	if err != nil {
+		return err
+	}
+
+	assets := v["assets"].([]interface{})
+	found := false
+	for _, asset := range assets {
+		assetMap := asset.(map[string]interface{})
+		if assetMap["name"].(string) == filename {
+			found = true
+			assetUrl := assetMap["browser_download_url"].(string)
+
+			if isChannelClosed(updateChan) {
+				os.Exit(1)
+			}
+			updateChan <- "loading latest " + filename
+
+			imagesResponse, err := http.Get(assetUrl)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = ExtractTarGz("", imagesResponse.Body)
 			if err != nil {
 				return err
 			}
-		case <-statusCh:
 		}
+	}
 
-		err = os.Rename(tmpOutputPath, absOutputPath)
-		if err != nil {
-			log.Warn("File " + swfFile.Name() + " could not be converted")
-		}
-		progressChan <- true
+	if !found {
+		log.Fatal("Could not find the specified file in the latest release")
 	}
 
-	wg.Wait()
+	if !isChannelClosed(updateChan) {
+		close(updateChan)
+	}
 
+	wg.Wait()
 	return nil
 }
 
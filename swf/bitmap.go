@@ -0,0 +1,239 @@
+package swf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+// maxBitmapSide bounds the width and height of embedded bitmaps.
+const maxBitmapSide = 8192
+
+// bitmap is a bitmap definition. It is decoded the first time a fill uses
+// it.
+type bitmap struct {
+	tag        uint16
+	data       []byte
+	jpegTables []byte
+
+	decoded bool
+	img     *image.RGBA
+	err     error
+}
+
+func (b *bitmap) image() (*image.RGBA, error) {
+	if !b.decoded {
+		b.decoded = true
+		b.img, b.err = b.decode()
+	}
+	return b.img, b.err
+}
+
+func (b *bitmap) decode() (*image.RGBA, error) {
+	switch b.tag {
+	case tagDefineBitsLossless, tagDefineBitsLossless2:
+		return decodeLossless(b.data, b.tag == tagDefineBitsLossless2)
+	case tagDefineBits:
+		data := b.data
+		if len(b.jpegTables) > 0 {
+			data = append(bytes.Clone(b.jpegTables), data...)
+		}
+		return decodeEmbeddedImage(data, nil)
+	case tagDefineBitsJPEG2:
+		return decodeEmbeddedImage(b.data, nil)
+	}
+
+	// DefineBitsJPEG3 and DefineBitsJPEG4 store a zlib compressed alpha
+	// channel after the image.
+	r := &reader{data: b.data}
+	alphaOffset := int(r.u32())
+	if b.tag == tagDefineBitsJPEG4 {
+		r.u16() // deblocking filter
+	}
+	data := r.bytes(alphaOffset)
+	if r.err != nil {
+		return nil, fmt.Errorf("bitmap: %w", r.err)
+	}
+	return decodeEmbeddedImage(data, r.rest())
+}
+
+// decodeEmbeddedImage decodes the JPEG, PNG or GIF data of a bitmap tag. For
+// JPEG data, alpha holds the zlib compressed alpha channel if not empty.
+func decodeEmbeddedImage(data []byte, alpha []byte) (*image.RGBA, error) {
+	isJPEG := len(data) >= 2 && data[0] == 0xff && (data[1] == 0xd8 || data[1] == 0xd9)
+	if isJPEG {
+		data = cleanJPEG(data)
+	}
+	var img image.Image
+	var err error
+	if isJPEG {
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	} else {
+		img, _, err = image.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bitmap: %w", err)
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() > maxBitmapSide || bounds.Dy() > maxBitmapSide {
+		return nil, fmt.Errorf("bitmap: %dx%d pixels", bounds.Dx(), bounds.Dy())
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+
+	if isJPEG && len(alpha) > 0 {
+		pixels := bounds.Dx() * bounds.Dy()
+		values, err := inflate(alpha, pixels)
+		if err != nil {
+			return nil, fmt.Errorf("bitmap alpha: %w", err)
+		}
+		for i := 0; i < pixels && i < len(values); i++ {
+			a := uint16(values[i])
+			for c := range 3 {
+				rgba.Pix[i*4+c] = uint8(uint16(rgba.Pix[i*4+c]) * a / 255)
+			}
+			rgba.Pix[i*4+3] = uint8(a)
+		}
+	}
+	return rgba, nil
+}
+
+// cleanJPEG removes the extra start and end of image markers SWF files put
+// between the encoding tables and the image, which the decoder stops at.
+func cleanJPEG(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xff, 0xd8)
+	pos := 0
+	for pos+1 < len(data) {
+		if data[pos] != 0xff {
+			pos++
+			continue
+		}
+		marker := data[pos+1]
+		switch {
+		case marker == 0xd8 || marker == 0xd9 || marker == 0xff:
+			// Dropped: the one start marker is written above.
+			pos += 2
+			if marker == 0xff {
+				pos--
+			}
+		case marker == 0xda:
+			// The entropy coded data runs until the end of the image.
+			return append(out, data[pos:]...)
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			out = append(out, data[pos:pos+2]...)
+			pos += 2
+		default:
+			if pos+4 > len(data) {
+				return out
+			}
+			end := min(pos+2+int(binary.BigEndian.Uint16(data[pos+2:])), len(data))
+			out = append(out, data[pos:end]...)
+			pos = end
+		}
+	}
+	return out
+}
+
+func decodeLossless(data []byte, withAlpha bool) (*image.RGBA, error) {
+	r := &reader{data: data}
+	format := r.u8()
+	width := int(r.u16())
+	height := int(r.u16())
+	var paletteSize int
+	if format == 3 {
+		paletteSize = int(r.u8()) + 1
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("bitmap: %w", r.err)
+	}
+	if width == 0 || height == 0 || width > maxBitmapSide || height > maxBitmapSide {
+		return nil, fmt.Errorf("bitmap: %dx%d pixels", width, height)
+	}
+
+	colorSize := 3
+	if withAlpha {
+		colorSize = 4
+	}
+	var stride int
+	switch {
+	case format == 3:
+		stride = (width + 3) &^ 3
+	case format == 4 && !withAlpha:
+		stride = (width*2 + 3) &^ 3
+	case format == 5:
+		stride = width * 4
+	default:
+		return nil, fmt.Errorf("%w: lossless bitmap format %d", ErrUnsupported, format)
+	}
+
+	values, err := inflate(r.rest(), paletteSize*colorSize+stride*height)
+	if err != nil {
+		return nil, fmt.Errorf("bitmap: %w", err)
+	}
+	if len(values) < paletteSize*colorSize+stride*height {
+		return nil, fmt.Errorf("bitmap: %w", io.ErrUnexpectedEOF)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	palette := values[:paletteSize*colorSize]
+	pixels := values[len(palette):]
+	for y := range height {
+		row := pixels[y*stride:]
+		for x := range width {
+			dst := img.Pix[y*img.Stride+x*4:]
+			switch format {
+			case 3:
+				index := int(row[x])
+				if index >= paletteSize {
+					continue
+				}
+				c := palette[index*colorSize:]
+				copy(dst[:colorSize], c[:colorSize])
+				if !withAlpha {
+					dst[3] = 255
+				}
+			case 4:
+				v := binary.BigEndian.Uint16(row[x*2:])
+				dst[0] = uint8(int(v>>10&0x1f) * 255 / 31)
+				dst[1] = uint8(int(v>>5&0x1f) * 255 / 31)
+				dst[2] = uint8(int(v&0x1f) * 255 / 31)
+				dst[3] = 255
+			case 5:
+				// ARGB, premultiplied like image.RGBA. DefineBitsLossless
+				// has no alpha and a reserved first byte instead.
+				dst[0], dst[1], dst[2], dst[3] = row[x*4+1], row[x*4+2], row[x*4+3], row[x*4]
+				if !withAlpha {
+					dst[3] = 255
+				}
+			}
+			// Broken files can have color values over their alpha.
+			dst[0] = min(dst[0], dst[3])
+			dst[1] = min(dst[1], dst[3])
+			dst[2] = min(dst[2], dst[3])
+		}
+	}
+	return img, nil
+}
+
+// inflate decompresses zlib data, reading at most size bytes.
+func inflate(data []byte, size int) ([]byte, error) {
+	z, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+	out, err := io.ReadAll(io.LimitReader(z, int64(size)))
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return out, nil
+}
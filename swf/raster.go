@@ -0,0 +1,214 @@
+package swf
+
+import (
+	"math"
+	"sort"
+)
+
+type point struct {
+	x, y float64
+}
+
+// rasterizer computes the anti-aliased coverage of closed paths with the
+// nonzero winding rule. Every line adds its signed area to an accumulation
+// buffer and a prefix sum over each row turns it into coverage, the way
+// font rasterizers do it.
+type rasterizer struct {
+	w, h int
+	// acc has two spare columns per row for lines on the right edge.
+	acc        []float32
+	minY, maxY int
+}
+
+func newRasterizer(w, h int) *rasterizer {
+	return &rasterizer{w: w, h: h, acc: make([]float32, (w+2)*h), minY: h, maxY: -1}
+}
+
+func (r *rasterizer) reset() {
+	if r.minY <= r.maxY {
+		clear(r.acc[r.minY*(r.w+2) : (r.maxY+1)*(r.w+2)])
+	}
+	r.minY, r.maxY = r.h, -1
+}
+
+// polygon adds the closed polygon pts.
+func (r *rasterizer) polygon(pts []point) {
+	for i, p := range pts {
+		r.line(p, pts[(i+1)%len(pts)])
+	}
+}
+
+// positivePolygon adds pts with a positive orientation, so that overlapping
+// polygons add up instead of cancelling out.
+func (r *rasterizer) positivePolygon(pts []point) {
+	var area float64
+	for i, p := range pts {
+		q := pts[(i+1)%len(pts)]
+		area += p.x*q.y - q.x*p.y
+	}
+	if area >= 0 {
+		r.polygon(pts)
+		return
+	}
+	for i := len(pts) - 1; i >= 0; i-- {
+		r.line(pts[i], pts[(i+len(pts)-1)%len(pts)])
+	}
+}
+
+// line adds a line. The parts left and right of the image are moved onto its
+// borders, where they still cover the pixels on their right.
+func (r *rasterizer) line(p0, p1 point) {
+	for _, v := range [...]float64{p0.x, p0.y, p1.x, p1.y} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return
+		}
+	}
+	if p0.y == p1.y {
+		return
+	}
+
+	ts := []float64{0, 1}
+	for _, border := range [...]float64{0, float64(r.w)} {
+		if (p0.x-border)*(p1.x-border) < 0 {
+			ts = append(ts, (border-p0.x)/(p1.x-p0.x))
+		}
+	}
+	if len(ts) > 2 {
+		sort.Float64s(ts)
+	}
+	at := func(t float64) point {
+		p := point{p0.x + (p1.x-p0.x)*t, p0.y + (p1.y-p0.y)*t}
+		p.x = min(max(p.x, 0), float64(r.w))
+		return p
+	}
+	for i := 0; i+1 < len(ts); i++ {
+		r.clippedLine(at(ts[i]), at(ts[i+1]))
+	}
+}
+
+// clippedLine adds a line with x between 0 and w.
+func (r *rasterizer) clippedLine(p0, p1 point) {
+	if p0.y == p1.y {
+		return
+	}
+	dir := float32(1)
+	if p0.y > p1.y {
+		dir = -1
+		p0, p1 = p1, p0
+	}
+	if p1.y <= 0 || p0.y >= float64(r.h) {
+		return
+	}
+
+	dxdy := (p1.x - p0.x) / (p1.y - p0.y)
+	x := p0.x
+	if p0.y < 0 {
+		x -= p0.y * dxdy
+	}
+	yStart := max(int(math.Floor(p0.y)), 0)
+	yEnd := min(int(math.Ceil(p1.y)), r.h)
+	r.minY = min(r.minY, yStart)
+	r.maxY = max(r.maxY, yEnd-1)
+	stride := r.w + 2
+
+	for y := yStart; y < yEnd; y++ {
+		row := r.acc[y*stride : (y+1)*stride]
+		dy := min(float64(y+1), p1.y) - max(float64(y), p0.y)
+		xNext := min(max(x+dxdy*dy, 0), float64(r.w))
+		d := float32(dy) * dir
+		x0, x1 := x, xNext
+		if x1 < x0 {
+			x0, x1 = x1, x0
+		}
+		x0Floor := math.Floor(x0)
+		x0i := int(x0Floor)
+		x1Ceil := math.Ceil(x1)
+		x1i := int(x1Ceil)
+
+		if x1i <= x0i+1 {
+			// The line stays within one pixel of the row.
+			xmf := float32(0.5*(x+xNext) - x0Floor)
+			row[x0i] += d - d*xmf
+			row[x0i+1] += d * xmf
+		} else {
+			s := float32(1 / (x1 - x0))
+			x0f := float32(x0 - x0Floor)
+			a0 := 0.5 * s * (1 - x0f) * (1 - x0f)
+			x1f := float32(x1 - x1Ceil + 1)
+			am := 0.5 * s * x1f * x1f
+			row[x0i] += d * a0
+			if x1i == x0i+2 {
+				row[x0i+1] += d * (1 - a0 - am)
+			} else {
+				a1 := s * (1.5 - x0f)
+				row[x0i+1] += d * (a1 - a0)
+				for xi := x0i + 2; xi < x1i-1; xi++ {
+					row[xi] += d * s
+				}
+				a2 := a1 + float32(x1i-x0i-3)*s
+				row[x1i-1] += d * (1 - a2 - am)
+			}
+			row[x1i] += d * am
+		}
+		x = xNext
+	}
+}
+
+// coverage calls fn with the coverage of every pixel of the rows the paths
+// touched that is at least partly covered, and resets the rasterizer.
+func (r *rasterizer) coverage(fn func(x, y int, c float32)) {
+	stride := r.w + 2
+	for y := r.minY; y <= r.maxY; y++ {
+		var acc float32
+		row := r.acc[y*stride : y*stride+r.w]
+		for x, v := range row {
+			acc += v
+			c := min(float32(math.Abs(float64(acc))), 1)
+			if c > 1.0/512 {
+				fn(x, y, c)
+			}
+		}
+	}
+	r.reset()
+}
+
+// flattenQuad appends the points of the quadratic curve from p0 through the
+// control point c to p1, without p0.
+func flattenQuad(pts []point, p0, c, p1 point) []point {
+	length := math.Hypot(c.x-p0.x, c.y-p0.y) + math.Hypot(p1.x-c.x, p1.y-c.y)
+	n := int(min(max(math.Ceil(math.Sqrt(length)), 1), 64))
+	for i := 1; i <= n; i++ {
+		t := float64(i) / float64(n)
+		u := 1 - t
+		pts = append(pts, point{
+			u*u*p0.x + 2*u*t*c.x + t*t*p1.x,
+			u*u*p0.y + 2*u*t*c.y + t*t*p1.y,
+		})
+	}
+	return pts
+}
+
+// stroke adds the outline of a polyline of the given width with round joins
+// and caps.
+func (r *rasterizer) stroke(pts []point, width float64) {
+	hw := width / 2
+	for i := 0; i+1 < len(pts); i++ {
+		a, b := pts[i], pts[i+1]
+		length := math.Hypot(b.x-a.x, b.y-a.y)
+		if length == 0 {
+			continue
+		}
+		nx, ny := -(b.y-a.y)/length*hw, (b.x-a.x)/length*hw
+		r.positivePolygon([]point{{a.x + nx, a.y + ny}, {b.x + nx, b.y + ny}, {b.x - nx, b.y - ny}, {a.x - nx, a.y - ny}})
+	}
+
+	segments := int(min(max(hw*4, 8), 64))
+	circle := make([]point, segments)
+	for _, p := range pts {
+		for i := range circle {
+			angle := 2 * math.Pi * float64(i) / float64(segments)
+			circle[i] = point{p.x + hw*math.Cos(angle), p.y + hw*math.Sin(angle)}
+		}
+		r.positivePolygon(circle)
+	}
+}
@@ -0,0 +1,199 @@
+package swf
+
+import (
+	"fmt"
+	"io"
+)
+
+// reader reads the little endian values and bit fields of SWF tags. Like the
+// streams of the other decoders, the first error sticks and every later read
+// returns zero values.
+type reader struct {
+	data []byte
+	pos  int
+	// bit is the number of bits already read from data[pos].
+	bit uint
+	err error
+}
+
+func (r *reader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *reader) align() {
+	if r.bit > 0 {
+		r.bit = 0
+		r.pos++
+	}
+}
+
+func (r *reader) remaining() int {
+	r.align()
+	return max(len(r.data)-r.pos, 0)
+}
+
+func (r *reader) bytes(n int) []byte {
+	r.align()
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data)-r.pos {
+		r.fail(io.ErrUnexpectedEOF)
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *reader) rest() []byte {
+	return r.bytes(r.remaining())
+}
+
+func (r *reader) u8() uint8 {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *reader) u16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return uint16(b[0]) | uint16(b[1])<<8
+}
+
+func (r *reader) i16() int16 {
+	return int16(r.u16())
+}
+
+func (r *reader) u32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+// str reads a NUL terminated string.
+func (r *reader) str() string {
+	r.align()
+	if r.err != nil {
+		return ""
+	}
+	for i := r.pos; i < len(r.data); i++ {
+		if r.data[i] == 0 {
+			s := string(r.data[r.pos:i])
+			r.pos = i + 1
+			return s
+		}
+	}
+	r.fail(fmt.Errorf("unterminated string"))
+	return ""
+}
+
+func (r *reader) ubits(n uint) uint32 {
+	var v uint32
+	for range n {
+		if r.err != nil {
+			return 0
+		}
+		if r.pos >= len(r.data) {
+			r.fail(io.ErrUnexpectedEOF)
+			return 0
+		}
+		v = v<<1 | uint32(r.data[r.pos]>>(7-r.bit)&1)
+		r.bit++
+		if r.bit == 8 {
+			r.bit = 0
+			r.pos++
+		}
+	}
+	return v
+}
+
+func (r *reader) sbits(n uint) int32 {
+	v := r.ubits(n)
+	if n > 0 && n < 32 && v&(1<<(n-1)) != 0 {
+		v |= ^uint32(0) << n
+	}
+	return int32(v)
+}
+
+// fbits reads a signed 16.16 fixed point bit field.
+func (r *reader) fbits(n uint) float64 {
+	return float64(r.sbits(n)) / 65536
+}
+
+func (r *reader) rect() Rect {
+	r.align()
+	n := uint(r.ubits(5))
+	rect := Rect{XMin: r.sbits(n), XMax: r.sbits(n), YMin: r.sbits(n), YMax: r.sbits(n)}
+	r.align()
+	return rect
+}
+
+func (r *reader) matrix() Matrix {
+	r.align()
+	m := Identity
+	if r.ubits(1) == 1 {
+		n := uint(r.ubits(5))
+		m.A = r.fbits(n)
+		m.D = r.fbits(n)
+	}
+	if r.ubits(1) == 1 {
+		n := uint(r.ubits(5))
+		m.B = r.fbits(n)
+		m.C = r.fbits(n)
+	}
+	n := uint(r.ubits(5))
+	m.TX = float64(r.sbits(n))
+	m.TY = float64(r.sbits(n))
+	r.align()
+	return m
+}
+
+func (r *reader) colorTransform(withAlpha bool) ColorTransform {
+	r.align()
+	cx := NoColorTransform
+	hasAdd := r.ubits(1) == 1
+	hasMult := r.ubits(1) == 1
+	n := uint(r.ubits(4))
+	terms := func(v *[4]float64, scale float64) {
+		for i := range 3 {
+			v[i] = float64(r.sbits(n)) / scale
+		}
+		if withAlpha {
+			v[3] = float64(r.sbits(n)) / scale
+		}
+	}
+	if hasMult {
+		terms(&cx.Mult, 256)
+	}
+	if hasAdd {
+		terms(&cx.Add, 255)
+	}
+	r.align()
+	return cx
+}
+
+func (r *reader) rgb() Color {
+	b := r.bytes(3)
+	if b == nil {
+		return Color{}
+	}
+	return Color{R: b[0], G: b[1], B: b[2], A: 255}
+}
+
+func (r *reader) rgba() Color {
+	b := r.bytes(4)
+	if b == nil {
+		return Color{}
+	}
+	return Color{R: b[0], G: b[1], B: b[2], A: b[3]}
+}
@@ -0,0 +1,428 @@
+package swf
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+// maxRenderSize bounds the longer side of rendered images.
+const maxRenderSize = 16384
+
+// maxNesting bounds how deep sprites can contain each other.
+const maxNesting = 32
+
+// Render draws the first frame on a transparent image whose longer side is
+// size pixels. The stage of the movie is the visible area. Movies with an
+// empty first frame, like the libraries Dofus 2 stores its item vectors in,
+// show their exported symbol with the highest character ID instead, framed
+// to its bounds.
+func (m *Movie) Render(size int) (*image.RGBA, error) {
+	if size <= 0 || size > maxRenderSize {
+		return nil, fmt.Errorf("invalid render size %d", size)
+	}
+
+	list := m.Frame
+	xMin, yMin := float64(m.FrameSize.XMin), float64(m.FrameSize.YMin)
+	xMax, yMax := float64(m.FrameSize.XMax), float64(m.FrameSize.YMax)
+	if len(list) == 0 {
+		id, ok := m.mainSymbol()
+		if !ok {
+			return nil, errors.New("the first frame is empty and nothing is exported")
+		}
+		list = []Placement{{Depth: 1, CharacterID: id, Matrix: Identity, ColorTransform: NoColorTransform}}
+		xMin, yMin, xMax, yMax = math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+		m.bounds(list, Identity, 0, func(x, y float64) {
+			xMin, yMin = min(xMin, x), min(yMin, y)
+			xMax, yMax = max(xMax, x), max(yMax, y)
+		})
+	}
+	width, height := xMax-xMin, yMax-yMin
+	if !(width > 0 && height > 0) || math.IsInf(width, 0) || math.IsInf(height, 0) {
+		return nil, errors.New("nothing to render: the visible area is empty")
+	}
+
+	scale := float64(size) / max(width, height)
+	img := image.NewRGBA(image.Rect(0, 0, max(int(math.Round(width*scale)), 1), max(int(math.Round(height*scale)), 1)))
+	r := &renderer{movie: m, raster: newRasterizer(img.Rect.Dx(), img.Rect.Dy())}
+	base := Matrix{A: scale, D: scale, TX: -xMin * scale, TY: -yMin * scale}
+	if err := r.drawList(img, list, base, NoColorTransform, 0); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+func (m *Movie) mainSymbol() (uint16, bool) {
+	var id uint16
+	found := false
+	for _, symbolID := range m.Symbols {
+		if symbolID != 0 && (!found || symbolID > id) {
+			id, found = symbolID, true
+		}
+	}
+	return id, found
+}
+
+// bounds calls fn with the corners of the bounds of every shape in list.
+func (m *Movie) bounds(list []Placement, matrix Matrix, nesting int, fn func(x, y float64)) {
+	if nesting > maxNesting {
+		return
+	}
+	for _, p := range list {
+		pm := matrix.Mul(p.Matrix)
+		if s, ok := m.shapes[p.CharacterID]; ok {
+			b := s.bounds
+			for _, corner := range [][2]int32{{b.XMin, b.YMin}, {b.XMax, b.YMin}, {b.XMin, b.YMax}, {b.XMax, b.YMax}} {
+				fn(pm.Apply(float64(corner[0]), float64(corner[1])))
+			}
+		} else if s, ok := m.sprites[p.CharacterID]; ok {
+			m.bounds(s.frame, pm, nesting+1, fn)
+		}
+	}
+}
+
+type renderer struct {
+	movie  *Movie
+	raster *rasterizer
+	// mask makes every fill opaque while a clipping mask is drawn.
+	mask bool
+}
+
+func (r *renderer) drawList(dst *image.RGBA, list []Placement, matrix Matrix, cx ColorTransform, nesting int) error {
+	if nesting > maxNesting {
+		return fmt.Errorf("sprites nested more than %d levels", maxNesting)
+	}
+	for i := 0; i < len(list); i++ {
+		p := list[i]
+		pm := matrix.Mul(p.Matrix)
+		pcx := cx.Mul(p.ColorTransform)
+		if p.ClipDepth == 0 {
+			if err := r.drawCharacter(dst, p.CharacterID, pm, pcx, nesting); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// A mask clips the placements above it up to its clip depth.
+		end := i + 1
+		for end < len(list) && list[end].Depth <= p.ClipDepth {
+			end++
+		}
+		mask := image.NewRGBA(dst.Rect)
+		wasMask := r.mask
+		r.mask = true
+		err := r.drawCharacter(mask, p.CharacterID, pm, pcx, nesting)
+		r.mask = wasMask
+		if err != nil {
+			return err
+		}
+		content := image.NewRGBA(dst.Rect)
+		if err := r.drawList(content, list[i+1:end], matrix, cx, nesting); err != nil {
+			return err
+		}
+		for j := 0; j < len(content.Pix); j += 4 {
+			a := float64(mask.Pix[j+3]) / 255
+			if a == 0 || content.Pix[j+3] == 0 {
+				continue
+			}
+			src := [4]float64{}
+			for c := range 4 {
+				src[c] = float64(content.Pix[j+c]) / 255 * a
+			}
+			blend(dst.Pix[j:j+4], src, 1)
+		}
+		i = end - 1
+	}
+	return nil
+}
+
+func (r *renderer) drawCharacter(dst *image.RGBA, id uint16, matrix Matrix, cx ColorTransform, nesting int) error {
+	m := r.movie
+	if s, ok := m.shapes[id]; ok {
+		return r.drawShape(dst, s, matrix, cx)
+	}
+	if s, ok := m.sprites[id]; ok {
+		return r.drawList(dst, s.frame, matrix, cx, nesting+1)
+	}
+	if name, ok := m.unsupported[id]; ok {
+		return fmt.Errorf("%w: %s", ErrUnsupported, name)
+	}
+	if _, ok := m.bitmaps[id]; ok {
+		return fmt.Errorf("%w: placed bitmap %d", ErrUnsupported, id)
+	}
+	return fmt.Errorf("character %d is not defined", id)
+}
+
+func (r *renderer) drawShape(dst *image.RGBA, s *shape, matrix Matrix, cx ColorTransform) error {
+	for _, group := range s.groups {
+		for i, fill := range group.fills {
+			index := i + 1
+			var pts []point
+			for _, e := range group.edges {
+				if e.fill1 != index && e.fill0 != index {
+					continue
+				}
+				pts = e.points(pts[:0], matrix)
+				// The fill is on the left of edges that use it as fill 0, so
+				// these run backwards to wind like the others.
+				if e.fill1 == index {
+					for k := 0; k+1 < len(pts); k++ {
+						r.raster.line(pts[k], pts[k+1])
+					}
+				}
+				if e.fill0 == index {
+					for k := len(pts) - 1; k > 0; k-- {
+						r.raster.line(pts[k], pts[k-1])
+					}
+				}
+			}
+			if err := r.paint(dst, &fill, matrix, cx); err != nil {
+				return err
+			}
+		}
+
+		for i, line := range group.lines {
+			index := i + 1
+			width := max(float64(line.width)*matrix.scale(), 1)
+			var pts []point
+			for _, e := range group.edges {
+				if e.line == index {
+					pts = e.points(pts[:0], matrix)
+					r.raster.stroke(pts, width)
+				}
+			}
+			fill := line.fill
+			if fill == nil {
+				fill = &fillStyle{kind: fillSolid, color: line.color}
+			}
+			if err := r.paint(dst, fill, matrix, cx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// points appends the edge in image space, flattening curves.
+func (e edge) points(pts []point, matrix Matrix) []point {
+	var p0, p1 point
+	p0.x, p0.y = matrix.Apply(e.x0, e.y0)
+	p1.x, p1.y = matrix.Apply(e.x1, e.y1)
+	pts = append(pts, p0)
+	if !e.curve {
+		return append(pts, p1)
+	}
+	var c point
+	c.x, c.y = matrix.Apply(e.cx, e.cy)
+	return flattenQuad(pts, p0, c, p1)
+}
+
+// paint fills the paths added to the rasterizer with fill.
+func (r *renderer) paint(dst *image.RGBA, fill *fillStyle, matrix Matrix, cx ColorTransform) error {
+	color, err := r.paintFunc(fill, matrix, cx)
+	if err != nil {
+		r.raster.reset()
+		return err
+	}
+	if r.mask {
+		color = func(x, y float64) [4]float64 { return [4]float64{1, 1, 1, 1} }
+	}
+	if color == nil {
+		r.raster.reset()
+		return nil
+	}
+	r.raster.coverage(func(x, y int, c float32) {
+		i := dst.PixOffset(x, y)
+		blend(dst.Pix[i:i+4], color(float64(x)+0.5, float64(y)+0.5), float64(c))
+	})
+	return nil
+}
+
+// paintFunc returns the premultiplied color of fill at each point of the
+// image, or nil when nothing is visible.
+func (r *renderer) paintFunc(fill *fillStyle, matrix Matrix, cx ColorTransform) (func(x, y float64) [4]float64, error) {
+	switch fill.kind {
+	case fillSolid:
+		c := premultiply(cx.apply(straight(fill.color)))
+		return func(x, y float64) [4]float64 { return c }, nil
+
+	case fillLinearGradient, fillRadialGradient, fillFocalGradient:
+		inverse, ok := matrix.Mul(fill.matrix).Invert()
+		if !ok || len(fill.gradient.stops) == 0 {
+			return nil, nil
+		}
+		lut := fill.gradient.lookupTable(cx)
+		spread := fill.gradient.spread
+		focal := fill.gradient.focal * 16384
+		kind := fill.kind
+		return func(x, y float64) [4]float64 {
+			gx, gy := inverse.Apply(x, y)
+			var t float64
+			switch kind {
+			case fillLinearGradient:
+				t = (gx + 16384) / 32768
+			case fillRadialGradient:
+				t = math.Hypot(gx, gy) / 16384
+			default:
+				t = focalRatio(gx, gy, focal)
+			}
+			switch spread {
+			case 1:
+				t = math.Mod(math.Abs(t), 2)
+				if t > 1 {
+					t = 2 - t
+				}
+			case 2:
+				t -= math.Floor(t)
+			}
+			return lut[int(min(max(t, 0), 1)*255+0.5)]
+		}, nil
+
+	case fillRepeatBitmap, fillClippedBitmap, fillRepeatBitmapNS, fillClippedBitmapNS:
+		b, ok := r.movie.bitmaps[fill.bitmapID]
+		if !ok {
+			// Flash uses the ID 65535 for fills without a bitmap.
+			return nil, nil
+		}
+		img, err := b.image()
+		if err != nil {
+			return nil, err
+		}
+		inverse, ok := matrix.Mul(fill.matrix).Invert()
+		if !ok {
+			return nil, nil
+		}
+		repeat := fill.kind == fillRepeatBitmap || fill.kind == fillRepeatBitmapNS
+		smooth := fill.kind == fillRepeatBitmap || fill.kind == fillClippedBitmap
+		identity := cx == NoColorTransform
+		return func(x, y float64) [4]float64 {
+			u, v := inverse.Apply(x, y)
+			var c [4]float64
+			if smooth {
+				c = sampleBilinear(img, u-0.5, v-0.5, repeat)
+			} else {
+				c = sampleNearest(img, int(math.Floor(u)), int(math.Floor(v)), repeat)
+			}
+			if identity {
+				return c
+			}
+			return premultiply(cx.apply(unpremultiply(c)))
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: fill style 0x%02x", ErrUnsupported, fill.kind)
+}
+
+// focalRatio returns the gradient position of (x, y) for a radial gradient
+// whose focal point is at (focal, 0): the distance to the focal point
+// relative to the distance between the focal point and the circle of radius
+// 16384 in the same direction.
+func focalRatio(x, y, focal float64) float64 {
+	dx, dy := x-focal, y
+	dd := dx*dx + dy*dy
+	if dd == 0 {
+		return 0
+	}
+	fd := focal * dx
+	s := (-fd + math.Sqrt(max(fd*fd-dd*(focal*focal-16384*16384), 0))) / dd
+	if s <= 0 {
+		return 1
+	}
+	return 1 / s
+}
+
+// lookupTable returns the premultiplied colors of the gradient for the
+// ratios 0 to 255.
+func (g *gradient) lookupTable(cx ColorTransform) *[256][4]float64 {
+	var lut [256][4]float64
+	stops := g.stops
+	k := 0
+	for ratio := range 256 {
+		for k+1 < len(stops) && int(stops[k+1].ratio) < ratio {
+			k++
+		}
+		var c [4]float64
+		switch {
+		case ratio <= int(stops[0].ratio):
+			c = straight(stops[0].color)
+		case k+1 >= len(stops):
+			c = straight(stops[len(stops)-1].color)
+		default:
+			a, b := stops[k], stops[k+1]
+			t := 0.0
+			if b.ratio > a.ratio {
+				t = (float64(ratio) - float64(a.ratio)) / (float64(b.ratio) - float64(a.ratio))
+			}
+			ca, cb := straight(a.color), straight(b.color)
+			for i := range 4 {
+				c[i] = ca[i] + (cb[i]-ca[i])*t
+			}
+		}
+		lut[ratio] = premultiply(cx.apply(c))
+	}
+	return &lut
+}
+
+func sampleNearest(img *image.RGBA, x, y int, repeat bool) [4]float64 {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if repeat {
+		x, y = ((x%w)+w)%w, ((y%h)+h)%h
+	} else {
+		x, y = min(max(x, 0), w-1), min(max(y, 0), h-1)
+	}
+	i := y*img.Stride + x*4
+	return [4]float64{
+		float64(img.Pix[i]) / 255,
+		float64(img.Pix[i+1]) / 255,
+		float64(img.Pix[i+2]) / 255,
+		float64(img.Pix[i+3]) / 255,
+	}
+}
+
+func sampleBilinear(img *image.RGBA, u, v float64, repeat bool) [4]float64 {
+	if math.IsNaN(u) || math.IsNaN(v) || math.Abs(u) > 1<<30 || math.Abs(v) > 1<<30 {
+		return [4]float64{}
+	}
+	x0, y0 := math.Floor(u), math.Floor(v)
+	fx, fy := u-x0, v-y0
+	ix, iy := int(x0), int(y0)
+	var c [4]float64
+	for _, s := range [...]struct {
+		dx, dy int
+		weight float64
+	}{{0, 0, (1 - fx) * (1 - fy)}, {1, 0, fx * (1 - fy)}, {0, 1, (1 - fx) * fy}, {1, 1, fx * fy}} {
+		if s.weight == 0 {
+			continue
+		}
+		p := sampleNearest(img, ix+s.dx, iy+s.dy, repeat)
+		for i := range 4 {
+			c[i] += p[i] * s.weight
+		}
+	}
+	return c
+}
+
+// blend draws the premultiplied color src with the given coverage over the
+// RGBA pixel dst.
+func blend(dst []byte, src [4]float64, coverage float64) {
+	inverse := 1 - src[3]*coverage
+	for i := range 4 {
+		v := src[i]*coverage*255 + float64(dst[i])*inverse
+		dst[i] = uint8(min(max(v+0.5, 0), 255))
+	}
+}
+
+func straight(c Color) [4]float64 {
+	return [4]float64{float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255, float64(c.A) / 255}
+}
+
+func premultiply(c [4]float64) [4]float64 {
+	return [4]float64{c[0] * c[3], c[1] * c[3], c[2] * c[3], c[3]}
+}
+
+func unpremultiply(c [4]float64) [4]float64 {
+	if c[3] == 0 {
+		return [4]float64{}
+	}
+	return [4]float64{c[0] / c[3], c[1] / c[3], c[2] / c[3], c[3]}
+}
@@ -0,0 +1,237 @@
+package swf
+
+import "fmt"
+
+const (
+	fillSolid           = 0x00
+	fillLinearGradient  = 0x10
+	fillRadialGradient  = 0x12
+	fillFocalGradient   = 0x13
+	fillRepeatBitmap    = 0x40
+	fillClippedBitmap   = 0x41
+	fillRepeatBitmapNS  = 0x42
+	fillClippedBitmapNS = 0x43
+)
+
+type fillStyle struct {
+	kind  uint8
+	color Color
+	// matrix maps the gradient square or the bitmap pixels to shape space.
+	matrix   Matrix
+	gradient gradient
+	bitmapID uint16
+}
+
+type gradient struct {
+	// spread is 0 for pad, 1 for reflect and 2 for repeat.
+	spread uint8
+	stops  []gradientStop
+	// focal is the focal point of focal radial gradients, from -1 to 1.
+	focal float64
+}
+
+type gradientStop struct {
+	ratio uint8
+	color Color
+}
+
+type lineStyle struct {
+	// width is in twips.
+	width uint16
+	color Color
+	// fill is set when a DefineShape4 line is painted with a fill style.
+	fill *fillStyle
+}
+
+// edge is a straight or quadratic edge in twips. The style indexes start at
+// 1, 0 meaning no style.
+type edge struct {
+	x0, y0, cx, cy, x1, y1 float64
+	curve                  bool
+	fill0, fill1, line     int
+}
+
+// styleGroup holds the edges that use one set of styles. A shape starts a new
+// group every time it defines new styles, and later groups are drawn on top.
+type styleGroup struct {
+	fills []fillStyle
+	lines []lineStyle
+	edges []edge
+}
+
+type shape struct {
+	id     uint16
+	bounds Rect
+	groups []styleGroup
+}
+
+func readShape(tag *reader, code uint16) (*shape, error) {
+	version := map[uint16]int{tagDefineShape: 1, tagDefineShape2: 2, tagDefineShape3: 3, tagDefineShape4: 4}[code]
+	s := &shape{id: tag.u16(), bounds: tag.rect()}
+	if version == 4 {
+		tag.rect() // edge bounds
+		tag.u8()   // flags
+	}
+
+	group, err := readStyles(tag, version)
+	if err != nil {
+		return nil, err
+	}
+	fillBits := uint(tag.ubits(4))
+	lineBits := uint(tag.ubits(4))
+
+	var x, y float64
+	var fill0, fill1, line int
+	for tag.err == nil {
+		if tag.ubits(1) == 0 {
+			flags := tag.ubits(5)
+			if flags == 0 {
+				break
+			}
+			if flags&0x10 != 0 && version >= 2 {
+				// The new styles replace the old ones for the indexes set in
+				// this record and the following edges.
+				s.groups = append(s.groups, group)
+				fill0, fill1, line = 0, 0, 0
+			}
+			if flags&0x01 != 0 {
+				n := uint(tag.ubits(5))
+				x = float64(tag.sbits(n))
+				y = float64(tag.sbits(n))
+			}
+			if flags&0x02 != 0 {
+				fill0 = int(tag.ubits(fillBits))
+			}
+			if flags&0x04 != 0 {
+				fill1 = int(tag.ubits(fillBits))
+			}
+			if flags&0x08 != 0 {
+				line = int(tag.ubits(lineBits))
+			}
+			if flags&0x10 != 0 && version >= 2 {
+				group, err = readStyles(tag, version)
+				if err != nil {
+					return nil, err
+				}
+				fillBits = uint(tag.ubits(4))
+				lineBits = uint(tag.ubits(4))
+			}
+			continue
+		}
+
+		e := edge{x0: x, y0: y, fill0: fill0, fill1: fill1, line: line}
+		n := uint(tag.ubits(4)) + 2
+		if tag.ubits(1) == 1 {
+			var dx, dy int32
+			if tag.ubits(1) == 1 {
+				dx = tag.sbits(n)
+				dy = tag.sbits(n)
+			} else if tag.ubits(1) == 1 {
+				dy = tag.sbits(n)
+			} else {
+				dx = tag.sbits(n)
+			}
+			x += float64(dx)
+			y += float64(dy)
+		} else {
+			e.curve = true
+			e.cx = x + float64(tag.sbits(n))
+			e.cy = y + float64(tag.sbits(n))
+			x = e.cx + float64(tag.sbits(n))
+			y = e.cy + float64(tag.sbits(n))
+		}
+		e.x1, e.y1 = x, y
+		if fill0 != 0 || fill1 != 0 || line != 0 {
+			group.edges = append(group.edges, e)
+		}
+	}
+	s.groups = append(s.groups, group)
+	return s, tag.err
+}
+
+func readStyles(tag *reader, version int) (styleGroup, error) {
+	var group styleGroup
+	count := int(tag.u8())
+	if count == 0xff && version >= 2 {
+		count = int(tag.u16())
+	}
+	for i := 0; i < count && tag.err == nil; i++ {
+		fill, err := readFillStyle(tag, version)
+		if err != nil {
+			return group, err
+		}
+		group.fills = append(group.fills, fill)
+	}
+
+	count = int(tag.u8())
+	if count == 0xff {
+		count = int(tag.u16())
+	}
+	for i := 0; i < count && tag.err == nil; i++ {
+		line := lineStyle{width: tag.u16()}
+		if version < 4 {
+			line.color = readColor(tag, version)
+			group.lines = append(group.lines, line)
+			continue
+		}
+
+		tag.ubits(2) // start cap
+		join := tag.ubits(2)
+		hasFill := tag.ubits(1) == 1
+		tag.ubits(3) // no horizontal and vertical scale, pixel hinting
+		tag.ubits(5) // reserved
+		tag.ubits(1) // no close
+		tag.ubits(2) // end cap
+		if join == 2 {
+			tag.u16() // miter limit
+		}
+		if hasFill {
+			fill, err := readFillStyle(tag, version)
+			if err != nil {
+				return group, err
+			}
+			line.fill = &fill
+		} else {
+			line.color = tag.rgba()
+		}
+		group.lines = append(group.lines, line)
+	}
+	return group, tag.err
+}
+
+func readFillStyle(tag *reader, version int) (fillStyle, error) {
+	fill := fillStyle{kind: tag.u8()}
+	switch fill.kind {
+	case fillSolid:
+		fill.color = readColor(tag, version)
+	case fillLinearGradient, fillRadialGradient, fillFocalGradient:
+		fill.matrix = tag.matrix()
+		fill.gradient.spread = uint8(tag.ubits(2))
+		tag.ubits(2) // interpolation mode, always drawn in sRGB
+		count := int(tag.ubits(4))
+		for range count {
+			stop := gradientStop{ratio: tag.u8()}
+			stop.color = readColor(tag, version)
+			fill.gradient.stops = append(fill.gradient.stops, stop)
+		}
+		if fill.kind == fillFocalGradient {
+			fill.gradient.focal = min(max(float64(tag.i16())/256, -1), 1)
+		}
+	case fillRepeatBitmap, fillClippedBitmap, fillRepeatBitmapNS, fillClippedBitmapNS:
+		fill.bitmapID = tag.u16()
+		fill.matrix = tag.matrix()
+	default:
+		if tag.err == nil {
+			return fill, fmt.Errorf("%w: fill style 0x%02x", ErrUnsupported, fill.kind)
+		}
+	}
+	return fill, tag.err
+}
+
+// readColor reads RGB in DefineShape and DefineShape2 and RGBA after.
+func readColor(tag *reader, version int) Color {
+	if version >= 3 {
+		return tag.rgba()
+	}
+	return tag.rgb()
+}
@@ -0,0 +1,412 @@
+// Package swf decodes the vector shapes of SWF files and renders the first
+// frame to an image without Flash or a browser. It covers the subset used by
+// the Dofus 2 item, mount and emote vectors: DefineShape 1 to 4 with solid,
+// gradient and bitmap fills, lossless and JPEG bitmaps, sprites and masks.
+// Text, morph shapes, buttons, filters and blend modes are not supported.
+package swf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// ErrUnsupported is returned when the first frame uses a feature the renderer
+// does not implement.
+var ErrUnsupported = errors.New("unsupported SWF feature")
+
+// maxFileLength bounds the decompressed size taken from the header.
+const maxFileLength = 256 << 20
+
+const (
+	tagEnd                 = 0
+	tagShowFrame           = 1
+	tagDefineShape         = 2
+	tagPlaceObject         = 4
+	tagRemoveObject        = 5
+	tagDefineBits          = 6
+	tagDefineButton        = 7
+	tagJPEGTables          = 8
+	tagDefineText          = 11
+	tagDoAction            = 12
+	tagDefineBitsLossless  = 20
+	tagDefineBitsJPEG2     = 21
+	tagDefineShape2        = 22
+	tagPlaceObject2        = 26
+	tagRemoveObject2       = 28
+	tagDefineShape3        = 32
+	tagDefineText2         = 33
+	tagDefineButton2       = 34
+	tagDefineBitsJPEG3     = 35
+	tagDefineBitsLossless2 = 36
+	tagDefineEditText      = 37
+	tagDefineSprite        = 39
+	tagDefineMorphShape    = 46
+	tagExportAssets        = 56
+	tagDefineVideoStream   = 60
+	tagPlaceObject3        = 70
+	tagSymbolClass         = 76
+	tagDefineShape4        = 83
+	tagDefineMorphShape2   = 84
+	tagDefineBitsJPEG4     = 90
+)
+
+// unsupportedTags names the character definitions that can be placed but not
+// rendered.
+var unsupportedTags = map[uint16]string{
+	tagDefineButton:      "DefineButton",
+	tagDefineText:        "DefineText",
+	tagDefineText2:       "DefineText2",
+	tagDefineButton2:     "DefineButton2",
+	tagDefineEditText:    "DefineEditText",
+	tagDefineMorphShape:  "DefineMorphShape",
+	tagDefineVideoStream: "DefineVideoStream",
+	tagDefineMorphShape2: "DefineMorphShape2",
+}
+
+// Rect is a rectangle in twips, 1/20 of a pixel.
+type Rect struct {
+	XMin, XMax, YMin, YMax int32
+}
+
+// Matrix is an affine transform. A point (x, y) maps to
+// (A*x + C*y + TX, B*x + D*y + TY).
+type Matrix struct {
+	A, B, C, D, TX, TY float64
+}
+
+// Identity is the matrix that keeps points in place.
+var Identity = Matrix{A: 1, D: 1}
+
+// Mul returns the transform that applies n and then m.
+func (m Matrix) Mul(n Matrix) Matrix {
+	return Matrix{
+		A:  m.A*n.A + m.C*n.B,
+		B:  m.B*n.A + m.D*n.B,
+		C:  m.A*n.C + m.C*n.D,
+		D:  m.B*n.C + m.D*n.D,
+		TX: m.A*n.TX + m.C*n.TY + m.TX,
+		TY: m.B*n.TX + m.D*n.TY + m.TY,
+	}
+}
+
+// Apply transforms the point (x, y).
+func (m Matrix) Apply(x, y float64) (float64, float64) {
+	return m.A*x + m.C*y + m.TX, m.B*x + m.D*y + m.TY
+}
+
+// Invert returns the inverse transform. ok is false when m collapses the
+// plane to a line or a point.
+func (m Matrix) Invert() (inverse Matrix, ok bool) {
+	det := m.A*m.D - m.B*m.C
+	if det == 0 || math.IsNaN(det) || math.IsInf(det, 0) {
+		return Matrix{}, false
+	}
+	return Matrix{
+		A:  m.D / det,
+		B:  -m.B / det,
+		C:  -m.C / det,
+		D:  m.A / det,
+		TX: (m.C*m.TY - m.D*m.TX) / det,
+		TY: (m.B*m.TX - m.A*m.TY) / det,
+	}, true
+}
+
+// scale is the average factor m scales lengths by.
+func (m Matrix) scale() float64 {
+	return math.Sqrt(math.Abs(m.A*m.D - m.B*m.C))
+}
+
+// Color is a straight alpha RGBA color.
+type Color struct {
+	R, G, B, A uint8
+}
+
+// ColorTransform multiplies and then offsets the red, green, blue and alpha
+// channels, all in the range 0 to 1.
+type ColorTransform struct {
+	Mult [4]float64
+	Add  [4]float64
+}
+
+// NoColorTransform keeps colors unchanged.
+var NoColorTransform = ColorTransform{Mult: [4]float64{1, 1, 1, 1}}
+
+// Mul returns the color transform that applies n and then cx.
+func (cx ColorTransform) Mul(n ColorTransform) ColorTransform {
+	var out ColorTransform
+	for i := range 4 {
+		out.Mult[i] = cx.Mult[i] * n.Mult[i]
+		out.Add[i] = cx.Mult[i]*n.Add[i] + cx.Add[i]
+	}
+	return out
+}
+
+// apply transforms the straight alpha color c with channels from 0 to 1.
+func (cx ColorTransform) apply(c [4]float64) [4]float64 {
+	for i := range 4 {
+		c[i] = min(max(c[i]*cx.Mult[i]+cx.Add[i], 0), 1)
+	}
+	return c
+}
+
+// Placement is a character on the display list of a frame.
+type Placement struct {
+	Depth          uint16
+	CharacterID    uint16
+	Matrix         Matrix
+	ColorTransform ColorTransform
+	// ClipDepth is not 0 when the character masks the placements up to that
+	// depth.
+	ClipDepth uint16
+}
+
+// Movie is a decoded SWF file.
+type Movie struct {
+	Version    int
+	FrameSize  Rect
+	FrameRate  float64
+	FrameCount int
+	// Frame is the display list of the first frame, sorted by depth.
+	Frame []Placement
+	// Symbols maps the class and export names of the SymbolClass and
+	// ExportAssets tags to character IDs.
+	Symbols map[string]uint16
+	// Actions holds the AVM1 bytecode of the DoAction tags of the main
+	// timeline in file order.
+	Actions [][]byte
+
+	shapes      map[uint16]*shape
+	sprites     map[uint16]*sprite
+	bitmaps     map[uint16]*bitmap
+	unsupported map[uint16]string
+	jpegTables  []byte
+}
+
+type sprite struct {
+	frame []Placement
+}
+
+// Decode reads a SWF file.
+func Decode(r io.Reader) (*Movie, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("invalid SWF file: %w", err)
+	}
+	fileLength := int64(binary.LittleEndian.Uint32(header[4:]))
+	if fileLength < 8 || fileLength > maxFileLength {
+		return nil, fmt.Errorf("invalid SWF file: length %d", fileLength)
+	}
+	body := io.LimitReader(r, fileLength-8)
+
+	switch string(header[:3]) {
+	case "FWS":
+	case "CWS":
+		z, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SWF file: %w", err)
+		}
+		defer z.Close()
+		body = io.LimitReader(z, fileLength-8)
+	case "ZWS":
+		// The compressed length is followed by the LZMA properties. The
+		// classic .lzma header needs the uncompressed size after them, which
+		// is left unknown so that streams with and without end marker work.
+		props := make([]byte, 9)
+		if _, err := io.ReadFull(r, props); err != nil {
+			return nil, fmt.Errorf("invalid SWF file: %w", err)
+		}
+		lzmaHeader := append(props[4:9:9], 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+		// The decoder allocates the whole dictionary up front, and one larger
+		// than the movie is never used.
+		if dictSize := binary.LittleEndian.Uint32(lzmaHeader[1:5]); int64(dictSize) > fileLength {
+			binary.LittleEndian.PutUint32(lzmaHeader[1:5], uint32(max(fileLength, 4096)))
+		}
+		z, err := lzma.NewReader(io.MultiReader(bytes.NewReader(lzmaHeader), r))
+		if err != nil {
+			return nil, fmt.Errorf("invalid SWF file: %w", err)
+		}
+		body = io.LimitReader(z, fileLength-8)
+	default:
+		return nil, fmt.Errorf("invalid SWF file: unknown signature %q", header[:3])
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("invalid SWF file: %w", err)
+	}
+
+	m := &Movie{
+		Version:     int(header[3]),
+		Symbols:     make(map[string]uint16),
+		shapes:      make(map[uint16]*shape),
+		sprites:     make(map[uint16]*sprite),
+		bitmaps:     make(map[uint16]*bitmap),
+		unsupported: make(map[uint16]string),
+	}
+	rd := &reader{data: data}
+	m.FrameSize = rd.rect()
+	m.FrameRate = float64(rd.u16()) / 256
+	m.FrameCount = int(rd.u16())
+	if rd.err != nil {
+		return nil, fmt.Errorf("invalid SWF file: %w", rd.err)
+	}
+
+	frame, err := m.readTimeline(rd, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SWF file: %w", err)
+	}
+	m.Frame = frame
+	return m, nil
+}
+
+// readTimeline reads the tags of the main timeline or of a sprite and
+// returns the display list of its first frame.
+func (m *Movie) readTimeline(rd *reader, depth int) ([]Placement, error) {
+	list := make(map[uint16]Placement)
+	firstFrameDone := false
+	for rd.remaining() > 0 {
+		codeAndLength := rd.u16()
+		code := codeAndLength >> 6
+		length := int(codeAndLength & 0x3f)
+		if length == 0x3f {
+			length = int(rd.u32())
+		}
+		body := rd.bytes(length)
+		if rd.err != nil {
+			// Many files are cut short after their last useful tag.
+			break
+		}
+		if code == tagEnd {
+			break
+		}
+
+		tag := &reader{data: body}
+		var err error
+		switch code {
+		case tagShowFrame:
+			firstFrameDone = true
+		case tagPlaceObject, tagPlaceObject2, tagPlaceObject3:
+			if !firstFrameDone {
+				err = readPlaceObject(tag, code, list)
+			}
+		case tagRemoveObject, tagRemoveObject2:
+			if !firstFrameDone {
+				if code == tagRemoveObject {
+					tag.u16()
+				}
+				delete(list, tag.u16())
+			}
+		case tagDefineShape, tagDefineShape2, tagDefineShape3, tagDefineShape4:
+			var s *shape
+			s, err = readShape(tag, code)
+			if err == nil {
+				m.shapes[s.id] = s
+			}
+		case tagDefineSprite:
+			if depth > 0 {
+				return nil, fmt.Errorf("nested DefineSprite")
+			}
+			id := tag.u16()
+			tag.u16() // frame count
+			var frame []Placement
+			frame, err = m.readTimeline(tag, depth+1)
+			if err == nil {
+				m.sprites[id] = &sprite{frame: frame}
+			}
+		case tagDoAction:
+			if depth == 0 {
+				m.Actions = append(m.Actions, body)
+			}
+		case tagJPEGTables:
+			m.jpegTables = tag.rest()
+		case tagDefineBits, tagDefineBitsJPEG2, tagDefineBitsJPEG3, tagDefineBitsJPEG4, tagDefineBitsLossless, tagDefineBitsLossless2:
+			id := tag.u16()
+			m.bitmaps[id] = &bitmap{tag: code, data: tag.rest(), jpegTables: m.jpegTables}
+		case tagSymbolClass, tagExportAssets:
+			count := int(tag.u16())
+			for i := 0; i < count && tag.err == nil; i++ {
+				id := tag.u16()
+				name := tag.str()
+				if tag.err == nil {
+					m.Symbols[name] = id
+				}
+			}
+		default:
+			if name, ok := unsupportedTags[code]; ok && len(body) >= 2 {
+				m.unsupported[binary.LittleEndian.Uint16(body)] = name
+			}
+		}
+		if err == nil {
+			err = tag.err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tag %d: %w", code, err)
+		}
+	}
+
+	frame := make([]Placement, 0, len(list))
+	for _, p := range list {
+		frame = append(frame, p)
+	}
+	sort.Slice(frame, func(i, j int) bool { return frame[i].Depth < frame[j].Depth })
+	return frame, nil
+}
+
+func readPlaceObject(tag *reader, code uint16, list map[uint16]Placement) error {
+	if code == tagPlaceObject {
+		p := Placement{CharacterID: tag.u16(), Depth: tag.u16(), ColorTransform: NoColorTransform}
+		p.Matrix = tag.matrix()
+		if tag.remaining() > 0 {
+			p.ColorTransform = tag.colorTransform(false)
+		}
+		list[p.Depth] = p
+		return nil
+	}
+
+	flags := tag.u8()
+	var flags2 uint8
+	if code == tagPlaceObject3 {
+		flags2 = tag.u8()
+	}
+	depth := tag.u16()
+	if flags2&0x08 != 0 || (flags2&0x10 != 0 && flags&0x02 != 0) {
+		tag.str() // class name
+	}
+
+	p, exists := list[depth]
+	move := flags&0x01 != 0
+	if !move || !exists {
+		p = Placement{Depth: depth, Matrix: Identity, ColorTransform: NoColorTransform}
+	}
+	if flags&0x02 != 0 {
+		p.CharacterID = tag.u16()
+	} else if !exists {
+		// Modifying an empty depth does nothing.
+		return nil
+	}
+	if flags&0x04 != 0 {
+		p.Matrix = tag.matrix()
+	}
+	if flags&0x08 != 0 {
+		p.ColorTransform = tag.colorTransform(true)
+	}
+	if flags&0x10 != 0 {
+		tag.u16() // ratio
+	}
+	if flags&0x20 != 0 {
+		tag.str() // name
+	}
+	if flags&0x40 != 0 {
+		p.ClipDepth = tag.u16()
+	}
+	list[depth] = p
+	return nil
+}
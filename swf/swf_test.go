@@ -0,0 +1,336 @@
+package swf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"testing"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// bitWriter builds the bit fields of test files.
+type bitWriter struct {
+	buf []byte
+	// n is the number of bits used in the last byte.
+	n uint
+}
+
+func (w *bitWriter) bits(v uint32, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		if w.n == 0 || w.n == 8 {
+			w.buf = append(w.buf, 0)
+			w.n = 0
+		}
+		w.buf[len(w.buf)-1] |= byte(v>>uint(i)&1) << (7 - w.n)
+		w.n++
+	}
+}
+
+func (w *bitWriter) sbits(v int32, n uint) {
+	w.bits(uint32(v)&(1<<n-1), n)
+}
+
+func (w *bitWriter) align() {
+	w.n = 0
+}
+
+func (w *bitWriter) put(values ...any) {
+	w.align()
+	var b bytes.Buffer
+	for _, v := range values {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	w.buf = append(w.buf, b.Bytes()...)
+}
+
+func (w *bitWriter) rect(xMin, xMax, yMin, yMax int32) {
+	w.align()
+	w.bits(17, 5)
+	for _, v := range []int32{xMin, xMax, yMin, yMax} {
+		w.sbits(v, 17)
+	}
+	w.align()
+}
+
+// matrix writes a scale and translation. A scale of 0 is left out.
+func (w *bitWriter) matrix(scale float64, tx, ty int32) {
+	w.align()
+	if scale != 0 {
+		w.bits(1, 1)
+		w.bits(31, 5)
+		w.sbits(int32(scale*65536), 31)
+		w.sbits(int32(scale*65536), 31)
+	} else {
+		w.bits(0, 1)
+	}
+	w.bits(0, 1)
+	w.bits(17, 5)
+	w.sbits(tx, 17)
+	w.sbits(ty, 17)
+	w.align()
+}
+
+func testTag(code uint16, body []byte) []byte {
+	var w bitWriter
+	w.put(code<<6|0x3f, uint32(len(body)))
+	return append(w.buf, body...)
+}
+
+// testMovie returns an uncompressed SWF file with a 100x100 pixel stage.
+func testMovie(tags ...[]byte) []byte {
+	var body bitWriter
+	body.rect(0, 2000, 0, 2000)
+	body.put(uint16(24<<8), uint16(1))
+	for _, tag := range tags {
+		body.buf = append(body.buf, tag...)
+	}
+	body.buf = append(body.buf, testTag(tagShowFrame, nil)...)
+	body.put(uint16(0))
+
+	var w bitWriter
+	w.buf = append(w.buf, "FWS\x0a"...)
+	w.put(uint32(8 + len(body.buf)))
+	return append(w.buf, body.buf...)
+}
+
+// testRectShape returns a DefineShape3 tag with a rectangle in twips filled
+// with the style fill writes.
+func testRectShape(id uint16, fill func(w *bitWriter), x0, y0, x1, y1 int32) []byte {
+	var w bitWriter
+	w.put(id)
+	w.rect(x0, x1, y0, y1)
+	w.put(uint8(1))
+	fill(&w)
+	w.put(uint8(0))
+	w.bits(1, 4) // fill bits
+	w.bits(0, 4) // line bits
+
+	// Move to the first corner with fill style 1.
+	w.bits(0, 1)
+	w.bits(0b00101, 5)
+	w.bits(17, 5)
+	w.sbits(x0, 17)
+	w.sbits(y0, 17)
+	w.bits(1, 1)
+	for _, d := range [][2]int32{{x1 - x0, 0}, {0, y1 - y0}, {x0 - x1, 0}, {0, y0 - y1}} {
+		w.bits(1, 1)  // edge
+		w.bits(1, 1)  // straight
+		w.bits(15, 4) // 17 bits
+		w.bits(1, 1)  // general line
+		w.sbits(d[0], 17)
+		w.sbits(d[1], 17)
+	}
+	w.bits(0, 6)
+	w.align()
+	return testTag(tagDefineShape3, w.buf)
+}
+
+func solidFill(r, g, b, a uint8) func(w *bitWriter) {
+	return func(w *bitWriter) {
+		w.put(uint8(fillSolid), r, g, b, a)
+	}
+}
+
+func testPlace(id uint16, depth uint16, tx, ty int32) []byte {
+	var w bitWriter
+	w.put(uint8(0x06), depth, id)
+	w.matrix(0, tx, ty)
+	return testTag(tagPlaceObject2, w.buf)
+}
+
+func pixel(img *image.RGBA, x, y int) [4]uint8 {
+	i := img.PixOffset(x, y)
+	return [4]uint8{img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]}
+}
+
+func render(t *testing.T, file []byte, size int) *image.RGBA {
+	t.Helper()
+	m, err := Decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := m.Render(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestRenderSolidShape(t *testing.T) {
+	file := testMovie(
+		testRectShape(1, solidFill(255, 0, 0, 255), 0, 0, 1000, 2000),
+		testPlace(1, 1, 0, 0),
+	)
+	img := render(t, file, 50)
+	if img.Rect.Dx() != 50 || img.Rect.Dy() != 50 {
+		t.Fatalf("size = %v", img.Rect)
+	}
+	if got := pixel(img, 10, 25); got != [4]uint8{255, 0, 0, 255} {
+		t.Errorf("inside = %v", got)
+	}
+	if got := pixel(img, 40, 25); got != [4]uint8{} {
+		t.Errorf("outside = %v", got)
+	}
+
+	// The same movie with a zlib compressed body.
+	var compressed bytes.Buffer
+	compressed.WriteString("CWS")
+	compressed.Write(file[3:8])
+	z := zlib.NewWriter(&compressed)
+	z.Write(file[8:])
+	z.Close()
+	if got := pixel(render(t, compressed.Bytes(), 50), 10, 25); got != [4]uint8{255, 0, 0, 255} {
+		t.Errorf("zlib inside = %v", got)
+	}
+
+	// And with an LZMA compressed body, which drops the size of the classic
+	// .lzma header.
+	var stream bytes.Buffer
+	lw, err := lzma.NewWriter(&stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lw.Write(file[8:])
+	lw.Close()
+	var w bitWriter
+	w.buf = append(w.buf, "ZWS"...)
+	w.buf = append(w.buf, file[3:8]...)
+	w.put(uint32(stream.Len() - 13))
+	w.buf = append(w.buf, stream.Bytes()[:5]...)
+	w.buf = append(w.buf, stream.Bytes()[13:]...)
+	if got := pixel(render(t, w.buf, 50), 10, 25); got != [4]uint8{255, 0, 0, 255} {
+		t.Errorf("lzma inside = %v", got)
+	}
+}
+
+func TestRenderAntiAliasing(t *testing.T) {
+	// The right edge of the rectangle runs through the middle of pixel 10.
+	file := testMovie(
+		testRectShape(1, solidFill(0, 0, 255, 255), 0, 0, 210, 2000),
+		testPlace(1, 1, 0, 0),
+	)
+	img := render(t, file, 100)
+	if got := pixel(img, 10, 50); got[3] < 120 || got[3] > 136 {
+		t.Errorf("edge pixel = %v", got)
+	}
+}
+
+func TestRenderExportedSprite(t *testing.T) {
+	var sprite bitWriter
+	sprite.put(uint16(2), uint16(1))
+	sprite.buf = append(sprite.buf, testPlace(1, 1, 400, 0)...)
+	sprite.buf = append(sprite.buf, testTag(tagShowFrame, nil)...)
+	sprite.put(uint16(0))
+
+	var symbols bitWriter
+	symbols.put(uint16(1), uint16(2))
+	symbols.buf = append(symbols.buf, "Item_1\x00"...)
+
+	// Nothing is placed on the stage, so the exported sprite is framed to
+	// its bounds.
+	file := testMovie(
+		testRectShape(1, solidFill(0, 255, 0, 128), 0, 0, 200, 100),
+		testTag(tagDefineSprite, sprite.buf),
+		testTag(tagSymbolClass, symbols.buf),
+	)
+	img := render(t, file, 20)
+	if img.Rect.Dx() != 20 || img.Rect.Dy() != 10 {
+		t.Fatalf("size = %v", img.Rect)
+	}
+	if got := pixel(img, 5, 5); got != [4]uint8{0, 128, 0, 128} {
+		t.Errorf("pixel = %v", got)
+	}
+}
+
+func TestRenderGradient(t *testing.T) {
+	gradient := func(w *bitWriter) {
+		w.put(uint8(fillLinearGradient))
+		w.matrix(2000.0/32768, 1000, 1000)
+		w.bits(0, 2)
+		w.bits(0, 2)
+		w.bits(2, 4)
+		w.put(uint8(0), uint8(0), uint8(0), uint8(0), uint8(255))
+		w.put(uint8(255), uint8(255), uint8(255), uint8(255), uint8(255))
+	}
+	file := testMovie(
+		testRectShape(1, gradient, 0, 0, 2000, 2000),
+		testPlace(1, 1, 0, 0),
+	)
+	img := render(t, file, 100)
+	left, middle, right := pixel(img, 1, 50), pixel(img, 50, 50), pixel(img, 98, 50)
+	if left[0] > 10 || right[0] < 245 || middle[0] < 120 || middle[0] > 136 || middle[3] != 255 {
+		t.Errorf("gradient = %v %v %v", left, middle, right)
+	}
+}
+
+func TestRenderBitmapFill(t *testing.T) {
+	var pixels bytes.Buffer
+	z := zlib.NewWriter(&pixels)
+	z.Write([]byte{255, 255, 0, 0, 255, 0, 0, 255})
+	z.Close()
+	var bits bitWriter
+	bits.put(uint16(3), uint8(5), uint16(2), uint16(1))
+	bits.buf = append(bits.buf, pixels.Bytes()...)
+
+	// Each bitmap pixel covers half of the stage.
+	fill := func(w *bitWriter) {
+		w.put(uint8(fillClippedBitmapNS), uint16(3))
+		w.matrix(1000, 0, 0)
+	}
+	file := testMovie(
+		testTag(tagDefineBitsLossless2, bits.buf),
+		testRectShape(1, fill, 0, 0, 2000, 2000),
+		testPlace(1, 1, 0, 0),
+	)
+	img := render(t, file, 100)
+	if got := pixel(img, 20, 50); got != [4]uint8{255, 0, 0, 255} {
+		t.Errorf("left = %v", got)
+	}
+	if got := pixel(img, 80, 50); got != [4]uint8{0, 0, 255, 255} {
+		t.Errorf("right = %v", got)
+	}
+}
+
+func TestRenderUnsupported(t *testing.T) {
+	var text bitWriter
+	text.put(uint16(1))
+	file := testMovie(testTag(tagDefineText, text.buf), testPlace(1, 1, 0, 0))
+	m, err := Decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Render(50); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("err = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	file := testMovie(
+		testRectShape(1, solidFill(255, 0, 0, 255), 0, 0, 1000, 2000),
+		testPlace(1, 1, 0, 0),
+	)
+	for n := range len(file) {
+		m, err := Decode(bytes.NewReader(file[:n]))
+		if err == nil {
+			// Files cut between tags still decode.
+			m.Render(10)
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add(testMovie(
+		testRectShape(1, solidFill(255, 0, 0, 255), 0, 0, 1000, 2000),
+		testPlace(1, 1, 0, 0),
+	))
+	f.Fuzz(func(t *testing.T, file []byte) {
+		m, err := Decode(bytes.NewReader(file))
+		if err != nil {
+			return
+		}
+		m.Render(16)
+	})
+}
@@ -0,0 +1,202 @@
+// Package bundle decodes Unity asset bundles (UnityFS) as shipped with
+// Dofus 3. MonoBehaviours are decoded with the type trees stored in the
+// bundle, including managed references, into plain JSON compatible values.
+package bundle
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/kvarenzn/ssm/uni"
+)
+
+const unityClassIDMonoScript = 115
+
+// Bundle is a loaded UnityFS container.
+type Bundle struct {
+	// LegacyFloats rounds normalized floats like experience points to one
+	// decimal, like older doduda versions did. By default they are written
+	// with the shortest representation that parses back to the same value.
+	LegacyFloats bool
+
+	objects []Object
+}
+
+// Object is a serialized object inside a bundle.
+type Object struct {
+	PathID  int64
+	ClassID uni.ClassID
+
+	file *uni.SerializedFile
+	info *uni.ObjectInfo
+}
+
+// Open reads the whole bundle from r, a UnityFS, UnityWeb or UnityRaw
+// container.
+func Open(r io.ReaderAt) (b *Bundle, err error) {
+	defer recoverMalformed(&err)
+	data, err := io.ReadAll(io.NewSectionReader(r, 0, math.MaxInt64))
+	if err != nil {
+		return nil, err
+	}
+
+	assetsManager := uni.NewAssetsManager()
+	if err := LoadAssets(data, "bundle", assetsManager); err != nil {
+		return nil, err
+	}
+
+	b = &Bundle{}
+	for _, assetFile := range assetsManager.AssetFiles {
+		for _, objectInfo := range assetFile.ObjectInfos {
+			b.objects = append(b.objects, Object{
+				PathID:  unityObjectPathID(assetFile, objectInfo),
+				ClassID: objectInfo.ClassID,
+				file:    assetFile,
+				info:    objectInfo,
+			})
+		}
+	}
+
+	return b, nil
+}
+
+// Objects returns all objects of all serialized files in the bundle.
+func (b *Bundle) Objects() []Object {
+	return b.objects
+}
+
+// MonoBehaviours returns the MonoBehaviour objects in bundle order.
+func (b *Bundle) MonoBehaviours() []Object {
+	var monos []Object
+	for _, object := range b.objects {
+		if object.ClassID == uni.ClassIDMonoBehaviour {
+			monos = append(monos, object)
+		}
+	}
+	return monos
+}
+
+// DecodeMonoBehaviour decodes object into maps, slices and scalars that can
+// be passed to json.Marshal.
+func (b *Bundle) DecodeMonoBehaviour(object Object) (_ any, err error) {
+	defer recoverMalformed(&err)
+	reader, err := object.reader()
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, _, err := decodeUnityTypeTree(b.decodeState(object), reader, object.info.SerializedType.Type.Nodes, 0)
+	return decoded, err
+}
+
+// StreamMonoBehaviour writes object as JSON to w while decoding, instead of
+// building the whole value in memory first. The written bytes are identical
+// to json.Marshal applied to the result of DecodeMonoBehaviour.
+func (b *Bundle) StreamMonoBehaviour(object Object, w io.Writer) (err error) {
+	defer recoverMalformed(&err)
+	reader, err := object.reader()
+	if err != nil {
+		return err
+	}
+
+	_, err = streamUnityTypeTree(b.decodeState(object), reader, object.info.SerializedType.Type.Nodes, 0, w)
+	return err
+}
+
+func (b *Bundle) decodeState(object Object) *unityDecodeState {
+	state := newUnityDecodeState(object.file)
+	state.legacyFloats = b.LegacyFloats
+	return state
+}
+
+// ScriptClassName resolves the m_Script pointer of a decoded MonoBehaviour to
+// the class name of the referenced MonoScript. Scripts that live in another
+// serialized file can not be resolved and yield "".
+func (b *Bundle) ScriptClassName(object Object, decoded any) string {
+	asMap, ok := decoded.(map[string]any)
+	if !ok {
+		return ""
+	}
+	script, ok := asMap["m_Script"].(map[string]any)
+	if !ok {
+		return ""
+	}
+	fileID, err := unityToInt(script["m_FileID"])
+	if err != nil || fileID != 0 {
+		return ""
+	}
+	pathID, err := unityToInt(script["m_PathID"])
+	if err != nil {
+		return ""
+	}
+
+	for _, candidate := range b.objects {
+		if candidate.file != object.file || candidate.ClassID != unityClassIDMonoScript || candidate.PathID != int64(pathID) {
+			continue
+		}
+
+		reader, err := candidate.reader()
+		if err != nil {
+			return ""
+		}
+		decoded, _, err := decodeUnityTypeTree(nil, reader, candidate.info.SerializedType.Type.Nodes, 0)
+		if err != nil {
+			return ""
+		}
+		scriptMap, ok := decoded.(map[string]any)
+		if !ok {
+			return ""
+		}
+		className, _ := scriptMap["m_ClassName"].(string)
+		if namespace, _ := scriptMap["m_Namespace"].(string); namespace != "" && className != "" {
+			return namespace + "." + className
+		}
+		return className
+	}
+
+	return ""
+}
+
+// MonoBehaviourName returns the trimmed m_Name of a decoded MonoBehaviour.
+func MonoBehaviourName(decoded any) string {
+	asMap, ok := decoded.(map[string]any)
+	if !ok {
+		return ""
+	}
+	name, _ := asMap["m_Name"].(string)
+	return strings.TrimSpace(name)
+}
+
+// recoverMalformed turns a panic of the uni readers, which trust the lengths
+// stored in the file, into an error.
+func recoverMalformed(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("malformed bundle: %v", r)
+	}
+}
+
+func (o Object) typeTree() ([]*uni.TypeTreeNode, error) {
+	if o.info == nil || o.info.SerializedType == nil || o.info.SerializedType.Type == nil || len(o.info.SerializedType.Type.Nodes) == 0 {
+		return nil, fmt.Errorf("bundle has no type tree for object %d", o.PathID)
+	}
+	return o.info.SerializedType.Type.Nodes, nil
+}
+
+// reader returns a reader positioned at the start of the object data.
+func (o Object) reader() (*uni.BinaryReader, error) {
+	if _, err := o.typeTree(); err != nil {
+		return nil, err
+	}
+
+	reader := uni.NewObjectReader(o.file.Reader.BinaryReader, o.file, o.info)
+	if err := reader.SeekTo(o.info.ByteStart); err != nil {
+		return nil, err
+	}
+	return reader.BinaryReader, nil
+}
+
+func unityObjectPathID(file *uni.SerializedFile, info *uni.ObjectInfo) int64 {
+	return uni.NewObject(uni.NewObjectReader(file.Reader.BinaryReader, file, info)).PathID
+}
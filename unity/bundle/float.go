@@ -0,0 +1,61 @@
+package bundle
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// unityJSONFloat is a float that always keeps a decimal point in JSON, so
+// integral values like experience points are written as 100.0 instead of 100.
+type unityJSONFloat float64
+
+func (f unityJSONFloat) MarshalJSON() ([]byte, error) {
+	return formatUnityJSONFloat(float64(f), 64), nil
+}
+
+// unityJSONFloat32 is a unityJSONFloat that keeps the float32 precision of
+// the type tree, so 0.1 stays 0.1 instead of 0.10000000149011612.
+type unityJSONFloat32 float32
+
+func (f unityJSONFloat32) MarshalJSON() ([]byte, error) {
+	return formatUnityJSONFloat(float64(f), 32), nil
+}
+
+// formatUnityJSONFloat writes the shortest representation that parses back
+// to the same float of bitSize bits, in the notation of encoding/json.
+func formatUnityJSONFloat(value float64, bitSize int) []byte {
+	if !isFiniteFloat(value) {
+		return []byte("null")
+	}
+	format := byte('f')
+	if abs := math.Abs(value); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	encoded := strconv.FormatFloat(value, format, -1, bitSize)
+	if format == 'e' {
+		// 1e-07 becomes 1e-7
+		if n := len(encoded); n >= 4 && encoded[n-4] == 'e' && encoded[n-3] == '-' && encoded[n-2] == '0' {
+			encoded = encoded[:n-2] + encoded[n-1:]
+		}
+	} else if !strings.Contains(encoded, ".") {
+		encoded += ".0"
+	}
+	return []byte(encoded)
+}
+
+// unityLegacyJSONFloat is a unityJSONFloat rounded to one decimal, like
+// older doduda versions wrote them.
+type unityLegacyJSONFloat float64
+
+func (f unityLegacyJSONFloat) MarshalJSON() ([]byte, error) {
+	value := float64(f)
+	if !isFiniteFloat(value) {
+		return []byte("null"), nil
+	}
+	return []byte(strconv.FormatFloat(value, 'f', 1, 64)), nil
+}
+
+func isFiniteFloat(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
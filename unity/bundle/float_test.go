@@ -0,0 +1,64 @@
+package bundle
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestUnityJSONFloat(t *testing.T) {
+	cases := []struct {
+		value  float64
+		want   string
+		legacy string
+	}{
+		{0.25, "0.25", "0.2"},
+		{1e-7, "1e-7", "0.0"},
+		{0.1, "0.1", "0.1"},
+		{1500, "1500.0", "1500.0"},
+		{-3.75, "-3.75", "-3.8"},
+		{math.NaN(), "null", "null"},
+		{math.Inf(-1), "null", "null"},
+	}
+
+	for _, tc := range cases {
+		for _, legacy := range []bool{false, true} {
+			var value any = unityJSONFloat(tc.value)
+			want := tc.want
+			if legacy {
+				value, want = unityLegacyJSONFloat(tc.value), tc.legacy
+			}
+
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				t.Fatalf("marshal %v: %v", tc.value, err)
+			}
+			if string(encoded) != want {
+				t.Fatalf("marshal %v (legacy %v): expected %s, got %s", tc.value, legacy, want, encoded)
+			}
+		}
+	}
+}
+
+func TestUnityJSONFloat32(t *testing.T) {
+	cases := []struct {
+		value float32
+		want  string
+	}{
+		{0.1, "0.1"},
+		{1.1, "1.1"},
+		{1500, "1500.0"},
+		{1e-7, "1e-7"},
+		{float32(math.Inf(1)), "null"},
+	}
+
+	for _, tc := range cases {
+		encoded, err := json.Marshal(unityJSONFloat32(tc.value))
+		if err != nil {
+			t.Fatalf("marshal %v: %v", tc.value, err)
+		}
+		if string(encoded) != tc.want {
+			t.Fatalf("marshal %v: expected %s, got %s", tc.value, tc.want, encoded)
+		}
+	}
+}
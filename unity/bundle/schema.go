@@ -0,0 +1,222 @@
+package bundle
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kvarenzn/ssm/uni"
+)
+
+// SchemaBuilder describes decoded type trees as JSON Schema. Managed
+// reference types are collected under $defs and shared between all schemas
+// built with the same builder.
+type SchemaBuilder struct {
+	refTypes            []*uni.SerializedType
+	defs                map[string]any
+	managedPayloadDepth int
+	registryRefDepth    int
+}
+
+func NewSchemaBuilder() *SchemaBuilder {
+	return &SchemaBuilder{defs: make(map[string]any)}
+}
+
+// Object returns the schema of the JSON that DecodeMonoBehaviour and
+// StreamMonoBehaviour produce for object.
+func (b *SchemaBuilder) Object(object Object) (map[string]any, error) {
+	nodes, err := object.typeTree()
+	if err != nil {
+		return nil, err
+	}
+	return b.TypeTree(nodes, object.file.RefTypes), nil
+}
+
+// TypeTree returns the schema of the node at the root of nodes. refTypes are
+// the managed reference types that ReferencedObject payloads can hold.
+func (b *SchemaBuilder) TypeTree(nodes []*uni.TypeTreeNode, refTypes []*uni.SerializedType) map[string]any {
+	b.refTypes = refTypes
+	return b.schema(nodes, 0)
+}
+
+// Defs returns the collected $defs, or nil when no schema referenced a
+// managed type.
+func (b *SchemaBuilder) Defs() map[string]any {
+	if len(b.defs) == 0 {
+		return nil
+	}
+	return b.defs
+}
+
+// schema describes the JSON that decodeUnityTypeTree produces for the node at
+// idx, including the unwrapping and normalization it applies.
+func (b *SchemaBuilder) schema(nodes []*uni.TypeTreeNode, idx int) map[string]any {
+	node := nodes[idx]
+	typeLower := strings.ToLower(node.Type)
+
+	switch typeLower {
+	case "referencedmanagedtype":
+		return SchemaObject(node.Type, map[string]any{
+			"class": SchemaScalar("string", "string"),
+			"ns":    SchemaScalar("string", "string"),
+			"asm":   SchemaScalar("string", "string"),
+		})
+	case "referencedobject":
+		if b.registryRefDepth == 0 {
+			return SchemaObject(node.Type, map[string]any{"rid": SchemaScalar("integer", "SInt64")})
+		}
+		properties := map[string]any{
+			"rid":  SchemaScalar("integer", "SInt64"),
+			"data": b.referencedDataSchema(),
+		}
+		for childIdx := idx + 1; childIdx < len(nodes) && nodes[childIdx].Level > node.Level; childIdx = unitySkipSubtree(nodes, childIdx) {
+			if strings.EqualFold(nodes[childIdx].Name, "type") {
+				properties["type"] = b.schema(nodes, childIdx)
+			}
+		}
+		return SchemaObject(node.Type, properties)
+	case "string":
+		return SchemaScalar("string", node.Type)
+	case "typelessdata":
+		return unitySchemaArray(node.Type, SchemaScalar("integer", "UInt8"))
+	case "array":
+		return b.arraySchema(nodes, idx)
+	}
+
+	hasChildren := idx+1 < len(nodes) && nodes[idx+1].Level > node.Level
+	if !hasChildren {
+		return unitySchemaPrimitive(node.Type)
+	}
+
+	properties := make(map[string]any)
+	for childIdx := idx + 1; childIdx < len(nodes) && nodes[childIdx].Level > node.Level; childIdx = unitySkipSubtree(nodes, childIdx) {
+		childNode := nodes[childIdx]
+		key := childNode.Name
+		if key == "" {
+			key = fmt.Sprintf("field_%d", childIdx)
+		}
+
+		var childSchema map[string]any
+		switch {
+		case strings.EqualFold(childNode.Name, "data") && strings.EqualFold(childNode.Type, "ReferencedObjectData"):
+			childSchema = b.referencedDataSchema()
+		case b.managedPayloadDepth > 0 && strings.EqualFold(childNode.Name, "references") && strings.EqualFold(childNode.Type, "ManagedReferencesRegistry"):
+			continue
+		case strings.EqualFold(node.Type, "ManagedReferencesRegistry") && key == "RefIds":
+			b.registryRefDepth++
+			childSchema = b.schema(nodes, childIdx)
+			b.registryRefDepth--
+			if childProperties, ok := childSchema["properties"].(map[string]any); ok {
+				if arraySchema, ok := childProperties["Array"].(map[string]any); ok {
+					childSchema = arraySchema
+				}
+			}
+		case strings.EqualFold(node.Type, "CharacterXpMappingData") && strings.EqualFold(key, "experiencePoints"):
+			childSchema = SchemaScalar("number", childNode.Type)
+		default:
+			childSchema = b.schema(nodes, childIdx)
+		}
+		properties[key] = childSchema
+	}
+
+	return SchemaObject(node.Type, properties)
+}
+
+func (b *SchemaBuilder) arraySchema(nodes []*uni.TypeTreeNode, idx int) map[string]any {
+	node := nodes[idx]
+	nextIdx := unitySkipSubtree(nodes, idx)
+	if idx+1 >= len(nodes) || nodes[idx+1].Level != node.Level+1 {
+		return unitySchemaArray(node.Type, map[string]any{})
+	}
+
+	dataIdx := unitySkipSubtree(nodes, idx+1)
+	if dataIdx >= nextIdx {
+		return unitySchemaArray(node.Type, map[string]any{})
+	}
+
+	dataNode := nodes[dataIdx]
+	dataHasChildren := dataIdx+1 < len(nodes) && nodes[dataIdx+1].Level > dataNode.Level
+	if !dataHasChildren && unityIsByteType(dataNode.Type) {
+		return unitySchemaArray(node.Type, SchemaScalar("integer", dataNode.Type))
+	}
+
+	return unitySchemaArray(node.Type, b.schema(nodes, dataIdx))
+}
+
+// referencedDataSchema lists every managed reference type of the file as a
+// possible payload. Their schemas are collected once under $defs.
+func (b *SchemaBuilder) referencedDataSchema() map[string]any {
+	var refs []string
+	for _, refType := range b.refTypes {
+		if refType == nil || refType.Type == nil || len(refType.Type.Nodes) == 0 {
+			continue
+		}
+
+		name := refType.ClassName
+		if refType.Namespace != "" {
+			name = refType.Namespace + "." + refType.ClassName
+		}
+		if _, ok := b.defs[name]; !ok {
+			b.defs[name] = map[string]any{} // guards against recursive types
+			b.managedPayloadDepth++
+			b.defs[name] = b.schema(refType.Type.Nodes, 0)
+			b.managedPayloadDepth--
+		}
+		refs = append(refs, name)
+	}
+
+	sort.Strings(refs)
+	anyOf := make([]any, 0, len(refs))
+	for _, name := range refs {
+		anyOf = append(anyOf, map[string]any{"$ref": "#/$defs/" + name})
+	}
+	return map[string]any{"anyOf": anyOf}
+}
+
+// SchemaObject returns an object schema with all properties required.
+func SchemaObject(unityType string, properties map[string]any) map[string]any {
+	required := make([]string, 0, len(properties))
+	for key := range properties {
+		required = append(required, key)
+	}
+	sort.Strings(required)
+
+	return map[string]any{
+		"type":        "object",
+		"x-unityType": unityType,
+		"properties":  properties,
+		"required":    required,
+	}
+}
+
+func unitySchemaArray(unityType string, items map[string]any) map[string]any {
+	return map[string]any{
+		"type":        "array",
+		"x-unityType": unityType,
+		"items":       items,
+	}
+}
+
+// SchemaScalar returns a schema of the given JSON type.
+func SchemaScalar(jsonType string, unityType string) map[string]any {
+	return map[string]any{
+		"type":        jsonType,
+		"x-unityType": unityType,
+	}
+}
+
+func unitySchemaPrimitive(typ string) map[string]any {
+	switch strings.ToLower(typ) {
+	case "bool":
+		return SchemaScalar("boolean", typ)
+	case "float", "single", "double":
+		return SchemaScalar("number", typ)
+	case "sint8", "int8", "uint8", "unsigned char", "char",
+		"sint16", "int16", "short", "uint16", "unsigned short",
+		"sint32", "int32", "int", "uint32", "unsigned int",
+		"sint64", "int64", "long", "long long", "uint64", "unsigned long long":
+		return SchemaScalar("integer", typ)
+	default:
+		return map[string]any{"x-unityType": typ}
+	}
+}
@@ -0,0 +1,291 @@
+package bundle
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/kvarenzn/ssm/uni"
+)
+
+// streamUnityTypeTree mirrors decodeUnityTypeTree but encodes the node at idx
+// directly to w. Plain objects, arrays and primitives are streamed. Managed
+// reference nodes are small and need lookahead, so they are decoded with
+// decodeUnityTypeTree and marshalled as a whole.
+func streamUnityTypeTree(state *unityDecodeState, reader *uni.BinaryReader, nodes []*uni.TypeTreeNode, idx int, w io.Writer) (int, error) {
+	if idx < 0 || idx >= len(nodes) {
+		return idx, fmt.Errorf("type tree node index out of range")
+	}
+
+	node := nodes[idx]
+	nextIdx := unitySkipSubtree(nodes, idx)
+	typeLower := strings.ToLower(node.Type)
+
+	switch typeLower {
+	case "referencedmanagedtype", "referencedobject", "string", "typelessdata":
+		return streamUnityTypeTreeValue(state, reader, nodes, idx, w)
+	case "array":
+		if err := streamUnityArray(state, reader, nodes, idx, w); err != nil {
+			return 0, err
+		}
+		return nextIdx, nil
+	}
+
+	hasChildren := idx+1 < len(nodes) && nodes[idx+1].Level > node.Level
+	if !hasChildren {
+		return streamUnityTypeTreeValue(state, reader, nodes, idx, w)
+	}
+
+	if err := streamUnityObject(state, reader, nodes, idx, w); err != nil {
+		return 0, err
+	}
+	return nextIdx, nil
+}
+
+func streamUnityTypeTreeValue(state *unityDecodeState, reader *uni.BinaryReader, nodes []*uni.TypeTreeNode, idx int, w io.Writer) (int, error) {
+	value, nextIdx, err := decodeUnityTypeTree(state, reader, nodes, idx)
+	if err != nil {
+		return 0, err
+	}
+	return nextIdx, writeUnityJSONValue(w, value)
+}
+
+func writeUnityJSONValue(w io.Writer, value any) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(encoded)
+	return err
+}
+
+type unityStreamField struct {
+	idx int
+	key string
+}
+
+func streamUnityObject(state *unityDecodeState, reader *uni.BinaryReader, nodes []*uni.TypeTreeNode, idx int, w io.Writer) error {
+	node := nodes[idx]
+
+	var fields []unityStreamField
+	seen := make(map[string]bool)
+	fallback := false
+	for childIdx := idx + 1; childIdx < len(nodes) && nodes[childIdx].Level > node.Level; childIdx = unitySkipSubtree(nodes, childIdx) {
+		childNode := nodes[childIdx]
+		key := childNode.Name
+		if key == "" {
+			key = fmt.Sprintf("field_%d", childIdx)
+		}
+		if seen[key] || strings.EqualFold(childNode.Type, "ReferencedObjectData") {
+			fallback = true
+			break
+		}
+		seen[key] = true
+		fields = append(fields, unityStreamField{idx: childIdx, key: key})
+	}
+
+	// Duplicate keys and referenced object payloads depend on sibling values,
+	// which only the tree decoder keeps around.
+	if fallback {
+		_, err := streamUnityTypeTreeValue(state, reader, nodes, idx, w)
+		return err
+	}
+
+	// json.Marshal emits map keys sorted. Fields are read in type tree order,
+	// so a field is written directly when it is next in sorted order and
+	// buffered otherwise. Large arrays are usually last in both orders.
+	order := make([]string, len(fields))
+	for i, field := range fields {
+		order[i] = field.key
+	}
+	sort.Strings(order)
+
+	buffered := make(map[string]*bytes.Buffer)
+	written := 0
+	if _, err := io.WriteString(w, "{"); err != nil {
+		return err
+	}
+
+	writeKey := func(key string) error {
+		if written > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		if err := writeUnityJSONValue(w, key); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, ":")
+		return err
+	}
+
+	flush := func() error {
+		for written < len(order) {
+			buf, ok := buffered[order[written]]
+			if !ok {
+				return nil
+			}
+			if err := writeKey(order[written]); err != nil {
+				return err
+			}
+			if _, err := buf.WriteTo(w); err != nil {
+				return err
+			}
+			delete(buffered, order[written])
+			written++
+		}
+		return nil
+	}
+
+	for _, field := range fields {
+		childNode := nodes[field.idx]
+
+		var target io.Writer
+		if order[written] == field.key {
+			if err := writeKey(field.key); err != nil {
+				return err
+			}
+			target = w
+		} else {
+			buf := &bytes.Buffer{}
+			buffered[field.key] = buf
+			target = buf
+		}
+
+		if err := streamUnityField(state, reader, nodes, idx, field, target); err != nil {
+			return fmt.Errorf("node %q(%s){%s} child %q(%s): %w", node.Name, node.Type, unityDirectChildSummary(nodes, idx), childNode.Name, childNode.Type, err)
+		}
+
+		if target == w {
+			written++
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.WriteString(w, "}"); err != nil {
+		return err
+	}
+
+	unityAlignIfNeeded(reader, node)
+	return nil
+}
+
+func streamUnityField(state *unityDecodeState, reader *uni.BinaryReader, nodes []*uni.TypeTreeNode, ownerIdx int, field unityStreamField, w io.Writer) error {
+	owner := nodes[ownerIdx]
+	childNode := nodes[field.idx]
+
+	if state != nil && strings.EqualFold(owner.Type, "ManagedReferencesRegistry") && field.key == "RefIds" {
+		state.registryRefDepth++
+		defer func() { state.registryRefDepth-- }()
+
+		// The tree decoder unwraps the vector to its inner Array.
+		arrayIdx := field.idx + 1
+		if arrayIdx < len(nodes) && unitySkipSubtree(nodes, arrayIdx) == unitySkipSubtree(nodes, field.idx) &&
+			nodes[arrayIdx].Level == childNode.Level+1 && nodes[arrayIdx].Name == "Array" && strings.EqualFold(nodes[arrayIdx].Type, "Array") {
+			if err := streamUnityArray(state, reader, nodes, arrayIdx, w); err != nil {
+				return err
+			}
+			unityAlignIfNeeded(reader, childNode)
+			return nil
+		}
+
+		value, _, err := decodeUnityTypeTree(state, reader, nodes, field.idx)
+		if err != nil {
+			return err
+		}
+		if asMap, ok := value.(map[string]any); ok {
+			if arr, ok := asMap["Array"]; ok {
+				value = arr
+			}
+		}
+		return writeUnityJSONValue(w, value)
+	}
+
+	hasChildren := field.idx+1 < len(nodes) && nodes[field.idx+1].Level > childNode.Level
+	if !hasChildren && !strings.EqualFold(childNode.Type, "ReferencedObject") {
+		value, _, err := decodeUnityTypeTree(state, reader, nodes, field.idx)
+		if err != nil {
+			return err
+		}
+		return writeUnityJSONValue(w, unityNormalizeFieldValue(state, owner.Type, field.key, value))
+	}
+
+	_, err := streamUnityTypeTree(state, reader, nodes, field.idx, w)
+	return err
+}
+
+func streamUnityArray(state *unityDecodeState, reader *uni.BinaryReader, nodes []*uni.TypeTreeNode, idx int, w io.Writer) error {
+	node := nodes[idx]
+	nextIdx := unitySkipSubtree(nodes, idx)
+	if idx+1 >= len(nodes) || nodes[idx+1].Level != node.Level+1 {
+		_, err := io.WriteString(w, "[]")
+		return err
+	}
+
+	sizeValue, sizeNextIdx, err := decodeUnityTypeTree(state, reader, nodes, idx+1)
+	if err != nil {
+		return err
+	}
+
+	count, err := unityToInt(sizeValue)
+	if err != nil {
+		return fmt.Errorf("array size decode failed: %w", err)
+	}
+	if count < 0 {
+		return fmt.Errorf("negative array size %d", count)
+	}
+	if count > 10_000_000 {
+		return fmt.Errorf("array size %d exceeds safety limit", count)
+	}
+	remaining := reader.Len() - reader.Position()
+	if int64(count) > remaining {
+		return fmt.Errorf("array size %d exceeds remaining bytes %d", count, remaining)
+	}
+
+	if sizeNextIdx >= nextIdx {
+		_, err := io.WriteString(w, "[]")
+		return err
+	}
+
+	dataIdx := sizeNextIdx
+	dataNode := nodes[dataIdx]
+	dataHasChildren := dataIdx+1 < len(nodes) && nodes[dataIdx+1].Level > dataNode.Level
+	if !dataHasChildren && unityIsByteType(dataNode.Type) {
+		if !unityCanRead(reader, int64(count)) {
+			return fmt.Errorf("array byte payload %d exceeds remaining bytes", count)
+		}
+		raw := reader.Bytes(count)
+		out := make([]int, len(raw))
+		for i, b := range raw {
+			out[i] = int(b)
+		}
+		unityAlignIfNeeded(reader, dataNode)
+		unityAlignIfNeeded(reader, node)
+		return writeUnityJSONValue(w, out)
+	}
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	for i := range count {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		if _, err := streamUnityTypeTree(state, reader, nodes, dataIdx, w); err != nil {
+			return fmt.Errorf("array %q element %d (%q/%s): %w", node.Name, i, dataNode.Name, dataNode.Type, err)
+		}
+	}
+	if _, err := io.WriteString(w, "]"); err != nil {
+		return err
+	}
+
+	unityAlignIfNeeded(reader, node)
+	return nil
+}
@@ -0,0 +1,96 @@
+package bundle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/kvarenzn/ssm/uni"
+)
+
+func TestStreamUnityTypeTreeMatchesMarshal(t *testing.T) {
+	nodes := testUnityStreamNodes()
+	data := testUnityStreamFixture()
+
+	treeReader := uni.NewBinaryReaderFromBytes(data, true)
+	decoded, _, err := decodeUnityTypeTree(newUnityDecodeState(nil), treeReader, nodes, 0)
+	if err != nil {
+		t.Fatalf("decodeUnityTypeTree returned error: %v", err)
+	}
+	want, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatalf("failed marshalling decoded tree: %v", err)
+	}
+
+	streamReader := uni.NewBinaryReaderFromBytes(data, true)
+	var got bytes.Buffer
+	if _, err := streamUnityTypeTree(newUnityDecodeState(nil), streamReader, nodes, 0, &got); err != nil {
+		t.Fatalf("streamUnityTypeTree returned error: %v", err)
+	}
+
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Fatalf("stream output differs from json.Marshal\n got: %s\nwant: %s", got.Bytes(), want)
+	}
+	if streamReader.Position() != treeReader.Position() {
+		t.Fatalf("stream consumed %d bytes, tree decoder %d", streamReader.Position(), treeReader.Position())
+	}
+}
+
+func testUnityStreamNodes() []*uni.TypeTreeNode {
+	return []*uni.TypeTreeNode{
+		{Name: "Base", Type: "ItemsDataRoot", Level: 0},
+		{Name: "m_Name", Type: "string", Level: 1},
+		{Name: "Array", Type: "Array", Level: 2},
+		{Name: "size", Type: "int", Level: 3},
+		{Name: "data", Type: "char", Level: 3},
+		{Name: "zebra", Type: "int", Level: 1},
+		{Name: "objects", Type: "vector", Level: 1},
+		{Name: "Array", Type: "Array", Level: 2},
+		{Name: "size", Type: "int", Level: 3},
+		{Name: "data", Type: "Item", Level: 3},
+		{Name: "id", Type: "int", Level: 4},
+		{Name: "label<&>", Type: "string", Level: 4},
+		{Name: "Array", Type: "Array", Level: 5},
+		{Name: "size", Type: "int", Level: 6},
+		{Name: "data", Type: "char", Level: 6},
+		{Name: "ratio", Type: "float", Level: 4},
+		{Name: "alpha", Type: "bool", Level: 1},
+		{Name: "raw", Type: "vector", Level: 1},
+		{Name: "Array", Type: "Array", Level: 2},
+		{Name: "size", Type: "int", Level: 3},
+		{Name: "data", Type: "UInt8", Level: 3},
+	}
+}
+
+func testUnityStreamFixture() []byte {
+	var data []byte
+	putInt := func(value int32) {
+		data = binary.BigEndian.AppendUint32(data, uint32(value))
+	}
+	putString := func(value string) {
+		putInt(int32(len(value)))
+		data = append(data, value...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+	}
+
+	putString("ItemsDataRoot")
+	putInt(7)
+	putInt(2)
+	for i, label := range []string{"Coiffe <Bouftou>", "Amulette & co"} {
+		putInt(int32(100 + i))
+		putString(label)
+		data = binary.BigEndian.AppendUint32(data, math.Float32bits(0.25*float32(i+1)))
+	}
+	data = append(data, 1)
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+	putInt(3)
+	data = append(data, 1, 2, 3)
+
+	return data
+}
@@ -0,0 +1,493 @@
+package bundle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/kvarenzn/ssm/uni"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// Archive flags of the UnityFS header.
+const (
+	unityArchiveCompressionMask         = 0x3f
+	unityArchiveBlocksInfoAtEnd         = 0x80
+	unityArchiveBlockInfoPadding        = 0x200
+	unityArchiveUnityCNEncryption       = 0x400
+	unityArchiveUnityCNEncryptionLegacy = 0x200
+)
+
+// Flags of a single storage block.
+const (
+	unityBlockCompressionMask = 0x3f
+	unityBlockStreamed        = 0x40
+	unityBlockEncrypted       = 0x100
+)
+
+// Compression types of blocks and blocks info.
+const (
+	unityCompressionNone  = 0
+	unityCompressionLZMA  = 1
+	unityCompressionLZ4   = 2
+	unityCompressionLZ4HC = 3
+	unityCompressionLZHAM = 4
+)
+
+// maxUnityPayloadSize caps the uncompressed size of a container, which the
+// block headers could otherwise set to anything.
+const maxUnityPayloadSize = 1 << 30
+
+// ErrUnsupported is wrapped by every error about a container feature that is
+// recognized but can not be decoded, like encryption or LZHAM blocks.
+var ErrUnsupported = errors.New("unsupported")
+
+type unityBundleStorageBlock struct {
+	compressedSize   uint32
+	uncompressedSize uint32
+	flags            uint16
+}
+
+type unityBundleNode struct {
+	offset int64
+	size   int64
+	path   string
+}
+
+// LoadAssets reads a UnityFS, UnityWeb or UnityRaw container and loads the
+// serialized files it holds into assetsManager. Other nodes become resource
+// readers. Open uses it, unity/images loads image bundles with it.
+func LoadAssets(data []byte, name string, assetsManager *uni.AssetsManager) error {
+	reader := uni.NewBinaryReaderFromBytes(data, true)
+	signature := reader.CString()
+
+	var (
+		unityVersion string
+		nodes        []unityBundleNode
+		payload      []byte
+		err          error
+	)
+	switch signature {
+	case "UnityFS":
+		unityVersion, nodes, payload, err = readUnityFS(reader, data)
+	case "UnityWeb", "UnityRaw":
+		unityVersion, nodes, payload, err = readUnityWebRaw(reader, data, signature)
+	case "UnityArchive":
+		return fmt.Errorf("bundle signature %q: %w", signature, ErrUnsupported)
+	default:
+		return fmt.Errorf("unsupported bundle signature %q", signature)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", signature, err)
+	}
+
+	for _, node := range nodes {
+		start := int(node.offset)
+		end := start + int(node.size)
+		if start < 0 || end < start || end > len(payload) {
+			return fmt.Errorf("invalid bundle node bounds for %s", node.path)
+		}
+
+		streamData := payload[start:end]
+		normalizedStream := normalizeUnitySerializedHeader(streamData)
+		subReader, err := uni.NewFileReader(streamData, node.path)
+		if err != nil {
+			return fmt.Errorf("create sub reader for %s: %w", node.path, err)
+		}
+
+		if subReader.FileType != uni.FileTypeAssetsFile && !bytes.Equal(normalizedStream, streamData) {
+			normalizedReader, err := uni.NewFileReader(normalizedStream, node.path)
+			if err == nil && normalizedReader.FileType == uni.FileTypeAssetsFile {
+				subReader = &uni.FileReader{
+					BinaryReader: uni.NewBinaryReaderFromBytes(normalizedStream, true),
+					Path:         node.path,
+					FileType:     uni.FileTypeAssetsFile,
+				}
+			}
+		}
+
+		if subReader.FileType != uni.FileTypeAssetsFile {
+			assetsManager.ResourceFileReaders[filepath.Base(node.path)] = subReader.BinaryReader
+			continue
+		}
+
+		if !bytes.Equal(normalizedStream, streamData) {
+			subReader = &uni.FileReader{
+				BinaryReader: uni.NewBinaryReaderFromBytes(normalizedStream, true),
+				Path:         node.path,
+				FileType:     uni.FileTypeAssetsFile,
+			}
+		}
+		if err := subReader.SeekTo(0); err != nil {
+			return err
+		}
+
+		if err := assetsManager.LoadAssets(subReader, name, unityVersion, nil); err != nil {
+			return fmt.Errorf("load assets from %s: %w", node.path, err)
+		}
+	}
+
+	return nil
+}
+
+// readUnityFS reads the header, blocks info and data blocks of a UnityFS
+// container. It returns the concatenated uncompressed blocks.
+func readUnityFS(reader *uni.BinaryReader, data []byte) (string, []unityBundleNode, []byte, error) {
+	version := int(reader.U32())
+	unityVersion := reader.CString()
+	_ = reader.CString() // unity revision
+
+	totalSize := int64(reader.S64())
+	if totalSize != int64(len(data)) {
+		return "", nil, nil, fmt.Errorf("bundle size mismatch: header=%d actual=%d", totalSize, len(data))
+	}
+
+	compressedBlocksInfoSize := int(reader.U32())
+	uncompressedBlocksInfoSize := int(reader.U32())
+	flags := int(reader.U32())
+	if int64(compressedBlocksInfoSize) > reader.Len()-reader.Position() {
+		return "", nil, nil, fmt.Errorf("blocks info of %d bytes exceeds the file", compressedBlocksInfoSize)
+	}
+	if uncompressedBlocksInfoSize > maxUnityPayloadSize {
+		return "", nil, nil, fmt.Errorf("blocks info of %d bytes exceeds the size limit", uncompressedBlocksInfoSize)
+	}
+
+	// Unity moved the UnityCN flag when it added the padding flag.
+	encryptionFlag := unityArchiveUnityCNEncryption
+	if unityUsesLegacyCNFlag(unityVersion) {
+		encryptionFlag = unityArchiveUnityCNEncryptionLegacy
+	}
+	if flags&encryptionFlag != 0 {
+		return "", nil, nil, fmt.Errorf("UnityCN encryption (archive flag 0x%x) in Unity %s: %w", encryptionFlag, unityVersion, ErrUnsupported)
+	}
+
+	if version >= 7 {
+		reader.Align(16)
+	}
+
+	var blockInfoCompressed []byte
+	if flags&unityArchiveBlocksInfoAtEnd != 0 {
+		position := reader.Position()
+		blockInfoOffset := int64(len(data) - compressedBlocksInfoSize)
+		if blockInfoOffset < 0 {
+			return "", nil, nil, fmt.Errorf("invalid blocks info offset")
+		}
+		if err := reader.SeekTo(blockInfoOffset); err != nil {
+			return "", nil, nil, err
+		}
+		blockInfoCompressed = reader.Bytes(compressedBlocksInfoSize)
+		if err := reader.SeekTo(position); err != nil {
+			return "", nil, nil, err
+		}
+	} else {
+		blockInfoCompressed = reader.Bytes(compressedBlocksInfoSize)
+	}
+
+	blockInfo, err := decompressUnityData(blockInfoCompressed, flags&unityArchiveCompressionMask, uncompressedBlocksInfoSize)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("decompress blocks info (archive flags 0x%x): %w", flags, err)
+	}
+
+	blockReader := uni.NewBinaryReaderFromBytes(blockInfo, true)
+	if len(blockInfo) < 20 {
+		return "", nil, nil, fmt.Errorf("blocks info of %d bytes is truncated", len(blockInfo))
+	}
+	blockReader.Skip(16) // uncompressed data hash
+
+	// Blocks take 10 bytes and nodes at least 21 bytes of the blocks info.
+	blocksCount := int(blockReader.S32())
+	if blocksCount < 0 || int64(blocksCount)*10+4 > blockReader.Len()-blockReader.Position() {
+		return "", nil, nil, fmt.Errorf("invalid block count %d", blocksCount)
+	}
+	blocks := make([]unityBundleStorageBlock, 0, blocksCount)
+	for range blocksCount {
+		blocks = append(blocks, unityBundleStorageBlock{
+			uncompressedSize: blockReader.U32(),
+			compressedSize:   blockReader.U32(),
+			flags:            blockReader.U16(),
+		})
+	}
+
+	nodesCount := int(blockReader.S32())
+	if nodesCount < 0 || int64(nodesCount)*21 > blockReader.Len()-blockReader.Position() {
+		return "", nil, nil, fmt.Errorf("invalid node count %d", nodesCount)
+	}
+	nodes := make([]unityBundleNode, 0, nodesCount)
+	for range nodesCount {
+		if blockReader.Len()-blockReader.Position() < 21 {
+			return "", nil, nil, fmt.Errorf("blocks info is truncated")
+		}
+		offset := blockReader.S64()
+		size := blockReader.S64()
+		_ = blockReader.U32() // node flags, currently unused
+		path := blockReader.CString()
+		nodes = append(nodes, unityBundleNode{
+			offset: offset,
+			size:   size,
+			path:   path,
+		})
+	}
+
+	if flags&unityArchiveBlockInfoPadding != 0 {
+		reader.Align(16)
+	}
+
+	var payloadSize int64
+	for i, block := range blocks {
+		payloadSize += int64(block.uncompressedSize)
+		if payloadSize > maxUnityPayloadSize {
+			return "", nil, nil, fmt.Errorf("data blocks up to %d exceed the size limit", i)
+		}
+	}
+
+	blockStream := bytes.NewBuffer(make([]byte, 0, payloadSize))
+	for i, block := range blocks {
+		if block.flags&unityBlockEncrypted != 0 {
+			return "", nil, nil, fmt.Errorf("data block %d is encrypted (block flag 0x%x): %w", i, unityBlockEncrypted, ErrUnsupported)
+		}
+		if int64(block.compressedSize) > reader.Len()-reader.Position() {
+			return "", nil, nil, fmt.Errorf("data block %d of %d bytes exceeds the file", i, block.compressedSize)
+		}
+
+		// Streamed blocks (unityBlockStreamed) only differ in how Unity
+		// loads them at runtime, their bytes decode the same way.
+		compressed := reader.Bytes(int(block.compressedSize))
+		uncompressed, err := decompressUnityData(compressed, int(block.flags&unityBlockCompressionMask), int(block.uncompressedSize))
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("decompress data block %d (block flags 0x%x): %w", i, block.flags, err)
+		}
+		blockStream.Write(uncompressed)
+	}
+
+	return unityVersion, nodes, blockStream.Bytes(), nil
+}
+
+// readUnityWebRaw reads the legacy UnityWeb (LZMA compressed) and UnityRaw
+// (uncompressed) containers used before Unity 5.3.
+func readUnityWebRaw(reader *uni.BinaryReader, data []byte, signature string) (string, []unityBundleNode, []byte, error) {
+	version := int(reader.U32())
+	unityVersion := reader.CString()
+	_ = reader.CString() // unity revision
+
+	if version >= 4 {
+		reader.Skip(16) // hash
+		reader.Skip(4)  // crc
+	}
+	_ = reader.U32() // minimum streamed bytes
+	headerSize := int64(reader.U32())
+	_ = reader.U32() // levels to download before streaming
+
+	levelCount := int(reader.S32())
+	if levelCount <= 0 || int64(levelCount)*8 > reader.Len()-reader.Position() {
+		return "", nil, nil, fmt.Errorf("invalid level count %d", levelCount)
+	}
+	var block unityBundleStorageBlock
+	for range levelCount {
+		// Only the last level holds the sizes of the complete file.
+		block.compressedSize = reader.U32()
+		block.uncompressedSize = reader.U32()
+	}
+
+	if headerSize < 0 || headerSize+int64(block.compressedSize) > int64(len(data)) {
+		return "", nil, nil, fmt.Errorf("data of %d bytes at %d exceeds the file", block.compressedSize, headerSize)
+	}
+	if err := reader.SeekTo(headerSize); err != nil {
+		return "", nil, nil, err
+	}
+	payload := reader.Bytes(int(block.compressedSize))
+
+	if signature == "UnityWeb" {
+		// UnityWeb files are a single classic .lzma stream.
+		if int64(block.uncompressedSize) > maxUnityPayloadSize {
+			return "", nil, nil, fmt.Errorf("data of %d bytes exceeds the size limit", block.uncompressedSize)
+		}
+		payload = bytes.Clone(payload)
+		capLZMADictionary(payload, int(block.uncompressedSize))
+		decoder, err := lzma.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("decompress lzma: %w", err)
+		}
+		payload, err = io.ReadAll(io.LimitReader(decoder, maxUnityPayloadSize+1))
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("decompress lzma: %w", err)
+		}
+		if len(payload) > maxUnityPayloadSize {
+			return "", nil, nil, fmt.Errorf("decompressed data exceeds the size limit")
+		}
+	}
+
+	// Nodes take at least 9 bytes of the directory.
+	directory := uni.NewBinaryReaderFromBytes(payload, true)
+	if len(payload) < 4 {
+		return "", nil, nil, fmt.Errorf("directory is truncated")
+	}
+	nodesCount := int(directory.S32())
+	if nodesCount < 0 || int64(nodesCount)*9 > directory.Len()-directory.Position() {
+		return "", nil, nil, fmt.Errorf("invalid node count %d", nodesCount)
+	}
+	nodes := make([]unityBundleNode, 0, nodesCount)
+	for range nodesCount {
+		if directory.Len()-directory.Position() < 9 {
+			return "", nil, nil, fmt.Errorf("directory is truncated")
+		}
+		path := directory.CString()
+		offset := int64(directory.U32())
+		size := int64(directory.U32())
+		nodes = append(nodes, unityBundleNode{
+			offset: offset,
+			size:   size,
+			path:   path,
+		})
+	}
+
+	return unityVersion, nodes, payload, nil
+}
+
+// unityUsesLegacyCNFlag reports whether unityVersion predates the archive
+// flag change in 2020.3.34, 2021.3.2 and 2022.1.1, where 0x200 still marked
+// UnityCN encryption.
+func unityUsesLegacyCNFlag(unityVersion string) bool {
+	var major, minor, patch int
+	if n, _ := fmt.Sscanf(unityVersion, "%d.%d.%d", &major, &minor, &patch); n < 3 {
+		return false
+	}
+
+	switch {
+	case major < 2020:
+		return true
+	case major == 2020:
+		return minor < 3 || (minor == 3 && patch < 34)
+	case major == 2021:
+		return minor < 3 || (minor == 3 && patch < 2)
+	case major == 2022:
+		return minor < 1 || (minor == 1 && patch < 1)
+	default:
+		return false
+	}
+}
+
+// decompressUnityData checks expectedSize against the limit and what the
+// compressed bytes can expand to before allocating.
+func decompressUnityData(data []byte, compression int, expectedSize int) ([]byte, error) {
+	if expectedSize < 0 || expectedSize > maxUnityPayloadSize {
+		return nil, fmt.Errorf("block of %d bytes exceeds the size limit", expectedSize)
+	}
+	switch compression {
+	case unityCompressionNone:
+		return data, nil
+	case unityCompressionLZMA:
+		decoder, err := lzma.NewReader(bytes.NewReader(prepareUnityLZMAStream(data, expectedSize)))
+		if err != nil {
+			return nil, err
+		}
+		limit := int64(expectedSize)
+		if limit == 0 {
+			limit = maxUnityPayloadSize
+		}
+		out, err := io.ReadAll(io.LimitReader(decoder, limit+1))
+		if err != nil {
+			return nil, err
+		}
+		if expectedSize > 0 && len(out) != expectedSize {
+			return nil, fmt.Errorf("lzma size mismatch: expected %d got %d", expectedSize, len(out))
+		}
+		return out, nil
+	case unityCompressionLZ4, unityCompressionLZ4HC:
+		// An LZ4 byte expands to at most 255 bytes.
+		if expectedSize > len(data)*255+16 {
+			return nil, fmt.Errorf("lz4 block of %d bytes can not hold %d bytes", len(data), expectedSize)
+		}
+		out := make([]byte, expectedSize+0x100)
+		n, err := lz4.UncompressBlock(data, out)
+		if err != nil {
+			return nil, err
+		}
+		if n != expectedSize {
+			return nil, fmt.Errorf("lz4 size mismatch: expected %d got %d", expectedSize, n)
+		}
+		return out[:expectedSize], nil
+	case unityCompressionLZHAM:
+		// Deferred: current Unity versions do not write LZHAM blocks.
+		return nil, fmt.Errorf("LZHAM compression (type %d): %w", compression, ErrUnsupported)
+	default:
+		return nil, fmt.Errorf("unknown compression type %d", compression)
+	}
+}
+
+func prepareUnityLZMAStream(compressed []byte, expectedSize int) []byte {
+	// Unity LZMA blocks use the first 5 bytes for properties and then the raw
+	// payload. Build a classic .lzma header by injecting the expected size.
+	if len(compressed) < 5 {
+		return compressed
+	}
+
+	header := make([]byte, 13)
+	copy(header[:5], compressed[:5])
+	capLZMADictionary(header, expectedSize)
+	binary.LittleEndian.PutUint64(header[5:], uint64(expectedSize))
+
+	out := make([]byte, 13+len(compressed)-5)
+	copy(out, header)
+	copy(out[13:], compressed[5:])
+	return out
+}
+
+// capLZMADictionary lowers the dictionary size of a .lzma header to size,
+// since the decoder allocates the whole dictionary up front and one larger
+// than the data is never used.
+func capLZMADictionary(header []byte, size int) {
+	if size <= 0 || len(header) < 5 {
+		return
+	}
+	if dictSize := binary.LittleEndian.Uint32(header[1:5]); int64(dictSize) > int64(size) {
+		binary.LittleEndian.PutUint32(header[1:5], uint32(max(size, 4096)))
+	}
+}
+
+func normalizeUnitySerializedHeader(stream []byte) []byte {
+	if len(stream) < 44 {
+		return stream
+	}
+
+	metadataV1 := binary.BigEndian.Uint32(stream[0:4])
+	fileSizeV1 := binary.BigEndian.Uint32(stream[4:8])
+	version := binary.BigEndian.Uint32(stream[8:12])
+	dataOffsetV1 := binary.BigEndian.Uint32(stream[12:16])
+	if version < 22 {
+		return stream
+	}
+
+	// This variant stores extended v22+ header fields directly at offset 16
+	// instead of after big-endian/reserved bytes. Normalize it to the layout
+	// expected by the parser.
+	if metadataV1 != 0 || fileSizeV1 != 0 || dataOffsetV1 != 0 {
+		return stream
+	}
+	if stream[17] == 0 && stream[18] == 0 && stream[19] == 0 {
+		return stream
+	}
+
+	metadataSize := binary.BigEndian.Uint32(stream[16:20])
+	fileSize := binary.BigEndian.Uint64(stream[20:28])
+	dataOffset := binary.BigEndian.Uint64(stream[28:36])
+	unknown := stream[36:44]
+	if fileSize == 0 || dataOffset == 0 {
+		return stream
+	}
+
+	normalized := make([]byte, len(stream)+4)
+	copy(normalized[0:16], stream[0:16])
+	normalized[16] = 0 // big-endian flag (false)
+	// normalized[17:20] reserved bytes remain zero
+	binary.BigEndian.PutUint32(normalized[20:24], metadataSize)
+	binary.BigEndian.PutUint64(normalized[24:32], fileSize)
+	binary.BigEndian.PutUint64(normalized[32:40], dataOffset+4)
+	copy(normalized[40:48], unknown)
+	copy(normalized[48:], stream[44:])
+
+	return normalized
+}
@@ -0,0 +1,118 @@
+package bundle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kvarenzn/ssm/uni"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+func TestReadUnityWebRaw(t *testing.T) {
+	var directory bytes.Buffer
+	binary.Write(&directory, binary.BigEndian, int32(1))
+	directory.WriteString("CAB-test\x00")
+	binary.Write(&directory, binary.BigEndian, uint32(21))
+	binary.Write(&directory, binary.BigEndian, uint32(5))
+	directory.WriteString("hello")
+	raw := directory.Bytes()
+
+	var compressed bytes.Buffer
+	writer, err := lzma.NewWriter(&compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write(raw)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for signature, payload := range map[string][]byte{"UnityRaw": raw, "UnityWeb": compressed.Bytes()} {
+		data := testUnityWebRawContainer(signature, payload, len(raw))
+		reader := uni.NewBinaryReaderFromBytes(data, true)
+		if got := reader.CString(); got != signature {
+			t.Fatalf("unexpected signature %q", got)
+		}
+
+		unityVersion, nodes, got, err := readUnityWebRaw(reader, data, signature)
+		if err != nil {
+			t.Fatalf("%s: readUnityWebRaw returned error: %v", signature, err)
+		}
+		if unityVersion != "5.2.1f1" {
+			t.Fatalf("%s: unexpected unity version %q", signature, unityVersion)
+		}
+		if len(nodes) != 1 || nodes[0].path != "CAB-test" {
+			t.Fatalf("%s: unexpected nodes %+v", signature, nodes)
+		}
+		if content := string(got[nodes[0].offset : nodes[0].offset+nodes[0].size]); content != "hello" {
+			t.Fatalf("%s: unexpected node content %q", signature, content)
+		}
+	}
+}
+
+func TestUnsupportedUnityContainers(t *testing.T) {
+	if _, err := decompressUnityData([]byte{0}, unityCompressionLZHAM, 1); !errors.Is(err, ErrUnsupported) || !strings.Contains(err.Error(), "LZHAM") {
+		t.Fatalf("expected unsupported LZHAM error, got %v", err)
+	}
+
+	var header bytes.Buffer
+	header.WriteString("UnityFS\x00")
+	binary.Write(&header, binary.BigEndian, uint32(8))
+	header.WriteString("2021.3.1f1\x00")
+	header.WriteString("rev\x00")
+	sizeOffset := header.Len()
+	binary.Write(&header, binary.BigEndian, int64(0))
+	binary.Write(&header, binary.BigEndian, uint32(0))
+	binary.Write(&header, binary.BigEndian, uint32(0))
+	binary.Write(&header, binary.BigEndian, uint32(0x200))
+	data := header.Bytes()
+	binary.BigEndian.PutUint64(data[sizeOffset:], uint64(len(data)))
+
+	err := LoadAssets(data, "bundle", uni.NewAssetsManager())
+	if !errors.Is(err, ErrUnsupported) || !strings.Contains(err.Error(), "archive flag 0x200") {
+		t.Fatalf("expected UnityCN error naming flag 0x200, got %v", err)
+	}
+}
+
+func testUnityWebRawContainer(signature string, payload []byte, uncompressedSize int) []byte {
+	var header bytes.Buffer
+	header.WriteString(signature + "\x00")
+	binary.Write(&header, binary.BigEndian, uint32(3))
+	header.WriteString("5.2.1f1\x00")
+	header.WriteString("rev\x00")
+	binary.Write(&header, binary.BigEndian, uint32(0)) // minimum streamed bytes
+	headerSizeOffset := header.Len()
+	binary.Write(&header, binary.BigEndian, uint32(0))
+	binary.Write(&header, binary.BigEndian, uint32(1)) // levels before streaming
+	binary.Write(&header, binary.BigEndian, int32(1))
+	binary.Write(&header, binary.BigEndian, uint32(len(payload)))
+	binary.Write(&header, binary.BigEndian, uint32(uncompressedSize))
+	binary.Write(&header, binary.BigEndian, uint32(0)) // complete file size
+	binary.Write(&header, binary.BigEndian, uint32(0)) // file info header size
+
+	data := header.Bytes()
+	binary.BigEndian.PutUint32(data[headerSizeOffset:], uint32(len(data)))
+	return append(data, payload...)
+}
+
+func FuzzOpen(f *testing.F) {
+	var directory bytes.Buffer
+	binary.Write(&directory, binary.BigEndian, int32(1))
+	directory.WriteString("CAB-test\x00")
+	binary.Write(&directory, binary.BigEndian, uint32(21))
+	binary.Write(&directory, binary.BigEndian, uint32(5))
+	directory.WriteString("hello")
+	f.Add(testUnityWebRawContainer("UnityRaw", directory.Bytes(), directory.Len()))
+	f.Fuzz(func(t *testing.T, file []byte) {
+		b, err := Open(bytes.NewReader(file))
+		if err != nil {
+			return
+		}
+		for _, object := range b.MonoBehaviours() {
+			b.DecodeMonoBehaviour(object)
+		}
+	})
+}
@@ -0,0 +1,228 @@
+// Package i18n decodes the Dofus 3 localization tables (<lang>.bin).
+package i18n
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Table is a decoded localization table.
+type Table struct {
+	// Version is the format version byte of the file.
+	Version int
+	// Language is the two letter language code of the file, for example "fr".
+	Language string
+	Entries  map[int]string
+	// StringEntries holds the texts that are addressed by a string key. The
+	// file only stores the FNV-1a hash of each key (see HashKey), so they are
+	// keyed by that hash.
+	StringEntries map[uint64]string
+}
+
+// Open reads the whole localization table from r. Values are sanitized:
+// byte order marks and control characters other than line breaks are
+// dropped, non-breaking spaces become spaces.
+//
+// The file starts with the version byte and the language code, followed by
+// the integer keyed table (int32 count, then int32 key and uint32 offset per
+// entry) and the string keyed table (int32 count, then uint64 key hash and
+// uint32 offset per entry). The offsets point to varint length prefixed UTF-8
+// strings behind the tables. Older files end the header after the integer
+// keyed table.
+func Open(r io.ReaderAt) (*Table, error) {
+	data, err := io.ReadAll(io.NewSectionReader(r, 0, math.MaxInt64))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 3 {
+		return nil, fmt.Errorf("invalid localization file: too short")
+	}
+
+	table := &Table{
+		Version:  int(data[0]),
+		Language: string(data[1:3]),
+	}
+	offset := 3
+
+	if len(data) < offset+4 {
+		return nil, fmt.Errorf("invalid localization file: missing integer table count")
+	}
+
+	intCount := int(int32(binary.LittleEndian.Uint32(data[offset : offset+4])))
+	offset += 4
+	if intCount < 0 {
+		return nil, fmt.Errorf("invalid localization file: negative integer table size")
+	}
+	if (len(data)-offset)/8 < intCount {
+		return nil, fmt.Errorf("invalid localization file: truncated integer offset table")
+	}
+
+	keyOffsets := make(map[int]uint32, intCount)
+	firstString := len(data)
+	for range intCount {
+		key := int(int32(binary.LittleEndian.Uint32(data[offset : offset+4])))
+		offset += 4
+
+		strOffset := binary.LittleEndian.Uint32(data[offset : offset+4])
+		offset += 4
+		keyOffsets[key] = strOffset
+		firstString = min(firstString, int(strOffset))
+	}
+
+	hashOffsets, err := readStringKeyedTable(data, offset, firstString)
+	if err != nil {
+		return nil, err
+	}
+
+	table.Entries = make(map[int]string, len(keyOffsets))
+	for key, strOffset := range keyOffsets {
+		value, err := readUnityI18NStringAt(data, int(strOffset))
+		if err != nil {
+			return nil, fmt.Errorf("read string for key %d at %d: %w", key, strOffset, err)
+		}
+		table.Entries[key] = sanitizeUnityI18NString(value)
+	}
+
+	table.StringEntries = make(map[uint64]string, len(hashOffsets))
+	for hash, strOffset := range hashOffsets {
+		value, err := readUnityI18NStringAt(data, int(strOffset))
+		if err != nil {
+			return nil, fmt.Errorf("read string for key hash %016x at %d: %w", hash, strOffset, err)
+		}
+		table.StringEntries[hash] = sanitizeUnityI18NString(value)
+	}
+
+	return table, nil
+}
+
+// readStringKeyedTable reads the string keyed table at offset. firstString is
+// the lowest string offset of the integer keyed table: when the strings start
+// right behind the integer keyed table, the file has no string keyed table.
+func readStringKeyedTable(data []byte, offset int, firstString int) (map[uint64]uint32, error) {
+	if firstString == offset || len(data) < offset+4 {
+		return nil, nil
+	}
+
+	count := int(int32(binary.LittleEndian.Uint32(data[offset : offset+4])))
+	offset += 4
+	if count < 0 {
+		return nil, fmt.Errorf("invalid localization file: negative string table size")
+	}
+	if (len(data)-offset)/12 < count || offset+count*12 > firstString {
+		return nil, fmt.Errorf("invalid localization file: truncated string offset table")
+	}
+
+	hashOffsets := make(map[uint64]uint32, count)
+	for range count {
+		hash := binary.LittleEndian.Uint64(data[offset : offset+8])
+		strOffset := binary.LittleEndian.Uint32(data[offset+8 : offset+12])
+		offset += 12
+		hashOffsets[hash] = strOffset
+	}
+	return hashOffsets, nil
+}
+
+// Get returns the text for key.
+func (t *Table) Get(key int) (string, bool) {
+	value, ok := t.Entries[key]
+	return value, ok
+}
+
+// Lookup returns the text for the string key.
+func (t *Table) Lookup(key string) (string, bool) {
+	value, ok := t.StringEntries[HashKey(key)]
+	return value, ok
+}
+
+// HashKey returns the hash the game stores for a string key: 64 bit FNV-1a
+// over the low byte of every UTF-16 code unit of the key.
+func HashKey(key string) uint64 {
+	hash := uint64(14695981039346656037)
+	for _, unit := range utf16.Encode([]rune(key)) {
+		hash ^= uint64(byte(unit))
+		hash *= 1099511628211
+	}
+	return hash
+}
+
+func readUnityI18NStringAt(data []byte, offset int) (string, error) {
+	if offset < 0 || offset >= len(data) {
+		return "", fmt.Errorf("offset out of range")
+	}
+
+	length, valueStart, err := decodeUnityI18NLength(data, offset)
+	if err != nil {
+		return "", err
+	}
+
+	if length < 0 {
+		return "", fmt.Errorf("negative string length")
+	}
+
+	valueEnd := valueStart + length
+	if valueEnd > len(data) {
+		return "", fmt.Errorf("string bytes out of range")
+	}
+
+	if !utf8.Valid(data[valueStart:valueEnd]) {
+		return "", fmt.Errorf("invalid utf-8 string bytes")
+	}
+
+	return string(data[valueStart:valueEnd]), nil
+}
+
+func decodeUnityI18NLength(data []byte, offset int) (length int, nextOffset int, err error) {
+	if offset >= len(data) {
+		return 0, 0, fmt.Errorf("missing string length")
+	}
+
+	first := data[offset]
+	offset++
+	if first&0x80 == 0 {
+		return int(first), offset, nil
+	}
+
+	length = int(first & 0x7F)
+	shift := 7
+	for {
+		if offset >= len(data) {
+			return 0, 0, fmt.Errorf("truncated varint length")
+		}
+		b := data[offset]
+		offset++
+
+		length |= int(b&0x7F) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+		if shift > 28 {
+			return 0, 0, fmt.Errorf("varint length too large")
+		}
+	}
+
+	return length, offset, nil
+}
+
+func sanitizeUnityI18NString(input string) string {
+	out := make([]rune, 0, len(input))
+	for _, r := range input {
+		switch {
+		case r == '\uFEFF':
+			continue
+		case unicode.IsControl(r) && r != '\n' && r != '\r':
+			continue
+		case r == '\u00A0':
+			out = append(out, ' ')
+		default:
+			out = append(out, r)
+		}
+	}
+	return string(out)
+}
@@ -0,0 +1,60 @@
+package i18n
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestOpenStringKeyedTable(t *testing.T) {
+	var data []byte
+	data = append(data, 3, 'f', 'r')
+	data = binary.LittleEndian.AppendUint32(data, 1)
+	data = binary.LittleEndian.AppendUint32(data, 7)
+	data = binary.LittleEndian.AppendUint32(data, 31) // 3 + 4+8 + 4+12
+	data = binary.LittleEndian.AppendUint32(data, 1)
+	data = binary.LittleEndian.AppendUint64(data, HashKey("ui.common.ok"))
+	data = binary.LittleEndian.AppendUint32(data, 40)
+	data = append(data, 8)
+	data = append(data, "Commerce"...)
+	data = append(data, 2)
+	data = append(data, "OK"...)
+
+	table, err := Open(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Version != 3 || table.Language != "fr" {
+		t.Fatalf("header = %d %q", table.Version, table.Language)
+	}
+	if value, ok := table.Get(7); !ok || value != "Commerce" {
+		t.Fatalf("Get(7) = %q, %v", value, ok)
+	}
+	if value, ok := table.Lookup("ui.common.ok"); !ok || value != "OK" {
+		t.Fatalf("Lookup = %q, %v", value, ok)
+	}
+	if _, ok := table.Lookup("ui.common.cancel"); ok {
+		t.Fatal("Lookup found a missing key")
+	}
+}
+
+func TestHashKey(t *testing.T) {
+	// FNV-1a 64 of "a".
+	if got := HashKey("a"); got != 0xaf63dc4c8601ec8c {
+		t.Fatalf("HashKey(a) = %016x", got)
+	}
+}
+
+func FuzzOpen(f *testing.F) {
+	var seed []byte
+	seed = append(seed, 3, 'f', 'r')
+	seed = binary.LittleEndian.AppendUint32(seed, 1)
+	seed = binary.LittleEndian.AppendUint32(seed, 7)
+	seed = binary.LittleEndian.AppendUint32(seed, 15)
+	seed = append(seed, 2)
+	seed = append(seed, "OK"...)
+	f.Add(seed)
+	f.Fuzz(func(t *testing.T, file []byte) {
+		Open(bytes.NewReader(file))
+	})
+}
@@ -0,0 +1,675 @@
+package images
+
+import "image/color"
+
+// ASTC blocks are decoded following the Khronos data format specification.
+// Only 2D LDR content is supported. Invalid blocks and HDR endpoint modes
+// decode to the error color magenta, as the specification asks for.
+
+var astcErrorColor = color.NRGBA{255, 0, 255, 255}
+
+// astcRange describes a quantization range of the integer sequence encoding:
+// every value has bits low bits and optionally a trit or quint digit.
+type astcRange struct {
+	levels int
+	trits  bool
+	quints bool
+	bits   int
+}
+
+var astcRanges = []astcRange{
+	{2, false, false, 1},
+	{3, true, false, 0},
+	{4, false, false, 2},
+	{5, false, true, 0},
+	{6, true, false, 1},
+	{8, false, false, 3},
+	{10, false, true, 1},
+	{12, true, false, 2},
+	{16, false, false, 4},
+	{20, false, true, 2},
+	{24, true, false, 3},
+	{32, false, false, 5},
+	{40, false, true, 3},
+	{48, true, false, 4},
+	{64, false, false, 6},
+	{80, false, true, 4},
+	{96, true, false, 5},
+	{128, false, false, 7},
+	{160, false, true, 5},
+	{192, true, false, 6},
+	{256, false, false, 8},
+}
+
+func (r astcRange) bitCount(count int) int {
+	total := count * r.bits
+	if r.trits {
+		total += (8*count + 4) / 5
+	}
+	if r.quints {
+		total += (7*count + 2) / 3
+	}
+	return total
+}
+
+type astcBlockMode struct {
+	weightWidth  int
+	weightHeight int
+	weightRange  astcRange
+	dualPlane    bool
+}
+
+// astcBits reads little endian bit fields from a 128 bit block.
+type astcBits [16]byte
+
+func (b *astcBits) get(offset int, count int) int {
+	value := 0
+	for i := 0; i < count; i++ {
+		bit := offset + i
+		if bit < 0 || bit >= 128 {
+			continue
+		}
+		value |= int(b[bit/8]>>(bit%8)&1) << i
+	}
+	return value
+}
+
+func (b *astcBits) reversed() astcBits {
+	var out astcBits
+	for i := 0; i < 16; i++ {
+		v := b[15-i]
+		v = v>>4 | v<<4
+		v = (v&0xcc)>>2 | (v&0x33)<<2
+		v = (v&0xaa)>>1 | (v&0x55)<<1
+		out[i] = v
+	}
+	return out
+}
+
+func decodeASTCBlock(block []byte, blockWidth int, blockHeight int, texels []color.NRGBA) {
+	var bits astcBits
+	copy(bits[:], block)
+
+	fill := func(c color.NRGBA) {
+		for i := range texels[:blockWidth*blockHeight] {
+			texels[i] = c
+		}
+	}
+
+	modeBits := bits.get(0, 11)
+	if modeBits&0x1ff == 0x1fc {
+		// Void extent: one constant color for the whole block.
+		if modeBits&0x200 != 0 {
+			fill(astcErrorColor)
+			return
+		}
+		fill(color.NRGBA{uint8(bits.get(64+8, 8)), uint8(bits.get(80+8, 8)), uint8(bits.get(96+8, 8)), uint8(bits.get(112+8, 8))})
+		return
+	}
+
+	mode, ok := decodeASTCBlockMode(modeBits)
+	if !ok || mode.weightWidth > blockWidth || mode.weightHeight > blockHeight {
+		fill(astcErrorColor)
+		return
+	}
+
+	planes := 1
+	if mode.dualPlane {
+		planes = 2
+	}
+	weightCount := mode.weightWidth * mode.weightHeight * planes
+	weightBits := mode.weightRange.bitCount(weightCount)
+	if weightCount > 64 || weightBits < 24 || weightBits > 96 {
+		fill(astcErrorColor)
+		return
+	}
+
+	partitions := bits.get(11, 2) + 1
+	if partitions == 4 && mode.dualPlane {
+		fill(astcErrorColor)
+		return
+	}
+
+	var endpointModes [4]int
+	colorStart := 17
+	extraBits := 0
+	seed := 0
+	if partitions == 1 {
+		endpointModes[0] = bits.get(13, 4)
+	} else {
+		seed = bits.get(13, 10)
+		colorStart = 29
+		selector := bits.get(23, 6)
+		if selector&3 == 0 {
+			for i := 0; i < partitions; i++ {
+				endpointModes[i] = selector >> 2
+			}
+		} else {
+			extraBits = 3*partitions - 4
+			encoded := selector>>2 | bits.get(128-weightBits-extraBits, extraBits)<<4
+			base := selector&3 - 1
+			for i := 0; i < partitions; i++ {
+				class := base + encoded>>i&1
+				endpointModes[i] = class<<2 | encoded>>(partitions+2*i)&3
+			}
+		}
+	}
+
+	colorEnd := 128 - weightBits - extraBits
+	componentSelector := -1
+	if mode.dualPlane {
+		colorEnd -= 2
+		componentSelector = bits.get(colorEnd, 2)
+	}
+
+	valueCount := 0
+	for i := 0; i < partitions; i++ {
+		valueCount += (endpointModes[i]>>2 + 1) * 2
+	}
+	if valueCount > 18 {
+		fill(astcErrorColor)
+		return
+	}
+
+	colorRange := -1
+	for i := len(astcRanges) - 1; i >= 0; i-- {
+		if astcRanges[i].bitCount(valueCount) <= colorEnd-colorStart {
+			colorRange = i
+			break
+		}
+	}
+	if colorRange < 4 {
+		fill(astcErrorColor)
+		return
+	}
+
+	colorValues := decodeASTCIntegers(&bits, colorStart, astcRanges[colorRange], valueCount)
+	for i := range colorValues {
+		colorValues[i] = unquantizeASTCColor(colorValues[i], astcRanges[colorRange])
+	}
+
+	var endpoints [4][2][4]int
+	offset := 0
+	for i := 0; i < partitions; i++ {
+		count := (endpointModes[i]>>2 + 1) * 2
+		var ok bool
+		endpoints[i], ok = decodeASTCEndpoints(endpointModes[i], colorValues[offset:offset+count])
+		if !ok {
+			fill(astcErrorColor)
+			return
+		}
+		offset += count
+	}
+
+	reversed := bits.reversed()
+	weights := decodeASTCIntegers(&reversed, 0, mode.weightRange, weightCount)
+	for i := range weights {
+		weights[i] = unquantizeASTCWeight(weights[i], mode.weightRange)
+	}
+
+	smallBlock := blockWidth*blockHeight < 31
+	for y := 0; y < blockHeight; y++ {
+		for x := 0; x < blockWidth; x++ {
+			partition := 0
+			if partitions > 1 {
+				partition = selectASTCPartition(seed, x, y, partitions, smallBlock)
+			}
+
+			var texel [4]uint8
+			for component := 0; component < 4; component++ {
+				plane := 0
+				if component == componentSelector {
+					plane = 1
+				}
+				weight := infillASTCWeight(weights, mode, blockWidth, blockHeight, x, y, plane, planes)
+				c0 := endpoints[partition][0][component] * 257
+				c1 := endpoints[partition][1][component] * 257
+				texel[component] = uint8((c0*(64-weight) + c1*weight + 32) >> 6 >> 8)
+			}
+			texels[y*blockWidth+x] = color.NRGBA{texel[0], texel[1], texel[2], texel[3]}
+		}
+	}
+}
+
+func decodeASTCBlockMode(m int) (astcBlockMode, bool) {
+	var mode astcBlockMode
+	var r int
+	precision := m >> 9 & 1
+	mode.dualPlane = m>>10&1 == 1
+
+	if m&3 != 0 {
+		r = (m&3)<<1 | m>>4&1
+		a := m >> 5 & 3
+		b := m >> 7 & 3
+		switch m >> 2 & 3 {
+		case 0:
+			mode.weightWidth, mode.weightHeight = b+4, a+2
+		case 1:
+			mode.weightWidth, mode.weightHeight = b+8, a+2
+		case 2:
+			mode.weightWidth, mode.weightHeight = a+2, b+8
+		default:
+			if m>>8&1 == 0 {
+				mode.weightWidth, mode.weightHeight = a+2, b&1+6
+			} else {
+				mode.weightWidth, mode.weightHeight = b&1+2, a+2
+			}
+		}
+	} else {
+		if m&0xf == 0 {
+			return mode, false
+		}
+		r = (m>>2&3)<<1 | m>>4&1
+		a := m >> 5 & 3
+		switch m >> 7 & 3 {
+		case 0:
+			mode.weightWidth, mode.weightHeight = 12, a+2
+		case 1:
+			mode.weightWidth, mode.weightHeight = a+2, 12
+		case 2:
+			b := m >> 9 & 3
+			mode.weightWidth, mode.weightHeight = a+6, b+6
+			precision = 0
+			mode.dualPlane = false
+		default:
+			switch a {
+			case 0:
+				mode.weightWidth, mode.weightHeight = 6, 10
+			case 1:
+				mode.weightWidth, mode.weightHeight = 10, 6
+			default:
+				return mode, false
+			}
+		}
+	}
+
+	if r < 2 {
+		return mode, false
+	}
+	// Weight ranges 0..11 in astcRanges, selected by r and the precision bit.
+	mode.weightRange = astcRanges[(r-2)+6*precision]
+	return mode, true
+}
+
+// decodeASTCIntegers reads count values of the integer sequence encoding,
+// starting at bit offset.
+func decodeASTCIntegers(bits *astcBits, offset int, r astcRange, count int) []int {
+	values := make([]int, 0, count+4)
+	switch {
+	case r.trits:
+		for len(values) < count {
+			var low [5]int
+			t := 0
+			pieces := [5]int{2, 2, 1, 2, 1}
+			shift := 0
+			for i := 0; i < 5; i++ {
+				low[i] = bits.get(offset, r.bits)
+				offset += r.bits
+				t |= bits.get(offset, pieces[i]) << shift
+				offset += pieces[i]
+				shift += pieces[i]
+			}
+			digits := astcTrits(t)
+			for i := 0; i < 5; i++ {
+				values = append(values, digits[i]<<r.bits|low[i])
+			}
+		}
+	case r.quints:
+		for len(values) < count {
+			var low [3]int
+			q := 0
+			pieces := [3]int{3, 2, 2}
+			shift := 0
+			for i := 0; i < 3; i++ {
+				low[i] = bits.get(offset, r.bits)
+				offset += r.bits
+				q |= bits.get(offset, pieces[i]) << shift
+				offset += pieces[i]
+				shift += pieces[i]
+			}
+			digits := astcQuints(q)
+			for i := 0; i < 3; i++ {
+				values = append(values, digits[i]<<r.bits|low[i])
+			}
+		}
+	default:
+		for len(values) < count {
+			values = append(values, bits.get(offset, r.bits))
+			offset += r.bits
+		}
+	}
+	return values[:count]
+}
+
+func astcTrits(t int) [5]int {
+	var out [5]int
+	bit := func(v int, i int) int { return v >> i & 1 }
+
+	var c int
+	if t>>2&7 == 7 {
+		c = (t>>5&7)<<2 | t&3
+		out[4], out[3] = 2, 2
+	} else {
+		c = t & 0x1f
+		if t>>5&3 == 3 {
+			out[4], out[3] = 2, bit(t, 7)
+		} else {
+			out[4], out[3] = bit(t, 7), t>>5&3
+		}
+	}
+
+	switch {
+	case c&3 == 3:
+		out[2], out[1] = 2, bit(c, 4)
+		out[0] = bit(c, 3)<<1 | (bit(c, 2) &^ bit(c, 3))
+	case c>>2&3 == 3:
+		out[2], out[1] = 2, 2
+		out[0] = c & 3
+	default:
+		out[2], out[1] = bit(c, 4), c>>2&3
+		out[0] = bit(c, 1)<<1 | (bit(c, 0) &^ bit(c, 1))
+	}
+	return out
+}
+
+func astcQuints(q int) [3]int {
+	var out [3]int
+	bit := func(v int, i int) int { return v >> i & 1 }
+
+	if q>>1&3 == 3 && q>>5&3 == 0 {
+		out[2] = bit(q, 0)<<2 | (bit(q, 4)&^bit(q, 0))<<1 | bit(q, 3)&^bit(q, 0)
+		out[1], out[0] = 4, 4
+		return out
+	}
+
+	var c int
+	if q>>1&3 == 3 {
+		out[2] = 4
+		c = (q>>3&3)<<3 | (^q>>5&3)<<1 | q&1
+	} else {
+		out[2] = q >> 5 & 3
+		c = q & 0x1f
+	}
+	if c&7 == 5 {
+		out[1], out[0] = 4, c>>3&3
+	} else {
+		out[1], out[0] = c>>3&3, c&7
+	}
+	return out
+}
+
+func unquantizeASTCColor(value int, r astcRange) int {
+	if !r.trits && !r.quints {
+		return replicateBits(value, r.bits, 8)
+	}
+
+	low := value & (1<<r.bits - 1)
+	digit := value >> r.bits
+	a := 0
+	if low&1 == 1 {
+		a = 0x1ff
+	}
+
+	var b, c int
+	switch {
+	case r.trits && r.bits == 1:
+		c = 204
+	case r.trits && r.bits == 2:
+		x := low >> 1 & 1
+		b, c = x<<8|x<<4|x<<2|x<<1, 93
+	case r.trits && r.bits == 3:
+		x := low >> 1 & 3
+		b, c = x<<7|x<<2|x, 44
+	case r.trits && r.bits == 4:
+		x := low >> 1 & 7
+		b, c = x<<6|x, 22
+	case r.trits && r.bits == 5:
+		x := low >> 1 & 15
+		b, c = x<<5|x>>2, 11
+	case r.trits && r.bits == 6:
+		x := low >> 1 & 31
+		b, c = x<<4|x>>4, 5
+	case r.quints && r.bits == 1:
+		c = 113
+	case r.quints && r.bits == 2:
+		x := low >> 1 & 1
+		b, c = x<<8|x<<3|x<<2, 54
+	case r.quints && r.bits == 3:
+		x := low >> 1 & 3
+		b, c = x<<7|x<<1|x>>1, 26
+	case r.quints && r.bits == 4:
+		x := low >> 1 & 7
+		b, c = x<<6|x>>1, 13
+	case r.quints && r.bits == 5:
+		x := low >> 1 & 15
+		b, c = x<<5|x>>3, 6
+	}
+
+	t := (digit*c + b) ^ a
+	return a&0x80 | t>>2
+}
+
+func unquantizeASTCWeight(value int, r astcRange) int {
+	var w int
+	switch {
+	case !r.trits && !r.quints:
+		w = replicateBits(value, r.bits, 6)
+	case r.bits == 0 && r.trits:
+		w = [3]int{0, 32, 63}[value]
+	case r.bits == 0 && r.quints:
+		w = [5]int{0, 16, 32, 47, 63}[value]
+	default:
+		low := value & (1<<r.bits - 1)
+		digit := value >> r.bits
+		a := 0
+		if low&1 == 1 {
+			a = 0x7f
+		}
+
+		var b, c int
+		switch {
+		case r.trits && r.bits == 1:
+			c = 50
+		case r.trits && r.bits == 2:
+			x := low >> 1 & 1
+			b, c = x<<6|x<<2|x, 23
+		case r.trits && r.bits == 3:
+			x := low >> 1 & 3
+			b, c = x<<5|x, 11
+		case r.quints && r.bits == 1:
+			c = 28
+		case r.quints && r.bits == 2:
+			x := low >> 1 & 1
+			b, c = x<<6|x<<1, 13
+		}
+
+		t := (digit*c + b) ^ a
+		w = a&0x20 | t>>2
+	}
+
+	if w > 32 {
+		w++
+	}
+	return w
+}
+
+func replicateBits(value int, from int, to int) int {
+	if from == 0 {
+		return 0
+	}
+	out := 0
+	shift := to
+	for shift > 0 {
+		shift -= from
+		if shift >= 0 {
+			out |= value << shift
+		} else {
+			out |= value >> -shift
+		}
+	}
+	return out & (1<<to - 1)
+}
+
+// decodeASTCEndpoints returns the two RGBA endpoints of one partition. HDR
+// modes are rejected.
+func decodeASTCEndpoints(mode int, v []int) ([2][4]int, bool) {
+	rgba := func(r, g, b, a int) [4]int {
+		return [4]int{int(clampByte(r)), int(clampByte(g)), int(clampByte(b)), int(clampByte(a))}
+	}
+	blueContract := func(r, g, b, a int) [4]int { return rgba((r+b)>>1, (g+b)>>1, b, a) }
+	transfer := func(a, b int) (int, int) {
+		b = b>>1 | a&0x80
+		a = a >> 1 & 0x3f
+		if a&0x20 != 0 {
+			a -= 0x40
+		}
+		return a, b
+	}
+
+	switch mode {
+	case 0:
+		return [2][4]int{rgba(v[0], v[0], v[0], 255), rgba(v[1], v[1], v[1], 255)}, true
+	case 1:
+		l0 := v[0]>>2 | v[1]&0xc0
+		l1 := min(l0+v[1]&0x3f, 255)
+		return [2][4]int{rgba(l0, l0, l0, 255), rgba(l1, l1, l1, 255)}, true
+	case 4:
+		return [2][4]int{rgba(v[0], v[0], v[0], v[2]), rgba(v[1], v[1], v[1], v[3])}, true
+	case 5:
+		v1, v0 := transfer(v[1], v[0])
+		v3, v2 := transfer(v[3], v[2])
+		return [2][4]int{rgba(v0, v0, v0, v2), rgba(v0+v1, v0+v1, v0+v1, v2+v3)}, true
+	case 6:
+		return [2][4]int{rgba(v[0]*v[3]>>8, v[1]*v[3]>>8, v[2]*v[3]>>8, 255), rgba(v[0], v[1], v[2], 255)}, true
+	case 8:
+		if v[1]+v[3]+v[5] >= v[0]+v[2]+v[4] {
+			return [2][4]int{rgba(v[0], v[2], v[4], 255), rgba(v[1], v[3], v[5], 255)}, true
+		}
+		return [2][4]int{blueContract(v[1], v[3], v[5], 255), blueContract(v[0], v[2], v[4], 255)}, true
+	case 9:
+		v1, v0 := transfer(v[1], v[0])
+		v3, v2 := transfer(v[3], v[2])
+		v5, v4 := transfer(v[5], v[4])
+		if v1+v3+v5 >= 0 {
+			return [2][4]int{rgba(v0, v2, v4, 255), rgba(v0+v1, v2+v3, v4+v5, 255)}, true
+		}
+		return [2][4]int{blueContract(v0+v1, v2+v3, v4+v5, 255), blueContract(v0, v2, v4, 255)}, true
+	case 10:
+		return [2][4]int{rgba(v[0]*v[3]>>8, v[1]*v[3]>>8, v[2]*v[3]>>8, v[4]), rgba(v[0], v[1], v[2], v[5])}, true
+	case 12:
+		if v[1]+v[3]+v[5] >= v[0]+v[2]+v[4] {
+			return [2][4]int{rgba(v[0], v[2], v[4], v[6]), rgba(v[1], v[3], v[5], v[7])}, true
+		}
+		return [2][4]int{blueContract(v[1], v[3], v[5], v[7]), blueContract(v[0], v[2], v[4], v[6])}, true
+	case 13:
+		v1, v0 := transfer(v[1], v[0])
+		v3, v2 := transfer(v[3], v[2])
+		v5, v4 := transfer(v[5], v[4])
+		v7, v6 := transfer(v[7], v[6])
+		if v1+v3+v5 >= 0 {
+			return [2][4]int{rgba(v0, v2, v4, v6), rgba(v0+v1, v2+v3, v4+v5, v6+v7)}, true
+		}
+		return [2][4]int{blueContract(v0+v1, v2+v3, v4+v5, v6+v7), blueContract(v0, v2, v4, v6)}, true
+	}
+	return [2][4]int{}, false
+}
+
+// infillASTCWeight interpolates the weight grid bilinearly at texel x, y.
+func infillASTCWeight(weights []int, mode astcBlockMode, blockWidth int, blockHeight int, x int, y int, plane int, planes int) int {
+	ds := (1024 + blockWidth/2) / max(blockWidth-1, 1)
+	dt := (1024 + blockHeight/2) / max(blockHeight-1, 1)
+
+	gs := (ds*x*(mode.weightWidth-1) + 32) >> 6
+	gt := (dt*y*(mode.weightHeight-1) + 32) >> 6
+	js, fs := gs>>4, gs&0xf
+	jt, ft := gt>>4, gt&0xf
+
+	w11 := (fs*ft + 8) >> 4
+	w10 := ft - w11
+	w01 := fs - w11
+	w00 := 16 - fs - ft + w11
+
+	at := func(gx, gy int) int {
+		gx = min(gx, mode.weightWidth-1)
+		gy = min(gy, mode.weightHeight-1)
+		return weights[(gy*mode.weightWidth+gx)*planes+plane]
+	}
+
+	return (at(js, jt)*w00 + at(js+1, jt)*w01 + at(js, jt+1)*w10 + at(js+1, jt+1)*w11 + 8) >> 4
+}
+
+// selectASTCPartition is the partition hash of the specification for 2D
+// blocks.
+func selectASTCPartition(seed int, x int, y int, partitions int, smallBlock bool) int {
+	if smallBlock {
+		x <<= 1
+		y <<= 1
+	}
+
+	seed += (partitions - 1) * 1024
+	rnum := uint32(seed)
+	rnum ^= rnum >> 15
+	rnum -= rnum << 17
+	rnum += rnum << 7
+	rnum += rnum << 4
+	rnum ^= rnum >> 5
+	rnum += rnum << 16
+	rnum ^= rnum >> 7
+	rnum ^= rnum >> 3
+	rnum ^= rnum << 6
+	rnum ^= rnum >> 17
+
+	var seeds [8]int
+	for i := range seeds {
+		s := int(rnum >> (4 * i) & 0xf)
+		seeds[i] = s * s
+	}
+
+	var sh1, sh2 int
+	if seed&1 == 1 {
+		sh1, sh2 = 5, 5
+		if seed&2 != 0 {
+			sh1 = 4
+		}
+		if partitions == 3 {
+			sh2 = 6
+		}
+	} else {
+		sh1, sh2 = 5, 5
+		if partitions == 3 {
+			sh1 = 6
+		}
+		if seed&2 != 0 {
+			sh2 = 4
+		}
+	}
+	for i := range seeds {
+		if i%2 == 0 {
+			seeds[i] >>= sh1
+		} else {
+			seeds[i] >>= sh2
+		}
+	}
+
+	a := (seeds[0]*x + seeds[1]*y + int(rnum>>14)) & 0x3f
+	b := (seeds[2]*x + seeds[3]*y + int(rnum>>10)) & 0x3f
+	c := (seeds[4]*x + seeds[5]*y + int(rnum>>6)) & 0x3f
+	d := (seeds[6]*x + seeds[7]*y + int(rnum>>2)) & 0x3f
+	if partitions < 4 {
+		d = 0
+	}
+	if partitions < 3 {
+		c = 0
+	}
+
+	switch {
+	case a >= b && a >= c && a >= d:
+		return 0
+	case b >= c && b >= d:
+		return 1
+	case c >= d:
+		return 2
+	default:
+		return 3
+	}
+}
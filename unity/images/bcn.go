@@ -0,0 +1,106 @@
+package images
+
+import (
+	"encoding/binary"
+	"image/color"
+)
+
+// decodeBC1Block decodes a DXT1 block. Unity DXT1 textures have no alpha, so
+// the fourth color of the three color mode is opaque black.
+func decodeBC1Block(block []byte, texels []color.NRGBA) {
+	decodeBC1Colors(block, texels, true)
+}
+
+// decodeBC3Block decodes a DXT5 block: a BC4 alpha block followed by a color
+// block that always uses the four color mode.
+func decodeBC3Block(block []byte, texels []color.NRGBA) {
+	decodeBC1Colors(block[8:], texels, false)
+
+	var alpha [16]uint8
+	decodeBC4Values(block, &alpha)
+	for i := range texels {
+		texels[i].A = alpha[i]
+	}
+}
+
+// decodeBC4Block decodes a single channel block into red.
+func decodeBC4Block(block []byte, texels []color.NRGBA) {
+	var red [16]uint8
+	decodeBC4Values(block, &red)
+	for i := range texels {
+		texels[i] = color.NRGBA{red[i], 0, 0, 255}
+	}
+}
+
+// decodeBC5Block decodes two channel blocks into red and green.
+func decodeBC5Block(block []byte, texels []color.NRGBA) {
+	var red, green [16]uint8
+	decodeBC4Values(block, &red)
+	decodeBC4Values(block[8:], &green)
+	for i := range texels {
+		texels[i] = color.NRGBA{red[i], green[i], 0, 255}
+	}
+}
+
+func decodeBC1Colors(block []byte, texels []color.NRGBA, allowThreeColor bool) {
+	c0 := binary.LittleEndian.Uint16(block)
+	c1 := binary.LittleEndian.Uint16(block[2:])
+	indices := binary.LittleEndian.Uint32(block[4:])
+
+	var palette [4]color.NRGBA
+	palette[0] = rgb565(c0)
+	palette[1] = rgb565(c1)
+	p0, p1 := palette[0], palette[1]
+	if c0 > c1 || !allowThreeColor {
+		palette[2] = color.NRGBA{
+			uint8((2*int(p0.R) + int(p1.R)) / 3),
+			uint8((2*int(p0.G) + int(p1.G)) / 3),
+			uint8((2*int(p0.B) + int(p1.B)) / 3),
+			255,
+		}
+		palette[3] = color.NRGBA{
+			uint8((int(p0.R) + 2*int(p1.R)) / 3),
+			uint8((int(p0.G) + 2*int(p1.G)) / 3),
+			uint8((int(p0.B) + 2*int(p1.B)) / 3),
+			255,
+		}
+	} else {
+		palette[2] = color.NRGBA{
+			uint8((int(p0.R) + int(p1.R)) / 2),
+			uint8((int(p0.G) + int(p1.G)) / 2),
+			uint8((int(p0.B) + int(p1.B)) / 2),
+			255,
+		}
+		palette[3] = color.NRGBA{0, 0, 0, 255}
+	}
+
+	for i := range texels {
+		texels[i] = palette[indices>>(2*i)&3]
+	}
+}
+
+// decodeBC4Values decodes the 16 values of an 8 byte BC4 block.
+func decodeBC4Values(block []byte, values *[16]uint8) {
+	v0, v1 := int(block[0]), int(block[1])
+
+	var palette [8]uint8
+	palette[0], palette[1] = uint8(v0), uint8(v1)
+	if v0 > v1 {
+		for i := 1; i < 7; i++ {
+			palette[i+1] = uint8(((7-i)*v0 + i*v1) / 7)
+		}
+	} else {
+		for i := 1; i < 5; i++ {
+			palette[i+1] = uint8(((5-i)*v0 + i*v1) / 5)
+		}
+		palette[6], palette[7] = 0, 255
+	}
+
+	var bits uint64
+	for i := 0; i < 6; i++ {
+		bits |= uint64(block[2+i]) << (8 * i)
+	}
+	for i := range values {
+		values[i] = palette[bits>>(3*i)&7]
+	}
+}
@@ -0,0 +1,634 @@
+package images
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Crunch formats of the crn header written by Unity's crunch library.
+const (
+	crunchDXT1  = 0
+	crunchDXT5  = 2
+	crunchETC1  = 10
+	crunchETC2A = 12
+)
+
+// crunchHeaderSize is the size of a crn header with a single mip level.
+const crunchHeaderSize = 74
+
+// Palettes of a crn file in header order.
+const (
+	crunchColorEndpoints = iota
+	crunchColorSelectors
+	crunchAlphaEndpoints
+	crunchAlphaSelectors
+)
+
+var errCrunchCorrupt = errors.New("crunched texture data is corrupt")
+
+// crunchCodeLengthOrder is the order in which the sizes of the code length
+// codes are sent, most probable first.
+var crunchCodeLengthOrder = [21]int{17, 18, 19, 20, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15, 16}
+
+// crunchDXT5FromLinear maps a linear alpha selector, ordered from the first to
+// the second endpoint, to its DXT5 index.
+var crunchDXT5FromLinear = [8]uint32{0, 2, 3, 4, 5, 6, 7, 1}
+
+type crunchPalette struct {
+	offset int
+	size   int
+	count  int
+}
+
+type crunchHeader struct {
+	width        int
+	height       int
+	format       int
+	palettes     [4]crunchPalette
+	tablesOffset int
+	tablesSize   int
+	levels       [][2]int
+}
+
+// crunchedLevel returns the blocks and size of the given mip level of a
+// crunched texture. The size is taken from the crunch header.
+func (c textureCodec) crunchedLevel(data []byte, level int) ([]byte, int, int, error) {
+	blocks, width, height, err := unpackCrunch(data, level)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if len(blocks) != c.levelSize(width, height) {
+		return nil, 0, 0, fmt.Errorf("%s texture holds crunched blocks of another format", c.name)
+	}
+	return blocks, width, height, nil
+}
+
+// unpackCrunch unpacks one mip level of a crn file into the DXT or ETC blocks
+// it was crunched from and returns them with the size of the level.
+func unpackCrunch(data []byte, level int) ([]byte, int, int, error) {
+	header, err := readCrunchHeader(data)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if level >= len(header.levels) {
+		return nil, 0, 0, fmt.Errorf("crunched texture has no mip level %d", level)
+	}
+
+	var alpha, etc bool
+	switch header.format {
+	case crunchDXT1:
+	case crunchDXT5:
+		alpha = true
+	case crunchETC1:
+		etc = true
+	case crunchETC2A:
+		alpha, etc = true, true
+	default:
+		return nil, 0, 0, fmt.Errorf("crunch format %d is not supported", header.format)
+	}
+	if header.palettes[crunchColorEndpoints].count == 0 || header.palettes[crunchColorSelectors].count == 0 ||
+		alpha && (header.palettes[crunchAlphaEndpoints].count == 0 || header.palettes[crunchAlphaSelectors].count == 0) {
+		return nil, 0, 0, errCrunchCorrupt
+	}
+
+	d := crunchDecoder{}
+	tables := crunchCodec{data: data[header.tablesOffset : header.tablesOffset+header.tablesSize]}
+	d.reference = tables.model()
+	d.endpointDelta[0] = tables.model()
+	d.selectorDelta[0] = tables.model()
+	if alpha {
+		d.endpointDelta[1] = tables.model()
+		d.selectorDelta[1] = tables.model()
+	}
+	if tables.err != nil {
+		return nil, 0, 0, tables.err
+	}
+
+	palette := func(index int) *crunchCodec {
+		p := header.palettes[index]
+		return &crunchCodec{data: data[p.offset : p.offset+p.size]}
+	}
+	if err := d.decodeColorEndpoints(palette(crunchColorEndpoints), header.palettes[crunchColorEndpoints].count, etc); err != nil {
+		return nil, 0, 0, err
+	}
+	if err := d.decodeColorSelectors(palette(crunchColorSelectors), header.palettes[crunchColorSelectors].count, etc); err != nil {
+		return nil, 0, 0, err
+	}
+	if alpha {
+		if err := d.decodeAlphaEndpoints(palette(crunchAlphaEndpoints), header.palettes[crunchAlphaEndpoints].count); err != nil {
+			return nil, 0, 0, err
+		}
+		if err := d.decodeAlphaSelectors(palette(crunchAlphaSelectors), header.palettes[crunchAlphaSelectors].count, etc); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+
+	width := max(1, header.width>>level)
+	height := max(1, header.height>>level)
+	blocksX, blocksY := (width+3)/4, (height+3)/4
+	codec := &crunchCodec{data: data[header.levels[level][0]:header.levels[level][1]]}
+	// Every block decodes at least one symbol of one bit or more.
+	if blocksX*blocksY > 8*len(codec.data) {
+		return nil, 0, 0, errCrunchCorrupt
+	}
+	var blocks []byte
+	if etc {
+		blocks = d.unpackETC(codec, blocksX, blocksY, alpha)
+	} else {
+		blocks = d.unpackDXT(codec, blocksX, blocksY, alpha)
+	}
+	if codec.err != nil {
+		return nil, 0, 0, codec.err
+	}
+	return blocks, width, height, nil
+}
+
+// readCrunchHeader reads the big endian crn header and checks that the
+// palettes, tables and levels it points to are inside the file.
+func readCrunchHeader(data []byte) (crunchHeader, error) {
+	if len(data) < crunchHeaderSize || data[0] != 'H' || data[1] != 'x' {
+		return crunchHeader{}, fmt.Errorf("crunched texture has no crunch header")
+	}
+
+	header := crunchHeader{
+		width:        crunchUint(data[12:14]),
+		height:       crunchUint(data[14:16]),
+		format:       int(data[18]),
+		tablesSize:   crunchUint(data[65:67]),
+		tablesOffset: crunchUint(data[67:70]),
+	}
+	headerSize := crunchUint(data[2:4])
+	dataSize := crunchUint(data[6:10])
+	levels := int(data[16])
+	if dataSize > len(data) || levels == 0 || headerSize < crunchHeaderSize+4*(levels-1) || headerSize > dataSize ||
+		header.width == 0 || header.height == 0 || header.tablesOffset+header.tablesSize > dataSize {
+		return crunchHeader{}, errCrunchCorrupt
+	}
+	for i := range header.palettes {
+		p := data[33+8*i:]
+		header.palettes[i] = crunchPalette{offset: crunchUint(p[0:3]), size: crunchUint(p[3:6]), count: crunchUint(p[6:8])}
+		if header.palettes[i].offset+header.palettes[i].size > dataSize {
+			return crunchHeader{}, errCrunchCorrupt
+		}
+	}
+
+	header.levels = make([][2]int, levels)
+	for i := range header.levels {
+		start, end := crunchUint(data[70+4*i:74+4*i]), dataSize
+		if i+1 < levels {
+			end = crunchUint(data[74+4*i : 78+4*i])
+		}
+		if start < headerSize || start > end || end > dataSize {
+			return crunchHeader{}, errCrunchCorrupt
+		}
+		header.levels[i] = [2]int{start, end}
+	}
+	return header, nil
+}
+
+func crunchUint(b []byte) int {
+	v := 0
+	for _, c := range b {
+		v = v<<8 | int(c)
+	}
+	return v
+}
+
+// crunchCodec reads bits most significant first and decodes Huffman coded
+// symbols. Reads past the end of the data return zero bits. The first error
+// is kept and later decodes return 0.
+type crunchCodec struct {
+	data     []byte
+	bitBuf   uint32
+	bitCount int
+	err      error
+}
+
+func (c *crunchCodec) bits(n int) int {
+	if n > 16 {
+		upper := c.bits(n - 16)
+		return upper<<16 | c.bits(16)
+	}
+	for c.bitCount < n {
+		var b byte
+		if len(c.data) > 0 {
+			b, c.data = c.data[0], c.data[1:]
+		}
+		c.bitCount += 8
+		c.bitBuf |= uint32(b) << (32 - c.bitCount)
+	}
+	v := c.bitBuf >> (32 - n)
+	c.bitBuf <<= n
+	c.bitCount -= n
+	return int(v)
+}
+
+func (c *crunchCodec) fail() {
+	if c.err == nil {
+		c.err = errCrunchCorrupt
+	}
+}
+
+// crunchModel is a canonical Huffman code: codes are assigned in order of
+// their size, and of their symbol for equal sizes.
+type crunchModel struct {
+	counts  [17]int
+	symbols []int
+}
+
+func newCrunchModel(sizes []uint8) crunchModel {
+	var m crunchModel
+	for size := 1; size <= 16; size++ {
+		for symbol, s := range sizes {
+			if int(s) == size {
+				m.counts[size]++
+				m.symbols = append(m.symbols, symbol)
+			}
+		}
+	}
+	return m
+}
+
+func (c *crunchCodec) decode(m *crunchModel) int {
+	if c.err != nil {
+		return 0
+	}
+	code, first, index := 0, 0, 0
+	for size := 1; size <= 16; size++ {
+		code |= c.bits(1)
+		if code >= first && code-first < m.counts[size] {
+			return m.symbols[index+code-first]
+		}
+		index += m.counts[size]
+		first = (first + m.counts[size]) << 1
+		code <<= 1
+	}
+	c.fail()
+	return 0
+}
+
+// model reads a Huffman code. The code sizes are themselves Huffman coded,
+// with run length codes 17 to 20 for runs of zeros and of the previous size.
+func (c *crunchCodec) model() crunchModel {
+	total := c.bits(14)
+	if total == 0 {
+		return crunchModel{}
+	}
+	count := c.bits(5)
+	if count < 1 || count > len(crunchCodeLengthOrder) {
+		c.fail()
+		return crunchModel{}
+	}
+	var lengthSizes [21]uint8
+	for i := 0; i < count; i++ {
+		lengthSizes[crunchCodeLengthOrder[i]] = uint8(c.bits(3))
+	}
+	lengths := newCrunchModel(lengthSizes[:])
+
+	sizes := make([]uint8, total)
+	for i := 0; i < total && c.err == nil; {
+		code := c.decode(&lengths)
+		if code <= 16 {
+			sizes[i] = uint8(code)
+			i++
+			continue
+		}
+
+		var run int
+		switch code {
+		case 17:
+			run = c.bits(3) + 3
+		case 18:
+			run = c.bits(7) + 11
+		case 19:
+			run = c.bits(2) + 3
+		default:
+			run = c.bits(7) + 7
+		}
+		if i+run > total || code >= 19 && (i == 0 || sizes[i-1] == 0) {
+			c.fail()
+			break
+		}
+		for end := i + run; i < end; i++ {
+			if code >= 19 {
+				sizes[i] = sizes[i-1]
+			}
+		}
+	}
+	return newCrunchModel(sizes)
+}
+
+// crunchDecoder holds the Huffman codes and palettes shared by the levels of
+// a crn file. Color selectors are stored twice for ETC, the second one for
+// flipped blocks, and alpha selectors hold the index bytes of their block.
+type crunchDecoder struct {
+	reference      crunchModel
+	endpointDelta  [2]crunchModel
+	selectorDelta  [2]crunchModel
+	colorEndpoints []uint32
+	colorSelectors []uint32
+	alphaEndpoints []uint16
+	alphaSelectors []byte
+}
+
+func (d *crunchDecoder) decodeColorEndpoints(c *crunchCodec, count int, etc bool) error {
+	d.colorEndpoints = make([]uint32, count)
+	if etc {
+		m := c.model()
+		var a uint32
+		for i := range d.colorEndpoints {
+			for shift := 0; shift < 32; shift += 8 {
+				a += uint32(c.decode(&m)) << shift
+			}
+			a &= 0x1f1f1f1f
+			d.colorEndpoints[i] = a
+		}
+		return c.err
+	}
+
+	m := [2]crunchModel{c.model(), c.model()}
+	var r0, g0, b0, r1, g1, b1 uint32
+	for i := range d.colorEndpoints {
+		r0 = (r0 + uint32(c.decode(&m[0]))) & 31
+		g0 = (g0 + uint32(c.decode(&m[1]))) & 63
+		b0 = (b0 + uint32(c.decode(&m[0]))) & 31
+		r1 = (r1 + uint32(c.decode(&m[0]))) & 31
+		g1 = (g1 + uint32(c.decode(&m[1]))) & 63
+		b1 = (b1 + uint32(c.decode(&m[0]))) & 31
+		d.colorEndpoints[i] = b0 | g0<<5 | r0<<11 | b1<<16 | g1<<21 | r1<<27
+	}
+	return c.err
+}
+
+func (d *crunchDecoder) decodeColorSelectors(c *crunchCodec, count int, etc bool) error {
+	m := c.model()
+	if etc {
+		d.colorSelectors = make([]uint32, count*2)
+	} else {
+		d.colorSelectors = make([]uint32, count)
+	}
+
+	var s uint32
+	for i := 0; i < count; i++ {
+		for shift := 0; shift < 32; shift += 4 {
+			s ^= uint32(c.decode(&m)) << shift
+		}
+		if !etc {
+			d.colorSelectors[i] = (s^s<<1)&0xaaaaaaaa | s>>1&0x55555555
+			continue
+		}
+
+		// The linear selectors are row by row, ETC stores the most
+		// significant bits of the column by column indices in the low half
+		// of the little endian word.
+		selector := ^s&0xaaaaaaaa | ^(s^s>>1)&0x55555555
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 4; x++ {
+				shift := (x*4 + y + 8) & 15
+				flipped := selector >> (x<<3 | y<<1)
+				plain := selector >> (y<<3 | x<<1)
+				d.colorSelectors[i<<1] |= (flipped>>1&1 | (flipped&1)<<16) << shift
+				d.colorSelectors[i<<1|1] |= (plain>>1&1 | (plain&1)<<16) << shift
+			}
+		}
+	}
+	return c.err
+}
+
+func (d *crunchDecoder) decodeAlphaEndpoints(c *crunchCodec, count int) error {
+	m := c.model()
+	d.alphaEndpoints = make([]uint16, count)
+	var a, b int
+	for i := range d.alphaEndpoints {
+		a = (a + c.decode(&m)) & 255
+		b = (b + c.decode(&m)) & 255
+		d.alphaEndpoints[i] = uint16(a | b<<8)
+	}
+	return c.err
+}
+
+func (d *crunchDecoder) decodeAlphaSelectors(c *crunchCodec, count int, etc bool) error {
+	m := c.model()
+	if !etc {
+		d.alphaSelectors = make([]byte, count*6)
+		var linear [2]uint32
+		for i := 0; i < count; i++ {
+			var s [2]uint32
+			for half := range s {
+				for shift := 0; shift < 24; shift += 6 {
+					linear[half] ^= uint32(c.decode(&m)) << shift
+					v := linear[half] >> shift & 63
+					s[half] |= (crunchDXT5FromLinear[v&7] | crunchDXT5FromLinear[v>>3]<<3) << shift
+				}
+			}
+			dst := d.alphaSelectors[i*6:]
+			binary.LittleEndian.PutUint16(dst, uint16(s[0]))
+			binary.LittleEndian.PutUint16(dst[2:], uint16(s[0]>>16|s[1]<<8))
+			binary.LittleEndian.PutUint16(dst[4:], uint16(s[1]>>8))
+		}
+		return c.err
+	}
+
+	// ETC2 alpha indices are 48 bits, big endian and column by column. Each
+	// selector is stored as is and transposed for flipped blocks.
+	d.alphaSelectors = make([]byte, count*12)
+	set := func(dst []byte, bit int, s byte) {
+		if bit&7 != 0 {
+			dst[bit>>3] |= s << (8 - bit&7)
+		}
+		if bit&7 < 3 {
+			dst[bit>>3-1] |= s >> (bit & 7)
+		}
+	}
+	var linear [8]uint32
+	for i := 0; i < count; i++ {
+		dst := d.alphaSelectors[i*12 : i*12+12]
+		var group uint32
+		for p := 0; p < 16; p++ {
+			if p&1 == 1 {
+				group >>= 3
+			} else {
+				linear[p>>1] ^= uint32(c.decode(&m))
+				group = linear[p>>1]
+			}
+			s := byte(group & 7)
+			if s <= 3 {
+				s = 3 - s
+			}
+			bit := 3 * (p + 1)
+			set(dst, bit, s)
+			set(dst, bit+9*(p&3-p>>2)+48, s)
+		}
+	}
+	return c.err
+}
+
+// unpackDXT unpacks DXT1 or DXT5 blocks. Blocks are coded in pairs of rows:
+// every 2x2 group of blocks starts with a reference code that says, for each
+// block, whether its endpoints are coded as a delta, the same as the block to
+// the left or the same as the block above.
+func (d *crunchDecoder) unpackDXT(c *crunchCodec, blocksX int, blocksY int, alpha bool) []byte {
+	blockBytes := 8
+	if alpha {
+		blockBytes = 16
+	}
+	blocks := make([]byte, blocksX*blocksY*blockBytes)
+	width, height := (blocksX+1)&^1, (blocksY+1)&^1
+
+	type above struct{ reference, color, alpha int }
+	buffer := make([]above, width)
+	var colorIndex, alphaIndex, group int
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if y&1 == 0 && x&1 == 0 {
+				group = c.decode(&d.reference)
+			}
+			b := &buffer[x]
+			reference := b.reference
+			if y&1 == 0 {
+				reference = group & 3
+				b.reference = group >> 2 & 3
+				group >>= 4
+			}
+
+			switch reference {
+			case 0:
+				colorIndex = (colorIndex + c.decode(&d.endpointDelta[0])) % len(d.colorEndpoints)
+				if alpha {
+					alphaIndex = (alphaIndex + c.decode(&d.endpointDelta[1])) % len(d.alphaEndpoints)
+				}
+				b.color, b.alpha = colorIndex, alphaIndex
+			case 1:
+				b.color, b.alpha = colorIndex, alphaIndex
+			default:
+				colorIndex, alphaIndex = b.color, b.alpha
+			}
+
+			colorSelector := c.decode(&d.selectorDelta[0])
+			alphaSelector := 0
+			if alpha {
+				alphaSelector = c.decode(&d.selectorDelta[1])
+			}
+			if x >= blocksX || y >= blocksY {
+				continue
+			}
+			if colorSelector >= len(d.colorSelectors) || alpha && alphaSelector >= len(d.alphaSelectors)/6 {
+				c.fail()
+				return nil
+			}
+
+			block := blocks[(y*blocksX+x)*blockBytes:]
+			if alpha {
+				binary.LittleEndian.PutUint16(block, d.alphaEndpoints[alphaIndex])
+				copy(block[2:8], d.alphaSelectors[alphaSelector*6:])
+				block = block[8:]
+			}
+			binary.LittleEndian.PutUint32(block, d.colorEndpoints[colorIndex])
+			binary.LittleEndian.PutUint32(block[4:], d.colorSelectors[colorSelector])
+		}
+	}
+	return blocks
+}
+
+// unpackETC unpacks ETC1 or ETC2 RGBA8 blocks. Every block has two endpoints,
+// one for each subblock, and the reference code of the first one can also
+// point to the block above and to the left.
+func (d *crunchDecoder) unpackETC(c *crunchCodec, blocksX int, blocksY int, alpha bool) []byte {
+	blockBytes := 8
+	if alpha {
+		blockBytes = 16
+	}
+	blocks := make([]byte, blocksX*blocksY*blockBytes)
+	width, height := (blocksX+1)&^1, (blocksY+1)&^1
+
+	// buffer[x<<1] holds the first endpoints of the block above and
+	// buffer[x<<1|1] the second ones, which become the diagonal reference of
+	// the block to the right.
+	type above struct{ reference, color, alpha int }
+	buffer := make([]above, width*2)
+	var colorIndex, alphaIndex, diagonalColor, diagonalAlpha int
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			b := &buffer[x<<1]
+			reference := b.reference
+			if y&1 == 0 {
+				group := c.decode(&d.reference)
+				reference = group&3 | group>>2&12
+				b.reference = group>>2&3 | group>>4&12
+			}
+
+			switch reference & 3 {
+			case 0:
+				colorIndex = (colorIndex + c.decode(&d.endpointDelta[0])) % len(d.colorEndpoints)
+				if alpha {
+					alphaIndex = (alphaIndex + c.decode(&d.endpointDelta[1])) % len(d.alphaEndpoints)
+				}
+				b.color, b.alpha = colorIndex, alphaIndex
+			case 1:
+				b.color, b.alpha = colorIndex, alphaIndex
+			case 3:
+				colorIndex, alphaIndex = diagonalColor, diagonalAlpha
+				b.color, b.alpha = colorIndex, alphaIndex
+			default:
+				colorIndex, alphaIndex = b.color, b.alpha
+			}
+			reference >>= 2
+
+			e0 := d.colorEndpoints[colorIndex]
+			colorSelector := c.decode(&d.selectorDelta[0])
+			alphaSelector := 0
+			if alpha {
+				alphaSelector = c.decode(&d.selectorDelta[1])
+			}
+			if reference != 0 {
+				colorIndex = (colorIndex + c.decode(&d.endpointDelta[0])) % len(d.colorEndpoints)
+			}
+			diagonal := &buffer[x<<1|1]
+			diagonalColor, diagonalAlpha = diagonal.color, diagonal.alpha
+			diagonal.color, diagonal.alpha = colorIndex, alphaIndex
+			e1 := d.colorEndpoints[colorIndex]
+
+			if x >= blocksX || y >= blocksY {
+				continue
+			}
+			if colorSelector >= len(d.colorSelectors)/2 || alpha && alphaSelector >= len(d.alphaSelectors)/12 {
+				c.fail()
+				return nil
+			}
+
+			flip := reference>>1 ^ 1
+			block := blocks[(y*blocksX+x)*blockBytes:]
+			if alpha {
+				binary.LittleEndian.PutUint16(block, d.alphaEndpoints[alphaIndex])
+				copy(block[2:8], d.alphaSelectors[alphaSelector*12+flip*6:])
+				block = block[8:]
+			}
+			crunchETCEndpoints(block, e0, e1, flip)
+			binary.LittleEndian.PutUint32(block[4:], d.colorSelectors[colorSelector<<1|flip])
+		}
+	}
+	return blocks
+}
+
+// crunchETCEndpoints writes the base colors, tables and flags of an ETC1
+// block. The endpoints hold 5 bit colors and a table index per subblock, the
+// block uses differential mode when the second color is close enough.
+func crunchETCEndpoints(block []byte, e0 uint32, e1 uint32, flip int) {
+	diff := 1
+	for c := 0; c < 3; c++ {
+		a, b := int(e0>>(8*c)&0xff), int(e1>>(8*c)&0xff)
+		if a+3 < b || b+4 < a {
+			diff = 0
+		}
+	}
+	for c := 0; c < 3; c++ {
+		a, b := byte(e0>>(8*c)), byte(e1>>(8*c))
+		if diff == 1 {
+			block[c] = a<<3 | (b-a)&7
+		} else {
+			block[c] = a<<3&0xf0 | b>>1
+		}
+	}
+	block[3] = byte(e0>>24)<<5 | byte(e1>>24)<<2 | byte(diff<<1|flip)
+}
@@ -0,0 +1,274 @@
+package images
+
+import (
+	"encoding/binary"
+	"image/color"
+)
+
+var etc1ModifierTable = [8][4]int{
+	{2, 8, -2, -8},
+	{5, 17, -5, -17},
+	{9, 29, -9, -29},
+	{13, 42, -13, -42},
+	{18, 60, -18, -60},
+	{24, 80, -24, -80},
+	{33, 106, -33, -106},
+	{47, 183, -47, -183},
+}
+
+var etc2DistanceTable = [8]int{3, 6, 11, 16, 23, 32, 41, 64}
+
+var eacModifierTable = [16][8]int{
+	{-3, -6, -9, -15, 2, 5, 8, 14},
+	{-3, -7, -10, -13, 2, 6, 9, 12},
+	{-2, -5, -8, -13, 1, 4, 7, 12},
+	{-2, -4, -6, -13, 1, 3, 5, 12},
+	{-3, -6, -8, -12, 2, 5, 7, 11},
+	{-3, -7, -9, -11, 2, 6, 8, 10},
+	{-4, -7, -8, -11, 3, 6, 7, 10},
+	{-3, -5, -8, -11, 2, 4, 7, 10},
+	{-2, -6, -8, -10, 1, 5, 7, 9},
+	{-2, -5, -8, -10, 1, 4, 7, 9},
+	{-2, -4, -8, -10, 1, 3, 7, 9},
+	{-2, -5, -7, -10, 1, 4, 6, 9},
+	{-3, -4, -7, -10, 2, 3, 6, 9},
+	{-1, -2, -3, -10, 0, 1, 2, 9},
+	{-4, -6, -8, -9, 3, 5, 7, 8},
+	{-3, -5, -7, -9, 2, 4, 6, 8},
+}
+
+func decodeETC1Block(block []byte, texels []color.NRGBA) {
+	decodeETCBlock(binary.BigEndian.Uint64(block), texels, false, true)
+}
+
+func decodeETC2RGBBlock(block []byte, texels []color.NRGBA) {
+	decodeETCBlock(binary.BigEndian.Uint64(block), texels, true, true)
+}
+
+// decodeETC2RGBA1Block decodes punch-through alpha blocks. The differential
+// bit is the opaque flag here and individual mode does not exist.
+func decodeETC2RGBA1Block(block []byte, texels []color.NRGBA) {
+	bits := binary.BigEndian.Uint64(block)
+	decodeETCBlock(bits|1<<33, texels, true, bits>>33&1 == 1)
+}
+
+func decodeETC2RGBA8Block(block []byte, texels []color.NRGBA) {
+	decodeETCBlock(binary.BigEndian.Uint64(block[8:]), texels, true, true)
+
+	var alpha [16]uint8
+	decodeETC2AlphaValues(block, &alpha)
+	for i := range texels {
+		texels[i].A = alpha[i]
+	}
+}
+
+func decodeEACRBlock(signed bool) func([]byte, []color.NRGBA) {
+	return func(block []byte, texels []color.NRGBA) {
+		var red [16]uint8
+		decodeEACValues(block, signed, &red)
+		for i := range texels {
+			texels[i] = color.NRGBA{red[i], 0, 0, 255}
+		}
+	}
+}
+
+func decodeEACRGBlock(signed bool) func([]byte, []color.NRGBA) {
+	return func(block []byte, texels []color.NRGBA) {
+		var red, green [16]uint8
+		decodeEACValues(block, signed, &red)
+		decodeEACValues(block[8:], signed, &green)
+		for i := range texels {
+			texels[i] = color.NRGBA{red[i], green[i], 0, 255}
+		}
+	}
+}
+
+// decodeETCBlock decodes an ETC1 or ETC2 color block. Texels are indexed
+// column by column in the block, the result is written row by row.
+func decodeETCBlock(bits uint64, texels []color.NRGBA, etc2 bool, opaque bool) {
+	field := func(shift uint, width uint) int { return int(bits >> shift & (1<<width - 1)) }
+
+	if field(33, 1) == 0 {
+		r1, r2 := field(60, 4)*17, field(56, 4)*17
+		g1, g2 := field(52, 4)*17, field(48, 4)*17
+		b1, b2 := field(44, 4)*17, field(40, 4)*17
+		decodeETCSubblocks(bits, texels, [2][3]int{{r1, g1, b1}, {r2, g2, b2}}, opaque)
+		return
+	}
+
+	r, g, b := field(59, 5), field(51, 5), field(43, 5)
+	dr, dg, db := signExtend3(field(56, 3)), signExtend3(field(48, 3)), signExtend3(field(40, 3))
+	switch {
+	case etc2 && (r+dr < 0 || r+dr > 31):
+		decodeETC2TMode(bits, texels, opaque)
+	case etc2 && (g+dg < 0 || g+dg > 31):
+		decodeETC2HMode(bits, texels, opaque)
+	case etc2 && (b+db < 0 || b+db > 31):
+		decodeETC2PlanarMode(bits, texels)
+	default:
+		decodeETCSubblocks(bits, texels, [2][3]int{
+			{expand5(r), expand5(g), expand5(b)},
+			{expand5(r + dr), expand5(g + dg), expand5(b + db)},
+		}, opaque)
+	}
+}
+
+func decodeETCSubblocks(bits uint64, texels []color.NRGBA, base [2][3]int, opaque bool) {
+	flip := bits>>32&1 == 1
+	tables := [2]int{int(bits >> 37 & 7), int(bits >> 34 & 7)}
+
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			subblock := 0
+			if (!flip && x >= 2) || (flip && y >= 2) {
+				subblock = 1
+			}
+
+			index := etcTexelIndex(bits, x, y)
+			if !opaque && index == 2 {
+				texels[y*4+x] = color.NRGBA{}
+				continue
+			}
+
+			modifier := etc1ModifierTable[tables[subblock]][index]
+			if !opaque && index == 0 {
+				modifier = 0
+			}
+			c := base[subblock]
+			texels[y*4+x] = color.NRGBA{clampByte(c[0] + modifier), clampByte(c[1] + modifier), clampByte(c[2] + modifier), 255}
+		}
+	}
+}
+
+func decodeETC2TMode(bits uint64, texels []color.NRGBA, opaque bool) {
+	field := func(shift uint, width uint) int { return int(bits >> shift & (1<<width - 1)) }
+
+	c0 := [3]int{(field(59, 2)<<2 | field(56, 2)) * 17, field(52, 4) * 17, field(48, 4) * 17}
+	c1 := [3]int{field(44, 4) * 17, field(40, 4) * 17, field(36, 4) * 17}
+	distance := etc2DistanceTable[field(34, 2)<<1|field(32, 1)]
+
+	paint := [4][3]int{c0, etc2Offset(c1, distance), c1, etc2Offset(c1, -distance)}
+	decodeETC2Paint(bits, texels, paint, opaque)
+}
+
+func decodeETC2HMode(bits uint64, texels []color.NRGBA, opaque bool) {
+	field := func(shift uint, width uint) int { return int(bits >> shift & (1<<width - 1)) }
+
+	r0, g0, b0 := field(59, 4), field(56, 3)<<1|field(52, 1), field(51, 1)<<3|field(47, 3)
+	r1, g1, b1 := field(43, 4), field(39, 4), field(35, 4)
+	distanceIndex := field(34, 1)<<2 | field(32, 1)<<1
+	if r0<<8|g0<<4|b0 >= r1<<8|g1<<4|b1 {
+		distanceIndex |= 1
+	}
+	distance := etc2DistanceTable[distanceIndex]
+
+	c0 := [3]int{r0 * 17, g0 * 17, b0 * 17}
+	c1 := [3]int{r1 * 17, g1 * 17, b1 * 17}
+	paint := [4][3]int{etc2Offset(c0, distance), etc2Offset(c0, -distance), etc2Offset(c1, distance), etc2Offset(c1, -distance)}
+	decodeETC2Paint(bits, texels, paint, opaque)
+}
+
+func decodeETC2PlanarMode(bits uint64, texels []color.NRGBA) {
+	field := func(shift uint, width uint) int { return int(bits >> shift & (1<<width - 1)) }
+
+	ro := expand6(field(57, 6))
+	gO := expand7(field(56, 1)<<6 | field(49, 6))
+	bo := expand6(field(48, 1)<<5 | field(43, 2)<<3 | field(39, 3))
+	rh := expand6(field(34, 5)<<1 | field(32, 1))
+	gh := expand7(field(25, 7))
+	bh := expand6(field(19, 6))
+	rv := expand6(field(13, 6))
+	gv := expand7(field(6, 7))
+	bv := expand6(field(0, 6))
+
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			texels[y*4+x] = color.NRGBA{
+				clampByte((x*(rh-ro) + y*(rv-ro) + 4*ro + 2) >> 2),
+				clampByte((x*(gh-gO) + y*(gv-gO) + 4*gO + 2) >> 2),
+				clampByte((x*(bh-bo) + y*(bv-bo) + 4*bo + 2) >> 2),
+				255,
+			}
+		}
+	}
+}
+
+func decodeETC2Paint(bits uint64, texels []color.NRGBA, paint [4][3]int, opaque bool) {
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			index := etcTexelIndex(bits, x, y)
+			if !opaque && index == 2 {
+				texels[y*4+x] = color.NRGBA{}
+				continue
+			}
+			c := paint[index]
+			texels[y*4+x] = color.NRGBA{clampByte(c[0]), clampByte(c[1]), clampByte(c[2]), 255}
+		}
+	}
+}
+
+// decodeETC2AlphaValues decodes the 8 bit alpha block of ETC2_RGBA8.
+func decodeETC2AlphaValues(block []byte, values *[16]uint8) {
+	bits := binary.BigEndian.Uint64(block)
+	multiplier := int(bits >> 52 & 0xf)
+	modifiers := eacModifierTable[bits>>48&0xf]
+
+	for i := 0; i < 16; i++ {
+		x, y := i/4, i%4
+		values[y*4+x] = clampByte(int(block[0]) + modifiers[bits>>(45-3*i)&7]*multiplier)
+	}
+}
+
+// decodeEACValues decodes an 8 byte EAC block. The 11 bit results are reduced
+// to 8 bits.
+func decodeEACValues(block []byte, signed bool, values *[16]uint8) {
+	bits := binary.BigEndian.Uint64(block)
+	multiplier := int(bits >> 52 & 0xf)
+	modifiers := eacModifierTable[bits>>48&0xf]
+
+	for i := 0; i < 16; i++ {
+		x, y := i/4, i%4
+		modifier := modifiers[bits>>(45-3*i)&7]
+
+		var value int
+		switch {
+		case !signed:
+			base := int(block[0])*8 + 4
+			if multiplier == 0 {
+				value = base + modifier
+			} else {
+				value = base + modifier*multiplier*8
+			}
+			value = min(max(value, 0), 2047) * 255 / 2047
+		default:
+			base := max(int(int8(block[0])), -127) * 8
+			if multiplier == 0 {
+				value = base + modifier
+			} else {
+				value = base + modifier*multiplier*8
+			}
+			value = (min(max(value, -1023), 1023) + 1023) * 255 / 2046
+		}
+		values[y*4+x] = uint8(value)
+	}
+}
+
+func etcTexelIndex(bits uint64, x int, y int) int {
+	i := uint(x*4 + y)
+	return int(bits>>(16+i)&1)<<1 | int(bits>>i&1)
+}
+
+func etc2Offset(c [3]int, distance int) [3]int {
+	return [3]int{c[0] + distance, c[1] + distance, c[2] + distance}
+}
+
+func signExtend3(v int) int {
+	if v >= 4 {
+		return v - 8
+	}
+	return v
+}
+
+func expand5(v int) int { return v<<3 | v>>2 }
+func expand6(v int) int { return v<<2 | v>>4 }
+func expand7(v int) int { return v<<1 | v>>6 }
@@ -0,0 +1,400 @@
+// Package images decodes the sprites and textures of Dofus 3 image bundles.
+package images
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"math"
+
+	"github.com/dofusdude/doduda/unity/bundle"
+	"github.com/kvarenzn/ssm/uni"
+)
+
+// Bundle is a loaded image bundle.
+type Bundle struct {
+	// MipLevel selects the mip level Textures decodes, 0 being the full
+	// resolution. Sprites always use the full resolution texture.
+	MipLevel int
+
+	assets       *uni.AssetsManager
+	textureCache map[*uni.Texture2D]image.Image
+}
+
+// Sprite is a decoded sprite. Image is the full texture the sprite lives on.
+// PathID is 0 when the object is unknown.
+type Sprite struct {
+	Name        string
+	TextureName string
+	PathID      int64
+	Image       image.Image
+
+	// Rect is the sprite area on its texture in pixels, with the origin at
+	// the bottom left like in Unity.
+	Rect SpriteRect
+	// Pivot is relative to Rect, (0.5, 0.5) being the center.
+	Pivot         SpritePivot
+	PixelsPerUnit float64
+	// Border is the 9-slice border in pixels, all zero for sprites that are
+	// not sliced.
+	Border SpriteBorder
+}
+
+// SpriteRect is a rectangle in texture pixels.
+type SpriteRect struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// SpritePivot is a normalized point inside a SpriteRect.
+type SpritePivot struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// SpriteBorder holds the distances of the 9-slice lines from the edges.
+type SpriteBorder struct {
+	Left   float64 `json:"left"`
+	Bottom float64 `json:"bottom"`
+	Right  float64 `json:"right"`
+	Top    float64 `json:"top"`
+}
+
+// Texture is a decoded Texture2D.
+type Texture struct {
+	Name   string
+	PathID int64
+	Image  image.Image
+}
+
+// Open reads the whole image bundle from r. The container is read by
+// bundle.LoadAssets, so image bundles support the same formats as data
+// bundles.
+func Open(r io.ReaderAt) (_ *Bundle, err error) {
+	defer recoverMalformed(&err)
+	data, err := io.ReadAll(io.NewSectionReader(r, 0, math.MaxInt64))
+	if err != nil {
+		return nil, err
+	}
+
+	assetsManager := uni.NewAssetsManager()
+	if err := bundle.LoadAssets(data, "data.imagebundle", assetsManager); err != nil {
+		return nil, err
+	}
+
+	for _, assetFile := range assetsManager.AssetFiles {
+		for _, objectInfo := range assetFile.ObjectInfos {
+			reader := uni.NewObjectReader(assetFile.Reader.BinaryReader, assetFile, objectInfo)
+			switch objectInfo.ClassID {
+			case uni.ClassIDAssetBundle:
+				assetFile.AddObject(uni.NewAssetBundle(reader))
+			case uni.ClassIDTexture2D:
+				assetFile.AddObject(uni.NewTexture2D(reader))
+			case uni.ClassIDSprite:
+				assetFile.AddObject(uni.NewSprite(reader))
+			default:
+				assetFile.AddObject(uni.NewObject(reader))
+			}
+		}
+	}
+
+	return &Bundle{
+		assets:       assetsManager,
+		textureCache: make(map[*uni.Texture2D]image.Image),
+	}, nil
+}
+
+// Sprites decodes all sprites in bundle order. Sprites sharing a texture
+// share the decoded image.
+func (b *Bundle) Sprites() (_ []Sprite, err error) {
+	defer recoverMalformed(&err)
+	var sprites []Sprite
+	for _, assetFile := range b.assets.AssetFiles {
+		for _, object := range assetFile.Objects {
+			sprite, ok := object.(*uni.Sprite)
+			if !ok {
+				continue
+			}
+
+			spriteImage, err := unityDecodeSpriteImage(sprite, b.textureCache)
+			if err != nil {
+				return nil, fmt.Errorf("decode sprite %q: %w", sprite.Name, err)
+			}
+
+			var textureName string
+			if sprite.RenderData != nil && sprite.RenderData.Texture != nil {
+				if texture, ok := sprite.RenderData.Texture.Get().(*uni.Texture2D); ok && texture != nil {
+					textureName = texture.Name
+				}
+			}
+
+			decoded := Sprite{
+				Name:          sprite.Name,
+				TextureName:   textureName,
+				PathID:        objectPathID(sprite.GetObject()),
+				Image:         spriteImage,
+				PixelsPerUnit: float64(sprite.PixelsToUnits),
+			}
+			if sprite.Rect != nil {
+				decoded.Rect = SpriteRect{float64(sprite.Rect.X), float64(sprite.Rect.Y), float64(sprite.Rect.Width), float64(sprite.Rect.Height)}
+			}
+			if sprite.Pivot != nil {
+				decoded.Pivot = SpritePivot{float64(sprite.Pivot.X), float64(sprite.Pivot.Y)}
+			}
+			if sprite.Border != nil {
+				// Unity stores the border as left, bottom, right, top.
+				decoded.Border = SpriteBorder{float64(sprite.Border.X), float64(sprite.Border.Y), float64(sprite.Border.Z), float64(sprite.Border.W)}
+			}
+			sprites = append(sprites, decoded)
+		}
+	}
+
+	return sprites, nil
+}
+
+// Textures decodes all Texture2D objects in bundle order at MipLevel.
+func (b *Bundle) Textures() (_ []Texture, err error) {
+	defer recoverMalformed(&err)
+	var textures []Texture
+	for _, assetFile := range b.assets.AssetFiles {
+		for _, object := range assetFile.Objects {
+			texture, ok := object.(*uni.Texture2D)
+			if !ok {
+				continue
+			}
+
+			// Sprites decode with their rect as size hint, so the cache is
+			// not used here.
+			textureImage, err := unityDecodeTextureImage(texture, 0, 0, b.MipLevel)
+			if err != nil {
+				return nil, fmt.Errorf("decode texture %q: %w", texture.Name, err)
+			}
+
+			textures = append(textures, Texture{
+				Name:   texture.Name,
+				PathID: objectPathID(texture.GetObject()),
+				Image:  textureImage,
+			})
+		}
+	}
+
+	return textures, nil
+}
+
+// recoverMalformed turns a panic of the uni readers, which trust the lengths
+// stored in the file, into an error.
+func recoverMalformed(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("malformed image bundle: %v", r)
+	}
+}
+
+// objectPathID returns 0, which is never a valid path ID, for nil.
+func objectPathID(object *uni.Object) int64 {
+	if object == nil {
+		return 0
+	}
+	return object.PathID
+}
+
+func unityDecodeSpriteImage(sprite *uni.Sprite, textureCache map[*uni.Texture2D]image.Image) (image.Image, error) {
+	if sprite == nil || sprite.RenderData == nil || sprite.RenderData.Texture == nil {
+		return nil, fmt.Errorf("sprite has no render data texture")
+	}
+
+	textureObject, ok := sprite.RenderData.Texture.Get().(*uni.Texture2D)
+	if !ok || textureObject == nil {
+		return nil, fmt.Errorf("sprite texture reference is not a Texture2D")
+	}
+
+	textureImage, ok := textureCache[textureObject]
+	if !ok {
+		var err error
+		hintWidth, hintHeight := 0, 0
+		if sprite.RenderData != nil && sprite.RenderData.TextureRect != nil {
+			hintWidth = int(math.Round(float64(sprite.RenderData.TextureRect.Width)))
+			hintHeight = int(math.Round(float64(sprite.RenderData.TextureRect.Height)))
+		}
+		textureImage, err = unityDecodeTextureImage(textureObject, hintWidth, hintHeight, 0)
+		if err != nil {
+			return nil, err
+		}
+		textureCache[textureObject] = textureImage
+	}
+
+	return textureImage, nil
+}
+
+// unityDecodeTextureImage decodes one mip level of texture, 0 being the full
+// resolution image.
+func unityDecodeTextureImage(texture *uni.Texture2D, hintWidth int, hintHeight int, mipLevel int) (image.Image, error) {
+	raw, err := unityReadTextureData(texture)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("texture data is empty")
+	}
+
+	meta := unityNormalizedTextureMeta(texture, len(raw), hintWidth, hintHeight)
+	if meta.width <= 0 || meta.height <= 0 {
+		return nil, fmt.Errorf("invalid texture dimensions %dx%d", meta.width, meta.height)
+	}
+
+	codec, native, err := textureCodecFor(meta.format)
+	if err != nil {
+		return nil, err
+	}
+	if !native {
+		if mipLevel > 0 {
+			return nil, fmt.Errorf("mip level %d of texture format %d is not supported", mipLevel, meta.format)
+		}
+		textureCopy := *texture
+		textureCopy.Width = int32(meta.width)
+		textureCopy.Height = int32(meta.height)
+		textureCopy.Format = meta.format
+		textureCopy.ImageData = uni.NewResourceReader(uni.NewBinaryReaderFromBytes(raw, true), 0, int64(len(raw)))
+		return uni.DecodeTexture2D(&textureCopy)
+	}
+
+	var data []byte
+	var width, height int
+	if _, ok := crunchedFormats[meta.format]; ok {
+		data, width, height, err = codec.crunchedLevel(raw, mipLevel)
+	} else {
+		data, width, height, err = codec.mipLevel(raw, meta.width, meta.height, mipLevel)
+	}
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := decodeTexture(codec, data, width, height)
+	if err != nil {
+		return nil, err
+	}
+	return unityFlipVerticalNRGBA(decoded), nil
+}
+
+type unityTextureMeta struct {
+	width        int
+	height       int
+	completeSize int
+	format       uni.TextureFormat
+}
+
+func unityNormalizedTextureMeta(texture *uni.Texture2D, rawLen int, hintWidth int, hintHeight int) unityTextureMeta {
+	meta := unityTextureMeta{
+		width:        int(texture.Width),
+		height:       int(texture.Height),
+		completeSize: int(texture.CompleteImageSize),
+		format:       texture.Format,
+	}
+
+	// Dofus 3 image bundles currently expose a shifted field layout in ssm:
+	// m_CompleteImageSize is read into height, and m_TextureFormat into mipsStripped.
+	if meta.format == uni.Alpha8 && meta.completeSize == 0 && texture.MipsStripped.IsSome() && meta.height > meta.width {
+		meta.completeSize = meta.height
+		meta.format = uni.TextureFormat(texture.MipsStripped.Unwrap())
+	}
+
+	if meta.completeSize <= 0 {
+		meta.completeSize = rawLen
+	}
+	if hintWidth > 0 && hintHeight > 0 && meta.completeSize > 0 && hintWidth*hintHeight == meta.completeSize {
+		meta.width = hintWidth
+		meta.height = hintHeight
+		return meta
+	}
+	if hintWidth > 0 && meta.completeSize > 0 && meta.completeSize%hintWidth == 0 {
+		derivedHeight := meta.completeSize / hintWidth
+		if hintHeight <= 0 || absInt(derivedHeight-hintHeight) <= 2 {
+			meta.width = hintWidth
+			meta.height = derivedHeight
+			return meta
+		}
+	}
+	if hintHeight > 0 && meta.completeSize > 0 && meta.completeSize%hintHeight == 0 {
+		derivedWidth := meta.completeSize / hintHeight
+		if hintWidth <= 0 || absInt(derivedWidth-hintWidth) <= 2 {
+			meta.width = derivedWidth
+			meta.height = hintHeight
+			return meta
+		}
+	}
+
+	if meta.width > 0 {
+		switch meta.format {
+		case uni.BC7:
+			if meta.completeSize%meta.width == 0 {
+				meta.height = meta.completeSize / meta.width
+			}
+		case uni.Alpha8:
+			if rawLen%meta.width == 0 {
+				meta.height = rawLen / meta.width
+			}
+		case uni.RGB24:
+			if rawLen%(meta.width*3) == 0 {
+				meta.height = rawLen / (meta.width * 3)
+			}
+		case uni.RGBA32, uni.ARGB32:
+			if rawLen%(meta.width*4) == 0 {
+				meta.height = rawLen / (meta.width * 4)
+			}
+		}
+	}
+
+	return meta
+}
+
+func absInt(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+func unityFlipVerticalNRGBA(src *image.NRGBA) *image.NRGBA {
+	if src == nil {
+		return nil
+	}
+	bounds := src.Bounds()
+	out := image.NewNRGBA(bounds)
+	rowSize := bounds.Dx() * 4
+	for y := 0; y < bounds.Dy(); y++ {
+		srcStart := (bounds.Dy()-1-y)*src.Stride + bounds.Min.X*4
+		dstStart := y*out.Stride + bounds.Min.X*4
+		copy(out.Pix[dstStart:dstStart+rowSize], src.Pix[srcStart:srcStart+rowSize])
+	}
+	return out
+}
+
+func unityReadTextureData(texture *uni.Texture2D) ([]byte, error) {
+	if texture == nil || texture.ImageData == nil {
+		return nil, fmt.Errorf("texture has no image data reader")
+	}
+
+	resourceReader := texture.ImageData
+	reader := resourceReader.GetReader()
+	if reader == nil {
+		return nil, fmt.Errorf("texture resource reader is unavailable")
+	}
+
+	size := resourceReader.Size
+	if size <= 0 {
+		return nil, nil
+	}
+	offset := resourceReader.Offset
+	if offset < 0 || offset+size > reader.Len() {
+		return nil, fmt.Errorf("texture data range is out of bounds (offset=%d size=%d len=%d)", offset, size, reader.Len())
+	}
+	if size > int64(^uint(0)>>1) {
+		return nil, fmt.Errorf("texture data size %d is too large", size)
+	}
+
+	if err := reader.SeekTo(offset); err != nil {
+		return nil, err
+	}
+	out := reader.Bytes(int(size))
+	return append([]byte(nil), out...), nil
+}
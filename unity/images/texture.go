@@ -0,0 +1,255 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/kvarenzn/ssm/uni"
+	"github.com/xypwn/filediver/dds"
+)
+
+// Unity TextureFormat values of the formats decoded in this package.
+const (
+	formatAlpha8            uni.TextureFormat = 1
+	formatARGB4444          uni.TextureFormat = 2
+	formatRGB24             uni.TextureFormat = 3
+	formatRGBA32            uni.TextureFormat = 4
+	formatARGB32            uni.TextureFormat = 5
+	formatRGB565            uni.TextureFormat = 7
+	formatR16               uni.TextureFormat = 9
+	formatDXT1              uni.TextureFormat = 10
+	formatDXT5              uni.TextureFormat = 12
+	formatRGBA4444          uni.TextureFormat = 13
+	formatBGRA32            uni.TextureFormat = 14
+	formatBC7               uni.TextureFormat = 25
+	formatBC4               uni.TextureFormat = 26
+	formatBC5               uni.TextureFormat = 27
+	formatDXT1Crunched      uni.TextureFormat = 28
+	formatDXT5Crunched      uni.TextureFormat = 29
+	formatETCRGB4           uni.TextureFormat = 34
+	formatEACR              uni.TextureFormat = 41
+	formatEACRSigned        uni.TextureFormat = 42
+	formatEACRG             uni.TextureFormat = 43
+	formatEACRGSigned       uni.TextureFormat = 44
+	formatETC2RGB           uni.TextureFormat = 45
+	formatETC2RGBA1         uni.TextureFormat = 46
+	formatETC2RGBA8         uni.TextureFormat = 47
+	formatASTC4x4           uni.TextureFormat = 48
+	formatASTCRGBA4x4       uni.TextureFormat = 54
+	formatRG16              uni.TextureFormat = 62
+	formatR8                uni.TextureFormat = 63
+	formatETCRGB4Crunched   uni.TextureFormat = 64
+	formatETC2RGBA8Crunched uni.TextureFormat = 65
+	formatASTCHDR4x4        uni.TextureFormat = 66
+	formatASTCHDR12x12      uni.TextureFormat = 71
+)
+
+// textureCodec decodes one texture format. Block based formats decode one
+// block of blockWidth x blockHeight texels from blockBytes bytes at a time.
+// Plain formats use a 1x1 block.
+type textureCodec struct {
+	name        string
+	blockWidth  int
+	blockHeight int
+	blockBytes  int
+	decodeBlock func(block []byte, texels []color.NRGBA)
+}
+
+var astcBlockSizes = [6]int{4, 5, 6, 8, 10, 12}
+
+// crunchedFormats maps the crunched formats to the format of the blocks they
+// unpack to.
+var crunchedFormats = map[uni.TextureFormat]uni.TextureFormat{
+	formatDXT1Crunched:      formatDXT1,
+	formatDXT5Crunched:      formatDXT5,
+	formatETCRGB4Crunched:   formatETCRGB4,
+	formatETC2RGBA8Crunched: formatETC2RGBA8,
+}
+
+// textureCodecFor returns false for formats that are left to
+// uni.DecodeTexture2D, and an error for known formats that can not be decoded.
+func textureCodecFor(format uni.TextureFormat) (textureCodec, bool, error) {
+	switch format {
+	case formatAlpha8:
+		return textureCodec{"Alpha8", 1, 1, 1, func(b []byte, t []color.NRGBA) { t[0] = color.NRGBA{255, 255, 255, b[0]} }}, true, nil
+	case formatARGB4444:
+		return textureCodec{"ARGB4444", 1, 1, 2, func(b []byte, t []color.NRGBA) {
+			v := binary.LittleEndian.Uint16(b)
+			t[0] = color.NRGBA{expand4(v >> 8), expand4(v >> 4), expand4(v), expand4(v >> 12)}
+		}}, true, nil
+	case formatRGBA4444:
+		return textureCodec{"RGBA4444", 1, 1, 2, func(b []byte, t []color.NRGBA) {
+			v := binary.LittleEndian.Uint16(b)
+			t[0] = color.NRGBA{expand4(v >> 12), expand4(v >> 8), expand4(v >> 4), expand4(v)}
+		}}, true, nil
+	case formatRGB565:
+		return textureCodec{"RGB565", 1, 1, 2, func(b []byte, t []color.NRGBA) {
+			t[0] = rgb565(binary.LittleEndian.Uint16(b))
+		}}, true, nil
+	case formatRGB24:
+		return textureCodec{"RGB24", 1, 1, 3, func(b []byte, t []color.NRGBA) { t[0] = color.NRGBA{b[0], b[1], b[2], 255} }}, true, nil
+	case formatRGBA32:
+		return textureCodec{"RGBA32", 1, 1, 4, func(b []byte, t []color.NRGBA) { t[0] = color.NRGBA{b[0], b[1], b[2], b[3]} }}, true, nil
+	case formatARGB32:
+		return textureCodec{"ARGB32", 1, 1, 4, func(b []byte, t []color.NRGBA) { t[0] = color.NRGBA{b[1], b[2], b[3], b[0]} }}, true, nil
+	case formatBGRA32:
+		return textureCodec{"BGRA32", 1, 1, 4, func(b []byte, t []color.NRGBA) { t[0] = color.NRGBA{b[2], b[1], b[0], b[3]} }}, true, nil
+	case formatR8:
+		return textureCodec{"R8", 1, 1, 1, func(b []byte, t []color.NRGBA) { t[0] = color.NRGBA{b[0], 0, 0, 255} }}, true, nil
+	case formatR16:
+		return textureCodec{"R16", 1, 1, 2, func(b []byte, t []color.NRGBA) { t[0] = color.NRGBA{b[1], 0, 0, 255} }}, true, nil
+	case formatRG16:
+		return textureCodec{"RG16", 1, 1, 2, func(b []byte, t []color.NRGBA) { t[0] = color.NRGBA{b[0], b[1], 0, 255} }}, true, nil
+	case formatDXT1:
+		return textureCodec{"DXT1", 4, 4, 8, decodeBC1Block}, true, nil
+	case formatDXT5:
+		return textureCodec{"DXT5", 4, 4, 16, decodeBC3Block}, true, nil
+	case formatBC4:
+		return textureCodec{"BC4", 4, 4, 8, decodeBC4Block}, true, nil
+	case formatBC5:
+		return textureCodec{"BC5", 4, 4, 16, decodeBC5Block}, true, nil
+	case formatBC7:
+		// Decoded as a whole by dds.DecompressBC7.
+		return textureCodec{"BC7", 4, 4, 16, nil}, true, nil
+	case formatETCRGB4:
+		return textureCodec{"ETC_RGB4", 4, 4, 8, decodeETC1Block}, true, nil
+	case formatETC2RGB:
+		return textureCodec{"ETC2_RGB", 4, 4, 8, decodeETC2RGBBlock}, true, nil
+	case formatETC2RGBA1:
+		return textureCodec{"ETC2_RGBA1", 4, 4, 8, decodeETC2RGBA1Block}, true, nil
+	case formatETC2RGBA8:
+		return textureCodec{"ETC2_RGBA8", 4, 4, 16, decodeETC2RGBA8Block}, true, nil
+	case formatEACR:
+		return textureCodec{"EAC_R", 4, 4, 8, decodeEACRBlock(false)}, true, nil
+	case formatEACRSigned:
+		return textureCodec{"EAC_R_SIGNED", 4, 4, 8, decodeEACRBlock(true)}, true, nil
+	case formatEACRG:
+		return textureCodec{"EAC_RG", 4, 4, 16, decodeEACRGBlock(false)}, true, nil
+	case formatEACRGSigned:
+		return textureCodec{"EAC_RG_SIGNED", 4, 4, 16, decodeEACRGBlock(true)}, true, nil
+	case formatDXT1Crunched, formatDXT5Crunched, formatETCRGB4Crunched, formatETC2RGBA8Crunched:
+		// Unpacked by crunchedLevel, then decoded as the block format.
+		codec, _, err := textureCodecFor(crunchedFormats[format])
+		codec.name += "Crunched"
+		return codec, true, err
+	}
+
+	if size, ok := astcFormatBlockSize(format); ok {
+		if format >= formatASTCHDR4x4 && format <= formatASTCHDR12x12 {
+			return textureCodec{}, true, fmt.Errorf("HDR ASTC texture format %d is not supported", format)
+		}
+		return textureCodec{
+			name:        fmt.Sprintf("ASTC_%dx%d", size, size),
+			blockWidth:  size,
+			blockHeight: size,
+			blockBytes:  16,
+			decodeBlock: func(block []byte, texels []color.NRGBA) { decodeASTCBlock(block, size, size, texels) },
+		}, true, nil
+	}
+
+	return textureCodec{}, false, nil
+}
+
+func astcFormatBlockSize(format uni.TextureFormat) (int, bool) {
+	for _, first := range []uni.TextureFormat{formatASTC4x4, formatASTCRGBA4x4, formatASTCHDR4x4} {
+		if format >= first && format < first+uni.TextureFormat(len(astcBlockSizes)) {
+			return astcBlockSizes[format-first], true
+		}
+	}
+	return 0, false
+}
+
+// levelSize returns the number of bytes of a width x height image.
+func (c textureCodec) levelSize(width int, height int) int {
+	blocksX := (width + c.blockWidth - 1) / c.blockWidth
+	blocksY := (height + c.blockHeight - 1) / c.blockHeight
+	return blocksX * blocksY * c.blockBytes
+}
+
+// mipLevel returns the data and size of the given mip level. Level 0 is the
+// full resolution image, every further level halves both sides.
+func (c textureCodec) mipLevel(data []byte, width int, height int, level int) ([]byte, int, int, error) {
+	if width <= 0 || height <= 0 {
+		return nil, 0, 0, fmt.Errorf("invalid texture dimensions %dx%d", width, height)
+	}
+	offset := 0
+	for i := 0; i < level; i++ {
+		offset += c.levelSize(width, height)
+		width = max(1, width/2)
+		height = max(1, height/2)
+	}
+
+	size := c.levelSize(width, height)
+	if offset+size > len(data) {
+		if level == 0 {
+			return nil, 0, 0, fmt.Errorf("%s texture data has %d bytes, %dx%d needs %d", c.name, len(data), width, height, size)
+		}
+		return nil, 0, 0, fmt.Errorf("%s texture has no mip level %d", c.name, level)
+	}
+	return data[offset : offset+size], width, height, nil
+}
+
+// decodeTexture decodes a width x height image of the given format. The rows
+// are returned in storage order, which is bottom-up for Unity textures.
+func decodeTexture(c textureCodec, data []byte, width int, height int) (*image.NRGBA, error) {
+	if width <= 0 || height <= 0 || len(data) < c.levelSize(width, height) {
+		return nil, fmt.Errorf("%s texture data has %d bytes, %dx%d needs %d", c.name, len(data), width, height, c.levelSize(max(width, 0), max(height, 0)))
+	}
+	decoded := image.NewNRGBA(image.Rect(0, 0, width, height))
+	if c.decodeBlock == nil {
+		if err := dds.DecompressBC7(decoded.Pix, bytes.NewReader(data), width, height, dds.Info{ColorModel: color.NRGBAModel}); err != nil {
+			return nil, err
+		}
+		return decoded, nil
+	}
+
+	texels := make([]color.NRGBA, c.blockWidth*c.blockHeight)
+	blocksX := (width + c.blockWidth - 1) / c.blockWidth
+	blocksY := (height + c.blockHeight - 1) / c.blockHeight
+	for by := 0; by < blocksY; by++ {
+		for bx := 0; bx < blocksX; bx++ {
+			offset := (by*blocksX + bx) * c.blockBytes
+			c.decodeBlock(data[offset:offset+c.blockBytes], texels)
+
+			for ty := 0; ty < c.blockHeight; ty++ {
+				y := by*c.blockHeight + ty
+				if y >= height {
+					break
+				}
+				for tx := 0; tx < c.blockWidth; tx++ {
+					x := bx*c.blockWidth + tx
+					if x >= width {
+						break
+					}
+					decoded.SetNRGBA(x, y, texels[ty*c.blockWidth+tx])
+				}
+			}
+		}
+	}
+
+	return decoded, nil
+}
+
+func expand4(v uint16) uint8 {
+	return uint8(v&0xf) * 17
+}
+
+func rgb565(v uint16) color.NRGBA {
+	r := uint8(v>>11) & 0x1f
+	g := uint8(v>>5) & 0x3f
+	b := uint8(v) & 0x1f
+	return color.NRGBA{r<<3 | r>>2, g<<2 | g>>4, b<<3 | b>>2, 255}
+}
+
+func clampByte(v int) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
@@ -0,0 +1,378 @@
+package images
+
+import (
+	"encoding/binary"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/kvarenzn/ssm/uni"
+)
+
+type textureTexel struct {
+	x, y  int
+	color color.NRGBA
+}
+
+func TestDecodeTextureFormats(t *testing.T) {
+	etc1 := []byte{0xf0, 0x0f, 0, 0, 0, 0, 0, 0}
+
+	tests := []struct {
+		name   string
+		format uni.TextureFormat
+		width  int
+		height int
+		data   []byte
+		want   []textureTexel
+	}{
+		{"Alpha8", formatAlpha8, 1, 1, []byte{0x80}, []textureTexel{{0, 0, color.NRGBA{255, 255, 255, 0x80}}}},
+		{"ARGB4444", formatARGB4444, 1, 1, []byte{0x42, 0x8f}, []textureTexel{{0, 0, color.NRGBA{0xff, 0x44, 0x22, 0x88}}}},
+		{"RGBA4444", formatRGBA4444, 1, 1, []byte{0x42, 0x8f}, []textureTexel{{0, 0, color.NRGBA{0x88, 0xff, 0x44, 0x22}}}},
+		{"RGB565", formatRGB565, 1, 1, []byte{0x00, 0xf8}, []textureTexel{{0, 0, color.NRGBA{255, 0, 0, 255}}}},
+		{"RGB24", formatRGB24, 2, 1, []byte{1, 2, 3, 4, 5, 6}, []textureTexel{{1, 0, color.NRGBA{4, 5, 6, 255}}}},
+		{"RGBA32", formatRGBA32, 1, 1, []byte{1, 2, 3, 4}, []textureTexel{{0, 0, color.NRGBA{1, 2, 3, 4}}}},
+		{"ARGB32", formatARGB32, 1, 1, []byte{4, 1, 2, 3}, []textureTexel{{0, 0, color.NRGBA{1, 2, 3, 4}}}},
+		{"BGRA32", formatBGRA32, 1, 1, []byte{3, 2, 1, 4}, []textureTexel{{0, 0, color.NRGBA{1, 2, 3, 4}}}},
+		{"R8", formatR8, 1, 1, []byte{7}, []textureTexel{{0, 0, color.NRGBA{7, 0, 0, 255}}}},
+		{"R16", formatR16, 1, 1, []byte{0x34, 0x12}, []textureTexel{{0, 0, color.NRGBA{0x12, 0, 0, 255}}}},
+		{"RG16", formatRG16, 1, 1, []byte{1, 2}, []textureTexel{{0, 0, color.NRGBA{1, 2, 0, 255}}}},
+		{"DXT1", formatDXT1, 4, 4, []byte{0x00, 0xf8, 0x1f, 0x00, 0x09, 0, 0, 0}, []textureTexel{
+			{0, 0, color.NRGBA{0, 0, 255, 255}},
+			{1, 0, color.NRGBA{170, 0, 85, 255}},
+			{3, 3, color.NRGBA{255, 0, 0, 255}},
+		}},
+		{"DXT5", formatDXT5, 4, 4, []byte{0xff, 0x00, 0x01, 0, 0, 0, 0, 0, 0x00, 0xf8, 0x1f, 0x00, 0, 0, 0, 0}, []textureTexel{
+			{0, 0, color.NRGBA{255, 0, 0, 0}},
+			{1, 0, color.NRGBA{255, 0, 0, 255}},
+		}},
+		{"BC4", formatBC4, 4, 4, []byte{200, 100, 0x02, 0, 0, 0, 0, 0}, []textureTexel{
+			{0, 0, color.NRGBA{185, 0, 0, 255}},
+			{1, 0, color.NRGBA{200, 0, 0, 255}},
+		}},
+		{"BC5", formatBC5, 4, 4, []byte{200, 100, 0x02, 0, 0, 0, 0, 0, 20, 0, 0, 0, 0, 0, 0, 0}, []textureTexel{
+			{0, 0, color.NRGBA{185, 20, 0, 255}},
+			{1, 0, color.NRGBA{200, 20, 0, 255}},
+		}},
+		{"ETC_RGB4", formatETCRGB4, 4, 4, etc1, []textureTexel{
+			{0, 0, color.NRGBA{255, 2, 2, 255}},
+			{3, 0, color.NRGBA{2, 255, 2, 255}},
+		}},
+		{"ETC2_RGB planar", formatETC2RGB, 4, 4, etcBlockBytes(63<<57 | 1<<42 | 31<<34 | 1<<33 | 1<<32 | 63<<13), []textureTexel{
+			{0, 0, color.NRGBA{255, 0, 0, 255}},
+			{3, 3, color.NRGBA{255, 0, 0, 255}},
+		}},
+		{"ETC2_RGBA1", formatETC2RGBA1, 4, 4, etcBlockBytes(31<<59 | 1<<16), []textureTexel{
+			{0, 0, color.NRGBA{}},
+			{1, 0, color.NRGBA{255, 0, 0, 255}},
+		}},
+		{"ETC2_RGBA8", formatETC2RGBA8, 4, 4, append([]byte{128, 0, 0, 0, 0, 0, 0, 0}, etc1...), []textureTexel{
+			{0, 0, color.NRGBA{255, 2, 2, 128}},
+		}},
+		{"EAC_R", formatEACR, 4, 4, eacBlockBytes(128, 1, 7), []textureTexel{
+			{2, 1, color.NRGBA{142, 0, 0, 255}},
+		}},
+		{"EAC_RG", formatEACRG, 4, 4, append(eacBlockBytes(128, 1, 7), eacBlockBytes(0, 0, 0)...), []textureTexel{
+			{2, 1, color.NRGBA{142, 0, 0, 255}},
+		}},
+		{"ASTC void extent", formatASTC4x4, 4, 4, astcVoidExtentBlock(0x1234, 0x5678, 0x9abc, 0xffff), []textureTexel{
+			{0, 0, color.NRGBA{0x12, 0x56, 0x9a, 0xff}},
+			{3, 3, color.NRGBA{0x12, 0x56, 0x9a, 0xff}},
+		}},
+		{"ASTC weight 0", formatASTC4x4, 4, 4, astcRGBBlock(false), []textureTexel{
+			{0, 0, color.NRGBA{10, 20, 30, 255}},
+			{3, 3, color.NRGBA{10, 20, 30, 255}},
+		}},
+		{"ASTC weight 64", formatASTCRGBA4x4 + 4, 10, 10, astcRGBBlock(true), []textureTexel{
+			{0, 0, color.NRGBA{200, 150, 100, 255}},
+			{9, 9, color.NRGBA{200, 150, 100, 255}},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			codec, native, err := textureCodecFor(test.format)
+			if err != nil || !native {
+				t.Fatalf("textureCodecFor(%d) = %v, %v", test.format, native, err)
+			}
+			data, width, height, err := codec.mipLevel(test.data, test.width, test.height, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := decodeTexture(codec, data, width, height)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, texel := range test.want {
+				if got := decoded.NRGBAAt(texel.x, texel.y); got != texel.color {
+					t.Errorf("texel %d,%d = %v, want %v", texel.x, texel.y, got, texel.color)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeTextureUnsupportedFormats(t *testing.T) {
+	for _, format := range []uni.TextureFormat{formatASTCHDR4x4} {
+		if _, _, err := textureCodecFor(format); err == nil {
+			t.Errorf("textureCodecFor(%d) returned no error", format)
+		}
+	}
+	if _, native, err := textureCodecFor(uni.TextureFormat(1000)); native || err != nil {
+		t.Errorf("unknown format: native %v, error %v", native, err)
+	}
+}
+
+func TestTextureMipLevel(t *testing.T) {
+	codec, _, _ := textureCodecFor(formatRGBA32)
+	data := make([]byte, (16+4+1)*4)
+	for i := 64; i < 80; i++ {
+		data[i] = 9
+	}
+
+	level, width, height, err := codec.mipLevel(data, 4, 4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width != 2 || height != 2 || len(level) != 16 || level[0] != 9 {
+		t.Fatalf("mip level 1 = %dx%d, %d bytes", width, height, len(level))
+	}
+
+	if _, width, height, err = codec.mipLevel(data, 4, 4, 2); err != nil || width != 1 || height != 1 {
+		t.Fatalf("mip level 2 = %dx%d, %v", width, height, err)
+	}
+	if _, _, _, err = codec.mipLevel(data, 4, 4, 3); err == nil || !strings.Contains(err.Error(), "mip level 3") {
+		t.Fatalf("mip level 3 error = %v", err)
+	}
+	if _, _, _, err = codec.mipLevel(data[:10], 4, 4, 0); err == nil {
+		t.Fatal("short level 0 returned no error")
+	}
+}
+
+func TestDecodeCrunchedTextures(t *testing.T) {
+	// Red and blue DXT endpoints, the first texel uses the second one.
+	dxtColors := [2][]byte{
+		crunchBits(func(w *crunchBitWriter) { w.model(6); w.model(6); w.symbols(6, 31, 0, 0, 0, 0, 31) }),
+		crunchBits(func(w *crunchBitWriter) { w.model(4); w.symbols(4, 3, 0, 0, 0, 0, 0, 0, 0) }),
+	}
+	// A red ETC endpoint with table 0, texel 1,0 is the brightest.
+	etcColors := [2][]byte{
+		crunchBits(func(w *crunchBitWriter) { w.model(8); w.symbols(8, 31, 0, 0, 0) }),
+		crunchBits(func(w *crunchBitWriter) { w.model(4); w.symbols(4, 12, 0, 0, 0, 0, 0, 0, 0) }),
+	}
+
+	tests := []struct {
+		name   string
+		format uni.TextureFormat
+		data   []byte
+		want   []textureTexel
+	}{
+		{"DXT1Crunched", formatDXT1Crunched, crunchFile(crunchDXT1, dxtColors[0], dxtColors[1], nil, nil), []textureTexel{
+			{0, 0, color.NRGBA{0, 0, 255, 255}},
+			{1, 0, color.NRGBA{255, 0, 0, 255}},
+			{3, 3, color.NRGBA{255, 0, 0, 255}},
+		}},
+		{"DXT5Crunched", formatDXT5Crunched, crunchFile(crunchDXT5, dxtColors[0], dxtColors[1],
+			crunchBits(func(w *crunchBitWriter) { w.model(8); w.symbols(8, 255, 0) }),
+			crunchBits(func(w *crunchBitWriter) { w.model(6); w.symbols(6, 7, 0, 0, 0, 0, 0, 0, 0) }),
+		), []textureTexel{
+			{0, 0, color.NRGBA{0, 0, 255, 0}},
+			{1, 0, color.NRGBA{255, 0, 0, 255}},
+		}},
+		{"ETC_RGB4Crunched", formatETCRGB4Crunched, crunchFile(crunchETC1, etcColors[0], etcColors[1], nil, nil), []textureTexel{
+			{0, 0, color.NRGBA{247, 0, 0, 255}},
+			{1, 0, color.NRGBA{255, 8, 8, 255}},
+			{0, 1, color.NRGBA{247, 0, 0, 255}},
+		}},
+		{"ETC2_RGBA8Crunched", formatETC2RGBA8Crunched, crunchFile(crunchETC2A, etcColors[0], etcColors[1],
+			crunchBits(func(w *crunchBitWriter) { w.model(8); w.symbols(8, 128, 0x10) }),
+			crunchBits(func(w *crunchBitWriter) { w.model(6); w.symbols(6, 7, 0, 0, 0, 0, 0, 0, 0) }),
+		), []textureTexel{
+			{0, 0, color.NRGBA{247, 0, 0, 142}},
+			{1, 0, color.NRGBA{255, 8, 8, 113}},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			codec, native, err := textureCodecFor(test.format)
+			if err != nil || !native {
+				t.Fatalf("textureCodecFor(%d) = %v, %v", test.format, native, err)
+			}
+			data, width, height, err := codec.crunchedLevel(test.data, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := decodeTexture(codec, data, width, height)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, texel := range test.want {
+				if got := decoded.NRGBAAt(texel.x, texel.y); got != texel.color {
+					t.Errorf("texel %d,%d = %v, want %v", texel.x, texel.y, got, texel.color)
+				}
+			}
+			if _, _, _, err := codec.crunchedLevel(test.data, 1); err == nil || !strings.Contains(err.Error(), "mip level 1") {
+				t.Errorf("mip level 1 error = %v", err)
+			}
+			if _, _, _, err := codec.crunchedLevel(test.data[:40], 0); err == nil {
+				t.Error("truncated file returned no error")
+			}
+		})
+	}
+}
+
+// crunchBitWriter writes bits most significant first, like crunch reads them.
+type crunchBitWriter struct {
+	data  []byte
+	count int
+}
+
+func (w *crunchBitWriter) bits(n int, v int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.count%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if v>>i&1 == 1 {
+			w.data[len(w.data)-1] |= 0x80 >> (w.count % 8)
+		}
+		w.count++
+	}
+}
+
+// model writes a Huffman code where all 1<<size symbols are size bits long,
+// so that symbols are sent as plain size bit numbers.
+func (w *crunchBitWriter) model(size int) {
+	w.bits(14, 1<<size)
+	w.bits(5, len(crunchCodeLengthOrder))
+	for _, code := range crunchCodeLengthOrder {
+		if code == size {
+			w.bits(3, 1)
+		} else {
+			w.bits(3, 0)
+		}
+	}
+	for i := 0; i < 1<<size; i++ {
+		w.bits(1, 0)
+	}
+}
+
+func (w *crunchBitWriter) symbols(size int, symbols ...int) {
+	for _, symbol := range symbols {
+		w.bits(size, symbol)
+	}
+}
+
+func crunchBits(write func(w *crunchBitWriter)) []byte {
+	var w crunchBitWriter
+	write(&w)
+	return w.data
+}
+
+// crunchFile builds a single level 4x4 crn file with one endpoint and one
+// selector in each palette. Every reference, delta and selector index of the
+// level is 0, so its data is all zero bits.
+func crunchFile(format int, colorEndpoints []byte, colorSelectors []byte, alphaEndpoints []byte, alphaSelectors []byte) []byte {
+	data := make([]byte, crunchHeaderSize)
+	put := func(offset int, size int, v int) {
+		for i := 0; i < size; i++ {
+			data[offset+i] = byte(v >> (8 * (size - 1 - i)))
+		}
+	}
+	data[0], data[1] = 'H', 'x'
+	put(2, 2, crunchHeaderSize)
+	put(12, 2, 4)
+	put(14, 2, 4)
+	data[16], data[17], data[18] = 1, 1, byte(format)
+
+	tables := crunchBits(func(w *crunchBitWriter) {
+		w.model(8)
+		w.model(1)
+		w.model(1)
+		if alphaEndpoints != nil {
+			w.model(1)
+			w.model(1)
+		}
+	})
+	put(65, 2, len(tables))
+	put(67, 3, len(data))
+	data = append(data, tables...)
+
+	for i, palette := range [][]byte{colorEndpoints, colorSelectors, alphaEndpoints, alphaSelectors} {
+		put(33+8*i, 3, len(data))
+		put(36+8*i, 3, len(palette))
+		if palette != nil {
+			put(39+8*i, 2, 1)
+		}
+		data = append(data, palette...)
+	}
+	put(70, 4, len(data))
+	data = append(data, make([]byte, 8)...)
+	put(6, 4, len(data))
+	return data
+}
+
+func etcBlockBytes(bits uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, bits)
+}
+
+// eacBlockBytes builds an EAC block using modifier table 0 and the same
+// modifier index for every texel.
+func eacBlockBytes(base int, multiplier int, index int) []byte {
+	bits := uint64(base)<<56 | uint64(multiplier)<<52
+	for i := 0; i < 16; i++ {
+		bits |= uint64(index) << (45 - 3*i)
+	}
+	return etcBlockBytes(bits)
+}
+
+func astcVoidExtentBlock(r, g, b, a uint16) []byte {
+	block := []byte{0xfc, 0xfd, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	for _, v := range []uint16{r, g, b, a} {
+		block = binary.LittleEndian.AppendUint16(block, v)
+	}
+	return block
+}
+
+// astcRGBBlock builds a single partition block with a 3x2 grid of 5 bit
+// weights and RGB direct endpoints (10, 20, 30) and (200, 150, 100). All
+// weights are 0 or all are at their maximum.
+func astcRGBBlock(maxWeights bool) []byte {
+	block := make([]byte, 16)
+	set := func(offset int, count int, value int) {
+		for i := 0; i < count; i++ {
+			if value>>i&1 == 1 {
+				block[(offset+i)/8] |= 1 << ((offset + i) % 8)
+			}
+		}
+	}
+
+	set(0, 11, 927)
+	set(13, 4, 8)
+	for i, v := range []int{10, 200, 20, 150, 30, 100} {
+		set(17+8*i, 8, v)
+	}
+	if maxWeights {
+		set(98, 30, 1<<30-1)
+	}
+	return block
+}
+
+func FuzzDecodeTexture(f *testing.F) {
+	f.Add(int(formatRGBA32), 1, 1, []byte{1, 2, 3, 4})
+	f.Add(int(formatETCRGB4), 4, 4, []byte{0xf0, 0x0f, 0, 0, 0, 0, 0, 0})
+	f.Add(int(formatASTC4x4), 4, 4, astcRGBBlock(true))
+	f.Add(int(formatETC2RGBA8Crunched), 4, 4, crunchFile(crunchETC2A, []byte{0}, []byte{0}, []byte{0}, []byte{0}))
+	f.Fuzz(func(t *testing.T, format int, width int, height int, data []byte) {
+		codec, native, err := textureCodecFor(uni.TextureFormat(format))
+		if err != nil || !native || width > 1<<12 || height > 1<<12 {
+			return
+		}
+		mipLevel := func() ([]byte, int, int, error) { return codec.mipLevel(data, width, height, 0) }
+		if _, ok := crunchedFormats[uni.TextureFormat(format)]; ok {
+			mipLevel = func() ([]byte, int, int, error) { return codec.crunchedLevel(data, 0) }
+		}
+		if level, width, height, err := mipLevel(); err == nil {
+			decodeTexture(codec, level, width, height)
+		}
+	})
+}
@@ -9,6 +9,14 @@ import (
 const (
 	UnityBackendDocker = "docker"
 	UnityBackendNative = "native"
+	UnityBackendExec   = "exec"
+)
+
+var (
+	// unityBackendFlag and unityExecFlag are set by --unity-backend and
+	// --unity-exec and take precedence over the environment variables.
+	unityBackendFlag string
+	unityExecFlag    string
 )
 
 type UnityUnpackBackend interface {
@@ -20,27 +28,33 @@ type UnityUnpackBackend interface {
 }
 
 func CurrentUnityUnpackBackend() (UnityUnpackBackend, error) {
-	backend := selectedUnityBackend()
+	return unityUnpackBackendByName(selectedUnityBackend())
+}
+
+func unityUnpackBackendByName(backend string) (UnityUnpackBackend, error) {
 	switch backend {
 	case UnityBackendDocker:
 		return dockerUnityUnpackBackend{}, nil
 	case UnityBackendNative:
 		return nativeUnityUnpackBackend{}, nil
+	case UnityBackendExec:
+		template := unityExecFlag
+		if template == "" {
+			template = os.Getenv("DODUDA_UNITY_EXEC")
+		}
+		return newExecUnityUnpackBackend(template)
 	default:
-		return nil, fmt.Errorf("unknown unity backend %q", backend)
+		return nil, fmt.Errorf("unknown unity backend %q, available: native, docker, exec", backend)
 	}
 }
 
 func selectedUnityBackend() string {
-	backend := strings.ToLower(strings.TrimSpace(os.Getenv("DODUDA_UNITY_BACKEND")))
+	backend := strings.ToLower(strings.TrimSpace(unityBackendFlag))
 	if backend == "" {
-		return UnityBackendNative
+		backend = strings.ToLower(strings.TrimSpace(os.Getenv("DODUDA_UNITY_BACKEND")))
 	}
-
-	switch backend {
-	case UnityBackendDocker, UnityBackendNative:
-		return backend
-	default:
-		return backend
+	if backend == "" {
+		return UnityBackendNative
 	}
+	return backend
 }
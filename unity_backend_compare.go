@@ -0,0 +1,818 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxUnityBackendDiffEntries caps the listed differences per file. The total
+// count is always reported.
+const maxUnityBackendDiffEntries = 100
+
+type unityBackendDiffReport struct {
+	Backends  [2]string              `json:"backends"`
+	Compared  int                    `json:"compared"`
+	Identical int                    `json:"identical"`
+	Files     []unityBackendFileDiff `json:"files"`
+	// NotCompared maps the kinds of files that were not compared to the
+	// reason.
+	NotCompared map[string]string `json:"notCompared,omitempty"`
+}
+
+type unityBackendFileDiff struct {
+	Kind             string                `json:"kind"` // bundle, i18n or image
+	Path             string                `json:"path"`
+	Status           string                `json:"status"` // differs, missing or error
+	Detail           string                `json:"detail,omitempty"`
+	Differences      []unityJSONDifference `json:"differences,omitempty"`
+	TotalDifferences int                   `json:"totalDifferences,omitempty"`
+	Pixels           *unityPixelDiff       `json:"pixels,omitempty"`
+}
+
+type unityJSONDifference struct {
+	Path  string `json:"path"`
+	Kind  string `json:"kind"` // added, removed, type, length or value
+	Left  string `json:"left,omitempty"`
+	Right string `json:"right,omitempty"`
+}
+
+type unityPixelDiff struct {
+	SizeMismatch    string `json:"sizeMismatch,omitempty"`
+	DifferentPixels int    `json:"differentPixels"`
+	TotalPixels     int    `json:"totalPixels"`
+	MaxDelta        uint8  `json:"maxDelta"`
+	Region          string `json:"region,omitempty"`
+	DiffImage       string `json:"diffImage,omitempty"`
+}
+
+// BackendDiff unpacks every *.bundle, *.bin and *.imagebundle file of inputDir
+// with both backends into outputDir/<backend> and compares the results. The
+// report is also written to outputDir/report.json, pixel diff images go to
+// outputDir/pixeldiff.
+func BackendDiff(left UnityUnpackBackend, right UnityUnpackBackend, inputDir string, outputDir string, prepare bool, headless bool) (*unityBackendDiffReport, error) {
+	if left.Name() == right.Name() {
+		return nil, fmt.Errorf("cannot compare backend %s with itself", left.Name())
+	}
+
+	entries, err := os.ReadDir(inputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var bundles, i18ns []string
+	hasImages := false
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		switch filepath.Ext(entry.Name()) {
+		case ".bundle":
+			bundles = append(bundles, entry.Name())
+		case ".bin":
+			i18ns = append(i18ns, entry.Name())
+		case ".imagebundle":
+			hasImages = true
+		}
+	}
+	if len(bundles) == 0 && len(i18ns) == 0 && !hasImages {
+		return nil, fmt.Errorf("no .bundle, .bin or .imagebundle files in %s", inputDir)
+	}
+
+	report := &unityBackendDiffReport{Backends: [2]string{left.Name(), right.Name()}}
+	failures := make(map[string]string)
+
+	// Comparing the native decoder with itself would always match.
+	if len(i18ns) > 0 && unityBackendDecodesI18nNatively(left) && unityBackendDecodesI18nNatively(right) {
+		report.NotCompared = map[string]string{
+			"i18n": fmt.Sprintf("%s and %s both decode i18n files with the native decoder", left.Name(), right.Name()),
+		}
+		i18ns = nil
+	}
+
+	for _, backend := range []UnityUnpackBackend{left, right} {
+		if prepare {
+			if err := backend.Prepare(true, headless); err != nil {
+				return nil, fmt.Errorf("prepare %s backend: %w", backend.Name(), err)
+			}
+		}
+
+		backendDir := filepath.Join(outputDir, backend.Name())
+		if err := os.RemoveAll(backendDir); err != nil {
+			return nil, err
+		}
+
+		for _, name := range bundles {
+			if err := unpackUnityBundleForDiff(backend, filepath.Join(inputDir, name), filepath.Join(backendDir, "bundles")); err != nil {
+				failures[backend.Name()+":bundles/"+name] = err.Error()
+			}
+		}
+
+		for _, name := range i18ns {
+			i18nDir := filepath.Join(backendDir, "i18n")
+			if err := os.MkdirAll(i18nDir, os.ModePerm); err != nil {
+				return nil, err
+			}
+			output := filepath.Join(i18nDir, strings.TrimSuffix(name, ".bin")+".json")
+			if err := backend.UnpackI18n(filepath.Join(inputDir, name), output); err != nil {
+				failures[backend.Name()+":i18n/"+name] = err.Error()
+			}
+		}
+
+		if hasImages {
+			imagesDir := filepath.Join(backendDir, "images")
+			if err := os.MkdirAll(imagesDir, os.ModePerm); err != nil {
+				return nil, err
+			}
+			if err := backend.UnpackImages(inputDir, imagesDir); err != nil {
+				failures[backend.Name()+":images"] = err.Error()
+			}
+		}
+	}
+
+	failureKeys := make([]string, 0, len(failures))
+	for key := range failures {
+		failureKeys = append(failureKeys, key)
+	}
+	sort.Strings(failureKeys)
+	for _, key := range failureKeys {
+		backendName, path, _ := strings.Cut(key, ":")
+		kind, _, _ := strings.Cut(path, "/")
+		report.Files = append(report.Files, unityBackendFileDiff{
+			Kind:   strings.TrimSuffix(kind, "s"),
+			Path:   path,
+			Status: "error",
+			Detail: backendName + ": " + failures[key],
+		})
+	}
+
+	leftDir := filepath.Join(outputDir, left.Name())
+	rightDir := filepath.Join(outputDir, right.Name())
+	for _, kind := range []string{"bundles", "i18n"} {
+		if err := report.compareJSONDirs(filepath.Join(leftDir, kind), filepath.Join(rightDir, kind), kind); err != nil {
+			return nil, err
+		}
+	}
+	if hasImages {
+		if err := report.compareImageDirs(filepath.Join(leftDir, "images"), filepath.Join(rightDir, "images"), filepath.Join(outputDir, "pixeldiff")); err != nil {
+			return nil, err
+		}
+	}
+
+	encoded, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, "report.json"), encoded, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// unityNativeI18nBackend is implemented by backends whose UnpackI18n runs
+// the native decoder.
+type unityNativeI18nBackend interface {
+	decodesI18nNatively() bool
+}
+
+func unityBackendDecodesI18nNatively(backend UnityUnpackBackend) bool {
+	native, ok := backend.(unityNativeI18nBackend)
+	return ok && native.decodesI18nNatively()
+}
+
+// unpackUnityBundleForDiff copies the bundle next to its output first. The
+// Docker backend always writes into the folder of the input file.
+func unpackUnityBundleForDiff(backend UnityUnpackBackend, inputPath string, outputDir string) error {
+	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	workPath := filepath.Join(outputDir, filepath.Base(inputPath))
+	if err := copyUnityDiffFile(inputPath, workPath); err != nil {
+		return err
+	}
+	defer os.Remove(workPath)
+
+	output := filepath.Join(outputDir, strings.TrimSuffix(filepath.Base(inputPath), ".bundle")+".json")
+	if err := backend.UnpackBundle(workPath, output); err != nil {
+		return err
+	}
+	if _, err := os.Stat(output); err != nil {
+		return fmt.Errorf("backend wrote no output: %w", err)
+	}
+	return nil
+}
+
+func copyUnityDiffFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// listUnityDiffFiles returns the slash separated paths of all files below dir
+// that have one of the extensions. A missing dir has no files.
+func listUnityDiffFiles(dir string, exts ...string) (map[string]bool, error) {
+	files := make(map[string]bool)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		for _, candidate := range exts {
+			if ext == candidate {
+				rel, err := filepath.Rel(dir, path)
+				if err != nil {
+					return err
+				}
+				files[filepath.ToSlash(rel)] = true
+				break
+			}
+		}
+		return nil
+	})
+	return files, err
+}
+
+func unionUnityDiffFiles(left map[string]bool, right map[string]bool) []string {
+	paths := make([]string, 0, len(left)+len(right))
+	for path := range left {
+		paths = append(paths, path)
+	}
+	for path := range right {
+		if !left[path] {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (r *unityBackendDiffReport) missing(kind string, path string, left bool) {
+	backend := r.Backends[1]
+	if left {
+		backend = r.Backends[0]
+	}
+	r.Files = append(r.Files, unityBackendFileDiff{
+		Kind:   kind,
+		Path:   path,
+		Status: "missing",
+		Detail: "only written by " + backend,
+	})
+}
+
+func (r *unityBackendDiffReport) compareJSONDirs(leftDir string, rightDir string, kind string) error {
+	leftFiles, err := listUnityDiffFiles(leftDir, ".json")
+	if err != nil {
+		return err
+	}
+	rightFiles, err := listUnityDiffFiles(rightDir, ".json")
+	if err != nil {
+		return err
+	}
+
+	fileKind := strings.TrimSuffix(kind, "s")
+	for _, path := range unionUnityDiffFiles(leftFiles, rightFiles) {
+		reportPath := kind + "/" + path
+		if !leftFiles[path] || !rightFiles[path] {
+			r.missing(fileKind, reportPath, leftFiles[path])
+			continue
+		}
+
+		r.Compared++
+		differences, total, err := diffUnityJSONFiles(filepath.Join(leftDir, path), filepath.Join(rightDir, path))
+		if err != nil {
+			r.Files = append(r.Files, unityBackendFileDiff{Kind: fileKind, Path: reportPath, Status: "error", Detail: err.Error()})
+			continue
+		}
+		if total == 0 {
+			r.Identical++
+			continue
+		}
+		r.Files = append(r.Files, unityBackendFileDiff{
+			Kind:             fileKind,
+			Path:             reportPath,
+			Status:           "differs",
+			Differences:      differences,
+			TotalDifferences: total,
+		})
+	}
+
+	return nil
+}
+
+func diffUnityJSONFiles(leftPath string, rightPath string) ([]unityJSONDifference, int, error) {
+	left, err := readUnityDiffJSON(leftPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	right, err := readUnityDiffJSON(rightPath)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var differences []unityJSONDifference
+	total := 0
+	diffUnityJSON("", left, right, &differences, &total)
+	return differences, total, nil
+}
+
+func readUnityDiffJSON(path string) (any, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", filepath.Base(path), err)
+	}
+	return value, nil
+}
+
+// diffUnityJSON walks both values and records where they differ. Numbers are
+// compared by value so that 1 and 1.0 are equal.
+func diffUnityJSON(path string, left any, right any, differences *[]unityJSONDifference, total *int) {
+	record := func(path string, kind string, leftValue string, rightValue string) {
+		*total++
+		if len(*differences) < maxUnityBackendDiffEntries {
+			*differences = append(*differences, unityJSONDifference{Path: path, Kind: kind, Left: leftValue, Right: rightValue})
+		}
+	}
+
+	if unityJSONKind(left) != unityJSONKind(right) {
+		record(path, "type", unityJSONKind(left), unityJSONKind(right))
+		return
+	}
+
+	switch l := left.(type) {
+	case map[string]any:
+		r := right.(map[string]any)
+		keys := make([]string, 0, len(l)+len(r))
+		for key := range l {
+			keys = append(keys, key)
+		}
+		for key := range r {
+			if _, ok := l[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			leftValue, inLeft := l[key]
+			rightValue, inRight := r[key]
+			switch {
+			case !inRight:
+				record(childPath, "removed", unityJSONSummary(leftValue), "")
+			case !inLeft:
+				record(childPath, "added", "", unityJSONSummary(rightValue))
+			default:
+				diffUnityJSON(childPath, leftValue, rightValue, differences, total)
+			}
+		}
+	case []any:
+		r := right.([]any)
+		if len(l) != len(r) {
+			record(path, "length", strconv.Itoa(len(l)), strconv.Itoa(len(r)))
+		}
+		for i := 0; i < len(l) && i < len(r); i++ {
+			diffUnityJSON(path+"["+strconv.Itoa(i)+"]", l[i], r[i], differences, total)
+		}
+	case json.Number:
+		r := right.(json.Number)
+		if l == r {
+			return
+		}
+		leftFloat, leftErr := l.Float64()
+		rightFloat, rightErr := r.Float64()
+		if leftErr != nil || rightErr != nil || leftFloat != rightFloat {
+			record(path, "value", l.String(), r.String())
+		}
+	default:
+		if left != right {
+			record(path, "value", unityJSONSummary(left), unityJSONSummary(right))
+		}
+	}
+}
+
+func unityJSONKind(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func unityJSONSummary(value any) string {
+	switch v := value.(type) {
+	case map[string]any:
+		return fmt.Sprintf("object(%d keys)", len(v))
+	case []any:
+		return fmt.Sprintf("array(%d)", len(v))
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	if len(encoded) > 80 {
+		return string(encoded[:77]) + "..."
+	}
+	return string(encoded)
+}
+
+func (r *unityBackendDiffReport) compareImageDirs(leftDir string, rightDir string, diffDir string) error {
+	leftFiles, err := listUnityDiffFiles(leftDir, ".png", ".jpg", ".jpeg")
+	if err != nil {
+		return err
+	}
+	rightFiles, err := listUnityDiffFiles(rightDir, ".png", ".jpg", ".jpeg")
+	if err != nil {
+		return err
+	}
+	pairs, err := pairUnityDiffImages(leftDir, leftFiles, rightDir, rightFiles)
+	if err != nil {
+		return err
+	}
+
+	for _, pair := range pairs {
+		path := pair.path()
+		reportPath := "images/" + path
+		if pair.left == "" || pair.right == "" {
+			r.missing("image", reportPath, pair.left != "")
+			continue
+		}
+		var detail string
+		if pair.right != pair.left {
+			detail = "compared with images/" + pair.right
+		}
+
+		r.Compared++
+		leftImage, err := readUnityDiffImage(filepath.Join(leftDir, pair.left))
+		if err == nil {
+			var rightImage image.Image
+			rightImage, err = readUnityDiffImage(filepath.Join(rightDir, pair.right))
+			if err == nil {
+				pixels, diffImage := diffUnityImages(leftImage, rightImage)
+				if pixels == nil {
+					r.Identical++
+					continue
+				}
+
+				if diffImage != nil {
+					pixels.DiffImage = filepath.Join(diffDir, strings.TrimSuffix(filepath.FromSlash(path), filepath.Ext(path))+".png")
+					if err := writeUnityDiffImage(pixels.DiffImage, diffImage); err != nil {
+						return err
+					}
+				}
+				r.Files = append(r.Files, unityBackendFileDiff{Kind: "image", Path: reportPath, Status: "differs", Detail: detail, Pixels: pixels})
+				continue
+			}
+		}
+		r.Files = append(r.Files, unityBackendFileDiff{Kind: "image", Path: reportPath, Status: "error", Detail: err.Error()})
+	}
+
+	return nil
+}
+
+// unityDiffImagePair is an image of both sides. A file only one side wrote
+// has an empty path on the other.
+type unityDiffImagePair struct {
+	left, right string
+}
+
+// pairUnityDiffImages matches the image files of both sides. Files with the
+// same path are compared with each other. The native backend writes
+// duplicate names as <name>_#p<pathID>.png where AssetStudio writes
+// <name>_#<n>.png, so the remaining files are matched through the names.json
+// of the native side: a file listed as alias is paired with the file the
+// alias was merged into, every other file with the files of the same name
+// without number suffix. Within one name, the native files are paired by
+// their rank in names.json and the others by their number suffix.
+func pairUnityDiffImages(leftDir string, leftFiles map[string]bool, rightDir string, rightFiles map[string]bool) ([]unityDiffImagePair, error) {
+	var pairs []unityDiffImagePair
+	var leftOnly, rightOnly []string
+	for _, path := range unionUnityDiffFiles(leftFiles, rightFiles) {
+		switch {
+		case leftFiles[path] && rightFiles[path]:
+			pairs = append(pairs, unityDiffImagePair{path, path})
+		case leftFiles[path]:
+			leftOnly = append(leftOnly, path)
+		default:
+			rightOnly = append(rightOnly, path)
+		}
+	}
+
+	leftNames, err := readUnityDiffImageNames(leftDir)
+	if err != nil {
+		return nil, err
+	}
+	rightNames, err := readUnityDiffImageNames(rightDir)
+	if err != nil {
+		return nil, err
+	}
+	matched, leftOnly, rightOnly := matchUnityDiffImages(leftNames, leftOnly, rightOnly)
+	pairs = append(pairs, matched...)
+	matched, rightOnly, leftOnly = matchUnityDiffImages(rightNames, rightOnly, leftOnly)
+	for _, pair := range matched {
+		pairs = append(pairs, unityDiffImagePair{pair.right, pair.left})
+	}
+
+	for _, path := range leftOnly {
+		pairs = append(pairs, unityDiffImagePair{left: path})
+	}
+	for _, path := range rightOnly {
+		pairs = append(pairs, unityDiffImagePair{right: path})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].path() < pairs[j].path() })
+	return pairs, nil
+}
+
+// path is the path the pair is reported under.
+func (p unityDiffImagePair) path() string {
+	if p.left != "" {
+		return p.left
+	}
+	return p.right
+}
+
+// unityDiffImageKey is file without extension and without the number suffix
+// of either backend, icon_#p30.png and icon_#2.png both become icon.
+func unityDiffImageKey(file string) string {
+	base := strings.TrimSuffix(path.Base(file), path.Ext(file))
+	return path.Join(path.Dir(file), cleanNumberSuffix(base))
+}
+
+// readUnityDiffImageNames reads every names.json below dir, keyed by the
+// slash separated path of the file relative to dir.
+func readUnityDiffImageNames(dir string) (map[string]*unityImageName, error) {
+	names := make(map[string]*unityImageName)
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && file == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || d.Name() != unityImageNamesFile {
+			return nil
+		}
+		entries, err := readUnityImageNames(filepath.Dir(file))
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, filepath.Dir(file))
+		if err != nil {
+			return err
+		}
+		for name, entry := range entries {
+			names[path.Join(filepath.ToSlash(rel), name)] = entry
+		}
+		return nil
+	})
+	return names, err
+}
+
+// matchUnityDiffImages pairs the named files, which names describes, with
+// the other files and returns the files of both that are left.
+func matchUnityDiffImages(names map[string]*unityImageName, named []string, other []string) ([]unityDiffImagePair, []string, []string) {
+	if len(names) == 0 || len(named) == 0 || len(other) == 0 {
+		return nil, named, other
+	}
+
+	var pairs []unityDiffImagePair
+	taken := make(map[string]bool)
+	otherSet := make(map[string]bool, len(other))
+	for _, file := range other {
+		otherSet[file] = true
+	}
+
+	// Aliases name the file an object was written as before cleaning.
+	groups := make(map[string][]string)
+	for _, file := range named {
+		entry := names[file]
+		if entry == nil {
+			continue
+		}
+		for _, alias := range entry.Aliases {
+			aliasPath := path.Join(path.Dir(file), alias.File)
+			if otherSet[aliasPath] && !taken[aliasPath] && !taken[file] {
+				pairs = append(pairs, unityDiffImagePair{file, aliasPath})
+				taken[file], taken[aliasPath] = true, true
+			}
+		}
+		if !taken[file] {
+			groups[unityDiffImageKey(file)] = append(groups[unityDiffImageKey(file)], file)
+		}
+	}
+
+	otherGroups := make(map[string][]string)
+	for _, file := range other {
+		if !taken[file] {
+			otherGroups[unityDiffImageKey(file)] = append(otherGroups[unityDiffImageKey(file)], file)
+		}
+	}
+
+	for key, group := range groups {
+		candidates := otherGroups[key]
+		sort.Slice(group, func(i, j int) bool { return names[group[i]].before(names[group[j]]) })
+		// <name>.png before <name>_#2.png before <name>_#10.png
+		sort.Slice(candidates, func(i, j int) bool {
+			if len(candidates[i]) != len(candidates[j]) {
+				return len(candidates[i]) < len(candidates[j])
+			}
+			return candidates[i] < candidates[j]
+		})
+		for i := range min(len(group), len(candidates)) {
+			pairs = append(pairs, unityDiffImagePair{group[i], candidates[i]})
+			taken[group[i]], taken[candidates[i]] = true, true
+		}
+	}
+
+	var namedLeft, otherLeft []string
+	for _, file := range named {
+		if !taken[file] {
+			namedLeft = append(namedLeft, file)
+		}
+	}
+	for _, file := range other {
+		if !taken[file] {
+			otherLeft = append(otherLeft, file)
+		}
+	}
+	return pairs, namedLeft, otherLeft
+}
+
+func readUnityDiffImage(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", filepath.Base(path), err)
+	}
+	return img, nil
+}
+
+func writeUnityDiffImage(path string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// diffUnityImages returns nil when both images are equal. Fully transparent
+// pixels are equal regardless of their color channels. The diff image shows
+// the left image dimmed with differing pixels in red.
+func diffUnityImages(left image.Image, right image.Image) (*unityPixelDiff, image.Image) {
+	leftSize := left.Bounds().Size()
+	rightSize := right.Bounds().Size()
+	if leftSize != rightSize {
+		return &unityPixelDiff{
+			SizeMismatch: fmt.Sprintf("%dx%d vs %dx%d", leftSize.X, leftSize.Y, rightSize.X, rightSize.Y),
+		}, nil
+	}
+
+	pixels := &unityPixelDiff{TotalPixels: leftSize.X * leftSize.Y}
+	diffImage := image.NewNRGBA(image.Rect(0, 0, leftSize.X, leftSize.Y))
+	draw.Draw(diffImage, diffImage.Bounds(), image.Transparent, image.Point{}, draw.Src)
+	var region image.Rectangle
+
+	for y := 0; y < leftSize.Y; y++ {
+		for x := 0; x < leftSize.X; x++ {
+			l := color.NRGBAModel.Convert(left.At(left.Bounds().Min.X+x, left.Bounds().Min.Y+y)).(color.NRGBA)
+			r := color.NRGBAModel.Convert(right.At(right.Bounds().Min.X+x, right.Bounds().Min.Y+y)).(color.NRGBA)
+
+			delta := uint8(0)
+			if l.A != 0 || r.A != 0 {
+				for _, channel := range [][2]uint8{{l.R, r.R}, {l.G, r.G}, {l.B, r.B}, {l.A, r.A}} {
+					d := channel[0] - channel[1]
+					if channel[1] > channel[0] {
+						d = channel[1] - channel[0]
+					}
+					delta = max(delta, d)
+				}
+			}
+
+			if delta == 0 {
+				gray := uint8((uint16(l.R) + uint16(l.G) + uint16(l.B)) / 3)
+				diffImage.SetNRGBA(x, y, color.NRGBA{R: gray, G: gray, B: gray, A: l.A / 4})
+				continue
+			}
+
+			pixels.DifferentPixels++
+			pixels.MaxDelta = max(pixels.MaxDelta, delta)
+			region = region.Union(image.Rect(x, y, x+1, y+1))
+			diffImage.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+
+	if pixels.DifferentPixels == 0 {
+		return nil, nil
+	}
+	pixels.Region = region.String()
+	return pixels, diffImage
+}
+
+func printUnityBackendDiff(w io.Writer, report *unityBackendDiffReport) {
+	fmt.Fprintf(w, "%s vs %s: %d compared, %d identical, %d with differences\n", report.Backends[0], report.Backends[1], report.Compared, report.Identical, len(report.Files))
+	kinds := make([]string, 0, len(report.NotCompared))
+	for kind := range report.NotCompared {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "%s not compared: %s\n", kind, report.NotCompared[kind])
+	}
+
+	for _, file := range report.Files {
+		fmt.Fprintf(w, "%s [%s]", file.Path, file.Status)
+		if file.Detail != "" {
+			fmt.Fprintf(w, " %s", file.Detail)
+		}
+		fmt.Fprintln(w)
+
+		for _, difference := range file.Differences {
+			switch difference.Kind {
+			case "added":
+				fmt.Fprintf(w, "  + %s %s\n", difference.Path, difference.Right)
+			case "removed":
+				fmt.Fprintf(w, "  - %s %s\n", difference.Path, difference.Left)
+			default:
+				fmt.Fprintf(w, "  ~ %s %s: %s -> %s\n", difference.Path, difference.Kind, difference.Left, difference.Right)
+			}
+		}
+		if file.TotalDifferences > len(file.Differences) {
+			fmt.Fprintf(w, "  ... %d more\n", file.TotalDifferences-len(file.Differences))
+		}
+
+		if pixels := file.Pixels; pixels != nil {
+			if pixels.SizeMismatch != "" {
+				fmt.Fprintf(w, "  size %s\n", pixels.SizeMismatch)
+			} else {
+				fmt.Fprintf(w, "  %d/%d pixels differ (max delta %d) in %s, see %s\n", pixels.DifferentPixels, pixels.TotalPixels, pixels.MaxDelta, pixels.Region, pixels.DiffImage)
+			}
+		}
+	}
+}
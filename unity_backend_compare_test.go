@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffUnityJSON(t *testing.T) {
+	var left, right any
+	decode := func(content string, target *any) {
+		decoder := json.NewDecoder(strings.NewReader(content))
+		decoder.UseNumber()
+		if err := decoder.Decode(target); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+	}
+	decode(`{"objects":[{"id":1,"weight":1.0,"name":"a"},{"id":2}],"gone":true}`, &left)
+	decode(`{"objects":[{"id":1,"weight":1,"name":"b"},{"id":"2"},{"id":3}],"new":null}`, &right)
+
+	var differences []unityJSONDifference
+	total := 0
+	diffUnityJSON("", left, right, &differences, &total)
+
+	want := []unityJSONDifference{
+		{Path: "gone", Kind: "removed", Left: "true"},
+		{Path: "new", Kind: "added", Right: "null"},
+		{Path: "objects", Kind: "length", Left: "2", Right: "3"},
+		{Path: "objects[0].name", Kind: "value", Left: `"a"`, Right: `"b"`},
+		{Path: "objects[1].id", Kind: "type", Left: "number", Right: "string"},
+	}
+	if total != len(want) {
+		t.Fatalf("expected %d differences, got %d: %+v", len(want), total, differences)
+	}
+	for i := range want {
+		if differences[i] != want[i] {
+			t.Fatalf("difference %d: expected %+v, got %+v", i, want[i], differences[i])
+		}
+	}
+}
+
+func TestDiffUnityImages(t *testing.T) {
+	left := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	right := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	// Transparent pixels only differing in color are equal.
+	right.SetNRGBA(0, 0, color.NRGBA{R: 200})
+	left.SetNRGBA(2, 1, color.NRGBA{R: 10, A: 255})
+	right.SetNRGBA(2, 1, color.NRGBA{R: 30, A: 255})
+	right.SetNRGBA(3, 2, color.NRGBA{A: 255})
+
+	pixels, diffImage := diffUnityImages(left, right)
+	if pixels == nil || diffImage == nil {
+		t.Fatalf("expected a difference")
+	}
+	if pixels.DifferentPixels != 2 || pixels.MaxDelta != 255 || pixels.Region != "(2,1)-(4,3)" {
+		t.Fatalf("unexpected pixel diff: %+v", pixels)
+	}
+
+	if pixels, _ := diffUnityImages(left, left); pixels != nil {
+		t.Fatalf("expected equal images, got %+v", pixels)
+	}
+	if pixels, _ := diffUnityImages(left, image.NewNRGBA(image.Rect(0, 0, 8, 4))); pixels == nil || pixels.SizeMismatch != "4x4 vs 8x4" {
+		t.Fatalf("expected size mismatch, got %+v", pixels)
+	}
+}
+
+func TestBackendDiffSkipsNativeI18n(t *testing.T) {
+	inputDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(inputDir, "fr.bin"), []byte("not read"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := BackendDiff(nativeUnityUnpackBackend{}, dockerUnityUnpackBackend{}, inputDir, t.TempDir(), false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Compared != 0 || len(report.Files) != 0 || report.NotCompared["i18n"] == "" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
+
+func TestCompareImageDirsPairsDockerNames(t *testing.T) {
+	nativeDir, dockerDir := t.TempDir(), t.TempDir()
+	write := func(dir string, name string, c color.NRGBA) {
+		img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+		}
+		if err := writeUnityDiffImage(filepath.Join(dir, name), img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	red, green, blue := color.NRGBA{R: 255, A: 255}, color.NRGBA{G: 255, A: 255}, color.NRGBA{B: 255, A: 255}
+
+	write(nativeDir, "icon.png", red)
+	write(nativeDir, "icon_#p30.png", green)
+	write(nativeDir, "icon_#p40.png", blue)
+	if err := writeUnityImageNames(nativeDir, map[string]*unityImageName{
+		"icon.png":      {Kind: unityImageKindSprite, Name: "icon", PathID: 20},
+		"icon_#p40.png": {Kind: unityImageKindSprite, Name: "icon", PathID: 40},
+		"icon_#p30.png": {Kind: unityImageKindSprite, Name: "icon", PathID: 30},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	write(dockerDir, "icon.png", red)
+	write(dockerDir, "icon_#2.png", green)
+	write(dockerDir, "icon_#3.png", red)
+	write(dockerDir, "other.png", red)
+
+	report := &unityBackendDiffReport{Backends: [2]string{"native", "docker"}}
+	if err := report.compareImageDirs(nativeDir, dockerDir, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if report.Compared != 3 || report.Identical != 2 || len(report.Files) != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if file := report.Files[0]; file.Path != "images/icon_#p40.png" || file.Status != "differs" || file.Detail != "compared with images/icon_#3.png" {
+		t.Fatalf("unexpected pixel diff: %+v", file)
+	}
+	if file := report.Files[1]; file.Path != "images/other.png" || file.Status != "missing" {
+		t.Fatalf("unexpected missing file: %+v", file)
+	}
+}
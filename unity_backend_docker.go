@@ -140,10 +140,13 @@ func (dockerUnityUnpackBackend) UnpackImages(inputDir string, outputDir string)
 	return nil
 }
 
+// UnpackI18n uses the native decoder, the images have no i18n tool.
 func (dockerUnityUnpackBackend) UnpackI18n(inputPath string, outputPath string) error {
 	return unpackUnityI18nNative(inputPath, outputPath)
 }
 
+func (dockerUnityUnpackBackend) decodesI18nNatively() bool { return true }
+
 func PullImages(images []string, muteSpinner bool, headless bool) error {
 	feedbacks := make(chan string)
 
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+
+	"charm.land/log/v2"
+)
+
+// The exec backend hands every Unity job to an external command. The command
+// template is split like a shell would do it (single and double quotes, no
+// variable expansion, no shell is started) and the placeholders below are
+// replaced in every argument:
+//
+//	{job}     bundle, images or i18n
+//	{input}   bundle: the .bundle file, images: the folder with the
+//	          .imagebundle files, i18n: the .bin file
+//	{output}  bundle and i18n: the .json file to write, images: the existing
+//	          folder to write the images into
+//
+// The same values are also passed as DODUDA_UNITY_JOB, DODUDA_UNITY_INPUT and
+// DODUDA_UNITY_OUTPUT environment variables.
+//
+// Exit code 0 means the job succeeded. For bundle and i18n jobs an old output
+// file is removed before the command starts and the command must write a new
+// one. Exit code 3 means the command does not handle this job and doduda falls
+// back to the native backend for it. Every other exit code fails the job with
+// the end of stderr in the error message.
+const (
+	unityExecJobBundle = "bundle"
+	unityExecJobImages = "images"
+	unityExecJobI18n   = "i18n"
+
+	unityExecExitUnsupported = 3
+	unityExecMaxStderr       = 2048
+)
+
+type execUnityUnpackBackend struct {
+	args     []string
+	fallback UnityUnpackBackend
+}
+
+func newExecUnityUnpackBackend(template string) (execUnityUnpackBackend, error) {
+	if strings.TrimSpace(template) == "" {
+		return execUnityUnpackBackend{}, fmt.Errorf("exec unity backend needs a command, set --unity-exec or DODUDA_UNITY_EXEC")
+	}
+
+	args, err := splitUnityExecTemplate(template)
+	if err != nil {
+		return execUnityUnpackBackend{}, err
+	}
+
+	return execUnityUnpackBackend{args: args, fallback: nativeUnityUnpackBackend{}}, nil
+}
+
+func (execUnityUnpackBackend) Name() string { return UnityBackendExec }
+
+func (b execUnityUnpackBackend) Prepare(muteSpinner bool, headless bool) error {
+	if _, err := exec.LookPath(b.args[0]); err != nil {
+		return fmt.Errorf("exec unity backend: %w", err)
+	}
+	return nil
+}
+
+func (b execUnityUnpackBackend) UnpackBundle(inputPath string, outputPath string) error {
+	if err := removeUnityExecOutput(outputPath); err != nil {
+		return err
+	}
+	handled, err := b.run(unityExecJobBundle, inputPath, outputPath)
+	if err != nil {
+		return err
+	}
+	if !handled {
+		return b.fallback.UnpackBundle(inputPath, outputPath)
+	}
+	return requireUnityExecOutput(unityExecJobBundle, outputPath)
+}
+
+func (b execUnityUnpackBackend) UnpackImages(inputDir string, outputDir string) error {
+	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	handled, err := b.run(unityExecJobImages, inputDir, outputDir)
+	if err != nil {
+		return err
+	}
+	if !handled {
+		return b.fallback.UnpackImages(inputDir, outputDir)
+	}
+	return nil
+}
+
+func (b execUnityUnpackBackend) UnpackI18n(inputPath string, outputPath string) error {
+	if err := removeUnityExecOutput(outputPath); err != nil {
+		return err
+	}
+	handled, err := b.run(unityExecJobI18n, inputPath, outputPath)
+	if err != nil {
+		return err
+	}
+	if !handled {
+		return b.fallback.UnpackI18n(inputPath, outputPath)
+	}
+	return requireUnityExecOutput(unityExecJobI18n, outputPath)
+}
+
+// run executes the command for one job. It returns false when the command
+// exited with unityExecExitUnsupported.
+func (b execUnityUnpackBackend) run(job string, input string, output string) (bool, error) {
+	replacer := strings.NewReplacer("{job}", job, "{input}", input, "{output}", output)
+	args := make([]string, len(b.args))
+	for i, arg := range b.args {
+		args[i] = replacer.Replace(arg)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"DODUDA_UNITY_JOB="+job,
+		"DODUDA_UNITY_INPUT="+input,
+		"DODUDA_UNITY_OUTPUT="+output,
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if stdout.Len() > 0 {
+		log.Debugf("exec unity backend %s %s: %s", job, input, strings.TrimSpace(stdout.String()))
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if exitErr.ExitCode() == unityExecExitUnsupported {
+			return false, nil
+		}
+
+		message := strings.TrimSpace(stderr.String())
+		if len(message) > unityExecMaxStderr {
+			message = "..." + message[len(message)-unityExecMaxStderr:]
+		}
+		if message == "" {
+			return true, fmt.Errorf("exec unity backend %s job for %s exited with code %d", job, input, exitErr.ExitCode())
+		}
+		return true, fmt.Errorf("exec unity backend %s job for %s exited with code %d: %s", job, input, exitErr.ExitCode(), message)
+	}
+	if err != nil {
+		return true, fmt.Errorf("exec unity backend %s job: %w", job, err)
+	}
+
+	return true, nil
+}
+
+// removeUnityExecOutput removes the output of an earlier run, so that
+// requireUnityExecOutput does not accept it.
+func removeUnityExecOutput(outputPath string) error {
+	if err := os.Remove(outputPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func requireUnityExecOutput(job string, outputPath string) error {
+	if _, err := os.Stat(outputPath); err != nil {
+		return fmt.Errorf("exec unity backend %s job exited with code 0 but did not write %s", job, outputPath)
+	}
+	return nil
+}
+
+// splitUnityExecTemplate splits a command line into arguments. Quotes group
+// words, a backslash escapes the next character outside of single quotes.
+func splitUnityExecTemplate(template string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, r := range template {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in unity exec command %q", template)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty unity exec command")
+	}
+	return args, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSplitUnityExecTemplate(t *testing.T) {
+	args, err := splitUnityExecTemplate(`python3 "my script.py" --out='{output}' a\ b {job}`)
+	if err != nil {
+		t.Fatalf("splitUnityExecTemplate returned error: %v", err)
+	}
+	want := []string{"python3", "my script.py", "--out={output}", "a b", "{job}"}
+	if !reflect.DeepEqual(args, want) {
+		t.Fatalf("expected %q, got %q", want, args)
+	}
+
+	if _, err := splitUnityExecTemplate(`run "unterminated`); err == nil {
+		t.Fatalf("expected error for unterminated quote")
+	}
+}
+
+func TestExecUnityUnpackBackend(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "extract.sh")
+	content := `#!/bin/sh
+case "$1" in
+  bundle) case "$2" in *stale*) exit 0 ;; esac
+    printf '{"job":"%s"}' "$DODUDA_UNITY_JOB" > "$3" ;;
+  images) exit 3 ;;
+  i18n) echo "broken table" >&2; exit 1 ;;
+esac
+`
+	if err := os.WriteFile(script, []byte(content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err := newExecUnityUnpackBackend(script + " {job} {input} {output}")
+	if err != nil {
+		t.Fatalf("newExecUnityUnpackBackend returned error: %v", err)
+	}
+	if err := backend.Prepare(true, true); err != nil {
+		t.Fatalf("Prepare returned error: %v", err)
+	}
+
+	output := filepath.Join(dir, "items.json")
+	if err := backend.UnpackBundle(filepath.Join(dir, "items.bundle"), output); err != nil {
+		t.Fatalf("UnpackBundle returned error: %v", err)
+	}
+	if written, _ := os.ReadFile(output); string(written) != `{"job":"bundle"}` {
+		t.Fatalf("unexpected bundle output %q", written)
+	}
+
+	// An output left over from an earlier run does not count as written.
+	err = backend.UnpackBundle(filepath.Join(dir, "stale.bundle"), output)
+	if err == nil || !strings.Contains(err.Error(), "did not write") {
+		t.Fatalf("expected missing output error, got %v", err)
+	}
+
+	// Exit code 3 falls back to the native backend, which finds no bundles.
+	if err := backend.UnpackImages(dir, filepath.Join(dir, "images")); err != nil {
+		t.Fatalf("UnpackImages fallback returned error: %v", err)
+	}
+
+	err = backend.UnpackI18n(filepath.Join(dir, "fr.bin"), filepath.Join(dir, "fr.json"))
+	if err == nil || !strings.Contains(err.Error(), "exited with code 1: broken table") {
+		t.Fatalf("expected exit code error with stderr, got %v", err)
+	}
+}
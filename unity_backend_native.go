@@ -19,3 +19,5 @@ func (nativeUnityUnpackBackend) UnpackImages(inputDir string, outputDir string)
 func (nativeUnityUnpackBackend) UnpackI18n(inputPath string, outputPath string) error {
 	return unpackUnityI18nNative(inputPath, outputPath)
 }
+
+func (nativeUnityUnpackBackend) decodesI18nNatively() bool { return true }
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The native backend names every image after its object: sprites and
+// textures after their m_Name, texture aliases after the texture of the
+// sprite they show. When several objects of one directory get the same name,
+// the one with the lowest rank keeps <name>.png and every other one is written
+// as <name>_#p<pathID>.png. Sprites rank before textures before texture
+// aliases, then lower path IDs before higher ones. The result does not depend
+// on the order of objects in the bundles.
+//
+// Every directory gets a names.json that maps each written file to its source
+// object. cleanImages keeps it up to date: when it drops the lower resolution
+// copies of a name, the dropped objects are listed as aliases of the file that
+// was kept, and renamed files are rekeyed.
+const (
+	unityImageNamesFile = "names.json"
+
+	unityImageKindSprite       = "sprite"
+	unityImageKindTexture      = "texture"
+	unityImageKindTextureAlias = "textureAlias"
+)
+
+type unityImageName struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	PathID int64  `json:"pathID"`
+	// Sprite is the sprite whose pixels a texture alias shows.
+	Sprite string `json:"sprite,omitempty"`
+	// Texture is the texture a sprite lives on.
+	Texture string                `json:"texture,omitempty"`
+	Aliases []unityImageNameAlias `json:"aliases,omitempty"`
+}
+
+// unityImageNameAlias is an object that was merged into another file. File
+// is the name it was written as before cleaning.
+type unityImageNameAlias struct {
+	File   string `json:"file"`
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	PathID int64  `json:"pathID"`
+}
+
+func unityImageKindRank(kind string) int {
+	switch kind {
+	case unityImageKindSprite:
+		return 0
+	case unityImageKindTexture:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func (n *unityImageName) before(other *unityImageName) bool {
+	if rank, otherRank := unityImageKindRank(n.Kind), unityImageKindRank(other.Kind); rank != otherRank {
+		return rank < otherRank
+	}
+	return n.PathID < other.PathID
+}
+
+func (n *unityImageName) sameObject(other *unityImageName) bool {
+	return n.Kind == other.Kind && n.Name == other.Name && n.PathID == other.PathID
+}
+
+// unityImageNamer assigns the file names of the native image export. onRename
+// is called when an already written file is moved away from its name.
+type unityImageNamer struct {
+	dirs     map[string]map[string]*unityImageName
+	onRename func(dir string, oldRel string, newRel string)
+}
+
+func newUnityImageNamer() *unityImageNamer {
+	return &unityImageNamer{dirs: make(map[string]map[string]*unityImageName)}
+}
+
+// entries returns the names of dir, loading an existing names.json first.
+func (n *unityImageNamer) entries(dir string) (map[string]*unityImageName, error) {
+	if entries, ok := n.dirs[dir]; ok {
+		return entries, nil
+	}
+
+	entries, err := readUnityImageNames(dir)
+	if err != nil {
+		return nil, err
+	}
+	n.dirs[dir] = entries
+	return entries, nil
+}
+
+// place returns the path the object has to be written to.
+func (n *unityImageNamer) place(dir string, baseName string, object unityImageName) (string, error) {
+	entries, err := n.entries(dir)
+	if err != nil {
+		return "", err
+	}
+
+	primary := baseName + ".png"
+	current, taken := entries[primary]
+	switch {
+	case !taken || current.sameObject(&object):
+		entries[primary] = &object
+		return filepath.Join(dir, primary), nil
+	case object.before(current):
+		secondary := unityImageSecondaryName(baseName, current.PathID)
+		if err := os.Rename(filepath.Join(dir, primary), filepath.Join(dir, secondary)); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		entries[secondary] = current
+		entries[primary] = &object
+		if n.onRename != nil {
+			n.onRename(dir, primary, secondary)
+		}
+		return filepath.Join(dir, primary), nil
+	default:
+		secondary := unityImageSecondaryName(baseName, object.PathID)
+		entries[secondary] = &object
+		return filepath.Join(dir, secondary), nil
+	}
+}
+
+// record stores the source of a file whose path was chosen elsewhere.
+func (n *unityImageNamer) record(dir string, imagePath string, object unityImageName) error {
+	entries, err := n.entries(dir)
+	if err != nil {
+		return err
+	}
+	relPath, err := filepath.Rel(dir, imagePath)
+	if err != nil {
+		return err
+	}
+	entries[filepath.ToSlash(relPath)] = &object
+	return nil
+}
+
+func (n *unityImageNamer) write() error {
+	for dir, entries := range n.dirs {
+		if err := writeUnityImageNames(dir, entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unityImageSecondaryName(baseName string, pathID int64) string {
+	return fmt.Sprintf("%s_#p%d.png", baseName, pathID)
+}
+
+func readUnityImageNames(dir string) (map[string]*unityImageName, error) {
+	entries := make(map[string]*unityImageName)
+	encoded, err := os.ReadFile(filepath.Join(dir, unityImageNamesFile))
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(encoded, &entries); err != nil {
+		return nil, fmt.Errorf("read %s: %w", filepath.Join(dir, unityImageNamesFile), err)
+	}
+	return entries, nil
+}
+
+func writeUnityImageNames(dir string, entries map[string]*unityImageName) error {
+	encoded, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, unityImageNamesFile), append(encoded, '\n'), os.ModePerm)
+}
+
+// unityImageSidecarSet holds the names.json, sprites.json and
+// provenance.json files below a directory while cleanImages removes and
+// renames the images they describe.
+type unityImageSidecarSet struct {
+	// dirs is sorted so that nested directories come before their parents.
+	dirs       []string
+	names      map[string]map[string]*unityImageName
+	sprites    map[string]map[string]json.RawMessage
+	provenance map[string]map[string]json.RawMessage
+}
+
+func openUnityImageSidecars(root string) (*unityImageSidecarSet, error) {
+	set := &unityImageSidecarSet{
+		names:      make(map[string]map[string]*unityImageName),
+		sprites:    make(map[string]map[string]json.RawMessage),
+		provenance: make(map[string]map[string]json.RawMessage),
+	}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		dir := filepath.Dir(path)
+		switch info.Name() {
+		case unityImageNamesFile:
+			entries, err := readUnityImageNames(dir)
+			if err != nil {
+				return err
+			}
+			set.names[dir] = entries
+		case unitySpriteSidecarName, imageProvenanceFile:
+			encoded, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			entries := make(map[string]json.RawMessage)
+			if err := json.Unmarshal(encoded, &entries); err != nil {
+				return fmt.Errorf("read %s: %w", path, err)
+			}
+			if info.Name() == imageProvenanceFile {
+				set.provenance[dir] = entries
+			} else {
+				set.sprites[dir] = entries
+			}
+		default:
+			return nil
+		}
+
+		if !contains(set.dirs, dir) {
+			set.dirs = append(set.dirs, dir)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(set.dirs, func(i, j int) bool { return len(set.dirs[i]) > len(set.dirs[j]) })
+	return set, nil
+}
+
+// locate returns the sidecar directory that describes path and the key of
+// path in it.
+func (s *unityImageSidecarSet) locate(path string) (string, string, bool) {
+	for _, dir := range s.dirs {
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return dir, filepath.ToSlash(relPath), true
+	}
+	return "", "", false
+}
+
+func (s *unityImageSidecarSet) remove(path string) {
+	dir, key, ok := s.locate(path)
+	if !ok {
+		return
+	}
+	delete(s.names[dir], key)
+	delete(s.sprites[dir], key)
+	delete(s.provenance[dir], key)
+}
+
+// merge removes the entries of removed and lists its objects as aliases of
+// kept.
+func (s *unityImageSidecarSet) merge(removed string, kept string) {
+	removedDir, removedKey, ok := s.locate(removed)
+	if !ok {
+		return
+	}
+	keptDir, keptKey, _ := s.locate(kept)
+
+	if removedName, ok := s.names[removedDir][removedKey]; ok {
+		if keptName, ok := s.names[keptDir][keptKey]; ok {
+			keptName.Aliases = append(keptName.Aliases, unityImageNameAlias{
+				File:   removedKey,
+				Kind:   removedName.Kind,
+				Name:   removedName.Name,
+				PathID: removedName.PathID,
+			})
+			keptName.Aliases = append(keptName.Aliases, removedName.Aliases...)
+			sort.Slice(keptName.Aliases, func(i, j int) bool { return keptName.Aliases[i].File < keptName.Aliases[j].File })
+		}
+	}
+	s.remove(removed)
+}
+
+func (s *unityImageSidecarSet) rename(oldPath string, newPath string) {
+	dir, oldKey, ok := s.locate(oldPath)
+	if !ok {
+		return
+	}
+	newRel, err := filepath.Rel(dir, newPath)
+	if err != nil {
+		return
+	}
+	newKey := filepath.ToSlash(newRel)
+
+	if entry, ok := s.names[dir][oldKey]; ok {
+		delete(s.names[dir], oldKey)
+		s.names[dir][newKey] = entry
+	}
+	if entry, ok := s.sprites[dir][oldKey]; ok {
+		delete(s.sprites[dir], oldKey)
+		s.sprites[dir][newKey] = entry
+	}
+	if entry, ok := s.provenance[dir][oldKey]; ok {
+		delete(s.provenance[dir], oldKey)
+		s.provenance[dir][newKey] = entry
+	}
+}
+
+func (s *unityImageSidecarSet) write() error {
+	for dir, entries := range s.names {
+		if err := writeUnityImageNames(dir, entries); err != nil {
+			return err
+		}
+	}
+	for name, sidecars := range map[string]map[string]map[string]json.RawMessage{
+		unitySpriteSidecarName: s.sprites,
+		imageProvenanceFile:    s.provenance,
+	} {
+		for dir, entries := range sidecars {
+			encoded, err := json.MarshalIndent(entries, "", "  ")
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(filepath.Join(dir, name), append(encoded, '\n'), os.ModePerm); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnityImageNamerIsOrderIndependent(t *testing.T) {
+	objects := []unityImageName{
+		{Kind: unityImageKindTextureAlias, Name: "icon", PathID: 1, Sprite: "other"},
+		{Kind: unityImageKindSprite, Name: "icon", PathID: 30},
+		{Kind: unityImageKindSprite, Name: "icon", PathID: 20},
+	}
+
+	results := make([]map[string]int64, 0, 2)
+	for _, order := range [][]int{{0, 1, 2}, {2, 1, 0}} {
+		dir := t.TempDir()
+		namer := newUnityImageNamer()
+		for _, i := range order {
+			path, err := namer.place(dir, "icon", objects[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, nil, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := namer.write(); err != nil {
+			t.Fatal(err)
+		}
+
+		entries, err := readUnityImageNames(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := make(map[string]int64)
+		for file, entry := range entries {
+			if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+				t.Fatalf("names.json lists missing file %s", file)
+			}
+			result[file] = entry.PathID
+		}
+		results = append(results, result)
+	}
+
+	want := map[string]int64{"icon.png": 20, "icon_#p30.png": 30, "icon_#p1.png": 1}
+	for _, result := range results {
+		if len(result) != len(want) {
+			t.Fatalf("names = %v, want %v", result, want)
+		}
+		for file, pathID := range want {
+			if result[file] != pathID {
+				t.Fatalf("names = %v, want %v", result, want)
+			}
+		}
+	}
+}
+
+func TestCleanImagesUpdatesNames(t *testing.T) {
+	dir := t.TempDir()
+	namer := newUnityImageNamer()
+	for _, object := range []struct {
+		pathID int64
+		size   int
+	}{{10, 32}, {20, 64}} {
+		path, err := namer.place(dir, "frame", unityImageName{Kind: unityImageKindSprite, Name: "frame", PathID: object.pathID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := unityWritePNG(path, image.NewNRGBA(image.Rect(0, 0, object.size, object.size))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := namer.write(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cleanImages(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := readUnityImageNames(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, ok := entries["frame-64.png"]
+	if !ok || len(entries) != 1 {
+		t.Fatalf("names = %v", entries)
+	}
+	if kept.PathID != 20 || len(kept.Aliases) != 1 || kept.Aliases[0].PathID != 10 || kept.Aliases[0].File != "frame.png" {
+		t.Fatalf("kept entry = %+v", kept)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "frame-64.png")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,66 +1,69 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
 	"image"
-	"image/color"
 	"image/png"
-	"math"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
-	"github.com/kvarenzn/ssm/uni"
-	"github.com/xypwn/filediver/dds"
+	"github.com/dofusdude/doduda/unity/images"
 )
 
+// unityTextureMipLevel is set by --mip-level. Textures are written at this
+// mip level, sprites always at full resolution.
+var unityTextureMipLevel int
+
 func unpackUnityImagesNative(inputDir string, outputDir string) error {
 	entries, err := os.ReadDir(inputDir)
 	if err != nil {
 		return err
 	}
 
+	namer := newUnityImageNamer()
+	var sidecars unitySpriteSidecars
+	if emitUnitySpriteSidecar {
+		sidecars = make(unitySpriteSidecars)
+		namer.onRename = func(dir string, oldRel string, newRel string) {
+			// Loading can only fail for a broken sprites.json, which write
+			// reports anyway.
+			_ = sidecars.rename(dir, oldRel, newRel)
+		}
+	}
+
 	for _, entry := range entries {
 		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".imagebundle") {
 			continue
 		}
 
 		inputPath := filepath.Join(inputDir, entry.Name())
-		if err := unpackUnityImageBundleNative(inputPath, outputDir); err != nil {
+		if err := unpackUnityImageBundleNative(inputPath, outputDir, namer, sidecars); err != nil {
 			return fmt.Errorf("unpack %s: %w", entry.Name(), err)
 		}
 	}
 
-	return nil
+	if err := namer.write(); err != nil {
+		return err
+	}
+	return sidecars.write()
 }
 
-func unpackUnityImageBundleNative(inputPath string, outputDir string) error {
-	data, err := os.ReadFile(inputPath)
+// unpackUnityImageBundleNative writes the images of one bundle with names
+// assigned by namer. Sprites are recorded in sidecars unless it is nil.
+func unpackUnityImageBundleNative(inputPath string, outputDir string, namer *unityImageNamer, sidecars unitySpriteSidecars) error {
+	file, err := os.Open(inputPath)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
-	assetsManager := uni.NewAssetsManager()
-	if err := assetsManager.LoadDataFromHandler(data, filepath.Base(inputPath)); err != nil {
+	imageBundle, err := images.Open(file)
+	if err != nil {
 		return err
 	}
-
-	for _, assetFile := range assetsManager.AssetFiles {
-		for _, objectInfo := range assetFile.ObjectInfos {
-			reader := uni.NewObjectReader(assetFile.Reader.BinaryReader, assetFile, objectInfo)
-			switch objectInfo.ClassID {
-			case uni.ClassIDAssetBundle:
-				assetFile.AddObject(uni.NewAssetBundle(reader))
-			case uni.ClassIDTexture2D:
-				assetFile.AddObject(uni.NewTexture2D(reader))
-			case uni.ClassIDSprite:
-				assetFile.AddObject(uni.NewSprite(reader))
-			default:
-				assetFile.AddObject(uni.NewObject(reader))
-			}
-		}
-	}
+	imageBundle.MipLevel = unityTextureMipLevel
 
 	targetDir := outputDir
 	if subdir := unityImageResolutionSubdir(filepath.Base(inputPath)); subdir != "" {
@@ -71,41 +74,63 @@ func unpackUnityImageBundleNative(inputPath string, outputDir string) error {
 	}
 	layerOrder := unityBundleLayerDirs(filepath.Base(inputPath))
 
-	textureCache := make(map[*uni.Texture2D]image.Image)
+	// Layered bundles keep the sprite order based layout, everything else is
+	// named by namer.
 	nameStates := make(map[string]*unityNameState)
-
-	for _, assetFile := range assetsManager.AssetFiles {
-		for _, object := range assetFile.Objects {
-			sprite, ok := object.(*uni.Sprite)
-			if !ok {
-				continue
+	writeImage := func(baseName string, img image.Image, object unityImageName) (string, error) {
+		var outputPath string
+		if len(layerOrder) > 0 {
+			outputPath = unityNextImagePath(targetDir, baseName, img, nameStates, layerOrder)
+			if err := namer.record(targetDir, outputPath, object); err != nil {
+				return "", err
 			}
-
-			spriteImage, err := unityDecodeSpriteImage(sprite, textureCache)
+		} else {
+			var err error
+			outputPath, err = namer.place(targetDir, baseName, object)
 			if err != nil {
-				return fmt.Errorf("decode sprite %q: %w", sprite.Name, err)
-			}
-
-			var textureName string
-			if sprite.RenderData != nil && sprite.RenderData.Texture != nil {
-				if texture, ok := sprite.RenderData.Texture.Get().(*uni.Texture2D); ok && texture != nil {
-					textureName = texture.Name
-				}
+				return "", err
 			}
+		}
+		return outputPath, unityWritePNG(outputPath, img)
+	}
 
-			outputName := unityOutputImageName(sprite.Name, unityObjectFallbackName(sprite.GetObject()))
-			outputPath := unityNextImagePath(targetDir, outputName, spriteImage, nameStates, layerOrder)
-			if err := unityWritePNG(outputPath, spriteImage); err != nil {
+	sprites, err := imageBundle.Sprites()
+	if err != nil {
+		return err
+	}
+	for _, sprite := range sprites {
+		outputName := unityOutputImageName(sprite.Name, unityObjectFallbackName(sprite.PathID))
+		outputPath, err := writeImage(outputName, sprite.Image, unityImageName{
+			Kind:    unityImageKindSprite,
+			Name:    sprite.Name,
+			PathID:  sprite.PathID,
+			Texture: sprite.TextureName,
+		})
+		if err != nil {
+			return err
+		}
+		if sidecars != nil {
+			if err := sidecars.add(targetDir, outputPath, sprite); err != nil {
 				return err
 			}
+		}
 
-			// AssetStudio occasionally names the emitted file after the linked texture
-			// instead of the sprite object. Export both aliases when they differ.
-			if len(layerOrder) == 0 && textureName != "" {
-				textureAliasName := unityOutputImageName(textureName, unityObjectFallbackName(sprite.GetObject()))
-				if textureAliasName != outputName {
-					textureAliasPath := unityNextImagePath(targetDir, textureAliasName, spriteImage, nameStates, layerOrder)
-					if err := unityWritePNG(textureAliasPath, spriteImage); err != nil {
+		// AssetStudio occasionally names the emitted file after the linked texture
+		// instead of the sprite object. Export both aliases when they differ.
+		if len(layerOrder) == 0 && sprite.TextureName != "" {
+			textureAliasName := unityOutputImageName(sprite.TextureName, unityObjectFallbackName(sprite.PathID))
+			if textureAliasName != outputName {
+				textureAliasPath, err := writeImage(textureAliasName, sprite.Image, unityImageName{
+					Kind:   unityImageKindTextureAlias,
+					Name:   sprite.TextureName,
+					PathID: sprite.PathID,
+					Sprite: sprite.Name,
+				})
+				if err != nil {
+					return err
+				}
+				if sidecars != nil {
+					if err := sidecars.add(targetDir, textureAliasPath, sprite); err != nil {
 						return err
 					}
 				}
@@ -117,213 +142,22 @@ func unpackUnityImageBundleNative(inputPath string, outputDir string) error {
 		return nil
 	}
 
-	for _, assetFile := range assetsManager.AssetFiles {
-		for _, object := range assetFile.Objects {
-			texture, ok := object.(*uni.Texture2D)
-			if !ok {
-				continue
-			}
-
-			textureImage, err := unityDecodeTextureImage(texture, 0, 0)
-			if err != nil {
-				return fmt.Errorf("decode texture %q: %w", texture.Name, err)
-			}
-
-			outputName := unityOutputImageName(texture.Name, unityObjectFallbackName(texture.GetObject()))
-			outputPath := unityNextImagePath(targetDir, outputName, textureImage, nameStates, layerOrder)
-			if err := unityWritePNG(outputPath, textureImage); err != nil {
-				return err
-			}
-		}
-	}
-
-	return nil
-}
-
-func unityDecodeSpriteImage(sprite *uni.Sprite, textureCache map[*uni.Texture2D]image.Image) (image.Image, error) {
-	if sprite == nil || sprite.RenderData == nil || sprite.RenderData.Texture == nil {
-		return nil, fmt.Errorf("sprite has no render data texture")
-	}
-
-	textureObject, ok := sprite.RenderData.Texture.Get().(*uni.Texture2D)
-	if !ok || textureObject == nil {
-		return nil, fmt.Errorf("sprite texture reference is not a Texture2D")
-	}
-
-	textureImage, ok := textureCache[textureObject]
-	if !ok {
-		var err error
-		hintWidth, hintHeight := 0, 0
-		if sprite.RenderData != nil && sprite.RenderData.TextureRect != nil {
-			hintWidth = int(math.Round(float64(sprite.RenderData.TextureRect.Width)))
-			hintHeight = int(math.Round(float64(sprite.RenderData.TextureRect.Height)))
-		}
-		textureImage, err = unityDecodeTextureImage(textureObject, hintWidth, hintHeight)
-		if err != nil {
-			return nil, err
-		}
-		textureCache[textureObject] = textureImage
-	}
-
-	return textureImage, nil
-}
-
-func unityDecodeTextureImage(texture *uni.Texture2D, hintWidth int, hintHeight int) (image.Image, error) {
-	raw, err := unityReadTextureData(texture)
+	textures, err := imageBundle.Textures()
 	if err != nil {
-		return nil, err
-	}
-	if len(raw) == 0 {
-		return nil, fmt.Errorf("texture data is empty")
-	}
-
-	meta := unityNormalizedTextureMeta(texture, len(raw), hintWidth, hintHeight)
-	if meta.width <= 0 || meta.height <= 0 {
-		return nil, fmt.Errorf("invalid texture dimensions %dx%d", meta.width, meta.height)
-	}
-
-	switch meta.format {
-	case uni.BC7:
-		decoded := image.NewNRGBA(image.Rect(0, 0, meta.width, meta.height))
-		size := meta.completeSize
-		if size <= 0 || size > len(raw) {
-			size = len(raw)
-		}
-		if err := dds.DecompressBC7(decoded.Pix, bytes.NewReader(raw[:size]), meta.width, meta.height, dds.Info{ColorModel: color.NRGBAModel}); err != nil {
-			return nil, err
-		}
-		return unityFlipVerticalNRGBA(decoded), nil
-	default:
-		textureCopy := *texture
-		textureCopy.Width = int32(meta.width)
-		textureCopy.Height = int32(meta.height)
-		textureCopy.Format = meta.format
-		textureCopy.ImageData = uni.NewResourceReader(uni.NewBinaryReaderFromBytes(raw, true), 0, int64(len(raw)))
-		return uni.DecodeTexture2D(&textureCopy)
-	}
-}
-
-type unityTextureMeta struct {
-	width        int
-	height       int
-	completeSize int
-	format       uni.TextureFormat
-}
-
-func unityNormalizedTextureMeta(texture *uni.Texture2D, rawLen int, hintWidth int, hintHeight int) unityTextureMeta {
-	meta := unityTextureMeta{
-		width:        int(texture.Width),
-		height:       int(texture.Height),
-		completeSize: int(texture.CompleteImageSize),
-		format:       texture.Format,
-	}
-
-	// Dofus 3 image bundles currently expose a shifted field layout in ssm:
-	// m_CompleteImageSize is read into height, and m_TextureFormat into mipsStripped.
-	if meta.format == uni.Alpha8 && meta.completeSize == 0 && texture.MipsStripped.IsSome() && meta.height > meta.width {
-		meta.completeSize = meta.height
-		meta.format = uni.TextureFormat(texture.MipsStripped.Unwrap())
-	}
-
-	if meta.completeSize <= 0 {
-		meta.completeSize = rawLen
-	}
-	if hintWidth > 0 && hintHeight > 0 && meta.completeSize > 0 && hintWidth*hintHeight == meta.completeSize {
-		meta.width = hintWidth
-		meta.height = hintHeight
-		return meta
-	}
-	if hintWidth > 0 && meta.completeSize > 0 && meta.completeSize%hintWidth == 0 {
-		derivedHeight := meta.completeSize / hintWidth
-		if hintHeight <= 0 || absInt(derivedHeight-hintHeight) <= 2 {
-			meta.width = hintWidth
-			meta.height = derivedHeight
-			return meta
-		}
-	}
-	if hintHeight > 0 && meta.completeSize > 0 && meta.completeSize%hintHeight == 0 {
-		derivedWidth := meta.completeSize / hintHeight
-		if hintWidth <= 0 || absInt(derivedWidth-hintWidth) <= 2 {
-			meta.width = derivedWidth
-			meta.height = hintHeight
-			return meta
-		}
+		return err
 	}
-
-	if meta.width > 0 {
-		switch meta.format {
-		case uni.BC7:
-			if meta.completeSize%meta.width == 0 {
-				meta.height = meta.completeSize / meta.width
-			}
-		case uni.Alpha8:
-			if rawLen%meta.width == 0 {
-				meta.height = rawLen / meta.width
-			}
-		case uni.RGB24:
-			if rawLen%(meta.width*3) == 0 {
-				meta.height = rawLen / (meta.width * 3)
-			}
-		case uni.RGBA32, uni.ARGB32:
-			if rawLen%(meta.width*4) == 0 {
-				meta.height = rawLen / (meta.width * 4)
-			}
+	for _, texture := range textures {
+		outputName := unityOutputImageName(texture.Name, unityObjectFallbackName(texture.PathID))
+		if _, err := writeImage(outputName, texture.Image, unityImageName{
+			Kind:   unityImageKindTexture,
+			Name:   texture.Name,
+			PathID: texture.PathID,
+		}); err != nil {
+			return err
 		}
 	}
 
-	return meta
-}
-
-func absInt(value int) int {
-	if value < 0 {
-		return -value
-	}
-	return value
-}
-
-func unityFlipVerticalNRGBA(src *image.NRGBA) *image.NRGBA {
-	if src == nil {
-		return nil
-	}
-	bounds := src.Bounds()
-	out := image.NewNRGBA(bounds)
-	rowSize := bounds.Dx() * 4
-	for y := 0; y < bounds.Dy(); y++ {
-		srcStart := (bounds.Dy()-1-y)*src.Stride + bounds.Min.X*4
-		dstStart := y*out.Stride + bounds.Min.X*4
-		copy(out.Pix[dstStart:dstStart+rowSize], src.Pix[srcStart:srcStart+rowSize])
-	}
-	return out
-}
-
-func unityReadTextureData(texture *uni.Texture2D) ([]byte, error) {
-	if texture == nil || texture.ImageData == nil {
-		return nil, fmt.Errorf("texture has no image data reader")
-	}
-
-	resourceReader := texture.ImageData
-	reader := resourceReader.GetReader()
-	if reader == nil {
-		return nil, fmt.Errorf("texture resource reader is unavailable")
-	}
-
-	size := resourceReader.Size
-	if size <= 0 {
-		return nil, nil
-	}
-	offset := resourceReader.Offset
-	if offset < 0 || offset+size > reader.Len() {
-		return nil, fmt.Errorf("texture data range is out of bounds (offset=%d size=%d len=%d)", offset, size, reader.Len())
-	}
-	if size > int64(^uint(0)>>1) {
-		return nil, fmt.Errorf("texture data size %d is too large", size)
-	}
-
-	if err := reader.SeekTo(offset); err != nil {
-		return nil, err
-	}
-	out := reader.Bytes(int(size))
-	return append([]byte(nil), out...), nil
+	return nil
 }
 
 func unityImageResolutionSubdir(bundleName string) string {
@@ -364,11 +198,11 @@ func unityOutputImageName(assetName string, fallback string) string {
 	return name
 }
 
-func unityObjectFallbackName(object *uni.Object) string {
-	if object == nil {
+func unityObjectFallbackName(pathID int64) string {
+	if pathID == 0 {
 		return "unnamed"
 	}
-	return fmt.Sprintf("%d", object.PathID)
+	return strconv.FormatInt(pathID, 10)
 }
 
 type unityNameState struct {
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const unitySchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// emitUnitySchema is set by --emit-schema. The native backend then writes a
+// JSON Schema next to every unpacked data root.
+var emitUnitySchema bool
+
+// unitySchemaPath returns <dir>/schema/<name>.schema.json for a data root
+// written to <dir>/<name>.json.
+func unitySchemaPath(outputPath string) string {
+	name := strings.TrimSuffix(filepath.Base(outputPath), filepath.Ext(outputPath))
+	return filepath.Join(filepath.Dir(outputPath), "schema", name+".schema.json")
+}
+
+// writeUnitySchema writes root with the shared defs as a standalone schema
+// document next to the data root at outputPath.
+func writeUnitySchema(outputPath string, defs map[string]any, root map[string]any) error {
+	schema := map[string]any{
+		"$schema": unitySchemaDialect,
+		"title":   strings.TrimSuffix(filepath.Base(outputPath), filepath.Ext(outputPath)),
+	}
+	for key, value := range root {
+		schema[key] = value
+	}
+	if len(defs) > 0 {
+		schema["$defs"] = defs
+	}
+
+	encoded, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	schemaPath := unitySchemaPath(outputPath)
+	if err := os.MkdirAll(filepath.Dir(schemaPath), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(schemaPath, encoded, os.ModePerm)
+}
+
+type unitySchemaChange struct {
+	Path string `json:"path"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+type unitySchemaFileDiff struct {
+	File    string              `json:"file"`
+	Added   []unitySchemaChange `json:"added,omitempty"`
+	Removed []unitySchemaChange `json:"removed,omitempty"`
+	Retyped []unitySchemaChange `json:"retyped,omitempty"`
+}
+
+func (d unitySchemaFileDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Retyped) == 0
+}
+
+// unitySchemaDir accepts either a schema directory or an output directory
+// that contains one.
+func unitySchemaDir(dir string) string {
+	if info, err := os.Stat(filepath.Join(dir, "schema")); err == nil && info.IsDir() {
+		return filepath.Join(dir, "schema")
+	}
+	return dir
+}
+
+func readUnitySchemas(dir string) (map[string]map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	schemas := make(map[string]map[string]string)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".schema.json") {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var schema map[string]any
+		if err := json.Unmarshal(content, &schema); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", entry.Name(), err)
+		}
+
+		fields := make(map[string]string)
+		flattenUnitySchema("", schema, fields)
+		if defs, ok := schema["$defs"].(map[string]any); ok {
+			for name, def := range defs {
+				if defSchema, ok := def.(map[string]any); ok {
+					flattenUnitySchema("$defs/"+name, defSchema, fields)
+				}
+			}
+		}
+		schemas[entry.Name()] = fields
+	}
+
+	return schemas, nil
+}
+
+// flattenUnitySchema maps every field path (objects[].name) to a short type
+// description like "string(string)" or "integer(int)".
+func flattenUnitySchema(path string, schema map[string]any, out map[string]string) {
+	if path != "" {
+		out[path] = unitySchemaTypeDescription(schema)
+	}
+
+	if properties, ok := schema["properties"].(map[string]any); ok {
+		for name, property := range properties {
+			propertySchema, ok := property.(map[string]any)
+			if !ok {
+				continue
+			}
+			childPath := name
+			if path != "" {
+				childPath = path + "." + name
+			}
+			flattenUnitySchema(childPath, propertySchema, out)
+		}
+	}
+
+	if items, ok := schema["items"].(map[string]any); ok {
+		flattenUnitySchema(path+"[]", items, out)
+	}
+}
+
+func unitySchemaTypeDescription(schema map[string]any) string {
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		refs := make([]string, 0, len(anyOf))
+		for _, entry := range anyOf {
+			if entryMap, ok := entry.(map[string]any); ok {
+				ref, _ := entryMap["$ref"].(string)
+				refs = append(refs, strings.TrimPrefix(ref, "#/$defs/"))
+			}
+		}
+		return "anyOf(" + strings.Join(refs, "|") + ")"
+	}
+
+	jsonType, _ := schema["type"].(string)
+	unityType, _ := schema["x-unityType"].(string)
+	if jsonType == "" {
+		jsonType = "any"
+	}
+	if unityType == "" {
+		return jsonType
+	}
+	return jsonType + "(" + unityType + ")"
+}
+
+func diffUnitySchemaDirs(oldDir string, newDir string) ([]unitySchemaFileDiff, error) {
+	oldSchemas, err := readUnitySchemas(unitySchemaDir(oldDir))
+	if err != nil {
+		return nil, err
+	}
+	newSchemas, err := readUnitySchemas(unitySchemaDir(newDir))
+	if err != nil {
+		return nil, err
+	}
+
+	fileNames := make(map[string]struct{})
+	for name := range oldSchemas {
+		fileNames[name] = struct{}{}
+	}
+	for name := range newSchemas {
+		fileNames[name] = struct{}{}
+	}
+	sortedNames := make([]string, 0, len(fileNames))
+	for name := range fileNames {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	var diffs []unitySchemaFileDiff
+	for _, name := range sortedNames {
+		diff := diffUnitySchemaFields(oldSchemas[name], newSchemas[name])
+		diff.File = name
+		if !diff.empty() {
+			diffs = append(diffs, diff)
+		}
+	}
+
+	return diffs, nil
+}
+
+func diffUnitySchemaFields(oldFields map[string]string, newFields map[string]string) unitySchemaFileDiff {
+	var diff unitySchemaFileDiff
+	for path, oldType := range oldFields {
+		newType, ok := newFields[path]
+		switch {
+		case !ok:
+			diff.Removed = append(diff.Removed, unitySchemaChange{Path: path, Old: oldType})
+		case newType != oldType:
+			diff.Retyped = append(diff.Retyped, unitySchemaChange{Path: path, Old: oldType, New: newType})
+		}
+	}
+	for path, newType := range newFields {
+		if _, ok := oldFields[path]; !ok {
+			diff.Added = append(diff.Added, unitySchemaChange{Path: path, New: newType})
+		}
+	}
+
+	for _, changes := range [][]unitySchemaChange{diff.Added, diff.Removed, diff.Retyped} {
+		sort.Slice(changes, func(i, j int) bool {
+			return changes[i].Path < changes[j].Path
+		})
+	}
+
+	return diff
+}
+
+func printUnitySchemaDiff(w io.Writer, diffs []unitySchemaFileDiff) {
+	if len(diffs) == 0 {
+		fmt.Fprintln(w, "No schema changes.")
+		return
+	}
+
+	for _, diff := range diffs {
+		fmt.Fprintln(w, diff.File)
+		for _, change := range diff.Added {
+			fmt.Fprintf(w, "  + %s %s\n", change.Path, change.New)
+		}
+		for _, change := range diff.Removed {
+			fmt.Fprintf(w, "  - %s %s\n", change.Path, change.Old)
+		}
+		for _, change := range diff.Retyped {
+			fmt.Fprintf(w, "  ~ %s %s -> %s\n", change.Path, change.Old, change.New)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dofusdude/doduda/unity/bundle"
+	"github.com/kvarenzn/ssm/uni"
+)
+
+func TestDiffUnitySchemaDirs(t *testing.T) {
+	oldDir := t.TempDir()
+	newDir := t.TempDir()
+
+	oldNodes := []*uni.TypeTreeNode{
+		{Name: "Base", Type: "ItemsDataRoot", Level: 0},
+		{Name: "objects", Type: "vector", Level: 1},
+		{Name: "Array", Type: "Array", Level: 2},
+		{Name: "size", Type: "int", Level: 3},
+		{Name: "data", Type: "Item", Level: 3},
+		{Name: "id", Type: "int", Level: 4},
+		{Name: "level", Type: "int", Level: 4},
+		{Name: "iconId", Type: "int", Level: 4},
+	}
+	newNodes := []*uni.TypeTreeNode{
+		{Name: "Base", Type: "ItemsDataRoot", Level: 0},
+		{Name: "objects", Type: "vector", Level: 1},
+		{Name: "Array", Type: "Array", Level: 2},
+		{Name: "size", Type: "int", Level: 3},
+		{Name: "data", Type: "Item", Level: 3},
+		{Name: "id", Type: "int", Level: 4},
+		{Name: "level", Type: "float", Level: 4},
+		{Name: "imageId", Type: "int", Level: 4},
+	}
+
+	for dir, nodes := range map[string][]*uni.TypeTreeNode{oldDir: oldNodes, newDir: newNodes} {
+		builder := bundle.NewSchemaBuilder()
+		root := builder.TypeTree(nodes, nil)
+		if err := writeUnitySchema(filepath.Join(dir, "items.json"), builder.Defs(), root); err != nil {
+			t.Fatalf("writeUnitySchema returned error: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(oldDir, "schema", "items.schema.json")); err != nil {
+		t.Fatalf("schema file was not written: %v", err)
+	}
+
+	diffs, err := diffUnitySchemaDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("diffUnitySchemaDirs returned error: %v", err)
+	}
+	if len(diffs) != 1 {
+		t.Fatalf("expected 1 changed file, got %d", len(diffs))
+	}
+
+	diff := diffs[0]
+	if len(diff.Added) != 1 || diff.Added[0].Path != "objects.Array[].imageId" {
+		t.Fatalf("unexpected added fields: %+v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].Path != "objects.Array[].iconId" {
+		t.Fatalf("unexpected removed fields: %+v", diff.Removed)
+	}
+	if len(diff.Retyped) != 1 || diff.Retyped[0].New != "number(float)" {
+		t.Fatalf("unexpected retyped fields: %+v", diff.Retyped)
+	}
+}
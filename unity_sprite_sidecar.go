@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dofusdude/doduda/unity/images"
+)
+
+const unitySpriteSidecarName = "sprites.json"
+
+// emitUnitySpriteSidecar is set by --sprites-json. The native backend then
+// writes a sprites.json with the sprite metadata into every image directory.
+var emitUnitySpriteSidecar bool
+
+type unitySpriteSidecarEntry struct {
+	Name          string              `json:"name"`
+	PathID        int64               `json:"pathID"`
+	Texture       string              `json:"texture"`
+	Rect          images.SpriteRect   `json:"rect"`
+	Pivot         images.SpritePivot  `json:"pivot"`
+	PixelsPerUnit float64             `json:"pixelsPerUnit"`
+	Border        images.SpriteBorder `json:"border"`
+}
+
+// unitySpriteSidecars collects sidecar entries per output directory, keyed by
+// the slash separated PNG path relative to that directory.
+type unitySpriteSidecars map[string]map[string]unitySpriteSidecarEntry
+
+func (s unitySpriteSidecars) add(dir string, imagePath string, sprite images.Sprite) error {
+	relPath, err := filepath.Rel(dir, imagePath)
+	if err != nil {
+		return err
+	}
+
+	entries, err := s.entries(dir)
+	if err != nil {
+		return err
+	}
+	entries[filepath.ToSlash(relPath)] = unitySpriteSidecarEntry{
+		Name:          sprite.Name,
+		PathID:        sprite.PathID,
+		Texture:       sprite.TextureName,
+		Rect:          sprite.Rect,
+		Pivot:         sprite.Pivot,
+		PixelsPerUnit: sprite.PixelsPerUnit,
+		Border:        sprite.Border,
+	}
+	return nil
+}
+
+// entries returns the entries of dir. Entries of an existing sprites.json are
+// loaded first and kept unless an image with the same path is written again.
+func (s unitySpriteSidecars) entries(dir string) (map[string]unitySpriteSidecarEntry, error) {
+	if entries, ok := s[dir]; ok {
+		return entries, nil
+	}
+
+	entries := make(map[string]unitySpriteSidecarEntry)
+	sidecarPath := filepath.Join(dir, unitySpriteSidecarName)
+	existing, err := os.ReadFile(sidecarPath)
+	if err == nil {
+		if err := json.Unmarshal(existing, &entries); err != nil {
+			return nil, fmt.Errorf("read %s: %w", sidecarPath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	s[dir] = entries
+	return entries, nil
+}
+
+// rename moves the entry of an image that was renamed in dir.
+func (s unitySpriteSidecars) rename(dir string, oldRel string, newRel string) error {
+	entries, err := s.entries(dir)
+	if err != nil {
+		return err
+	}
+	if entry, ok := entries[oldRel]; ok {
+		delete(entries, oldRel)
+		entries[newRel] = entry
+	}
+	return nil
+}
+
+func (s unitySpriteSidecars) write() error {
+	for dir, entries := range s {
+		encoded, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, unitySpriteSidecarName), append(encoded, '\n'), os.ModePerm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
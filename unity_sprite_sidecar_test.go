@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dofusdude/doduda/unity/images"
+)
+
+func TestUnitySpriteSidecarsMerge(t *testing.T) {
+	dir := t.TempDir()
+	sprite := images.Sprite{
+		Name:          "frame",
+		TextureName:   "atlas",
+		PathID:        42,
+		Rect:          images.SpriteRect{X: 1, Y: 2, Width: 30, Height: 40},
+		Pivot:         images.SpritePivot{X: 0.5, Y: 0},
+		PixelsPerUnit: 100,
+		Border:        images.SpriteBorder{Left: 4, Bottom: 5, Right: 6, Top: 7},
+	}
+
+	first := make(unitySpriteSidecars)
+	if err := first.add(dir, filepath.Join(dir, "up", "frame.png"), sprite); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.write(); err != nil {
+		t.Fatal(err)
+	}
+
+	sprite.Name = "other"
+	second := make(unitySpriteSidecars)
+	if err := second.add(dir, filepath.Join(dir, "other.png"), sprite); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.write(); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := os.ReadFile(filepath.Join(dir, unitySpriteSidecarName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries map[string]unitySpriteSidecarEntry
+	if err := json.Unmarshal(encoded, &entries); err != nil {
+		t.Fatal(err)
+	}
+
+	frame, ok := entries["up/frame.png"]
+	if !ok || len(entries) != 2 {
+		t.Fatalf("entries = %v", entries)
+	}
+	if frame.Name != "frame" || frame.PathID != 42 || frame.Texture != "atlas" || frame.Border.Top != 7 || frame.Pivot.X != 0.5 || frame.Rect.Width != 30 {
+		t.Fatalf("frame entry = %+v", frame)
+	}
+	if entries["other.png"].Name != "other" {
+		t.Fatalf("other entry = %+v", entries["other.png"])
+	}
+}
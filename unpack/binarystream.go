@@ -2,12 +2,16 @@ package unpack
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
 )
 
+// BinaryStream reads and writes values of a seekable stream. The first read
+// error sticks: later reads return zero values and Err returns it.
 type BinaryStream struct {
 	baseStream io.ReadWriteSeeker
 	bigEndian  bool
+	err        error
 }
 
 func NewBinaryStream(baseStream io.ReadWriteSeeker, bigEndian bool) *BinaryStream {
@@ -19,6 +23,11 @@ func NewBinaryStream(baseStream io.ReadWriteSeeker, bigEndian bool) *BinaryStrea
 
 // Comment functions
 
+// Err returns the first read error.
+func (bs *BinaryStream) Err() error {
+	return bs.err
+}
+
 func (bs *BinaryStream) Position() int64 {
 	position, _ := bs.baseStream.Seek(0, io.SeekCurrent)
 	return position
@@ -116,7 +125,12 @@ func (bs *BinaryStream) readValue(format string, data interface{}) {
 		byteOrder = binary.LittleEndian
 	}
 
-	binary.Read(bs.baseStream, byteOrder, data)
+	if bs.err != nil {
+		return
+	}
+	if err := binary.Read(bs.baseStream, byteOrder, data); err != nil {
+		bs.err = err
+	}
 }
 
 func (bs *BinaryStream) ReadBool() bool {
@@ -186,22 +200,29 @@ func (bs *BinaryStream) ReadFloat64() float64 {
 }
 
 func (bs *BinaryStream) ReadString() string {
-	length := bs.ReadUint16()
-	data := make([]byte, length)
-	bs.baseStream.Read(data)
-	return string(data)
+	return string(bs.ReadBytes(int(bs.ReadUint16())))
 }
 
+// ReadBytes checks length against the bytes available before allocating.
 func (bs *BinaryStream) ReadBytes(length int) []byte {
+	if bs.err != nil {
+		return nil
+	}
+	if available := bs.BytesAvailable(); length < 0 || int64(length) > available {
+		bs.err = fmt.Errorf("length %d exceeds the %d available bytes", length, available)
+		return nil
+	}
 	data := make([]byte, length)
 	bs.ReadBytesIntoBuffer(data)
 	return data
 }
 
 func (bs *BinaryStream) ReadBytesIntoBuffer(buffer []byte) {
-	_, err := io.ReadFull(bs.baseStream, buffer)
-	if err != nil {
-		panic(err)
+	if bs.err != nil {
+		return
+	}
+	if _, err := io.ReadFull(bs.baseStream, buffer); err != nil {
+		bs.err = err
 	}
 }
 
@@ -2,28 +2,451 @@ package unpack
 
 import (
 	"bytes"
-	"unicode"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dofusdude/doduda/swf"
 )
 
-func LanguageExtractStrings(data []byte) ([]string, error) {
-	var strings []string
-	var buffer bytes.Buffer
+// langObject is an ActionScript object that keeps the order its members were
+// assigned in.
+type langObject struct {
+	keys   []string
+	values map[string]any
+}
+
+func newLangObject() *langObject {
+	return &langObject{values: make(map[string]any)}
+}
+
+func (o *langObject) set(key string, value any) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+// langMachine runs the data only subset of AVM1 that the language files use:
+// pushes, constant pools, variables, members and object and array literals.
+// Values are strings, float64, bool, nil and *langObject.
+type langMachine struct {
+	globals   *langObject
+	stack     []any
+	constants []string
+	registers [4]any
+	// budget is the number of bytes string concatenations may still
+	// allocate, since a few actions can double a string over and over.
+	budget int
+}
+
+// ReadLang decodes a legacy language file, a lang_*.swf movie or a .bin file
+// with the bare AVM1 bytecode of one. Their actions assign the texts to
+// variables and object members. Every string is returned in the same shape
+// as D2I.Read: "texts" maps generated IDs to the texts, "nameText" maps the
+// dotted path of the variable, like "I.u.39.n", to the ID, and "idText" maps
+// the IDs to their position in the sorted texts.
+func ReadLang(data []byte) (map[string]map[string]interface{}, error) {
+	var actions [][]byte
+	if len(data) >= 3 && (string(data[:3]) == "FWS" || string(data[:3]) == "CWS" || string(data[:3]) == "ZWS") {
+		movie, err := swf.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid lang file: %w", err)
+		}
+		actions = movie.Actions
+	} else {
+		actions = [][]byte{data}
+	}
+
+	m := &langMachine{globals: newLangObject(), budget: 4*len(data) + 1<<20}
+	for _, code := range actions {
+		if err := m.run(code); err != nil {
+			return nil, fmt.Errorf("invalid lang file: %w", err)
+		}
+	}
+
+	obj := map[string]map[string]interface{}{
+		"texts":    make(map[string]interface{}),
+		"nameText": make(map[string]interface{}),
+		"idText":   make(map[string]interface{}),
+	}
+	var texts []string
+	m.flatten("", m.globals, make(map[*langObject]bool), func(path string, text string) {
+		texts = append(texts, text)
+		id := len(texts)
+		obj["texts"][strconv.Itoa(id)] = text
+		obj["nameText"][path] = id
+	})
+
+	order := make([]int, len(texts))
+	for i := range order {
+		order[i] = i + 1
+	}
+	sort.SliceStable(order, func(i, j int) bool { return texts[order[i]-1] < texts[order[j]-1] })
+	for position, id := range order {
+		obj["idText"][strconv.Itoa(id)] = position + 1
+	}
+	return obj, nil
+}
+
+// flatten calls fn with the path and value of every string below o in
+// assignment order. Objects reachable twice are only visited the first time.
+func (m *langMachine) flatten(prefix string, o *langObject, seen map[*langObject]bool, fn func(path string, text string)) {
+	seen[o] = true
+	for _, key := range o.keys {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		switch value := o.values[key].(type) {
+		case string:
+			fn(path, value)
+		case *langObject:
+			if !seen[value] {
+				m.flatten(path, value, seen, fn)
+			}
+		}
+	}
+}
+
+func (m *langMachine) run(code []byte) error {
+	pos := 0
+	for pos < len(code) {
+		start := pos
+		action := code[pos]
+		pos++
+		if action == 0x00 {
+			return nil
+		}
+
+		var body []byte
+		if action >= 0x80 {
+			if pos+2 > len(code) {
+				return fmt.Errorf("action 0x%02x at %d is truncated", action, start)
+			}
+			length := int(binary.LittleEndian.Uint16(code[pos:]))
+			pos += 2
+			if pos+length > len(code) {
+				return fmt.Errorf("action 0x%02x at %d is truncated", action, start)
+			}
+			body = code[pos : pos+length]
+			pos += length
+		}
+
+		if err := m.step(action, body); err != nil {
+			return fmt.Errorf("action 0x%02x at %d: %w", action, start, err)
+		}
+	}
+	return nil
+}
 
-	for _, b := range data {
-		if unicode.IsPrint(rune(b)) {
-			buffer.WriteByte(b)
+func (m *langMachine) step(action byte, body []byte) error {
+	switch action {
+	case 0x06, 0x07: // Play, Stop
+	case 0x88: // ConstantPool
+		if len(body) < 2 {
+			return fmt.Errorf("truncated constant pool")
+		}
+		count := int(binary.LittleEndian.Uint16(body))
+		m.constants = m.constants[:0]
+		rest := body[2:]
+		for range count {
+			value, n, err := langString(rest)
+			if err != nil {
+				return err
+			}
+			m.constants = append(m.constants, value)
+			rest = rest[n:]
+		}
+	case 0x96: // Push
+		return m.push(body)
+	case 0x17: // Pop
+		_, err := m.pop()
+		return err
+	case 0x4C: // PushDuplicate
+		value, err := m.pop()
+		if err != nil {
+			return err
+		}
+		m.stack = append(m.stack, value, value)
+	case 0x4D: // StackSwap
+		b, err := m.pop()
+		if err != nil {
+			return err
+		}
+		a, err := m.pop()
+		if err != nil {
+			return err
+		}
+		m.stack = append(m.stack, b, a)
+	case 0x87: // StoreRegister
+		if len(body) < 1 || int(body[0]) >= len(m.registers) || len(m.stack) == 0 {
+			return fmt.Errorf("invalid register store")
+		}
+		m.registers[body[0]] = m.stack[len(m.stack)-1]
+	case 0x1C: // GetVariable
+		name, err := m.pop()
+		if err != nil {
+			return err
+		}
+		owner, key := m.variable(langToString(name))
+		m.stack = append(m.stack, owner.values[key])
+	case 0x1D, 0x3C: // SetVariable, DefineLocal
+		value, err := m.pop()
+		if err != nil {
+			return err
+		}
+		name, err := m.pop()
+		if err != nil {
+			return err
+		}
+		owner, key := m.variable(langToString(name))
+		owner.set(key, value)
+	case 0x41: // DefineLocal2
+		name, err := m.pop()
+		if err != nil {
+			return err
+		}
+		owner, key := m.variable(langToString(name))
+		if _, ok := owner.values[key]; !ok {
+			owner.set(key, nil)
+		}
+	case 0x4E: // GetMember
+		name, err := m.pop()
+		if err != nil {
+			return err
+		}
+		target, err := m.pop()
+		if err != nil {
+			return err
+		}
+		var value any
+		if o, ok := target.(*langObject); ok {
+			value = o.values[langToString(name)]
+		}
+		m.stack = append(m.stack, value)
+	case 0x4F: // SetMember
+		value, err := m.pop()
+		if err != nil {
+			return err
+		}
+		name, err := m.pop()
+		if err != nil {
+			return err
+		}
+		target, err := m.pop()
+		if err != nil {
+			return err
+		}
+		if o, ok := target.(*langObject); ok {
+			o.set(langToString(name), value)
+		}
+	case 0x43: // InitObject
+		count, err := m.popCount(2)
+		if err != nil {
+			return err
+		}
+		// The first member is on top, its value above its name.
+		pairs := m.stack[len(m.stack)-2*count:]
+		m.stack = m.stack[:len(m.stack)-2*count]
+		o := newLangObject()
+		for i := len(pairs) - 2; i >= 0; i -= 2 {
+			o.set(langToString(pairs[i]), pairs[i+1])
+		}
+		m.stack = append(m.stack, o)
+	case 0x42: // InitArray
+		count, err := m.popCount(1)
+		if err != nil {
+			return err
+		}
+		m.stack = append(m.stack, m.popArray(count))
+	case 0x40: // NewObject
+		name, err := m.pop()
+		if err != nil {
+			return err
+		}
+		count, err := m.popCount(1)
+		if err != nil {
+			return err
+		}
+		o := m.popArray(count)
+		if langToString(name) != "Array" || count == 1 {
+			// new Array(n) only sets the length and other classes keep their
+			// arguments to themselves.
+			o = newLangObject()
+		}
+		m.stack = append(m.stack, o)
+	case 0x0A, 0x21, 0x47: // Add, StringAdd, Add2
+		b, err := m.pop()
+		if err != nil {
+			return err
+		}
+		a, err := m.pop()
+		if err != nil {
+			return err
+		}
+		_, aString := a.(string)
+		_, bString := b.(string)
+		if action == 0x21 || aString || bString {
+			aText, bText := langToString(a), langToString(b)
+			m.budget -= len(aText) + len(bText)
+			if m.budget < 0 {
+				return fmt.Errorf("strings exceed the size limit")
+			}
+			m.stack = append(m.stack, aText+bText)
 		} else {
-			if buffer.Len() > 1 { // If the buffer contains a string longer than 1 character
-				strings = append(strings, buffer.String())
+			m.stack = append(m.stack, langToNumber(a)+langToNumber(b))
+		}
+	default:
+		return fmt.Errorf("unsupported action")
+	}
+	return nil
+}
+
+func (m *langMachine) push(body []byte) error {
+	for len(body) > 0 {
+		kind := body[0]
+		body = body[1:]
+		size := map[byte]int{1: 4, 4: 1, 5: 1, 6: 8, 7: 4, 8: 1, 9: 2}[kind]
+		if len(body) < size {
+			return fmt.Errorf("truncated push")
+		}
+		switch kind {
+		case 0:
+			value, n, err := langString(body)
+			if err != nil {
+				return err
+			}
+			m.stack = append(m.stack, value)
+			size = n
+		case 1:
+			m.stack = append(m.stack, float64(math.Float32frombits(binary.LittleEndian.Uint32(body))))
+		case 2, 3:
+			m.stack = append(m.stack, nil)
+		case 4:
+			if int(body[0]) >= len(m.registers) {
+				return fmt.Errorf("register %d out of range", body[0])
+			}
+			m.stack = append(m.stack, m.registers[body[0]])
+		case 5:
+			m.stack = append(m.stack, body[0] != 0)
+		case 6:
+			// The high half of the double comes first.
+			bits := uint64(binary.LittleEndian.Uint32(body))<<32 | uint64(binary.LittleEndian.Uint32(body[4:]))
+			m.stack = append(m.stack, math.Float64frombits(bits))
+		case 7:
+			m.stack = append(m.stack, float64(int32(binary.LittleEndian.Uint32(body))))
+		case 8, 9:
+			index := int(body[0])
+			if kind == 9 {
+				index = int(binary.LittleEndian.Uint16(body))
 			}
-			buffer.Reset()
+			if index >= len(m.constants) {
+				return fmt.Errorf("constant %d out of range", index)
+			}
+			m.stack = append(m.stack, m.constants[index])
+		default:
+			return fmt.Errorf("unknown push type %d", kind)
+		}
+		body = body[size:]
+	}
+	return nil
+}
+
+func (m *langMachine) pop() (any, error) {
+	if len(m.stack) == 0 {
+		return nil, fmt.Errorf("stack underflow")
+	}
+	value := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return value, nil
+}
+
+// popCount pops an element count and checks that the stack holds that many
+// elements of size values each.
+func (m *langMachine) popCount(size int) (int, error) {
+	value, err := m.pop()
+	if err != nil {
+		return 0, err
+	}
+	count := langToNumber(value)
+	if count < 0 || count != math.Trunc(count) || count*float64(size) > float64(len(m.stack)) {
+		return 0, fmt.Errorf("invalid element count %v", value)
+	}
+	return int(count), nil
+}
+
+// popArray pops count elements, the first one on top, into an array object.
+func (m *langMachine) popArray(count int) *langObject {
+	o := newLangObject()
+	for i := range count {
+		o.set(strconv.Itoa(i), m.stack[len(m.stack)-1-i])
+	}
+	m.stack = m.stack[:len(m.stack)-count]
+	return o
+}
+
+// variable returns the object and member a variable name refers to. Dotted
+// names and the _global and _root prefixes resolve from the globals.
+func (m *langMachine) variable(name string) (*langObject, string) {
+	for _, prefix := range []string{"_global.", "_root.", "this."} {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	owner := m.globals
+	parts := strings.Split(name, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := owner.values[part].(*langObject)
+		if !ok {
+			next = newLangObject()
+			owner.set(part, next)
 		}
+		owner = next
+	}
+	return owner, parts[len(parts)-1]
+}
+
+func langString(data []byte) (string, int, error) {
+	end := bytes.IndexByte(data, 0)
+	if end < 0 {
+		return "", 0, fmt.Errorf("unterminated string")
 	}
+	return string(data[:end]), end + 1, nil
+}
 
-	// Add the last string if present
-	if buffer.Len() > 1 {
-		strings = append(strings, buffer.String())
+func langToString(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	case nil:
+		return "undefined"
+	default:
+		return "[object Object]"
 	}
+}
 
-	return strings, nil
+func langToNumber(value any) float64 {
+	switch value := value.(type) {
+	case float64:
+		return value
+	case bool:
+		if value {
+			return 1
+		}
+		return 0
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return math.NaN()
+		}
+		return number
+	default:
+		return math.NaN()
+	}
 }
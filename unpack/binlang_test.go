@@ -0,0 +1,107 @@
+package unpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// langActions builds AVM1 bytecode.
+type langActions struct {
+	bytes.Buffer
+}
+
+func (a *langActions) action(code byte, body ...byte) {
+	a.WriteByte(code)
+	if code >= 0x80 {
+		binary.Write(a, binary.LittleEndian, uint16(len(body)))
+		a.Write(body)
+	}
+}
+
+// push pushes strings, int32 values and constant pool indexes given as
+// uint8.
+func (a *langActions) push(values ...any) {
+	var body []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			body = append(append(append(body, 0), v...), 0)
+		case int:
+			body = binary.LittleEndian.AppendUint32(append(body, 7), uint32(v))
+		case uint8:
+			body = append(body, 8, v)
+		}
+	}
+	a.action(0x96, body...)
+}
+
+func testLangActions() []byte {
+	var a langActions
+	a.action(0x88, append([]byte{2, 0}, "I\x00u\x00"...)...)
+	// I = new Object(); I.u = {};
+	a.push(uint8(0), 0, "Object")
+	a.action(0x40)
+	a.action(0x1D)
+	a.push(uint8(0))
+	a.action(0x1C)
+	a.push(uint8(1), 0)
+	a.action(0x43)
+	a.action(0x4F)
+	// I.u[39] = {n: "Amulette", d: "Épée"};
+	a.push(uint8(0))
+	a.action(0x1C)
+	a.push(uint8(1))
+	a.action(0x4E)
+	a.push(39, "d", "Épée", "n", "Amulette", 2)
+	a.action(0x43)
+	a.action(0x4F)
+	// C = ["b", "a"];
+	a.push("C", "a", "b", 2)
+	a.action(0x42)
+	a.action(0x1D)
+	a.action(0x00)
+	return a.Bytes()
+}
+
+func TestReadLang(t *testing.T) {
+	actions := testLangActions()
+
+	var movie d2oFixture
+	movie.WriteString("FWS\x06")
+	binary.Write(&movie, binary.LittleEndian, uint32(0))
+	movie.put(uint8(0))
+	binary.Write(&movie, binary.LittleEndian, []uint16{25 << 8, 1, 12<<6 | 0x3f})
+	binary.Write(&movie, binary.LittleEndian, uint32(len(actions)))
+	movie.Write(actions)
+	movie.Write([]byte{0x40, 0, 0, 0})
+	swf := movie.Bytes()
+	binary.LittleEndian.PutUint32(swf[4:], uint32(len(swf)))
+
+	for name, file := range map[string][]byte{"swf": swf, "bin": actions} {
+		data, err := ReadLang(file)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(data["texts"]) != 4 || data["texts"]["2"] != "Épée" || data["texts"]["4"] != "a" {
+			t.Fatalf("%s: texts = %v", name, data["texts"])
+		}
+		if data["nameText"]["I.u.39.n"] != 1 || data["nameText"]["C.0"] != 3 {
+			t.Fatalf("%s: nameText = %v", name, data["nameText"])
+		}
+		if data["idText"]["1"] != 1 || data["idText"]["4"] != 2 || data["idText"]["2"] != 4 {
+			t.Fatalf("%s: idText = %v", name, data["idText"])
+		}
+	}
+
+	for n := range len(actions) - 1 {
+		ReadLang(actions[:n])
+	}
+}
+
+func FuzzReadLang(f *testing.F) {
+	f.Add(testLangActions())
+	f.Fuzz(func(t *testing.T, file []byte) {
+		ReadLang(file)
+	})
+}
@@ -0,0 +1,125 @@
+package unpack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// checkedStream reads big endian values. The first error sticks: later reads
+// return zero values and the error stays in err.
+type checkedStream struct {
+	r    io.ReadSeeker
+	pos  int64
+	size int64
+	err  error
+	buf  [8]byte
+}
+
+func (s *checkedStream) errOr(err error) error {
+	if s.err != nil {
+		return s.err
+	}
+	return err
+}
+
+func (s *checkedStream) remaining() int64 {
+	return s.size - s.pos
+}
+
+func (s *checkedStream) seek(pos int64) {
+	if s.err != nil {
+		return
+	}
+	if pos < 0 || pos > s.size {
+		s.err = fmt.Errorf("offset %d out of range", pos)
+		return
+	}
+	if _, err := s.r.Seek(pos, io.SeekStart); err != nil {
+		s.err = err
+		return
+	}
+	s.pos = pos
+}
+
+// reset clears the error and moves to pos.
+func (s *checkedStream) reset(pos int64) {
+	s.err = nil
+	s.seek(pos)
+}
+
+func (s *checkedStream) read(p []byte) bool {
+	if s.err != nil {
+		return false
+	}
+	if int64(len(p)) > s.remaining() {
+		s.err = io.ErrUnexpectedEOF
+		return false
+	}
+	if _, err := io.ReadFull(s.r, p); err != nil {
+		s.err = err
+		return false
+	}
+	s.pos += int64(len(p))
+	return true
+}
+
+// bytes checks n against the remaining input before allocating.
+func (s *checkedStream) bytes(n int) []byte {
+	if s.err != nil {
+		return nil
+	}
+	if n < 0 || int64(n) > s.remaining() {
+		s.err = fmt.Errorf("length %d exceeds the %d remaining bytes", n, s.remaining())
+		return nil
+	}
+	p := make([]byte, n)
+	if !s.read(p) {
+		return nil
+	}
+	return p
+}
+
+func (s *checkedStream) uint8() uint8 {
+	if !s.read(s.buf[:1]) {
+		return 0
+	}
+	return s.buf[0]
+}
+
+func (s *checkedStream) uint16() uint16 {
+	if !s.read(s.buf[:2]) {
+		return 0
+	}
+	return binary.BigEndian.Uint16(s.buf[:2])
+}
+
+func (s *checkedStream) uint32() uint32 {
+	if !s.read(s.buf[:4]) {
+		return 0
+	}
+	return binary.BigEndian.Uint32(s.buf[:4])
+}
+
+func (s *checkedStream) int32() int32 {
+	return int32(s.uint32())
+}
+
+func (s *checkedStream) uint64() uint64 {
+	if !s.read(s.buf[:8]) {
+		return 0
+	}
+	return binary.BigEndian.Uint64(s.buf[:8])
+}
+
+func (s *checkedStream) utf() string {
+	return string(s.bytes(int(s.uint16())))
+}
+
+func (s *checkedStream) int8() int8 {
+	return int8(s.uint8())
+}
+
+func (s *checkedStream) int16() int16 {
+	return int16(s.uint16())
+}
@@ -6,71 +6,94 @@ import (
 )
 
 type D2I struct {
-	Stream io.ReadWriteSeeker
+	Stream io.ReadSeeker
 	Obj    map[string]map[string]interface{}
 }
 
-func NewD2I(stream io.ReadWriteSeeker) *D2I {
+func NewD2I(stream io.ReadSeeker) *D2I {
 	return &D2I{
 		Stream: stream,
 		Obj:    make(map[string]map[string]interface{}),
 	}
 }
 
-func (d *D2I) Read() map[string]map[string]interface{} {
-	raw := NewBinaryStream(d.Stream, true)
+// Read decodes the texts, the named texts and the sort order of the file.
+// Every section length and text pointer is checked against the file size.
+func (d *D2I) Read() (map[string]map[string]interface{}, error) {
+	size, err := d.Stream.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	s := &checkedStream{r: d.Stream, size: size}
+	s.seek(0)
 
 	indexs := make(map[int]int)
-	unDiacriticalIndex := make(map[int]int)
 
 	d.Obj["texts"] = make(map[string]interface{})
 	d.Obj["nameText"] = make(map[string]interface{})
 	d.Obj["idText"] = make(map[string]interface{})
 
-	indexesPointer := raw.ReadInt32()
-	d.Stream.Seek(int64(indexesPointer), io.SeekStart)
+	s.seek(int64(s.uint32()))
 
-	i := 0
-	indexesLength := raw.ReadInt32()
-	for i < int(indexesLength) {
-		key := int(raw.ReadInt32())
-		diacriticalText := raw.ReadBool()
-		pointer := int(raw.ReadInt32())
-		indexs[pointer] = key
+	// section reads the length of the next section and calls entry until the
+	// section is consumed.
+	section := func(name string, entry func()) error {
+		length := int64(s.int32())
+		if s.err == nil && (length < 0 || length > s.remaining()) {
+			return fmt.Errorf("%s length %d out of range", name, length)
+		}
+		end := s.pos + length
+		for s.err == nil && s.pos < end {
+			entry()
+		}
+		if s.err == nil && s.pos != end {
+			return fmt.Errorf("%s overruns its length", name)
+		}
+		return s.err
+	}
 
+	err = section("index", func() {
+		key := int(s.int32())
+		diacriticalText := s.uint8() == 1
+		pointer := int(s.int32())
 		if diacriticalText {
-			i += 4
-			unDiacriticalIndex[key] = int(raw.ReadInt32())
-		} else {
-			unDiacriticalIndex[key] = pointer
+			s.int32() // undiacritical text pointer
 		}
-		i += 9
+		indexs[pointer] = key
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid D2I file: %w", err)
 	}
 
-	indexesLength = raw.ReadInt32()
-	for indexesLength > 0 {
-		position, _ := d.Stream.Seek(0, io.SeekCurrent)
-		textKey := raw.ReadString()
-		pointer := int(raw.ReadInt32())
+	err = section("named index", func() {
+		textKey := s.utf()
+		pointer := int(s.int32())
 		d.Obj["nameText"][textKey] = indexs[pointer]
-		position_updated, _ := d.Stream.Seek(0, io.SeekCurrent)
-		indexesLength = (indexesLength - (int32(position_updated) - int32(position)))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid D2I file: %w", err)
 	}
 
-	i = 0
-	indexesLength = raw.ReadInt32()
-	for indexesLength > 0 {
-		position, _ := d.Stream.Seek(0, io.SeekCurrent)
-		i += 1
-		d.Obj["idText"][fmt.Sprint(raw.ReadInt32())] = i
-		position_updated, _ := d.Stream.Seek(0, io.SeekCurrent)
-		indexesLength = (indexesLength - (int32(position_updated) - int32(position)))
+	i := 0
+	err = section("sort order", func() {
+		id := s.int32()
+		if s.err == nil {
+			i += 1
+			d.Obj["idText"][fmt.Sprint(id)] = i
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid D2I file: %w", err)
 	}
 
 	for pointer, key := range indexs {
-		d.Stream.Seek(int64(pointer), io.SeekStart)
-		d.Obj["texts"][fmt.Sprint(key)] = raw.ReadString()
+		s.seek(int64(pointer))
+		text := s.utf()
+		if s.err != nil {
+			return nil, fmt.Errorf("invalid D2I file: text %d: %w", key, s.err)
+		}
+		d.Obj["texts"][fmt.Sprint(key)] = text
 	}
 
-	return d.Obj
+	return d.Obj, nil
 }
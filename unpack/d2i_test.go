@@ -0,0 +1,50 @@
+package unpack
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteD2IRoundTrip(t *testing.T) {
+	var out bytes.Buffer
+	err := WriteD2I(&out, D2ITexts{
+		Texts:     map[int]string{1: "Oui", 2: "Non", 30: "Épée"},
+		NameTexts: map[string]int{"ui.common.yes": 1},
+		SortedIDs: []int{30, 2, 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := NewD2I(bytes.NewReader(out.Bytes())).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data["texts"]) != 3 || data["texts"]["30"] != "Épée" || data["texts"]["2"] != "Non" {
+		t.Fatalf("texts = %v", data["texts"])
+	}
+	if data["nameText"]["ui.common.yes"] != 1 {
+		t.Fatalf("nameText = %v", data["nameText"])
+	}
+	if data["idText"]["30"] != 1 || data["idText"]["1"] != 3 {
+		t.Fatalf("idText = %v", data["idText"])
+	}
+
+	err = WriteD2I(&out, D2ITexts{NameTexts: map[string]int{"missing": 4}})
+	if err == nil {
+		t.Fatal("named text without text: no error")
+	}
+}
+
+func FuzzD2I(f *testing.F) {
+	var seed bytes.Buffer
+	WriteD2I(&seed, D2ITexts{
+		Texts:     map[int]string{1: "Oui", 2: "Non"},
+		NameTexts: map[string]int{"ui.common.yes": 1},
+		SortedIDs: []int{2, 1},
+	})
+	f.Add(seed.Bytes())
+	f.Fuzz(func(t *testing.T, file []byte) {
+		NewD2I(bytes.NewReader(file)).Read()
+	})
+}
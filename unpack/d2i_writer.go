@@ -0,0 +1,93 @@
+package unpack
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// D2ITexts is the content of a D2I file.
+type D2ITexts struct {
+	// Texts maps text IDs to texts.
+	Texts map[int]string
+	// NameTexts maps the named texts, for example "ui.common.yes", to text
+	// IDs.
+	NameTexts map[string]int
+	// SortedIDs lists the text IDs in the order of the sort index. If nil,
+	// the IDs of Texts are written in ascending order.
+	SortedIDs []int
+}
+
+// WriteD2I encodes texts as a D2I file. Texts are written without their
+// undiacritical variants.
+func WriteD2I(w io.Writer, texts D2ITexts) error {
+	mem := &memoryStream{}
+	bs := NewBinaryStream(mem, true)
+	bs.WriteInt32(0) // index offset, set below
+
+	keys := make([]int, 0, len(texts.Texts))
+	for key := range texts.Texts {
+		if key < math.MinInt32 || key > math.MaxInt32 {
+			return fmt.Errorf("text id %d is out of range", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	pointers := make(map[int]int64, len(keys))
+	for _, key := range keys {
+		pointers[key] = mem.pos
+		if err := writeUTF(bs, texts.Texts[key]); err != nil {
+			return fmt.Errorf("text %d: %w", key, err)
+		}
+	}
+
+	indexOffset := mem.pos
+	if indexOffset > math.MaxInt32 {
+		return fmt.Errorf("texts take %d bytes, more than a D2I file can address", indexOffset)
+	}
+	bs.WriteInt32(int32(len(keys) * 9))
+	for _, key := range keys {
+		bs.WriteInt32(int32(key))
+		bs.WriteBool(false)
+		bs.WriteInt32(int32(pointers[key]))
+	}
+
+	names := make([]string, 0, len(texts.NameTexts))
+	namesLength := 0
+	for name := range texts.NameTexts {
+		names = append(names, name)
+		namesLength += 2 + len(name) + 4
+	}
+	sort.Strings(names)
+	bs.WriteInt32(int32(namesLength))
+	for _, name := range names {
+		pointer, ok := pointers[texts.NameTexts[name]]
+		if !ok {
+			return fmt.Errorf("named text %s refers to missing text %d", name, texts.NameTexts[name])
+		}
+		if err := writeUTF(bs, name); err != nil {
+			return err
+		}
+		bs.WriteInt32(int32(pointer))
+	}
+
+	sortedIDs := texts.SortedIDs
+	if sortedIDs == nil {
+		sortedIDs = keys
+	}
+	bs.WriteInt32(int32(len(sortedIDs) * 4))
+	for _, id := range sortedIDs {
+		if id < math.MinInt32 || id > math.MaxInt32 {
+			return fmt.Errorf("sorted text id %d is out of range", id)
+		}
+		bs.WriteInt32(int32(id))
+	}
+
+	bs.SetPosition(0)
+	bs.WriteInt32(int32(indexOffset))
+
+	_, err := w.Write(mem.data)
+	return err
+}
@@ -1,287 +1,378 @@
 package unpack
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math"
+	"sort"
 )
 
-type GameDataProcess struct {
-	stream           *BinaryStream
-	sortIndex        map[string]int64
-	queryableField   []string
-	searchFieldIndex map[string]int64
-	searchFieldType  map[string]int32
-	searchFieldCount map[string]int32
-}
-
-func (process *GameDataProcess) parseStream(D2OFileBinary *BinaryStream) {
-	length := int64(D2OFileBinary.ReadInt32())
-	off := D2OFileBinary.Position() + length + 4
-	for length > 0 {
-		available := D2OFileBinary.BytesAvailable()
-		string := D2OFileBinary.ReadString()
-		process.queryableField = append(process.queryableField, string)
-		process.searchFieldIndex[string] = int64(D2OFileBinary.ReadInt32()) + off
-		process.searchFieldType[string] = D2OFileBinary.ReadInt32()
-		process.searchFieldCount[string] = D2OFileBinary.ReadInt32()
-		length = length - (available - D2OFileBinary.BytesAvailable())
-	}
-}
+// GameDataKind is the kind of a D2O field type.
+type GameDataKind string
+
+const (
+	GameDataInt     GameDataKind = "int"
+	GameDataBool    GameDataKind = "bool"
+	GameDataString  GameDataKind = "string"
+	GameDataNumber  GameDataKind = "number"
+	GameDataI18n    GameDataKind = "i18n"
+	GameDataUint    GameDataKind = "uint"
+	GameDataVector  GameDataKind = "vector"
+	GameDataObject  GameDataKind = "object"
+	d2oNullObjectID              = -1431655766
+	d2oMaxDepth                  = 64
+)
 
-func NewGameDataProcess(D2OFileBinary *BinaryStream) *GameDataProcess {
-	process := new(GameDataProcess)
-	process.stream = D2OFileBinary
-	process.sortIndex = make(map[string]int64)
-	process.searchFieldIndex = make(map[string]int64)
-	process.searchFieldType = make(map[string]int32)
-	process.searchFieldCount = make(map[string]int32)
-	process.parseStream(D2OFileBinary)
-	return process
+// ErrD2ONotFound is returned by Get for IDs that are not in the index table.
+var ErrD2ONotFound = errors.New("d2o object not found")
+
+// GameDataType is the type of a D2O field.
+type GameDataType struct {
+	Kind GameDataKind `json:"kind"`
+	// ClassID is the declared class of object fields. The stored objects name
+	// their own class, so it is only a hint.
+	ClassID int32 `json:"classId,omitempty"`
+	// Name is the type name of vectors as stored in the file, for example
+	// "Vector.<int>".
+	Name string `json:"name,omitempty"`
+	// Elem is the element type of vectors.
+	Elem *GameDataType `json:"elem,omitempty"`
 }
 
+// GameDataField is a field of a D2O class.
 type GameDataField struct {
-	name             string
-	innerReadMethods []func(*BinaryStream, int) interface{}
-	innerTypeNames   []string
-	d2oReader        *D2OReader
-	readData         func(*BinaryStream, int) interface{}
+	Name string       `json:"name"`
+	Type GameDataType `json:"type"`
 }
 
-func NewGameDataField(name string, d2oReader *D2OReader) *GameDataField {
-	field := new(GameDataField)
-	field.name = name
-	field.d2oReader = d2oReader
-	field.innerReadMethods = make([]func(*BinaryStream, int) interface{}, 0)
-	field.innerTypeNames = make([]string, 0)
-	return field
+// GameDataClassDefinition is a class definition of a D2O file.
+type GameDataClassDefinition struct {
+	ID      int32           `json:"id"`
+	Name    string          `json:"name"`
+	Package string          `json:"package"`
+	Fields  []GameDataField `json:"fields"`
 }
 
-func (field *GameDataField) readType(D2OFileBinary *BinaryStream) {
-	readID := D2OFileBinary.ReadInt32()
-	field.readData = field.getReadMethod(readID, D2OFileBinary)
+// FullName returns the package qualified class name.
+func (classDef *GameDataClassDefinition) FullName() string {
+	return classDef.Package + "." + classDef.Name
 }
 
-// readInteger
-func (field *GameDataField) readInteger(D2OFileBinary *BinaryStream, vecIndex int) interface{} {
-	return D2OFileBinary.ReadInt32()
+type d2oIndexEntry struct {
+	id     int32
+	offset int64
 }
 
-// readBoolean
-func (field *GameDataField) readBoolean(D2OFileBinary *BinaryStream, vecIndex int) interface{} {
-	return D2OFileBinary.ReadBool()
+// D2OReader reads D2O files. Objects are decoded on demand, so Get only
+// decodes the requested object. A D2OReader is not safe for concurrent use.
+type D2OReader struct {
+	stream           *checkedStream
+	streamStartIndex int64
+	classes          map[int32]*GameDataClassDefinition
+	indexOffsets     map[int32]int64
+	// index holds the index table sorted by object offset.
+	index []d2oIndexEntry
 }
 
-// readString
-func (field *GameDataField) readString(D2OFileBinary *BinaryStream, vecIndex int) interface{} {
-	str := D2OFileBinary.ReadString()
-	if str == "null" {
-		str = ""
+// NewD2OReader reads the header, index table and class definitions of a D2O
+// file.
+func NewD2OReader(stream io.ReadSeeker) (*D2OReader, error) {
+	size, err := stream.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
 	}
-	return str
-}
-
-// readNumber
-func (field *GameDataField) readNumber(D2OFileBinary *BinaryStream, vecIndex int) interface{} {
-	return D2OFileBinary.ReadFloat64()
-}
+	s := &checkedStream{r: stream, size: size}
+	s.seek(0)
 
-// readI18n
-func (field *GameDataField) readI18n(D2OFileBinary *BinaryStream, vecIndex int) interface{} {
-	return D2OFileBinary.ReadInt32()
-}
-
-// readUnsignedInteger
-func (field *GameDataField) readUnsignedInteger(D2OFileBinary *BinaryStream, vecIndex int) interface{} {
-	return D2OFileBinary.ReadUint32()
-}
-
-// readVector
-func (field *GameDataField) readVector(D2OFileBinary *BinaryStream, vecIndex int) interface{} {
-	vectorSize := int(D2OFileBinary.ReadInt32())
-	vector := make([]interface{}, vectorSize)
-	for i := 0; i < vectorSize; i++ {
-		vector[i] = field.innerReadMethods[vecIndex](D2OFileBinary, vecIndex+1)
+	reader := &D2OReader{
+		stream:           s,
+		classes:          make(map[int32]*GameDataClassDefinition),
+		indexOffsets:     make(map[int32]int64),
+		streamStartIndex: 7,
 	}
-	return vector
-}
 
-// readObject
-func (field *GameDataField) readObject(D2OFileBinary *BinaryStream, vecIndex int) interface{} {
-	objectID := D2OFileBinary.ReadInt32()
-	if objectID == -1431655766 {
-		return nil
+	stringHeader := string(s.bytes(3))
+	baseOffset := int64(0)
+	if stringHeader != "D2O" {
+		s.seek(0)
+		if s.utf() != "AKSF" {
+			return nil, fmt.Errorf("malformed game data file: %w", s.errOr(fmt.Errorf("unknown header")))
+		}
+		s.uint16()
+		baseOffset = int64(s.int32())
+		s.seek(s.pos + baseOffset)
+		reader.streamStartIndex = s.pos + 7
+		if string(s.bytes(3)) != "D2O" {
+			return nil, fmt.Errorf("malformed game data file: %w", s.errOr(fmt.Errorf("missing D2O header")))
+		}
 	}
 
-	obj := field.d2oReader.GetClassDefinition(objectID)
-	return obj.read(D2OFileBinary)
-}
+	offset := int64(s.int32())
+	s.seek(baseOffset + offset)
+	indexLength := int64(s.int32())
+	if s.err == nil && (indexLength < 0 || indexLength%8 != 0 || indexLength > s.remaining()) {
+		return nil, fmt.Errorf("malformed game data file: index table of %d bytes", indexLength)
+	}
+	for i := int64(0); i < indexLength && s.err == nil; i += 8 {
+		id := s.int32()
+		objectOffset := baseOffset + int64(s.int32())
+		reader.indexOffsets[id] = objectOffset
+		reader.index = append(reader.index, d2oIndexEntry{id: id, offset: objectOffset})
+	}
+	sort.SliceStable(reader.index, func(i, j int) bool { return reader.index[i].offset < reader.index[j].offset })
 
-func (field *GameDataField) getReadMethod(readID int32, D2OFileBinary *BinaryStream) func(*BinaryStream, int) interface{} {
-	if readID == -1 {
-		return field.readInteger
-	} else if readID == -2 {
-		return field.readBoolean
-	} else if readID == -3 {
-		return field.readString
-	} else if readID == -4 {
-		return field.readNumber
-	} else if readID == -5 {
-		return field.readI18n
-	} else if readID == -6 {
-		return field.readUnsignedInteger
-	} else if readID == -99 {
-		field.innerTypeNames = append(field.innerTypeNames, D2OFileBinary.ReadString())
-		field.innerReadMethods = append([]func(*BinaryStream, int) interface{}{field.getReadMethod(D2OFileBinary.ReadInt32(), D2OFileBinary)}, field.innerReadMethods...)
-		return field.readVector
-	} else {
-		if readID > 0 {
-			return field.readObject
+	classCount := s.int32()
+	if s.err == nil && (classCount < 0 || int64(classCount) > s.remaining()/4) {
+		return nil, fmt.Errorf("malformed game data file: %d classes", classCount)
+	}
+	for i := int32(0); i < classCount && s.err == nil; i++ {
+		classID := s.int32()
+		if err := reader.readClassDefinition(classID); err != nil {
+			return nil, fmt.Errorf("class %d: %w", classID, err)
 		}
-		panic("Unknown type " + string(readID) + ".")
 	}
-}
-
-type GameDataClassDefinition struct {
-	class     string
-	fields    []*GameDataField
-	d2oReader *D2OReader
-}
-
-func NewGameDataClassDefinition(class_pkg string, class_name string, d2oReader *D2OReader) *GameDataClassDefinition {
-	return &GameDataClassDefinition{
-		class:     class_pkg + "." + class_name,
-		fields:    make([]*GameDataField, 0),
-		d2oReader: d2oReader,
+	if s.err != nil {
+		return nil, fmt.Errorf("malformed game data file: %w", s.err)
 	}
+
+	return reader, nil
 }
 
-func (classDef *GameDataClassDefinition) read(D2OFileBinary *BinaryStream) interface{} {
-	obj := make(map[string]interface{})
-	for _, field := range classDef.fields {
-		obj[field.name] = field.readData(D2OFileBinary, 0)
+// Classes returns the class definitions sorted by ID.
+func (dr *D2OReader) Classes() []*GameDataClassDefinition {
+	classes := make([]*GameDataClassDefinition, 0, len(dr.classes))
+	for _, class := range dr.classes {
+		classes = append(classes, class)
 	}
-	return obj
+	sort.Slice(classes, func(i, j int) bool { return classes[i].ID < classes[j].ID })
+	return classes
 }
 
-func (classDef *GameDataClassDefinition) addField(name string, D2OFileBinary *BinaryStream) {
-	field := NewGameDataField(name, classDef.d2oReader)
-	field.readType(D2OFileBinary)
-	classDef.fields = append(classDef.fields, field)
+// GetClassDefinition returns the class definition for a given class ID or nil.
+func (dr *D2OReader) GetClassDefinition(classID int32) *GameDataClassDefinition {
+	return dr.classes[classID]
 }
 
-// D2OReader represents a reader for D2O files.
-type D2OReader struct {
-	stream            io.Reader
-	streamStartIndex  int64
-	classes           map[int32]*GameDataClassDefinition
-	counter           int
-	D2OFileBinary     *BinaryStream
-	gameDataProcessor *GameDataProcess
+// Len returns the number of objects in the index table.
+func (dr *D2OReader) Len() int {
+	return len(dr.index)
 }
 
-// NewD2OReader creates a new D2OReader instance with the given stream.
-func NewD2OReader(stream io.ReadWriteSeeker) (*D2OReader, error) {
-	reader := &D2OReader{
-		stream:           stream,
-		classes:          make(map[int32]*GameDataClassDefinition),
-		streamStartIndex: 7,
-		counter:          0,
+// Get decodes the object with the given ID. It returns ErrD2ONotFound if the
+// ID is not in the index table.
+func (dr *D2OReader) Get(id int32) (map[string]interface{}, error) {
+	offset, ok := dr.indexOffsets[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrD2ONotFound, id)
 	}
 
-	D2OFileBinary := BinaryStream{baseStream: stream, bigEndian: true}
-	reader.D2OFileBinary = &D2OFileBinary
+	dr.stream.reset(offset)
+	object, err := dr.readObject()
+	if err != nil {
+		return nil, fmt.Errorf("object %d: %w", id, err)
+	}
+	return object, nil
+}
 
-	stringHeader := string(D2OFileBinary.ReadBytes(3))
-	baseOffset := int32(0)
-	if stringHeader != "D2O" {
-		D2OFileBinary.Seek(0, io.SeekStart)
-		stringHeader := D2OFileBinary.ReadString()
-		if stringHeader != "AKSF" {
-			return nil, fmt.Errorf("malformed game data file")
+// Iterate decodes the objects in file order and calls fn for each of them.
+// It stops at the first error, including errors returned by fn.
+func (dr *D2OReader) Iterate(fn func(id int32, object map[string]interface{}) error) error {
+	dr.stream.reset(dr.streamStartIndex)
+	for _, entry := range dr.index {
+		object, err := dr.readObject()
+		if err != nil {
+			return fmt.Errorf("object %d: %w", entry.id, err)
 		}
-		D2OFileBinary.ReadUint16()
-		baseOffset = D2OFileBinary.ReadInt32()
-		D2OFileBinary.Seek(int64(baseOffset), io.SeekCurrent)
-		reader.streamStartIndex = D2OFileBinary.Position() + 7
-		stringHeaderBytes := string(D2OFileBinary.ReadBytes(3))
-		if stringHeaderBytes != "D2O" {
-			return nil, fmt.Errorf("malformed game data file")
+		position := dr.stream.pos
+		if err := fn(entry.id, object); err != nil {
+			return err
 		}
+		dr.stream.reset(position)
 	}
+	return nil
+}
 
-	offset := D2OFileBinary.ReadInt32()
-	D2OFileBinary.Seek(int64(baseOffset+offset), io.SeekStart)
-	indexNumber := D2OFileBinary.ReadInt32()
-	index := 0
-	indexOffsets := make(map[int32]int32)
-
-	for int32(index) < indexNumber {
-		indexID := D2OFileBinary.ReadInt32()
-		offset := D2OFileBinary.ReadInt32()
-		indexOffsets[indexID] = baseOffset + offset
-		reader.counter += 1
-		index = index + 8
+// GetObjects decodes all objects in file order.
+func (dr *D2OReader) GetObjects() ([]interface{}, error) {
+	if len(dr.index) == 0 {
+		return nil, nil
 	}
 
-	classNumber := D2OFileBinary.ReadInt32()
-	classIndex := 0
+	objects := make([]interface{}, 0, len(dr.index))
+	err := dr.Iterate(func(id int32, object map[string]interface{}) error {
+		objects = append(objects, object)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
 
-	for int32(classIndex) < classNumber {
-		classID := D2OFileBinary.ReadInt32()
-		reader.readClassDefinition(classID, &D2OFileBinary)
-		classIndex += 1
+func (dr *D2OReader) readClassDefinition(classID int32) error {
+	s := dr.stream
+	classDef := &GameDataClassDefinition{
+		ID:   classID,
+		Name: s.utf(),
 	}
+	classDef.Package = s.utf()
 
-	if D2OFileBinary.BytesAvailable() > 0 {
-		reader.gameDataProcessor = NewGameDataProcess(&D2OFileBinary)
+	fieldCount := s.int32()
+	if s.err == nil && (fieldCount < 0 || int64(fieldCount) > s.remaining()/6) {
+		return fmt.Errorf("%d fields", fieldCount)
+	}
+	for i := int32(0); i < fieldCount && s.err == nil; i++ {
+		field := GameDataField{Name: s.utf()}
+		fieldType, err := dr.readType(0)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		field.Type = fieldType
+		classDef.Fields = append(classDef.Fields, field)
+	}
+	if s.err != nil {
+		return s.err
 	}
 
-	return reader, nil
+	dr.classes[classID] = classDef
+	return nil
 }
 
-func (dr *D2OReader) GetObjects() []interface{} {
-	if dr.counter == 0 {
-		return nil
+func (dr *D2OReader) readType(depth int) (GameDataType, error) {
+	s := dr.stream
+	readID := s.int32()
+	if s.err != nil {
+		return GameDataType{}, s.err
 	}
 
-	D2OFileBinary := dr.D2OFileBinary
-	D2OFileBinary.SetPosition(dr.streamStartIndex)
-	objects := make([]interface{}, 0)
-	i := 0
-
-	for i < dr.counter {
-		classId := D2OFileBinary.ReadInt32()
-		class := dr.classes[classId]
-		if class == nil {
-			log.Fatal("class is nil")
+	switch readID {
+	case -1:
+		return GameDataType{Kind: GameDataInt}, nil
+	case -2:
+		return GameDataType{Kind: GameDataBool}, nil
+	case -3:
+		return GameDataType{Kind: GameDataString}, nil
+	case -4:
+		return GameDataType{Kind: GameDataNumber}, nil
+	case -5:
+		return GameDataType{Kind: GameDataI18n}, nil
+	case -6:
+		return GameDataType{Kind: GameDataUint}, nil
+	case -99:
+		if depth >= d2oMaxDepth {
+			return GameDataType{}, fmt.Errorf("vector types nested too deep")
 		}
-
-		object := class.read(D2OFileBinary)
-		objects = append(objects, object)
-		i += 1
+		name := s.utf()
+		elem, err := dr.readType(depth + 1)
+		if err != nil {
+			return GameDataType{}, err
+		}
+		return GameDataType{Kind: GameDataVector, Name: name, Elem: &elem}, nil
+	default:
+		if readID > 0 {
+			return GameDataType{Kind: GameDataObject, ClassID: readID}, nil
+		}
+		return GameDataType{}, fmt.Errorf("unknown type %d", readID)
 	}
+}
 
-	return objects
+// minSize returns the smallest number of bytes a value of the type takes in
+// a file, which bounds the length of vectors.
+func (t *GameDataType) minSize() int64 {
+	switch t.Kind {
+	case GameDataBool:
+		return 1
+	case GameDataString:
+		return 2
+	case GameDataNumber:
+		return 8
+	default:
+		return 4
+	}
 }
 
-// GetClassDefinition returns the class definition for a given object_id.
-func (dr *D2OReader) GetClassDefinition(objectID int32) *GameDataClassDefinition {
-	return dr.classes[objectID]
+// readObject reads a class ID and the fields of that class.
+func (dr *D2OReader) readObject() (map[string]interface{}, error) {
+	classID := dr.stream.int32()
+	if dr.stream.err != nil {
+		return nil, dr.stream.err
+	}
+	return dr.readClass(classID, 0)
 }
 
-func (dr *D2OReader) readClassDefinition(classID int32, D2OFileBinary *BinaryStream) {
-	className := D2OFileBinary.ReadString()
-	classPackage := D2OFileBinary.ReadString()
-	classDef := NewGameDataClassDefinition(classPackage, className, dr)
-	fieldNumber := D2OFileBinary.ReadInt32()
-	fieldIndex := 0
-
-	for int32(fieldIndex) < fieldNumber {
-		field := D2OFileBinary.ReadString()
-		classDef.addField(field, D2OFileBinary)
-		fieldIndex += 1
+func (dr *D2OReader) readClass(classID int32, depth int) (map[string]interface{}, error) {
+	classDef := dr.classes[classID]
+	if classDef == nil {
+		return nil, fmt.Errorf("unknown class %d", classID)
+	}
+	if depth >= d2oMaxDepth {
+		return nil, fmt.Errorf("objects nested too deep")
+	}
+
+	obj := make(map[string]interface{}, len(classDef.Fields))
+	for i := range classDef.Fields {
+		field := &classDef.Fields[i]
+		value, err := dr.readValue(&field.Type, depth)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", classDef.Name, field.Name, err)
+		}
+		obj[field.Name] = value
 	}
+	return obj, nil
+}
 
-	dr.classes[classID] = classDef
+func (dr *D2OReader) readValue(fieldType *GameDataType, depth int) (interface{}, error) {
+	s := dr.stream
+	var value interface{}
+	switch fieldType.Kind {
+	case GameDataInt, GameDataI18n:
+		value = s.int32()
+	case GameDataBool:
+		value = s.uint8() == 1
+	case GameDataString:
+		str := s.utf()
+		if str == "null" {
+			str = ""
+		}
+		value = str
+	case GameDataNumber:
+		value = math.Float64frombits(s.uint64())
+	case GameDataUint:
+		value = s.uint32()
+	case GameDataVector:
+		size := s.int32()
+		if s.err != nil {
+			return nil, s.err
+		}
+		if size < 0 || int64(size) > s.remaining()/fieldType.Elem.minSize() {
+			return nil, fmt.Errorf("vector of %d elements exceeds the %d remaining bytes", size, s.remaining())
+		}
+		vector := make([]interface{}, size)
+		for i := range vector {
+			element, err := dr.readValue(fieldType.Elem, depth)
+			if err != nil {
+				return nil, err
+			}
+			vector[i] = element
+		}
+		value = vector
+	case GameDataObject:
+		classID := s.int32()
+		if s.err != nil {
+			return nil, s.err
+		}
+		if classID == d2oNullObjectID {
+			return nil, nil
+		}
+		obj, err := dr.readClass(classID, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		value = obj
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return value, nil
 }
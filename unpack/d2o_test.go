@@ -0,0 +1,202 @@
+package unpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type d2oFixture struct {
+	bytes.Buffer
+}
+
+func (f *d2oFixture) int32(v int32) {
+	binary.Write(&f.Buffer, binary.BigEndian, v)
+}
+
+func (f *d2oFixture) utf(v string) {
+	binary.Write(&f.Buffer, binary.BigEndian, uint16(len(v)))
+	f.WriteString(v)
+}
+
+// testD2OFile returns a D2O file with an Item class (id, name, tags and an
+// optional Bonus object) and the objects 10 and 20.
+func testD2OFile() []byte {
+	var objects d2oFixture
+	objects.int32(1)
+	objects.int32(10)
+	objects.utf("Sword")
+	objects.int32(2)
+	objects.int32(4)
+	objects.int32(5)
+	objects.int32(2)
+	objects.int32(7)
+	second := objects.Len()
+	objects.int32(1)
+	objects.int32(20)
+	objects.utf("null")
+	objects.int32(0)
+	objects.int32(d2oNullObjectID)
+
+	var f d2oFixture
+	f.WriteString("D2O")
+	f.int32(int32(7 + objects.Len()))
+	f.Write(objects.Bytes())
+
+	f.int32(16)
+	f.int32(10)
+	f.int32(7)
+	f.int32(20)
+	f.int32(int32(7 + second))
+
+	f.int32(2)
+	f.int32(1)
+	f.utf("Item")
+	f.utf("com.ankamagames.dofus.datacenter.items")
+	f.int32(4)
+	f.utf("id")
+	f.int32(-1)
+	f.utf("name")
+	f.int32(-3)
+	f.utf("tags")
+	f.int32(-99)
+	f.utf("Vector.<int>")
+	f.int32(-1)
+	f.utf("bonus")
+	f.int32(2)
+	f.int32(2)
+	f.utf("Bonus")
+	f.utf("com.ankamagames.dofus.datacenter.items")
+	f.int32(1)
+	f.utf("value")
+	f.int32(-1)
+	return f.Bytes()
+}
+
+func TestD2OReader(t *testing.T) {
+	reader, err := NewD2OReader(bytes.NewReader(testD2OFile()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	classes := reader.Classes()
+	if len(classes) != 2 || classes[0].FullName() != "com.ankamagames.dofus.datacenter.items.Item" || len(classes[0].Fields) != 4 {
+		t.Fatalf("classes = %+v", classes)
+	}
+	if tags := classes[0].Fields[2].Type; tags.Kind != GameDataVector || tags.Elem.Kind != GameDataInt {
+		t.Fatalf("tags type = %+v", tags)
+	}
+
+	second, err := reader.Get(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second["name"] != "" || second["bonus"] != nil || len(second["tags"].([]interface{})) != 0 {
+		t.Fatalf("object 20 = %v", second)
+	}
+	first, err := reader.Get(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first["name"] != "Sword" || first["bonus"].(map[string]interface{})["value"] != int32(7) {
+		t.Fatalf("object 10 = %v", first)
+	}
+	if _, err := reader.Get(30); !errors.Is(err, ErrD2ONotFound) {
+		t.Fatalf("Get(30) error = %v", err)
+	}
+
+	var ids []int32
+	err = reader.Iterate(func(id int32, object map[string]interface{}) error {
+		ids = append(ids, id)
+		if object["id"] != id {
+			t.Fatalf("object %d has id %v", id, object["id"])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != 10 || ids[1] != 20 {
+		t.Fatalf("ids = %v", ids)
+	}
+}
+
+func TestD2OReaderMalformed(t *testing.T) {
+	file := testD2OFile()
+	for n := 0; n < len(file); n++ {
+		reader, err := NewD2OReader(bytes.NewReader(file[:n]))
+		if err == nil {
+			if _, err := reader.GetObjects(); err == nil {
+				t.Fatalf("truncated to %d bytes: no error", n)
+			}
+		}
+	}
+
+	corrupt := bytes.Clone(file)
+	corrupt[7+3] = 9 // class ID of object 10
+	reader, err := NewD2OReader(bytes.NewReader(corrupt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Get(10); err == nil {
+		t.Fatal("unknown class: no error")
+	}
+	if _, err := reader.Get(20); err != nil {
+		t.Fatalf("Get(20) after a failed Get: %v", err)
+	}
+}
+
+func TestWriteD2ORoundTrip(t *testing.T) {
+	file := testD2OFile()
+	reader, err := NewD2OReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := reader.GetObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := make([]map[string]interface{}, len(decoded))
+	for i, object := range decoded {
+		objects[i] = object.(map[string]interface{})
+	}
+
+	var out bytes.Buffer
+	if err := WriteD2O(&out, reader.Classes(), objects); err != nil {
+		t.Fatal(err)
+	}
+	written, err := NewD2OReader(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := written.GetObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "null" strings are read as "" and written back as "", so only the
+	// decoded objects are equal.
+	if !reflect.DeepEqual(again, decoded) || !reflect.DeepEqual(written.Classes(), reader.Classes()) {
+		t.Fatalf("round trip changed the objects:\n%v\n%v", again, decoded)
+	}
+	if len(out.Bytes()) != len(file)-4 {
+		t.Fatalf("wrote %d bytes, want %d", len(out.Bytes()), len(file)-4)
+	}
+
+	objects[0]["unknown"] = 1
+	if err := WriteD2O(&out, reader.Classes(), objects); err == nil {
+		t.Fatal("object without class: no error")
+	}
+}
+
+func FuzzD2OReader(f *testing.F) {
+	f.Add(testD2OFile())
+	f.Fuzz(func(t *testing.T, file []byte) {
+		reader, err := NewD2OReader(bytes.NewReader(file))
+		if err != nil {
+			return
+		}
+		reader.Iterate(func(id int32, object map[string]interface{}) error { return nil })
+	})
+}
@@ -0,0 +1,306 @@
+package unpack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// WriteD2O encodes objects as a D2O file with the given class definitions.
+//
+// Each object is written with the class whose field names equal its keys.
+// Nested objects prefer the class declared by their field. When several
+// classes match, the lowest class ID wins. Objects are indexed by their "id"
+// field, or by their position if they have none.
+//
+// Values can be the types D2OReader returns or what encoding/json decodes:
+// float64 or json.Number for numbers and null for NaN numbers. The search
+// tables the game client uses for queries are not written.
+func WriteD2O(w io.Writer, classes []*GameDataClassDefinition, objects []map[string]interface{}) error {
+	enc := &d2oEncoder{
+		classes:  make(map[int32]*GameDataClassDefinition, len(classes)),
+		byFields: make(map[string][]int32),
+	}
+	sorted := make([]*GameDataClassDefinition, len(classes))
+	copy(sorted, classes)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	for _, class := range sorted {
+		if _, ok := enc.classes[class.ID]; ok {
+			return fmt.Errorf("duplicate class %d", class.ID)
+		}
+		enc.classes[class.ID] = class
+		key := d2oFieldKey(class.Fields)
+		enc.byFields[key] = append(enc.byFields[key], class.ID)
+	}
+
+	mem := &memoryStream{}
+	bs := NewBinaryStream(mem, true)
+	bs.writeBytes([]byte("D2O"))
+	bs.WriteInt32(0) // index offset, set below
+
+	ids := make([]int32, 0, len(objects))
+	offsets := make(map[int32]int64, len(objects))
+	for i, object := range objects {
+		id := int32(i)
+		if value, ok := object["id"]; ok {
+			v, err := d2oInt(value, math.MinInt32, math.MaxInt32)
+			if err != nil {
+				return fmt.Errorf("object %d: id: %w", i, err)
+			}
+			id = int32(v)
+		}
+		if _, ok := offsets[id]; ok {
+			return fmt.Errorf("object %d: duplicate id %d", i, id)
+		}
+		ids = append(ids, id)
+		offsets[id] = mem.pos
+
+		class, err := enc.classFor(object, 0)
+		if err != nil {
+			return fmt.Errorf("object %d: %w", id, err)
+		}
+		bs.WriteInt32(class.ID)
+		if err := enc.writeFields(bs, class, object, 0); err != nil {
+			return fmt.Errorf("object %d: %w", id, err)
+		}
+	}
+
+	indexOffset := mem.pos
+	if indexOffset > math.MaxInt32 {
+		return fmt.Errorf("objects take %d bytes, more than a D2O file can address", indexOffset)
+	}
+	bs.WriteInt32(int32(len(ids) * 8))
+	for _, id := range ids {
+		bs.WriteInt32(id)
+		bs.WriteInt32(int32(offsets[id]))
+	}
+
+	bs.WriteInt32(int32(len(sorted)))
+	for _, class := range sorted {
+		bs.WriteInt32(class.ID)
+		if err := writeUTF(bs, class.Name); err != nil {
+			return fmt.Errorf("class %d: %w", class.ID, err)
+		}
+		if err := writeUTF(bs, class.Package); err != nil {
+			return fmt.Errorf("class %d: %w", class.ID, err)
+		}
+		bs.WriteInt32(int32(len(class.Fields)))
+		for _, field := range class.Fields {
+			if err := writeUTF(bs, field.Name); err != nil {
+				return fmt.Errorf("class %d: %w", class.ID, err)
+			}
+			if err := writeD2OType(bs, &field.Type); err != nil {
+				return fmt.Errorf("class %d: field %s: %w", class.ID, field.Name, err)
+			}
+		}
+	}
+
+	bs.SetPosition(3)
+	bs.WriteInt32(int32(indexOffset))
+
+	_, err := w.Write(mem.data)
+	return err
+}
+
+type d2oEncoder struct {
+	classes map[int32]*GameDataClassDefinition
+	// byFields maps the sorted field names of the classes to their IDs.
+	byFields map[string][]int32
+}
+
+func d2oFieldKey(fields []GameDataField) string {
+	names := make([]string, len(fields))
+	for i, field := range fields {
+		names[i] = field.Name
+	}
+	sort.Strings(names)
+	return strings.Join(names, "\x00")
+}
+
+func (enc *d2oEncoder) classFor(object map[string]interface{}, declared int32) (*GameDataClassDefinition, error) {
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	key := strings.Join(names, "\x00")
+
+	if class, ok := enc.classes[declared]; ok && d2oFieldKey(class.Fields) == key {
+		return class, nil
+	}
+	if ids := enc.byFields[key]; len(ids) > 0 {
+		return enc.classes[ids[0]], nil
+	}
+	return nil, fmt.Errorf("no class has the fields %s", strings.Join(names, ", "))
+}
+
+func (enc *d2oEncoder) writeFields(bs *BinaryStream, class *GameDataClassDefinition, object map[string]interface{}, depth int) error {
+	if depth >= d2oMaxDepth {
+		return fmt.Errorf("objects nested too deep")
+	}
+	for i := range class.Fields {
+		field := &class.Fields[i]
+		if err := enc.writeValue(bs, &field.Type, object[field.Name], depth); err != nil {
+			return fmt.Errorf("%s.%s: %w", class.Name, field.Name, err)
+		}
+	}
+	return nil
+}
+
+func (enc *d2oEncoder) writeValue(bs *BinaryStream, fieldType *GameDataType, value interface{}, depth int) error {
+	switch fieldType.Kind {
+	case GameDataInt, GameDataI18n:
+		v, err := d2oInt(value, math.MinInt32, math.MaxInt32)
+		if err != nil {
+			return err
+		}
+		bs.WriteInt32(int32(v))
+	case GameDataUint:
+		v, err := d2oInt(value, 0, math.MaxUint32)
+		if err != nil {
+			return err
+		}
+		bs.WriteUint32(uint32(v))
+	case GameDataBool:
+		v, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("%T is not a bool", value)
+		}
+		bs.WriteBool(v)
+	case GameDataString:
+		v, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%T is not a string", value)
+		}
+		return writeUTF(bs, v)
+	case GameDataNumber:
+		v, err := d2oNumber(value)
+		if err != nil {
+			return err
+		}
+		bs.WriteFloat64(v)
+	case GameDataVector:
+		if fieldType.Elem == nil {
+			return fmt.Errorf("vector without element type")
+		}
+		var vector []interface{}
+		if value != nil {
+			v, ok := value.([]interface{})
+			if !ok {
+				return fmt.Errorf("%T is not an array", value)
+			}
+			vector = v
+		}
+		bs.WriteInt32(int32(len(vector)))
+		for i, element := range vector {
+			if err := enc.writeValue(bs, fieldType.Elem, element, depth); err != nil {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+	case GameDataObject:
+		if value == nil {
+			bs.WriteInt32(d2oNullObjectID)
+			return nil
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%T is not an object", value)
+		}
+		class, err := enc.classFor(object, fieldType.ClassID)
+		if err != nil {
+			return err
+		}
+		bs.WriteInt32(class.ID)
+		return enc.writeFields(bs, class, object, depth+1)
+	default:
+		return fmt.Errorf("unknown type %q", fieldType.Kind)
+	}
+	return nil
+}
+
+func writeD2OType(bs *BinaryStream, fieldType *GameDataType) error {
+	switch fieldType.Kind {
+	case GameDataInt:
+		bs.WriteInt32(-1)
+	case GameDataBool:
+		bs.WriteInt32(-2)
+	case GameDataString:
+		bs.WriteInt32(-3)
+	case GameDataNumber:
+		bs.WriteInt32(-4)
+	case GameDataI18n:
+		bs.WriteInt32(-5)
+	case GameDataUint:
+		bs.WriteInt32(-6)
+	case GameDataVector:
+		if fieldType.Elem == nil {
+			return fmt.Errorf("vector without element type")
+		}
+		bs.WriteInt32(-99)
+		if err := writeUTF(bs, fieldType.Name); err != nil {
+			return err
+		}
+		return writeD2OType(bs, fieldType.Elem)
+	case GameDataObject:
+		if fieldType.ClassID <= 0 {
+			return fmt.Errorf("object type with class %d", fieldType.ClassID)
+		}
+		bs.WriteInt32(fieldType.ClassID)
+	default:
+		return fmt.Errorf("unknown type %q", fieldType.Kind)
+	}
+	return nil
+}
+
+func d2oInt(value interface{}, min int64, max int64) (int64, error) {
+	var v int64
+	switch n := value.(type) {
+	case int:
+		v = int64(n)
+	case int32:
+		v = int64(n)
+	case int64:
+		v = n
+	case uint32:
+		v = int64(n)
+	case float64:
+		if n != math.Trunc(n) || n < math.MinInt64 || n > math.MaxInt64 {
+			return 0, fmt.Errorf("%v is not an integer", n)
+		}
+		v = int64(n)
+	case json.Number:
+		i, err := n.Int64()
+		if err != nil {
+			return 0, fmt.Errorf("%s is not an integer", n)
+		}
+		v = i
+	default:
+		return 0, fmt.Errorf("%T is not an integer", value)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("%d is out of range", v)
+	}
+	return v, nil
+}
+
+func d2oNumber(value interface{}) (float64, error) {
+	switch n := value.(type) {
+	case nil:
+		return math.NaN(), nil
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case json.Number:
+		return n.Float64()
+	default:
+		v, err := d2oInt(value, math.MinInt64, math.MaxInt64)
+		if err != nil {
+			return 0, fmt.Errorf("%T is not a number", value)
+		}
+		return float64(v), nil
+	}
+}
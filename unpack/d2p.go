@@ -1,79 +1,222 @@
 package unpack
 
-import "io"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
 
+// D2PEntry is a file in a D2P archive.
+type D2PEntry struct {
+	Name string
+	// Archive is the path of the archive the entry is stored in. It is empty
+	// for archives read with ReadD2P.
+	Archive string
+	// Offset is the position of the file in its archive.
+	Offset int64
+	Length int64
+	r      io.ReaderAt
+}
+
+// Open returns a reader for the file. Nothing is read until it is used.
+func (e *D2PEntry) Open() *io.SectionReader {
+	return io.NewSectionReader(e.r, e.Offset, e.Length)
+}
+
+// ReadAll reads the whole file.
+func (e *D2PEntry) ReadAll() ([]byte, error) {
+	data := make([]byte, e.Length)
+	if _, err := io.ReadFull(e.Open(), data); err != nil {
+		return nil, fmt.Errorf("read %s: %w", e.Name, err)
+	}
+	return data, nil
+}
+
+// D2P is the index of a D2P archive or of a chain of archives linked through
+// their "link" property. Only the index is read up front, files are read on
+// demand.
 type D2P struct {
-	Stream           io.ReadWriteSeeker
-	baseOffset       uint32
-	baseLength       uint32
-	indexesOffset    uint32
-	numberIndexes    uint32
-	propertiesOffset uint32
-	numberProperties uint32
-	filesPosition    map[string]map[string]uint32
-	files            map[string][]byte
-	properties       map[string]string
+	// Entries holds the files in archive order. If several archives of a chain
+	// contain the same name, the first one wins.
+	Entries []*D2PEntry
+	// Properties holds the properties of the first archive.
+	Properties map[string]string
+	// Archives lists the paths of the archives of the chain in link order.
+	Archives []string
+	byName   map[string]*D2PEntry
+	files    []*os.File
 }
 
-func NewD2P(stream io.ReadWriteSeeker) *D2P {
-	d2p := &D2P{
-		Stream:        stream,
-		filesPosition: make(map[string]map[string]uint32),
-		files:         make(map[string][]byte),
-		properties:    make(map[string]string),
+// ReadD2P reads the index of a single D2P archive of the given size. Links to
+// other archives are not followed.
+func ReadD2P(r io.ReaderAt, size int64) (*D2P, error) {
+	d2p := &D2P{byName: make(map[string]*D2PEntry)}
+	properties, err := d2p.read(r, size, "")
+	if err != nil {
+		return nil, err
 	}
+	d2p.Properties = properties
+	return d2p, nil
+}
 
-	d2pFileBinary := NewBinaryStream(stream, true)
-	bytesHeader := string(d2pFileBinary.ReadBytes(2))
-	if bytesHeader != "\x02\x01" {
-		panic("Invalid D2P file")
+// OpenD2P opens the D2P archive at path and every archive it links to. Linked
+// archives are looked up next to the archive that names them. The archives
+// stay open until Close is called.
+func OpenD2P(path string) (*D2P, error) {
+	d2p := &D2P{byName: make(map[string]*D2PEntry)}
+	visited := make(map[string]bool)
+	for path != "" {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			d2p.Close()
+			return nil, err
+		}
+		if visited[absPath] {
+			d2p.Close()
+			return nil, fmt.Errorf("%s: link cycle", path)
+		}
+		visited[absPath] = true
+
+		f, err := os.Open(path)
+		if err != nil {
+			d2p.Close()
+			return nil, err
+		}
+		d2p.files = append(d2p.files, f)
+		d2p.Archives = append(d2p.Archives, path)
+
+		info, err := f.Stat()
+		if err != nil {
+			d2p.Close()
+			return nil, err
+		}
+		properties, err := d2p.read(f, info.Size(), path)
+		if err != nil {
+			d2p.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if d2p.Properties == nil {
+			d2p.Properties = properties
+		}
+
+		path = ""
+		if link, ok := properties["link"]; ok && link != "" {
+			path = filepath.Join(filepath.Dir(absPath), filepath.FromSlash(link))
+		}
 	}
+	return d2p, nil
+}
 
-	stream.Seek(-24, io.SeekEnd)
-	d2p.baseOffset = d2pFileBinary.ReadUint32()
-	d2p.baseLength = d2pFileBinary.ReadUint32()
-	d2p.indexesOffset = d2pFileBinary.ReadUint32()
-	d2p.numberIndexes = d2pFileBinary.ReadUint32()
-	d2p.propertiesOffset = d2pFileBinary.ReadUint32()
-	d2p.numberProperties = d2pFileBinary.ReadUint32()
-
-	stream.Seek(int64(d2p.indexesOffset), io.SeekStart)
-	for i := uint32(0); i < d2p.numberIndexes; i++ {
-		fileName := d2pFileBinary.ReadString()
-		offset := d2pFileBinary.ReadUint32()
-		length := d2pFileBinary.ReadUint32()
-		d2p.filesPosition[fileName] = map[string]uint32{
-			"offset": offset + d2p.baseOffset,
-			"length": length,
+// WalkD2P opens the archives at paths one after another with OpenD2P and
+// calls fn with each of them. An archive that was read as part of the link
+// chain of an earlier one is not opened again, fn gets a nil D2P for it. The
+// archives are closed when fn returns.
+func WalkD2P(paths []string, fn func(path string, d2p *D2P) error) error {
+	read := make(map[string]bool)
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if read[absPath] {
+			if err := fn(path, nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		d2p, err := OpenD2P(path)
+		if err != nil {
+			return err
+		}
+		for _, archive := range d2p.Archives {
+			absArchive, err := filepath.Abs(archive)
+			if err != nil {
+				d2p.Close()
+				return err
+			}
+			read[absArchive] = true
+		}
+		if err := fn(path, d2p); err != nil {
+			d2p.Close()
+			return err
+		}
+		if err := d2p.Close(); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	stream.Seek(int64(d2p.propertiesOffset), io.SeekStart)
+// Entry returns the file with the given name.
+func (d2p *D2P) Entry(name string) (*D2PEntry, bool) {
+	entry, ok := d2p.byName[name]
+	return entry, ok
+}
 
-	for i := uint32(0); i < d2p.numberProperties; i++ {
-		pptyType := d2pFileBinary.ReadString()
-		pptyValue := d2pFileBinary.ReadString()
-		d2p.properties[pptyType] = pptyValue
+// Close closes the archives opened by OpenD2P.
+func (d2p *D2P) Close() error {
+	var errs []error
+	for _, f := range d2p.files {
+		errs = append(errs, f.Close())
 	}
+	d2p.files = nil
+	return errors.Join(errs...)
+}
 
-	for fileName, position := range d2p.filesPosition {
-		d2p.Stream.Seek(int64(position["offset"]), io.SeekStart)
-		file := d2pFileBinary.ReadBytes(int(position["length"]))
-		d2p.files[fileName] = file
+// read adds the entries of one archive and returns its properties.
+func (d2p *D2P) read(r io.ReaderAt, size int64, archive string) (map[string]string, error) {
+	s := &checkedStream{r: io.NewSectionReader(r, 0, size), size: size}
+	if header := s.bytes(2); s.err != nil || string(header) != "\x02\x01" {
+		return nil, fmt.Errorf("invalid D2P file: %w", s.errOr(fmt.Errorf("unknown header")))
 	}
 
-	return d2p
-}
+	s.seek(size - 24)
+	baseOffset := int64(s.uint32())
+	s.uint32() // base length
+	indexesOffset := int64(s.uint32())
+	numberIndexes := int64(s.uint32())
+	propertiesOffset := int64(s.uint32())
+	numberProperties := int64(s.uint32())
+	if s.err != nil {
+		return nil, fmt.Errorf("invalid D2P file: %w", s.err)
+	}
 
-func (d2p *D2P) GetFiles() map[string]map[string]interface{} {
-	toReturn := make(map[string]map[string]interface{})
-	for fileName, position := range d2p.filesPosition {
-		object_ := make(map[string]interface{})
-		object_["position"] = position
-		if d2p.files != nil {
-			object_["binary"] = d2p.files[fileName]
+	s.seek(indexesOffset)
+	if s.err == nil && numberIndexes > s.remaining()/10 {
+		return nil, fmt.Errorf("invalid D2P file: %d files", numberIndexes)
+	}
+	for i := int64(0); i < numberIndexes && s.err == nil; i++ {
+		entry := &D2PEntry{Name: s.utf(), Archive: archive, r: r}
+		entry.Offset = baseOffset + int64(s.uint32())
+		entry.Length = int64(s.uint32())
+		if s.err == nil && entry.Offset+entry.Length > size {
+			return nil, fmt.Errorf("invalid D2P file: %s is out of range", entry.Name)
+		}
+		if _, ok := d2p.byName[entry.Name]; ok || s.err != nil {
+			continue
 		}
-		toReturn[fileName] = object_
+		d2p.byName[entry.Name] = entry
+		d2p.Entries = append(d2p.Entries, entry)
+	}
+
+	s.seek(propertiesOffset)
+	if s.err != nil {
+		return nil, fmt.Errorf("invalid D2P file: %w", s.err)
 	}
-	return toReturn
+	if numberProperties > s.remaining()/4 {
+		return nil, fmt.Errorf("invalid D2P file: %d properties", numberProperties)
+	}
+	properties := make(map[string]string, numberProperties)
+	for i := int64(0); i < numberProperties && s.err == nil; i++ {
+		key := s.utf()
+		properties[key] = s.utf()
+	}
+	if s.err != nil {
+		return nil, fmt.Errorf("invalid D2P file: %w", s.err)
+	}
+
+	return properties, nil
 }
@@ -0,0 +1,135 @@
+package unpack
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// testD2PFile returns a D2P archive with the given files and properties.
+func testD2PFile(files [][2]string, properties map[string]string) []byte {
+	var f d2oFixture
+	f.WriteString("\x02\x01")
+	baseOffset := f.Len()
+	offsets := make([]int, len(files))
+	for i, file := range files {
+		offsets[i] = f.Len() - baseOffset
+		f.WriteString(file[1])
+	}
+
+	indexesOffset := f.Len()
+	for i, file := range files {
+		f.utf(file[0])
+		f.int32(int32(offsets[i]))
+		f.int32(int32(len(file[1])))
+	}
+	propertiesOffset := f.Len()
+	for key, value := range properties {
+		f.utf(key)
+		f.utf(value)
+	}
+
+	for _, v := range []int{baseOffset, indexesOffset - baseOffset, indexesOffset, len(files), propertiesOffset, len(properties)} {
+		f.int32(int32(v))
+	}
+	return f.Bytes()
+}
+
+func TestOpenD2PFollowsLinks(t *testing.T) {
+	dir := t.TempDir()
+	first := testD2PFile([][2]string{{"1.png", "one"}, {"2.png", "two"}}, map[string]string{"link": "bitmap0_1.d2p"})
+	second := testD2PFile([][2]string{{"2.png", "other"}, {"3.png", "three"}}, nil)
+	if err := os.WriteFile(filepath.Join(dir, "bitmap0.d2p"), first, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bitmap0_1.d2p"), second, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := OpenD2P(filepath.Join(dir, "bitmap0.d2p"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	if len(archive.Archives) != 2 || len(archive.Entries) != 3 {
+		t.Fatalf("archives = %v, entries = %d", archive.Archives, len(archive.Entries))
+	}
+	for name, want := range map[string]string{"1.png": "one", "2.png": "two", "3.png": "three"} {
+		entry, ok := archive.Entry(name)
+		if !ok {
+			t.Fatalf("missing %s", name)
+		}
+		data, err := entry.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Fatalf("%s = %q, want %q", name, data, want)
+		}
+	}
+}
+
+func TestWalkD2PSkipsLinkedArchives(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"bitmap0.d2p":   testD2PFile([][2]string{{"1.png", "one"}}, map[string]string{"link": "bitmap0_1.d2p"}),
+		"bitmap0_1.d2p": testD2PFile([][2]string{{"2.png", "two"}}, nil),
+		"bitmap1.d2p":   testD2PFile([][2]string{{"3.png", "three"}}, nil),
+	}
+	var paths []string
+	for _, name := range []string{"bitmap0.d2p", "bitmap0_1.d2p", "bitmap1.d2p"} {
+		paths = append(paths, filepath.Join(dir, name))
+		if err := os.WriteFile(paths[len(paths)-1], files[name], os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var entries []int
+	err := WalkD2P(paths, func(path string, d2p *D2P) error {
+		if d2p == nil {
+			entries = append(entries, -1)
+			return nil
+		}
+		entries = append(entries, len(d2p.Entries))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{2, -1, 1}; !slices.Equal(entries, want) {
+		t.Errorf("entries = %v, want %v", entries, want)
+	}
+}
+
+func TestReadD2PMalformed(t *testing.T) {
+	file := testD2PFile([][2]string{{"1.png", "one"}}, map[string]string{"link": "next.d2p"})
+	archive, err := ReadD2P(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive.Properties["link"] != "next.d2p" {
+		t.Fatalf("properties = %v", archive.Properties)
+	}
+
+	for n := 0; n < len(file); n++ {
+		if _, err := ReadD2P(bytes.NewReader(file[:n]), int64(n)); err == nil {
+			t.Fatalf("truncated to %d bytes: no error", n)
+		}
+	}
+}
+
+func FuzzReadD2P(f *testing.F) {
+	f.Add(testD2PFile([][2]string{{"1.png", "one"}}, map[string]string{"link": "next.d2p"}))
+	f.Fuzz(func(t *testing.T, file []byte) {
+		archive, err := ReadD2P(bytes.NewReader(file), int64(len(file)))
+		if err != nil {
+			return
+		}
+		for _, entry := range archive.Entries {
+			entry.ReadAll()
+		}
+	})
+}
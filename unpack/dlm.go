@@ -0,0 +1,408 @@
+package unpack
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// DefaultDLMKey is the key Dofus 2 encrypts the data of its maps with.
+const DefaultDLMKey = "649ae451ca33ec53bbcbcc33becf15f4"
+
+const (
+	dlmHeader     = 77
+	dlmCellsCount = 560
+	// dlmMaxSize limits the inflated size of a map. Real maps are a few
+	// hundred kilobytes at most.
+	dlmMaxSize = 64 << 20
+
+	dlmElementGraphical = 2
+	dlmElementSound     = 33
+)
+
+// DLMMap is a decoded Dofus 2 map (.dlm). The field names follow the game
+// client.
+type DLMMap struct {
+	MapVersion             int          `json:"mapVersion"`
+	ID                     uint32       `json:"id"`
+	Encrypted              bool         `json:"encrypted"`
+	EncryptionVersion      int          `json:"encryptionVersion"`
+	RelativeID             uint32       `json:"relativeId"`
+	MapType                int          `json:"mapType"`
+	SubareaID              int32        `json:"subareaId"`
+	TopNeighbourID         int32        `json:"topNeighbourId"`
+	BottomNeighbourID      int32        `json:"bottomNeighbourId"`
+	LeftNeighbourID        int32        `json:"leftNeighbourId"`
+	RightNeighbourID       int32        `json:"rightNeighbourId"`
+	ShadowBonusOnEntities  int32        `json:"shadowBonusOnEntities"`
+	BackgroundColor        DLMColor     `json:"backgroundColor"`
+	GridColor              DLMColor     `json:"gridColor"`
+	ZoomScale              float64      `json:"zoomScale"`
+	ZoomOffsetX            int16        `json:"zoomOffsetX"`
+	ZoomOffsetY            int16        `json:"zoomOffsetY"`
+	TacticalModeTemplateID int32        `json:"tacticalModeTemplateId"`
+	UseLowPassFilter       bool         `json:"useLowPassFilter"`
+	UseReverb              bool         `json:"useReverb"`
+	PresetID               int32        `json:"presetId"`
+	BackgroundFixtures     []DLMFixture `json:"backgroundFixtures"`
+	ForegroundFixtures     []DLMFixture `json:"foregroundFixtures"`
+	GroundCRC              int32        `json:"groundCRC"`
+	Layers                 []DLMLayer   `json:"layers"`
+	Cells                  []DLMCell    `json:"cells"`
+}
+
+type DLMColor struct {
+	Red   uint8 `json:"red"`
+	Green uint8 `json:"green"`
+	Blue  uint8 `json:"blue"`
+	Alpha uint8 `json:"alpha"`
+}
+
+// DLMFixture is a background or foreground picture of a map.
+type DLMFixture struct {
+	FixtureID       int32 `json:"fixtureId"`
+	OffsetX         int16 `json:"offsetX"`
+	OffsetY         int16 `json:"offsetY"`
+	Rotation        int16 `json:"rotation"`
+	XScale          int16 `json:"xScale"`
+	YScale          int16 `json:"yScale"`
+	RedMultiplier   int8  `json:"redMultiplier"`
+	GreenMultiplier int8  `json:"greenMultiplier"`
+	BlueMultiplier  int8  `json:"blueMultiplier"`
+	Alpha           uint8 `json:"alpha"`
+}
+
+type DLMLayer struct {
+	LayerID int32          `json:"layerId"`
+	Cells   []DLMLayerCell `json:"cells"`
+}
+
+type DLMLayerCell struct {
+	CellID   int16        `json:"cellId"`
+	Elements []DLMElement `json:"elements"`
+}
+
+// DLMElement is either a graphical or a sound element.
+type DLMElement struct {
+	Graphical *DLMGraphicalElement `json:"graphical,omitempty"`
+	Sound     *DLMSoundElement     `json:"sound,omitempty"`
+}
+
+type DLMGraphicalElement struct {
+	ElementID uint32  `json:"elementId"`
+	Hue       [3]int8 `json:"hue"`
+	Shadow    [3]int8 `json:"shadow"`
+	// OffsetX and OffsetY are in cells, PixelOffsetX and PixelOffsetY in
+	// pixels. Maps store one of them, the other one is computed.
+	OffsetX      float64 `json:"offsetX"`
+	OffsetY      float64 `json:"offsetY"`
+	PixelOffsetX float64 `json:"pixelOffsetX"`
+	PixelOffsetY float64 `json:"pixelOffsetY"`
+	Altitude     int8    `json:"altitude"`
+	Identifier   uint32  `json:"identifier"`
+}
+
+type DLMSoundElement struct {
+	SoundID              int32 `json:"soundId"`
+	BaseVolume           int16 `json:"baseVolume"`
+	FullVolumeDistance   int32 `json:"fullVolumeDistance"`
+	NullVolumeDistance   int32 `json:"nullVolumeDistance"`
+	MinDelayBetweenLoops int16 `json:"minDelayBetweenLoops"`
+	MaxDelayBetweenLoops int16 `json:"maxDelayBetweenLoops"`
+}
+
+// DLMCell holds the movement data of a cell.
+type DLMCell struct {
+	ID                     int   `json:"id"`
+	Floor                  int   `json:"floor"`
+	Mov                    bool  `json:"mov"`
+	Los                    bool  `json:"los"`
+	NonWalkableDuringFight bool  `json:"nonWalkableDuringFight"`
+	NonWalkableDuringRP    bool  `json:"nonWalkableDuringRP"`
+	Blue                   bool  `json:"blue"`
+	Red                    bool  `json:"red"`
+	Visible                bool  `json:"visible"`
+	FarmCell               bool  `json:"farmCell"`
+	HavenbagCell           bool  `json:"havenbagCell"`
+	TopArrow               bool  `json:"topArrow"`
+	BottomArrow            bool  `json:"bottomArrow"`
+	RightArrow             bool  `json:"rightArrow"`
+	LeftArrow              bool  `json:"leftArrow"`
+	Speed                  int8  `json:"speed"`
+	MapChangeData          uint8 `json:"mapChangeData"`
+	MoveZone               uint8 `json:"moveZone"`
+	LinkedZone             uint8 `json:"linkedZone"`
+}
+
+// ReadDLM decodes a map file as stored in the maps archives. The file is
+// inflated first unless it is already raw. key decrypts the map data of
+// encrypted maps, usually DefaultDLMKey.
+func ReadDLM(data []byte, key []byte) (*DLMMap, error) {
+	if len(data) == 0 || data[0] != dlmHeader {
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid DLM file: %w", err)
+		}
+		inflated, err := io.ReadAll(io.LimitReader(zr, dlmMaxSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("invalid DLM file: %w", err)
+		}
+		if len(inflated) > dlmMaxSize {
+			return nil, fmt.Errorf("invalid DLM file: larger than %d bytes", dlmMaxSize)
+		}
+		data = inflated
+	}
+
+	s := &checkedStream{r: bytes.NewReader(data), size: int64(len(data))}
+	if header := s.uint8(); s.err != nil || header != dlmHeader {
+		return nil, fmt.Errorf("invalid DLM file: %w", s.errOr(fmt.Errorf("unknown header")))
+	}
+
+	m := &DLMMap{MapVersion: int(s.int8())}
+	m.ID = s.uint32()
+	if m.MapVersion >= 7 {
+		m.Encrypted = s.uint8() != 0
+		m.EncryptionVersion = int(s.int8())
+		dataLen := int64(s.int32())
+		if s.err == nil && (dataLen < 0 || dataLen > s.remaining()) {
+			return nil, fmt.Errorf("map %d: data of %d bytes is out of range", m.ID, dataLen)
+		}
+		if m.Encrypted {
+			if len(key) == 0 {
+				return nil, fmt.Errorf("map %d is encrypted and no key is set", m.ID)
+			}
+			decrypted := s.bytes(int(dataLen))
+			for i := range decrypted {
+				decrypted[i] ^= key[i%len(key)]
+			}
+			s = &checkedStream{r: bytes.NewReader(decrypted), size: int64(len(decrypted)), err: s.err}
+		}
+	}
+
+	if err := m.read(s); err != nil {
+		return nil, fmt.Errorf("map %d: %w", m.ID, err)
+	}
+	return m, nil
+}
+
+func (m *DLMMap) read(s *checkedStream) error {
+	m.RelativeID = s.uint32()
+	m.MapType = int(s.int8())
+	m.SubareaID = s.int32()
+	m.TopNeighbourID = s.int32()
+	m.BottomNeighbourID = s.int32()
+	m.LeftNeighbourID = s.int32()
+	m.RightNeighbourID = s.int32()
+	m.ShadowBonusOnEntities = s.int32()
+
+	if m.MapVersion >= 9 {
+		m.BackgroundColor = dlmColor(s.uint32())
+		m.GridColor = dlmColor(s.uint32())
+	} else if m.MapVersion >= 3 {
+		m.BackgroundColor = DLMColor{Red: s.uint8(), Green: s.uint8(), Blue: s.uint8()}
+	}
+
+	if m.MapVersion >= 4 {
+		m.ZoomScale = float64(s.uint16()) / 100
+		m.ZoomOffsetX = s.int16()
+		m.ZoomOffsetY = s.int16()
+		if m.ZoomScale < 1 {
+			m.ZoomScale = 1
+			m.ZoomOffsetX = 0
+			m.ZoomOffsetY = 0
+		}
+	}
+
+	if m.MapVersion > 10 {
+		m.TacticalModeTemplateID = s.int32()
+	}
+
+	m.UseLowPassFilter = s.int8() == 1
+	m.UseReverb = s.int8() == 1
+	m.PresetID = -1
+	if m.UseReverb {
+		m.PresetID = s.int32()
+	}
+
+	m.BackgroundFixtures = readDLMFixtures(s)
+	m.ForegroundFixtures = readDLMFixtures(s)
+
+	s.int32()
+	m.GroundCRC = s.int32()
+
+	layersCount := int(s.int8())
+	m.Layers = make([]DLMLayer, 0, max(layersCount, 0))
+	for i := 0; i < layersCount && s.err == nil; i++ {
+		layer, err := m.readLayer(s)
+		if err != nil {
+			return fmt.Errorf("layer %d: %w", i, err)
+		}
+		m.Layers = append(m.Layers, layer)
+	}
+
+	m.Cells = make([]DLMCell, dlmCellsCount)
+	for i := range m.Cells {
+		m.Cells[i] = m.readCell(s, i)
+		if s.err != nil {
+			return fmt.Errorf("cell %d: %w", i, s.err)
+		}
+	}
+	return s.err
+}
+
+func dlmColor(color uint32) DLMColor {
+	return DLMColor{
+		Alpha: uint8(color >> 24),
+		Red:   uint8(color >> 16),
+		Green: uint8(color >> 8),
+		Blue:  uint8(color),
+	}
+}
+
+func readDLMFixtures(s *checkedStream) []DLMFixture {
+	count := int(s.int8())
+	fixtures := make([]DLMFixture, 0, max(count, 0))
+	for i := 0; i < count && s.err == nil; i++ {
+		fixtures = append(fixtures, DLMFixture{
+			FixtureID:       s.int32(),
+			OffsetX:         s.int16(),
+			OffsetY:         s.int16(),
+			Rotation:        s.int16(),
+			XScale:          s.int16(),
+			YScale:          s.int16(),
+			RedMultiplier:   s.int8(),
+			GreenMultiplier: s.int8(),
+			BlueMultiplier:  s.int8(),
+			Alpha:           s.uint8(),
+		})
+	}
+	return fixtures
+}
+
+func (m *DLMMap) readLayer(s *checkedStream) (DLMLayer, error) {
+	var layer DLMLayer
+	if m.MapVersion >= 9 {
+		layer.LayerID = int32(s.int8())
+	} else {
+		layer.LayerID = s.int32()
+	}
+
+	cellsCount := int(s.int16())
+	layer.Cells = make([]DLMLayerCell, 0, max(min(cellsCount, dlmCellsCount), 0))
+	for i := 0; i < cellsCount && s.err == nil; i++ {
+		cell := DLMLayerCell{CellID: s.int16()}
+		elementsCount := int(s.int16())
+		for j := 0; j < elementsCount && s.err == nil; j++ {
+			element, err := m.readElement(s)
+			if err != nil {
+				return layer, fmt.Errorf("cell %d: %w", cell.CellID, err)
+			}
+			cell.Elements = append(cell.Elements, element)
+		}
+		layer.Cells = append(layer.Cells, cell)
+	}
+	return layer, s.err
+}
+
+func (m *DLMMap) readElement(s *checkedStream) (DLMElement, error) {
+	elementType := s.int8()
+	if s.err != nil {
+		return DLMElement{}, s.err
+	}
+
+	switch elementType {
+	case dlmElementGraphical:
+		e := &DLMGraphicalElement{ElementID: s.uint32()}
+		e.Hue = [3]int8{s.int8(), s.int8(), s.int8()}
+		e.Shadow = [3]int8{s.int8(), s.int8(), s.int8()}
+		if m.MapVersion <= 4 {
+			e.OffsetX = float64(s.int8())
+			e.OffsetY = float64(s.int8())
+			e.PixelOffsetX = e.OffsetX * 43
+			e.PixelOffsetY = e.OffsetY * 21.5
+		} else {
+			e.PixelOffsetX = float64(s.int16())
+			e.PixelOffsetY = float64(s.int16())
+			e.OffsetX = e.PixelOffsetX / 43
+			e.OffsetY = e.PixelOffsetY / 21.5
+		}
+		e.Altitude = s.int8()
+		e.Identifier = s.uint32()
+		return DLMElement{Graphical: e}, s.err
+	case dlmElementSound:
+		return DLMElement{Sound: &DLMSoundElement{
+			SoundID:              s.int32(),
+			BaseVolume:           s.int16(),
+			FullVolumeDistance:   s.int32(),
+			NullVolumeDistance:   s.int32(),
+			MinDelayBetweenLoops: s.int16(),
+			MaxDelayBetweenLoops: s.int16(),
+		}}, s.err
+	default:
+		return DLMElement{}, fmt.Errorf("unknown element type %d", elementType)
+	}
+}
+
+func (m *DLMMap) readCell(s *checkedStream, id int) DLMCell {
+	cell := DLMCell{ID: id, Floor: int(s.int8()) * 10}
+	if cell.Floor == -1280 {
+		return cell
+	}
+
+	if m.MapVersion >= 9 {
+		flags := s.uint16()
+		cell.Mov = flags&1 == 0
+		cell.NonWalkableDuringFight = flags&2 != 0
+		cell.NonWalkableDuringRP = flags&4 != 0
+		cell.Los = flags&8 == 0
+		cell.Blue = flags&16 != 0
+		cell.Red = flags&32 != 0
+		cell.Visible = flags&64 != 0
+		cell.FarmCell = flags&128 != 0
+		if m.MapVersion >= 10 {
+			cell.HavenbagCell = flags&256 != 0
+			cell.TopArrow = flags&512 != 0
+			cell.BottomArrow = flags&1024 != 0
+			cell.RightArrow = flags&2048 != 0
+			cell.LeftArrow = flags&4096 != 0
+		} else {
+			cell.TopArrow = flags&256 != 0
+			cell.BottomArrow = flags&512 != 0
+			cell.RightArrow = flags&1024 != 0
+			cell.LeftArrow = flags&2048 != 0
+		}
+	} else {
+		flags := s.uint8()
+		cell.Mov = flags&1 != 0
+		cell.Los = flags&2 != 0
+		cell.NonWalkableDuringFight = flags&4 != 0
+		cell.Red = flags&8 != 0
+		cell.Blue = flags&16 != 0
+		cell.FarmCell = flags&32 != 0
+		cell.Visible = flags&64 != 0
+		cell.NonWalkableDuringRP = flags&128 != 0
+	}
+
+	cell.Speed = s.int8()
+	cell.MapChangeData = s.uint8()
+	if m.MapVersion > 5 {
+		cell.MoveZone = s.uint8()
+	}
+	if m.MapVersion > 10 && cell.hasLinkedZone() {
+		cell.LinkedZone = s.uint8()
+	}
+	if m.MapVersion > 7 && m.MapVersion < 9 {
+		arrows := s.uint8()
+		cell.TopArrow = arrows&1 != 0
+		cell.BottomArrow = arrows&2 != 0
+		cell.RightArrow = arrows&4 != 0
+		cell.LeftArrow = arrows&8 != 0
+	}
+	return cell
+}
+
+// hasLinkedZone reports whether the cell has a linked roleplay or fight zone.
+func (c *DLMCell) hasLinkedZone() bool {
+	return c.Mov && !c.FarmCell
+}
@@ -0,0 +1,99 @@
+package unpack
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"testing"
+)
+
+func (f *d2oFixture) put(values ...interface{}) {
+	for _, v := range values {
+		binary.Write(&f.Buffer, binary.BigEndian, v)
+	}
+}
+
+// testDLMFile returns a compressed and encrypted version 11 map.
+func testDLMFile(key string) []byte {
+	var body d2oFixture
+	body.put(uint32(5), int8(0), int32(450), int32(1), int32(2), int32(3), int32(4), int32(0))
+	body.put(uint32(0x80112233), uint32(0xff000000))
+	body.put(uint16(100), int16(0), int16(0), int32(0))
+	body.put(int8(0), int8(1), int32(7))
+	body.put(int8(1), int32(99), int16(1), int16(2), int16(0), int16(1000), int16(1000), int8(0), int8(0), int8(0), uint8(255))
+	body.put(int8(0))
+	body.put(int32(0), int32(1234))
+	body.put(int8(1), int8(0), int16(1), int16(42), int16(2))
+	body.put(int8(dlmElementGraphical), uint32(17), int8(1), int8(2), int8(3), int8(0), int8(0), int8(0), int16(86), int16(-43), int8(1), uint32(8))
+	body.put(int8(dlmElementSound), int32(3), int16(80), int32(1), int32(2), int16(10), int16(20))
+	for i := 0; i < dlmCellsCount; i++ {
+		if i == 1 {
+			body.put(int8(-128))
+			continue
+		}
+		body.put(int8(0), uint16(8|512), int8(0), uint8(0), uint8(0))
+		// Cells with mov and without farmCell have a linked zone.
+		body.put(uint8(0))
+	}
+
+	encrypted := body.Bytes()
+	for i := range encrypted {
+		encrypted[i] ^= key[i%len(key)]
+	}
+
+	var raw d2oFixture
+	raw.put(uint8(dlmHeader), int8(11), uint32(5), uint8(1), int8(1), int32(len(encrypted)))
+	raw.Write(encrypted)
+
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(raw.Bytes())
+	zw.Close()
+	return compressed.Bytes()
+}
+
+func TestReadDLM(t *testing.T) {
+	file := testDLMFile(DefaultDLMKey)
+	m, err := ReadDLM(file, []byte(DefaultDLMKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.ID != 5 || m.SubareaID != 450 || m.RightNeighbourID != 4 || !m.Encrypted || m.PresetID != 7 || m.GroundCRC != 1234 {
+		t.Fatalf("map = %+v", m)
+	}
+	if m.BackgroundColor != (DLMColor{Alpha: 0x80, Red: 0x11, Green: 0x22, Blue: 0x33}) || m.ZoomScale != 1 {
+		t.Fatalf("colors = %+v %+v, zoom = %v", m.BackgroundColor, m.GridColor, m.ZoomScale)
+	}
+	if len(m.BackgroundFixtures) != 1 || m.BackgroundFixtures[0].FixtureID != 99 || m.BackgroundFixtures[0].Alpha != 255 {
+		t.Fatalf("fixtures = %+v", m.BackgroundFixtures)
+	}
+
+	elements := m.Layers[0].Cells[0].Elements
+	if len(elements) != 2 || elements[0].Graphical.ElementID != 17 || elements[0].Graphical.OffsetX != 2 || elements[0].Graphical.OffsetY != -2 {
+		t.Fatalf("elements = %+v", elements[0].Graphical)
+	}
+	if elements[1].Sound == nil || elements[1].Sound.MaxDelayBetweenLoops != 20 {
+		t.Fatalf("sound = %+v", elements[1].Sound)
+	}
+
+	if len(m.Cells) != dlmCellsCount || !m.Cells[0].Mov || m.Cells[0].Los || !m.Cells[0].TopArrow || m.Cells[1].Floor != -1280 {
+		t.Fatalf("cells = %+v %+v", m.Cells[0], m.Cells[1])
+	}
+
+	if _, err := ReadDLM(file, nil); err == nil {
+		t.Fatal("encrypted map without key: no error")
+	}
+	for n := 0; n < len(file); n += 7 {
+		if _, err := ReadDLM(file[:n], []byte(DefaultDLMKey)); err == nil {
+			t.Fatalf("truncated to %d bytes: no error", n)
+		}
+	}
+}
+
+func FuzzReadDLM(f *testing.F) {
+	f.Add(testDLMFile(DefaultDLMKey))
+	f.Fuzz(func(t *testing.T, file []byte) {
+		ReadDLM(file, []byte(DefaultDLMKey))
+	})
+}
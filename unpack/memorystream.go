@@ -0,0 +1,60 @@
+package unpack
+
+import (
+	"fmt"
+	"io"
+)
+
+// memoryStream is an in-memory io.ReadWriteSeeker to encode files with a
+// BinaryStream before writing them out.
+type memoryStream struct {
+	data []byte
+	pos  int64
+}
+
+func (m *memoryStream) Read(p []byte) (int, error) {
+	if m.pos >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[m.pos:])
+	m.pos += int64(n)
+	return n, nil
+}
+
+func (m *memoryStream) Write(p []byte) (int, error) {
+	end := m.pos + int64(len(p))
+	if end > int64(len(m.data)) {
+		m.data = append(m.data, make([]byte, end-int64(len(m.data)))...)
+	}
+	copy(m.data[m.pos:], p)
+	m.pos = end
+	return len(p), nil
+}
+
+func (m *memoryStream) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = m.pos + offset
+	case io.SeekEnd:
+		pos = int64(len(m.data)) + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if pos < 0 {
+		return 0, fmt.Errorf("negative position %d", pos)
+	}
+	m.pos = pos
+	return pos, nil
+}
+
+// writeUTF writes a string with a uint16 length prefix.
+func writeUTF(bs *BinaryStream, value string) error {
+	if len(value) > 0xFFFF {
+		return fmt.Errorf("string of %d bytes is too long", len(value))
+	}
+	bs.WriteString(value)
+	return nil
+}
@@ -0,0 +1,56 @@
+package unpack
+
+import (
+	"fmt"
+	"io"
+)
+
+const swlHeader = 76
+
+// SWL is a Dofus 2 SWL library: a SWF file with the list of the classes it
+// exports.
+type SWL struct {
+	Version   int
+	FrameRate uint32
+	Classes   []string
+	// SWF is the embedded SWF file.
+	SWF []byte
+}
+
+// ReadSWL reads the SWL library in r.
+func ReadSWL(r io.ReadSeeker) (*SWL, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	s := &checkedStream{r: r, size: size}
+	s.seek(0)
+
+	if header := s.uint8(); s.err != nil || header != swlHeader {
+		return nil, fmt.Errorf("invalid SWL file: %w", s.errOr(fmt.Errorf("unknown header")))
+	}
+
+	swl := &SWL{Version: int(s.int8())}
+	swl.FrameRate = s.uint32()
+	classCount := int64(s.int32())
+	if s.err == nil && (classCount < 0 || classCount > s.remaining()/2) {
+		return nil, fmt.Errorf("invalid SWL file: %d classes", classCount)
+	}
+	for i := int64(0); i < classCount && s.err == nil; i++ {
+		swl.Classes = append(swl.Classes, s.utf())
+	}
+	swl.SWF = s.bytes(int(s.remaining()))
+	if s.err != nil {
+		return nil, fmt.Errorf("invalid SWL file: %w", s.err)
+	}
+
+	if len(swl.SWF) < 3 {
+		return nil, fmt.Errorf("invalid SWL file: no SWF data")
+	}
+	switch string(swl.SWF[:3]) {
+	case "FWS", "CWS", "ZWS":
+	default:
+		return nil, fmt.Errorf("invalid SWL file: embedded data is not a SWF file")
+	}
+	return swl, nil
+}
@@ -0,0 +1,40 @@
+package unpack
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReadSWL(t *testing.T) {
+	var f d2oFixture
+	f.put(uint8(swlHeader), int8(1), uint32(25), int32(2))
+	f.utf("Bitmap_1")
+	f.utf("Anim_2")
+	f.WriteString("FWS\x0a")
+
+	swl, err := ReadSWL(bytes.NewReader(f.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if swl.Version != 1 || swl.FrameRate != 25 || len(swl.Classes) != 2 || swl.Classes[1] != "Anim_2" || string(swl.SWF) != "FWS\x0a" {
+		t.Fatalf("swl = %+v", swl)
+	}
+
+	file := f.Bytes()
+	for n := 0; n < len(file)-1; n++ {
+		if _, err := ReadSWL(bytes.NewReader(file[:n])); err == nil {
+			t.Fatalf("truncated to %d bytes: no error", n)
+		}
+	}
+}
+
+func FuzzReadSWL(f *testing.F) {
+	var seed d2oFixture
+	seed.put(uint8(swlHeader), int8(1), uint32(25), int32(1))
+	seed.utf("Bitmap_1")
+	seed.WriteString("FWS\x0a")
+	f.Add(seed.Bytes())
+	f.Fuzz(func(t *testing.T, file []byte) {
+		ReadSWL(bytes.NewReader(file))
+	})
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -473,6 +472,12 @@ func Download(releaseChannel string, version string, dir string, clean bool, ful
 			}
 		}
 
+		if rawDofusMajorVersion == 2 && !ignoresRegex(ignore, "data-maps") {
+			if err := DownloadMaps(&ankaManifest, bin, rawDofusMajorVersion, dir, indent, headless); err != nil {
+				log.Fatal(err)
+			}
+		}
+
 		if err := DownloadImagesLauncher(&ankaManifest, bin, jobs, rawDofusMajorVersion, dir, ignore, headless); err != nil {
 			log.Fatal(err)
 		}
@@ -484,6 +489,13 @@ func Download(releaseChannel string, version string, dir string, clean bool, ful
 				jobs = 1
 			}
 			DownloadMountsImages(gamedata, bin, &ankaManifest, jobs, dir, headless)
+			if err := deriveFolderImageSizes(filepath.Join(dir, "img", "mount"), imageName); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if err := convertImages(filepath.Join(dir, "img"), jobs, headless); err != nil {
+			log.Fatal(err)
 		}
 
 		os.RemoveAll(fmt.Sprintf("%s/tmp", dir))
@@ -580,7 +592,9 @@ func Unpack(file string, dir string, destDir string, category string, indent str
 	absOutPath := filepath.Join(destDir, fileNoExt+".json")
 
 	supportedUnpack := []string{"d2o", "d2i", "imagebundle", "bundle", "bin"}
-	isSupported := slices.Contains(supportedUnpack, suffix)
+	// Legacy language files share the .bin suffix with the Dofus 3 ones.
+	isLang := strings.HasPrefix(fileNoExt, "lang_") && (suffix == "swf" || suffix == "bin")
+	isSupported := slices.Contains(supportedUnpack, suffix) || isLang
 
 	if !isSupported {
 		log.Warnf("Unsupported file type for unpacking %s", suffix)
@@ -603,7 +617,11 @@ func Unpack(file string, dir string, destDir string, category string, indent str
 			log.Fatal(err)
 		}
 
-		objects := reader.GetObjects()
+		decoded, err := reader.GetObjects()
+		if err != nil {
+			log.Fatal(fmt.Errorf("%s: %w", file, err))
+		}
+		objects := jsonFiniteValue(decoded)
 		var marshalledBytes []byte
 		if indent != "" {
 			marshalledBytes, err = jsnan.MarshalIndent(objects, "", indent)
@@ -613,7 +631,6 @@ func Unpack(file string, dir string, destDir string, category string, indent str
 		if err != nil {
 			log.Fatal(err)
 		}
-		marshalledBytes = bytes.ReplaceAll(marshalledBytes, []byte("NaN"), []byte("null"))
 
 		err = os.WriteFile(absOutPath, marshalledBytes, os.ModePerm)
 		if err != nil {
@@ -633,7 +650,11 @@ func Unpack(file string, dir string, destDir string, category string, indent str
 			}
 		}()
 
-		data := unpack.NewD2I(f).Read()
+		decoded, err := unpack.NewD2I(f).Read()
+		if err != nil {
+			log.Fatal(fmt.Errorf("%s: %w", file, err))
+		}
+		data := jsonFiniteValue(decoded)
 
 		var marshalledBytes []byte
 		if indent != "" {
@@ -644,7 +665,6 @@ func Unpack(file string, dir string, destDir string, category string, indent str
 		if err != nil {
 			log.Fatal(err)
 		}
-		marshalledBytes = bytes.ReplaceAll(marshalledBytes, []byte("NaN"), []byte("null"))
 
 		err = os.WriteFile(absOutPath, marshalledBytes, os.ModePerm)
 		if err != nil {
@@ -667,7 +687,33 @@ func Unpack(file string, dir string, destDir string, category string, indent str
 		}
 	}
 
-	if suffix == "bin" {
+	if isLang {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		decoded, err := unpack.ReadLang(raw)
+		if err != nil {
+			log.Fatal(fmt.Errorf("%s: %w", file, err))
+		}
+
+		var marshalledBytes []byte
+		if indent != "" {
+			marshalledBytes, err = jsnan.MarshalIndent(decoded, "", indent)
+		} else {
+			marshalledBytes, err = jsnan.Marshal(decoded)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = os.WriteFile(absOutPath, marshalledBytes, os.ModePerm)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if suffix == "bin" && !isLang {
 		err := UnpackUnityI18n(category, file, absOutPath, muteSpinner, headless)
 		if err != nil {
 			log.Fatal(err)
@@ -750,9 +796,13 @@ func DownloadUnpackFiles(title string, bin int, manifest *ankabuffer.Manifest, f
 
 	toDownload = toDownloadFiltered
 
+	// friendlyNames maps the manifest names to the local names. The files are
+	// split into bins below, so their index in toDownload is lost.
+	friendlyNames := make(map[string]string, len(toDownload))
 	for i, file := range toDownload {
 		filesToDownload = append(filesToDownload, manifest.Fragments[fragment].Files[file.Filename])
 		toDownload[i].Hash = manifest.Fragments[fragment].Files[file.Filename].Hash
+		friendlyNames[file.Filename] = file.FriendlyName
 	}
 
 	var filebins [][]ankabuffer.File
@@ -851,9 +901,9 @@ func DownloadUnpackFiles(title string, bin int, manifest *ankabuffer.Manifest, f
 		bundleDownloadWg.Wait()
 
 		var wg sync.WaitGroup
-		for i, file := range filesToDownload {
+		for _, file := range filesToDownload {
 			wg.Add(1)
-			go func(file ankabuffer.File, bundlesBuffer map[string]DownloadedBundle, dir string, destDir string, i int) {
+			go func(file ankabuffer.File, bundlesBuffer map[string]DownloadedBundle, dir string, destDir string) {
 				defer wg.Done()
 				var fileData []byte
 
@@ -911,7 +961,7 @@ func DownloadUnpackFiles(title string, bin int, manifest *ankabuffer.Manifest, f
 					log.Fatal(err)
 				}
 
-				offlineFilePath := filepath.Join(destDir, toDownload[i].FriendlyName)
+				offlineFilePath := filepath.Join(destDir, friendlyNames[file.Name])
 
 				// anonymous function to safely defer closing file
 				func() {
@@ -945,7 +995,7 @@ func DownloadUnpackFiles(title string, bin int, manifest *ankabuffer.Manifest, f
 					}
 				}
 
-			}(file, bundlesBuffer, dir, destDir, i)
+			}(file, bundlesBuffer, dir, destDir)
 		}
 
 		wg.Wait()
@@ -0,0 +1,145 @@
+package webp
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+)
+
+func init() {
+	image.RegisterFormat("webp", "RIFF????WEBPVP8", Decode, DecodeConfig)
+}
+
+var errUnsupported = errors.New("webp: animated images are not supported")
+
+// Decode reads a lossless or lossy WebP image, with or without alpha
+// channel, as *image.NRGBA. Lossy images are converted with the BT.601
+// coefficients of libwebp, the chroma planes are upsampled by repeating
+// their samples.
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errFormat
+	}
+	size := int64(binary.LittleEndian.Uint32(data[4:]))
+	if size < 4 || size > int64(len(data)-8) {
+		return nil, errFormat
+	}
+	data = data[12 : 8+size]
+
+	var alpha []byte
+	var vp8x bool
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errFormat
+		}
+		id := string(data[:4])
+		size := int64(binary.LittleEndian.Uint32(data[4:]))
+		if size > int64(len(data)-8) {
+			return nil, errFormat
+		}
+		body := data[8 : 8+size]
+		data = data[8+size:]
+		if size&1 != 0 && len(data) > 0 {
+			data = data[1:]
+		}
+
+		switch id {
+		case "VP8X":
+			if len(body) < 10 {
+				return nil, errFormat
+			}
+			if body[0]&0x02 != 0 {
+				return nil, errUnsupported
+			}
+			vp8x = true
+		case "ALPH":
+			if !vp8x {
+				return nil, errFormat
+			}
+			alpha = body
+		case "VP8L":
+			return decodeLossless(body)
+		case "VP8 ":
+			img, err := decodeLossy(body)
+			if err != nil {
+				return nil, err
+			}
+			if alpha != nil {
+				if err := decodeAlpha(alpha, img); err != nil {
+					return nil, err
+				}
+			}
+			return img, nil
+		case "ANIM", "ANMF":
+			return nil, errUnsupported
+		default:
+			// ICCP, EXIF, XMP and unknown chunks are skipped.
+			if !vp8x {
+				return nil, errFormat
+			}
+		}
+	}
+	return nil, errFormat
+}
+
+// decodeAlpha decodes the ALPH chunk data into the alpha channel of img.
+func decodeAlpha(data []byte, img *image.NRGBA) error {
+	if len(data) < 1 {
+		return errFormat
+	}
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	values := make([]uint8, width*height)
+	switch data[0] & 0x03 {
+	case 0:
+		if len(data)-1 < len(values) {
+			return errFormat
+		}
+		copy(values, data[1:])
+	case 1:
+		argb, err := decodeImageStream(newBitReader(data[1:]), width, height)
+		if err != nil {
+			return err
+		}
+		for i, p := range argb {
+			values[i] = uint8(p >> 8)
+		}
+	default:
+		return errFormat
+	}
+
+	// The filters predict from the pixels to the left, above and above left.
+	method := data[0] >> 2 & 0x03
+	for y := range height {
+		row := values[y*width : (y+1)*width]
+		for x := range row {
+			var pred uint8
+			switch {
+			case method == 0 || x == 0 && y == 0:
+			case y == 0:
+				pred = row[x-1]
+			case x == 0:
+				pred = values[(y-1)*width]
+			case method == 1:
+				pred = row[x-1]
+			case method == 2:
+				pred = values[(y-1)*width+x]
+			default:
+				a, b, c := int(row[x-1]), int(values[(y-1)*width+x]), int(values[(y-1)*width+x-1])
+				pred = uint8(clamp255(a + b - c))
+			}
+			row[x] += pred
+		}
+	}
+
+	for y := range height {
+		for x, a := range values[y*width : (y+1)*width] {
+			img.Pix[y*img.Stride+4*x+3] = a
+		}
+	}
+	return nil
+}
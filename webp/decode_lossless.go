@@ -0,0 +1,444 @@
+package webp
+
+import (
+	"image"
+)
+
+// The lossless decoder reads every VP8L feature of RFC 9649: the four
+// transforms, the color cache and meta prefix codes.
+
+// distanceMap holds the x and y offsets of the 120 short distance codes.
+var distanceMap = [120][2]int8{
+	{0, 1}, {1, 0}, {1, 1}, {-1, 1}, {0, 2}, {2, 0}, {1, 2}, {-1, 2},
+	{2, 1}, {-2, 1}, {2, 2}, {-2, 2}, {0, 3}, {3, 0}, {1, 3}, {-1, 3},
+	{3, 1}, {-3, 1}, {2, 3}, {-2, 3}, {3, 2}, {-3, 2}, {0, 4}, {4, 0},
+	{1, 4}, {-1, 4}, {4, 1}, {-4, 1}, {3, 3}, {-3, 3}, {2, 4}, {-2, 4},
+	{4, 2}, {-4, 2}, {0, 5}, {3, 4}, {-3, 4}, {4, 3}, {-4, 3}, {5, 0},
+	{1, 5}, {-1, 5}, {5, 1}, {-5, 1}, {2, 5}, {-2, 5}, {5, 2}, {-5, 2},
+	{4, 4}, {-4, 4}, {3, 5}, {-3, 5}, {5, 3}, {-5, 3}, {0, 6}, {6, 0},
+	{1, 6}, {-1, 6}, {6, 1}, {-6, 1}, {2, 6}, {-2, 6}, {6, 2}, {-6, 2},
+	{4, 5}, {-4, 5}, {5, 4}, {-5, 4}, {3, 6}, {-3, 6}, {6, 3}, {-6, 3},
+	{0, 7}, {7, 0}, {1, 7}, {-1, 7}, {5, 5}, {-5, 5}, {7, 1}, {-7, 1},
+	{4, 6}, {-4, 6}, {6, 4}, {-6, 4}, {2, 7}, {-2, 7}, {7, 2}, {-7, 2},
+	{3, 7}, {-3, 7}, {7, 3}, {-7, 3}, {5, 6}, {-5, 6}, {6, 5}, {-6, 5},
+	{8, 0}, {4, 7}, {-4, 7}, {7, 4}, {-7, 4}, {8, 1}, {8, 2}, {6, 6},
+	{-6, 6}, {8, 3}, {5, 7}, {-5, 7}, {7, 5}, {-7, 5}, {8, 4}, {6, 7},
+	{-6, 7}, {7, 6}, {-7, 6}, {8, 5}, {7, 7}, {-7, 7}, {8, 6}, {8, 7},
+}
+
+// bitReader reads the least significant bit first. Reading past the end
+// returns zeros and sets eof.
+type bitReader struct {
+	data  []byte
+	bits  uint64
+	nbits uint
+	eof   bool
+}
+
+func newBitReader(data []byte) *bitReader {
+	return &bitReader{data: data}
+}
+
+func (r *bitReader) read(n uint) uint32 {
+	for r.nbits < n {
+		if len(r.data) > 0 {
+			r.bits |= uint64(r.data[0]) << r.nbits
+			r.data = r.data[1:]
+		} else {
+			r.eof = true
+		}
+		r.nbits += 8
+	}
+	v := uint32(r.bits & (1<<n - 1))
+	r.bits >>= n
+	r.nbits -= n
+	return v
+}
+
+// prefixDecoder decodes a canonical prefix code one bit at a time. A code
+// with a single symbol reads no bits.
+type prefixDecoder struct {
+	counts  [16]uint16
+	symbols []uint16
+}
+
+// newPrefixDecoder returns the decoder of lengths, or false if they are not
+// a complete code.
+func newPrefixDecoder(lengths []uint8) (prefixDecoder, bool) {
+	var d prefixDecoder
+	for _, l := range lengths {
+		d.counts[l]++
+	}
+	d.counts[0] = 0
+	var offsets [16]int
+	for l := 2; l < 16; l++ {
+		offsets[l] = offsets[l-1] + int(d.counts[l-1])
+	}
+	n := offsets[15] + int(d.counts[15])
+	d.symbols = make([]uint16, n)
+	for s, l := range lengths {
+		if l != 0 {
+			d.symbols[offsets[l]] = uint16(s)
+			offsets[l]++
+		}
+	}
+	switch n {
+	case 0:
+		return d, false
+	case 1:
+		return d, true
+	}
+	left := 1
+	for l := 1; l < 16; l++ {
+		left = left<<1 - int(d.counts[l])
+		if left < 0 {
+			return d, false
+		}
+	}
+	return d, left == 0
+}
+
+func (d *prefixDecoder) decode(r *bitReader) int {
+	if len(d.symbols) == 1 {
+		return int(d.symbols[0])
+	}
+	code, first, index := 0, 0, 0
+	for l := 1; l < 16; l++ {
+		code |= int(r.read(1))
+		count := int(d.counts[l])
+		if code-first < count {
+			return int(d.symbols[index+code-first])
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	r.eof = true
+	return 0
+}
+
+// readPrefixCode reads the simple or normal code of an alphabet.
+func readPrefixCode(r *bitReader, alphabetSize int) (prefixDecoder, error) {
+	lengths := make([]uint8, alphabetSize)
+	if r.read(1) == 1 {
+		n := r.read(1) + 1
+		for i := range n {
+			var s uint32
+			if i == 0 {
+				s = r.read(1 + 7*uint(r.read(1)))
+			} else {
+				s = r.read(8)
+			}
+			if int(s) >= alphabetSize {
+				return prefixDecoder{}, errFormat
+			}
+			lengths[s] = 1
+		}
+	} else {
+		clLengths := make([]uint8, len(codeLengthOrder))
+		n := int(r.read(4)) + 4
+		for _, s := range codeLengthOrder[:n] {
+			clLengths[s] = uint8(r.read(3))
+		}
+		clCode, ok := newPrefixDecoder(clLengths)
+		if !ok {
+			return prefixDecoder{}, errFormat
+		}
+
+		maxSymbol := alphabetSize
+		if r.read(1) == 1 {
+			maxSymbol = 2 + int(r.read(2+2*uint(r.read(3))))
+			if maxSymbol > alphabetSize {
+				return prefixDecoder{}, errFormat
+			}
+		}
+		prev := uint8(8)
+		for i := 0; i < alphabetSize && maxSymbol > 0; maxSymbol-- {
+			s := clCode.decode(r)
+			if s < 16 {
+				lengths[i] = uint8(s)
+				if s != 0 {
+					prev = uint8(s)
+				}
+				i++
+				continue
+			}
+			var repeat int
+			var value uint8
+			switch s {
+			case 16:
+				repeat, value = 3+int(r.read(2)), prev
+			case 17:
+				repeat = 3 + int(r.read(3))
+			default:
+				repeat = 11 + int(r.read(7))
+			}
+			if i+repeat > alphabetSize {
+				return prefixDecoder{}, errFormat
+			}
+			for range repeat {
+				lengths[i] = value
+				i++
+			}
+		}
+	}
+	if r.eof {
+		return prefixDecoder{}, errFormat
+	}
+	d, ok := newPrefixDecoder(lengths)
+	if !ok {
+		return prefixDecoder{}, errFormat
+	}
+	return d, nil
+}
+
+// decodeLossless returns the image of a VP8L chunk.
+func decodeLossless(data []byte) (*image.NRGBA, error) {
+	r := newBitReader(data)
+	if r.read(8) != 0x2f {
+		return nil, errFormat
+	}
+	width := int(r.read(14)) + 1
+	height := int(r.read(14)) + 1
+	r.read(1) // alpha hint
+	if r.read(3) != 0 {
+		return nil, errFormat
+	}
+	argb, err := decodeImageStream(r, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i, p := range argb {
+		img.Pix[4*i+0] = uint8(p >> 16)
+		img.Pix[4*i+1] = uint8(p >> 8)
+		img.Pix[4*i+2] = uint8(p)
+		img.Pix[4*i+3] = uint8(p >> 24)
+	}
+	return img, nil
+}
+
+type transform struct {
+	kind int
+	bits int
+	// width is the width of the image the transform is applied to.
+	width int
+	data  []uint32
+}
+
+// decodeImageStream reads the transforms and the main image and returns the
+// ARGB pixels.
+func decodeImageStream(r *bitReader, width int, height int) ([]uint32, error) {
+	var transforms []transform
+	seen := 0
+	xsize := width
+	for r.read(1) == 1 {
+		t := transform{kind: int(r.read(2)), width: xsize}
+		if seen&(1<<t.kind) != 0 {
+			return nil, errFormat
+		}
+		seen |= 1 << t.kind
+
+		var err error
+		switch t.kind {
+		case transformPredictor, transformCrossColor:
+			t.bits = int(r.read(3)) + 2
+			t.data, err = decodeEntropyImage(r, subSampleSize(xsize, t.bits), subSampleSize(height, t.bits), false)
+		case transformColorIndexing:
+			n := int(r.read(8)) + 1
+			t.data, err = decodeEntropyImage(r, n, 1, false)
+			if err != nil {
+				return nil, err
+			}
+			for i := 1; i < n; i++ {
+				t.data[i] = addPixels(t.data[i], t.data[i-1])
+			}
+			switch {
+			case n <= 2:
+				t.bits = 3
+			case n <= 4:
+				t.bits = 2
+			case n <= 16:
+				t.bits = 1
+			}
+			xsize = subSampleSize(xsize, t.bits)
+		}
+		if err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, t)
+	}
+
+	argb, err := decodeEntropyImage(r, xsize, height, true)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(transforms) - 1; i >= 0; i-- {
+		argb = transforms[i].inverse(argb, height)
+	}
+	return argb, nil
+}
+
+// inverse undoes the transform on argb.
+func (t *transform) inverse(argb []uint32, height int) []uint32 {
+	width := t.width
+	switch t.kind {
+	case transformPredictor:
+		tilesX := subSampleSize(width, t.bits)
+		for y := range height {
+			for x := range width {
+				mode := int(t.data[(y>>t.bits)*tilesX+x>>t.bits] >> 8 & 0x0f)
+				if mode > 13 {
+					mode = 0
+				}
+				i := y*width + x
+				argb[i] = addPixels(argb[i], predictPixel(argb, width, x, y, mode))
+			}
+		}
+	case transformCrossColor:
+		tilesX := subSampleSize(width, t.bits)
+		for y := range height {
+			for x := range width {
+				m := t.data[(y>>t.bits)*tilesX+x>>t.bits]
+				i := y*width + x
+				p := argb[i]
+				green := int8(p >> 8)
+				red := int(p>>16) + colorDelta(int8(m), green)
+				blue := int(p) + colorDelta(int8(m>>8), green)
+				blue += colorDelta(int8(m>>16), int8(red))
+				argb[i] = p&0xff00ff00 | uint32(red&0xff)<<16 | uint32(blue&0xff)
+			}
+		}
+	case transformSubtractGreen:
+		for i, p := range argb {
+			g := p >> 8 & 0xff
+			argb[i] = p&0xff00ff00 | (p&0x00ff0000+g<<16)&0x00ff0000 | (p+g)&0xff
+		}
+	case transformColorIndexing:
+		packedWidth := subSampleSize(width, t.bits)
+		pixelBits := 8 >> t.bits
+		mask := uint32(1)<<pixelBits - 1
+		out := make([]uint32, width*height)
+		for y := range height {
+			for x := range width {
+				packed := argb[y*packedWidth+x>>t.bits] >> 8
+				index := int(packed >> ((x & (1<<t.bits - 1)) * pixelBits) & mask)
+				if index < len(t.data) {
+					out[y*width+x] = t.data[index]
+				}
+			}
+		}
+		return out
+	}
+	return argb
+}
+
+// decodeEntropyImage reads the prefix codes and the pixels of an image. Only
+// the main image can have meta prefix codes.
+func decodeEntropyImage(r *bitReader, width int, height int, main bool) ([]uint32, error) {
+	var cache []uint32
+	cacheBits := 0
+	if r.read(1) == 1 {
+		cacheBits = int(r.read(4))
+		if cacheBits < 1 || cacheBits > 11 {
+			return nil, errFormat
+		}
+		cache = make([]uint32, 1<<cacheBits)
+	}
+
+	var groupImage []uint32
+	groupBits, groupsX, numGroups := 0, 0, 1
+	if main && r.read(1) == 1 {
+		groupBits = int(r.read(3)) + 2
+		groupsX = subSampleSize(width, groupBits)
+		var err error
+		groupImage, err = decodeEntropyImage(r, groupsX, subSampleSize(height, groupBits), false)
+		if err != nil {
+			return nil, err
+		}
+		for i, p := range groupImage {
+			groupImage[i] = p >> 8 & 0xffff
+			numGroups = max(numGroups, int(groupImage[i])+1)
+		}
+	}
+
+	sizes := [5]int{numLiteralCodes + numLengthCodes + len(cache), numLiteralCodes, numLiteralCodes, numLiteralCodes, numDistCodes}
+	groups := make([][5]prefixDecoder, numGroups)
+	for i := range groups {
+		for j, size := range sizes {
+			var err error
+			if groups[i][j], err = readPrefixCode(r, size); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	argb := make([]uint32, width*height)
+	for i := 0; i < len(argb); {
+		group := &groups[0]
+		if groupImage != nil {
+			x, y := i%width, i/width
+			group = &groups[groupImage[(y>>groupBits)*groupsX+x>>groupBits]]
+		}
+		n := 1
+		switch g := group[0].decode(r); {
+		case g < numLiteralCodes:
+			red := group[1].decode(r)
+			blue := group[2].decode(r)
+			alpha := group[3].decode(r)
+			argb[i] = uint32(alpha)<<24 | uint32(red)<<16 | uint32(g)<<8 | uint32(blue)
+		case g < numLiteralCodes+numLengthCodes:
+			n = prefixValue(r, g-numLiteralCodes)
+			dist := prefixValue(r, group[4].decode(r))
+			if dist > len(distanceMap) {
+				dist -= len(distanceMap)
+			} else {
+				offset := distanceMap[dist-1]
+				dist = max(int(offset[0])+int(offset[1])*width, 1)
+			}
+			if dist > i || n > len(argb)-i {
+				return nil, errFormat
+			}
+			for j := i; j < i+n; j++ {
+				argb[j] = argb[j-dist]
+			}
+		default:
+			argb[i] = cache[g-numLiteralCodes-numLengthCodes]
+		}
+		if r.eof {
+			return nil, errFormat
+		}
+		if cache != nil {
+			for _, p := range argb[i : i+n] {
+				cache[(0x1e35a7bd*p)>>(32-cacheBits)] = p
+			}
+		}
+		i += n
+	}
+	return argb, nil
+}
+
+// prefixValue reads the extra bits of a length or distance prefix symbol.
+func prefixValue(r *bitReader, symbol int) int {
+	if symbol < 4 {
+		return symbol + 1
+	}
+	extra := uint(symbol-2) >> 1
+	offset := (2 + symbol&1) << extra
+	return offset + int(r.read(extra)) + 1
+}
+
+func subSampleSize(size int, bits int) int {
+	return (size + 1<<bits - 1) >> bits
+}
+
+func addPixels(a uint32, b uint32) uint32 {
+	alphaGreen := a&0xff00ff00 + b&0xff00ff00
+	redBlue := a&0x00ff00ff + b&0x00ff00ff
+	return alphaGreen&0xff00ff00 | redBlue&0x00ff00ff
+}
+
+func colorDelta(t int8, c int8) int {
+	return int(t) * int(c) >> 5
+}
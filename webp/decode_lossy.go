@@ -0,0 +1,830 @@
+package webp
+
+import (
+	"encoding/binary"
+	"image"
+)
+
+// The lossy decoder reads key frames as specified in RFC 6386: all luma and
+// chroma predictors, segments, token partitions and both loop filters. The
+// YUV planes match libwebp, the conversion to RGB repeats the chroma samples
+// instead of interpolating them.
+
+// boolReader is the boolean decoder of section 7. Past the end it reads zero
+// bytes and reports eof once it needs more than one of them.
+type boolReader struct {
+	data     []byte
+	value    uint32
+	rng      uint32
+	bitCount int
+	padding  int
+}
+
+func newBoolReader(data []byte) *boolReader {
+	r := &boolReader{data: data, rng: 255}
+	r.value = uint32(r.next())<<8 | uint32(r.next())
+	return r
+}
+
+func (r *boolReader) next() uint8 {
+	if len(r.data) == 0 {
+		r.padding++
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *boolReader) eof() bool {
+	return r.padding > 1
+}
+
+func (r *boolReader) get(prob uint8) bool {
+	split := 1 + (r.rng-1)*uint32(prob)>>8
+	bigSplit := split << 8
+	bit := r.value >= bigSplit
+	if bit {
+		r.rng -= split
+		r.value -= bigSplit
+	} else {
+		r.rng = split
+	}
+	for r.rng < 128 {
+		r.value <<= 1
+		r.rng <<= 1
+		if r.bitCount++; r.bitCount == 8 {
+			r.bitCount = 0
+			r.value |= uint32(r.next())
+		}
+	}
+	return bit
+}
+
+func (r *boolReader) flag() bool {
+	return r.get(128)
+}
+
+// literal reads n bits, most significant bit first.
+func (r *boolReader) literal(n int) uint32 {
+	var v uint32
+	for range n {
+		v = v<<1 | uint32(btoi(r.flag()))
+	}
+	return v
+}
+
+// signed reads an optional value of n bits followed by its sign.
+func (r *boolReader) signed(n int) int32 {
+	if !r.flag() {
+		return 0
+	}
+	v := int32(r.literal(n))
+	if r.flag() {
+		return -v
+	}
+	return v
+}
+
+// filterParams are the loop filter thresholds of a macroblock, section 15.
+// A zero limit turns the filter off.
+type filterParams struct {
+	limit, ilevel, hevThresh int
+}
+
+type mbFilter struct {
+	filterParams
+	// inner filters the edges between the 4x4 blocks.
+	inner bool
+}
+
+type vp8Decoder struct {
+	width, height int
+	mbw, mbh      int
+
+	segmentMap   bool
+	segmentProbs [3]uint8
+	quants       [4]quantizer
+	// strengths are the loop filter thresholds by segment and by whether the
+	// macroblock uses 4x4 luma prediction.
+	strengths    [4][2]filterParams
+	filter       bool
+	simpleFilter bool
+	probs        [4][8][3][11]uint8
+	useSkip      bool
+	skipProb     uint8
+
+	// Planes padded to whole macroblocks. They hold the pixels before the
+	// loop filter until all macroblocks are predicted.
+	planes [3][]uint8
+	stride [3]int
+
+	// The 4x4 luma modes and non-zero flags of the blocks above and left of
+	// the macroblock, in the layout of the encoder.
+	topModes  []uint8
+	leftModes [4]uint8
+	topNZ     [][9]uint8
+	leftNZ    [9]uint8
+	filters   []mbFilter
+}
+
+// decodeLossy returns the image of a VP8 chunk. The alpha channel is opaque.
+func decodeLossy(data []byte) (*image.NRGBA, error) {
+	if len(data) < 10 {
+		return nil, errFormat
+	}
+	tag := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16
+	if tag&1 != 0 || tag>>1&7 > 3 || data[3] != 0x9d || data[4] != 0x01 || data[5] != 0x2a {
+		return nil, errFormat
+	}
+	d := &vp8Decoder{
+		width:  int(binary.LittleEndian.Uint16(data[6:]) & 0x3fff),
+		height: int(binary.LittleEndian.Uint16(data[8:]) & 0x3fff),
+	}
+	if d.width == 0 || d.height == 0 {
+		return nil, errFormat
+	}
+	data = data[10:]
+	firstSize := int(tag >> 5)
+	if firstSize > len(data) {
+		return nil, errFormat
+	}
+	br := newBoolReader(data[:firstSize])
+	partitions, err := d.readHeader(br, data[firstSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	d.mbw, d.mbh = (d.width+15)/16, (d.height+15)/16
+	for p := range d.planes {
+		n := 16
+		if p > 0 {
+			n = 8
+		}
+		d.stride[p] = d.mbw * n
+		d.planes[p] = make([]uint8, d.stride[p]*d.mbh*n)
+	}
+	d.topModes = make([]uint8, 4*d.mbw)
+	d.topNZ = make([][9]uint8, d.mbw)
+	d.filters = make([]mbFilter, d.mbw*d.mbh)
+	for mby := range d.mbh {
+		d.leftModes = [4]uint8{}
+		d.leftNZ = [9]uint8{}
+		tokens := partitions[mby%len(partitions)]
+		for mbx := range d.mbw {
+			d.decodeMacroblock(br, tokens, mbx, mby)
+			if br.eof() || tokens.eof() {
+				return nil, errFormat
+			}
+		}
+	}
+	if d.filter {
+		d.loopFilter()
+	}
+	return d.image(), nil
+}
+
+// readHeader reads the frame header of section 9 and returns the token
+// partitions.
+func (d *vp8Decoder) readHeader(br *boolReader, rest []byte) ([]*boolReader, error) {
+	br.literal(2) // color space and clamping type
+
+	var segmentQuant, segmentLevel [4]int32
+	segments, absolute := br.flag(), true
+	if segments {
+		d.segmentMap = br.flag()
+		if br.flag() {
+			absolute = br.flag()
+			for i := range segmentQuant {
+				segmentQuant[i] = br.signed(7)
+			}
+			for i := range segmentLevel {
+				segmentLevel[i] = br.signed(6)
+			}
+		}
+		if d.segmentMap {
+			for i := range d.segmentProbs {
+				d.segmentProbs[i] = 255
+				if br.flag() {
+					d.segmentProbs[i] = uint8(br.literal(8))
+				}
+			}
+		}
+	}
+
+	d.simpleFilter = br.flag()
+	level := int32(br.literal(6))
+	sharpness := int32(br.literal(3))
+	// Only the deltas of intra frames and 4x4 prediction apply to key
+	// frames.
+	var refDelta, modeDelta int32
+	if br.flag() && br.flag() {
+		for i := range 4 {
+			if v := br.signed(6); i == 0 {
+				refDelta = v
+			}
+		}
+		for i := range 4 {
+			if v := br.signed(6); i == 0 {
+				modeDelta = v
+			}
+		}
+	}
+	numPartitions := 1 << br.literal(2)
+
+	q := int32(br.literal(7))
+	var deltas [5]int32 // Y DC, Y2 DC, Y2 AC, UV DC, UV AC
+	for i := range deltas {
+		deltas[i] = br.signed(4)
+	}
+	br.flag() // refresh the probabilities
+
+	d.probs = defaultCoeffProbs
+	for i := range d.probs {
+		for j := range d.probs[i] {
+			for k := range d.probs[i][j] {
+				for l := range d.probs[i][j][k] {
+					if br.get(coeffUpdateProbs[i][j][k][l]) {
+						d.probs[i][j][k][l] = uint8(br.literal(8))
+					}
+				}
+			}
+		}
+	}
+	if d.useSkip = br.flag(); d.useSkip {
+		d.skipProb = uint8(br.literal(8))
+	}
+	if br.eof() {
+		return nil, errFormat
+	}
+
+	for s := range d.quants {
+		sq, sl := q, level
+		if segments {
+			sq, sl = segmentQuant[s], segmentLevel[s]
+			if !absolute {
+				sq += q
+				sl += level
+			}
+		}
+		d.quants[s] = quantizer{
+			y1: [2]int32{dcQuant[quantIndex(sq+deltas[0])], acQuant[quantIndex(sq)]},
+			y2: [2]int32{dcQuant[quantIndex(sq+deltas[1])] * 2, max(acQuant[quantIndex(sq+deltas[2])]*155/100, 8)},
+			uv: [2]int32{dcQuant[min(quantIndex(sq+deltas[3]), 117)], acQuant[quantIndex(sq+deltas[4])]},
+		}
+		for i4x4 := range 2 {
+			l := sl + refDelta
+			if i4x4 == 1 {
+				l += modeDelta
+			}
+			d.strengths[s][i4x4] = filterStrength(int(min(max(l, 0), 63)), int(sharpness))
+		}
+	}
+	d.filter = level != 0
+
+	if len(rest) < 3*(numPartitions-1) {
+		return nil, errFormat
+	}
+	sizes := rest[:3*(numPartitions-1)]
+	rest = rest[3*(numPartitions-1):]
+	partitions := make([]*boolReader, 0, numPartitions)
+	for i := 0; i < len(sizes); i += 3 {
+		size := int(sizes[i]) | int(sizes[i+1])<<8 | int(sizes[i+2])<<16
+		if size > len(rest) {
+			return nil, errFormat
+		}
+		partitions = append(partitions, newBoolReader(rest[:size]))
+		rest = rest[size:]
+	}
+	return append(partitions, newBoolReader(rest)), nil
+}
+
+func quantIndex(q int32) int32 {
+	return min(max(q, 0), 127)
+}
+
+// filterStrength returns the thresholds of a loop filter level, section
+// 15.2.
+func filterStrength(level int, sharpness int) filterParams {
+	if level == 0 {
+		return filterParams{}
+	}
+	ilevel := level
+	if sharpness > 0 {
+		if sharpness > 4 {
+			ilevel >>= 2
+		} else {
+			ilevel >>= 1
+		}
+		ilevel = min(ilevel, 9-sharpness)
+	}
+	ilevel = max(ilevel, 1)
+	hevThresh := 0
+	switch {
+	case level >= 40:
+		hevThresh = 2
+	case level >= 15:
+		hevThresh = 1
+	}
+	return filterParams{limit: 2*level + ilevel, ilevel: ilevel, hevThresh: hevThresh}
+}
+
+// decodeMacroblock reads the modes of a macroblock from the first partition
+// and its tokens from tokens and reconstructs it.
+func (d *vp8Decoder) decodeMacroblock(br *boolReader, tokens *boolReader, mbx int, mby int) {
+	segment := 0
+	if d.segmentMap {
+		if !br.get(d.segmentProbs[0]) {
+			segment = btoi(br.get(d.segmentProbs[1]))
+		} else {
+			segment = 2 + btoi(br.get(d.segmentProbs[2]))
+		}
+	}
+	skip := d.useSkip && br.get(d.skipProb)
+
+	var modes [16]uint8
+	top := d.topModes[4*mbx : 4*mbx+4]
+	i4x4 := !br.get(145)
+	if i4x4 {
+		for y := range 4 {
+			for x := range 4 {
+				m := readBlockMode(br, &bModeProbs[top[x]][d.leftModes[y]])
+				modes[4*y+x], top[x], d.leftModes[y] = m, m, m
+			}
+		}
+	} else {
+		var m uint8
+		switch {
+		case !br.get(156):
+			m = predDC
+			if br.get(163) {
+				m = predVE
+			}
+		case !br.get(128):
+			m = predHE
+		default:
+			m = predTM
+		}
+		modes[0] = m
+		for i := range 4 {
+			top[i], d.leftModes[i] = m, m
+		}
+	}
+	var uvMode uint8
+	switch {
+	case !br.get(142):
+		uvMode = predDC
+	case !br.get(114):
+		uvMode = predVE
+	case !br.get(183):
+		uvMode = predHE
+	default:
+		uvMode = predTM
+	}
+
+	var coeffs [24][16]int32
+	nonZero := false
+	topNZ := &d.topNZ[mbx]
+	if skip {
+		// A skipped macroblock has no Y2 block to reset with 4x4
+		// prediction.
+		n := 8
+		if !i4x4 {
+			n = 9
+		}
+		for i := range n {
+			topNZ[i], d.leftNZ[i] = 0, 0
+		}
+	} else {
+		nonZero = d.readCoefficients(tokens, &coeffs, topNZ, i4x4, &d.quants[segment])
+	}
+	d.filters[mby*d.mbw+mbx] = mbFilter{d.strengths[segment][btoi(i4x4)], i4x4 || nonZero}
+
+	x0, y0 := 16*mbx, 16*mby
+	if i4x4 {
+		for i := range 16 {
+			d.predictLuma4(x0, y0, i%4, i/4, modes[i], &coeffs[i])
+		}
+	} else {
+		d.reconstruct(0, x0, y0, 16, modes[0], coeffs[:16])
+	}
+	d.reconstruct(1, x0/2, y0/2, 8, uvMode, coeffs[16:20])
+	d.reconstruct(2, x0/2, y0/2, 8, uvMode, coeffs[20:24])
+}
+
+// readBlockMode reads a 4x4 luma mode with the tree of section 11.2.
+func readBlockMode(br *boolReader, probs *[9]uint8) uint8 {
+	switch {
+	case !br.get(probs[0]):
+		return predDC
+	case !br.get(probs[1]):
+		return predTM
+	case !br.get(probs[2]):
+		return predVE
+	case !br.get(probs[3]):
+		if !br.get(probs[4]) {
+			return predHE
+		}
+		if !br.get(probs[5]) {
+			return predRD
+		}
+		return predVR
+	case !br.get(probs[6]):
+		return predLD
+	case !br.get(probs[7]):
+		return predVL
+	case !br.get(probs[8]):
+		return predHD
+	}
+	return predHU
+}
+
+// readCoefficients reads the dequantized coefficients of a macroblock in
+// raster order and returns whether any block has one that is not zero.
+func (d *vp8Decoder) readCoefficients(r *boolReader, coeffs *[24][16]int32, top *[9]uint8, i4x4 bool, q *quantizer) bool {
+	left := &d.leftNZ
+	plane, first := uint8(planeYWithDC), 0
+	if !i4x4 {
+		var dc [16]int32
+		n := d.readBlock(r, planeY2, top[8]+left[8], q.y2, 0, &dc)
+		top[8] = uint8(btoi(n > 0))
+		left[8] = top[8]
+		for i, v := range inverseWHT(dc) {
+			coeffs[i][0] = v
+		}
+		plane, first = planeYAfterY2, 1
+	}
+
+	nonZero := false
+	for y := range 4 {
+		for x := range 4 {
+			b := &coeffs[4*y+x]
+			n := d.readBlock(r, plane, top[x]+left[y], q.y1, first, b)
+			top[x] = uint8(btoi(n > first))
+			left[y] = top[x]
+			nonZero = nonZero || n > 1 || b[0] != 0
+		}
+	}
+	for c := range 2 {
+		for y := range 2 {
+			for x := range 2 {
+				b := &coeffs[16+4*c+2*y+x]
+				n := d.readBlock(r, planeUV, top[4+2*c+x]+left[4+2*c+y], q.uv, 0, b)
+				top[4+2*c+x] = uint8(btoi(n > 0))
+				left[4+2*c+y] = top[4+2*c+x]
+				nonZero = nonZero || n > 1 || b[0] != 0
+			}
+		}
+	}
+	return nonZero
+}
+
+// readBlock reads the tokens of a block from position n, section 13, and
+// returns the position after the last one.
+func (d *vp8Decoder) readBlock(r *boolReader, plane uint8, ctx uint8, dq [2]int32, n int, out *[16]int32) int {
+	probs := &d.probs[plane]
+	p := &probs[bands[n]][ctx]
+	for ; n < 16; n++ {
+		if !r.get(p[0]) {
+			return n
+		}
+		for !r.get(p[1]) {
+			if n++; n == 16 {
+				return 16
+			}
+			p = &probs[bands[n]][0]
+		}
+
+		var v int32
+		next := &probs[bands[n+1]]
+		if !r.get(p[2]) {
+			v, p = 1, &next[1]
+		} else {
+			v, p = readLevel(r, p), &next[2]
+		}
+		if r.flag() {
+			v = -v
+		}
+		out[zigzag[n]] = v * dq[min(n, 1)]
+	}
+	return 16
+}
+
+// readLevel reads a coefficient level above 1.
+func readLevel(r *boolReader, p *[11]uint8) int32 {
+	switch {
+	case !r.get(p[3]):
+		if !r.get(p[4]) {
+			return 2
+		}
+		return 3 + int32(btoi(r.get(p[5])))
+	case !r.get(p[6]):
+		if !r.get(p[7]) {
+			return 5 + int32(btoi(r.get(159)))
+		}
+		v := 7 + 2*int32(btoi(r.get(165)))
+		return v + int32(btoi(r.get(145)))
+	}
+	high := btoi(r.get(p[8]))
+	cat := 2*high + btoi(r.get(p[9+high]))
+	var extra int32
+	for _, prob := range catProbs[cat] {
+		extra = extra<<1 | int32(btoi(r.get(prob)))
+	}
+	return 3 + 8<<cat + extra
+}
+
+// reconstruct predicts the n x n block at x0, y0 of plane p with mode and
+// adds the residuals of its 4x4 blocks.
+func (d *vp8Decoder) reconstruct(p int, x0 int, y0 int, n int, mode uint8, coeffs [][16]int32) {
+	top, left, corner := edges(d.planes[p], d.stride[p], x0, y0, n)
+	pred := predictBlock(int(mode), top, left, corner, y0 > 0, x0 > 0)
+	for i := range coeffs {
+		bx, by := 4*(i%(n/4)), 4*(i/(n/4))
+		d.addResidual(p, x0+bx, y0+by, pred[by*n+bx:], n, &coeffs[i])
+	}
+}
+
+// addResidual stores the sum of pred and the inverse transform of coeffs at
+// x0, y0 of plane p. Rows of pred are stride values apart.
+func (d *vp8Decoder) addResidual(p int, x0 int, y0 int, pred []int32, stride int, coeffs *[16]int32) {
+	residual := inverseDCT(*coeffs)
+	for y := range 4 {
+		row := d.planes[p][(y0+y)*d.stride[p]+x0:]
+		for x := range 4 {
+			row[x] = uint8(min(max(pred[y*stride+x]+residual[4*y+x], 0), 255))
+		}
+	}
+}
+
+// predictLuma4 reconstructs the 4x4 luma block bx, by of the macroblock at
+// x0, y0. The blocks of the right column use the pixels above right of the
+// macroblock, section 12.3.
+func (d *vp8Decoder) predictLuma4(x0 int, y0 int, bx int, by int, mode uint8, coeffs *[16]int32) {
+	plane, stride := d.planes[0], d.stride[0]
+	x, y := x0+4*bx, y0+4*by
+	top4, left, corner := edges(plane, stride, x, y, 4)
+
+	var top [8]int32
+	copy(top[:], top4)
+	for i := 4; i < 8; i++ {
+		switch {
+		case y0 == 0 && (by == 0 || bx == 3):
+			top[i] = 127
+		case bx < 3:
+			top[i] = int32(plane[(y-1)*stride+x+i])
+		case x0+16 < stride:
+			top[i] = int32(plane[(y0-1)*stride+x0+12+i])
+		default:
+			top[i] = int32(plane[(y0-1)*stride+x0+15])
+		}
+	}
+
+	pred := predict4(mode, top, [4]int32(left), corner)
+	d.addResidual(0, x, y, pred[:], 4, coeffs)
+}
+
+func avg2(a int32, b int32) int32 {
+	return (a + b + 1) >> 1
+}
+
+func avg3(a int32, b int32, c int32) int32 {
+	return (a + 2*b + c + 2) >> 2
+}
+
+// predict4 returns the prediction of a 4x4 luma block, section 12.3. top
+// holds the pixels above and above right of the block.
+func predict4(mode uint8, top [8]int32, left [4]int32, corner int32) [16]int32 {
+	// e is the edge from the bottom left to the top right pixel.
+	e := [13]int32{left[3], left[2], left[1], left[0], corner}
+	copy(e[5:], top[:])
+
+	var pred [16]int32
+	switch mode {
+	case predDC:
+		dc := int32(4)
+		for i := range 4 {
+			dc += top[i] + left[i]
+		}
+		for i := range pred {
+			pred[i] = dc >> 3
+		}
+	case predTM:
+		for i := range pred {
+			pred[i] = min(max(left[i/4]+top[i%4]-corner, 0), 255)
+		}
+	case predVE:
+		for i := range pred {
+			x := i % 4
+			pred[i] = avg3(e[4+x], e[5+x], e[6+x])
+		}
+	case predHE:
+		for i := range pred {
+			y := i / 4
+			pred[i] = avg3(e[4-y], e[3-y], e[max(2-y, 0)])
+		}
+	case predRD:
+		for i := range pred {
+			c := 4 - i/4 + i%4
+			pred[i] = avg3(e[c-1], e[c], e[c+1])
+		}
+	case predVR:
+		for x := range 4 {
+			pred[x] = avg2(e[4+x], e[5+x])
+			pred[4+x] = avg3(e[3+x], e[4+x], e[5+x])
+		}
+		for y := 2; y < 4; y++ {
+			pred[4*y] = avg3(e[4-y], e[5-y], e[6-y])
+			copy(pred[4*y+1:4*y+4], pred[4*y-8:])
+		}
+	case predLD:
+		for i := range pred {
+			c := i/4 + i%4
+			pred[i] = avg3(top[c], top[c+1], top[min(c+2, 7)])
+		}
+	case predVL:
+		for x := range 4 {
+			pred[x] = avg2(top[x], top[x+1])
+			pred[4+x] = avg3(top[x], top[x+1], top[x+2])
+			pred[8+x] = avg2(top[x+1], top[x+2])
+			pred[12+x] = avg3(top[x+1], top[x+2], top[x+3])
+		}
+		pred[11] = avg3(top[4], top[5], top[6])
+		pred[15] = avg3(top[5], top[6], top[7])
+	case predHD:
+		for y := range 4 {
+			pred[4*y] = avg2(e[3-y], e[4-y])
+			pred[4*y+1] = avg3(e[3-y], e[4-y], e[5-y])
+			if y > 0 {
+				pred[4*y+2], pred[4*y+3] = pred[4*y-4], pred[4*y-3]
+			}
+		}
+		pred[2] = avg3(e[4], e[5], e[6])
+		pred[3] = avg3(e[5], e[6], e[7])
+	case predHU:
+		for i := range pred {
+			// Every row starts two steps further down the left edge.
+			j := 2*(i/4) + i%4
+			k := j / 2
+			switch {
+			case j >= 6:
+				pred[i] = left[3]
+			case j%2 == 0:
+				pred[i] = avg2(left[k], left[k+1])
+			default:
+				pred[i] = avg3(left[k], left[k+1], left[min(k+2, 3)])
+			}
+		}
+	}
+	return pred
+}
+
+// loopFilter filters the edges of every macroblock in raster order, section
+// 15. The simple filter only changes the luma plane.
+func (d *vp8Decoder) loopFilter() {
+	for mby := range d.mbh {
+		for mbx := range d.mbw {
+			f := d.filters[mby*d.mbw+mbx]
+			if f.limit == 0 {
+				continue
+			}
+			planes := 3
+			if d.simpleFilter {
+				planes = 1
+			}
+			for p := range planes {
+				pix, stride := d.planes[p], d.stride[p]
+				n := 16
+				if p > 0 {
+					n = 8
+				}
+				i := mby*n*stride + mbx*n
+				if mbx > 0 {
+					d.filterEdge(pix, i, 1, stride, n, f.filterParams, true)
+				}
+				if f.inner {
+					for x := 4; x < n; x += 4 {
+						d.filterEdge(pix, i+x, 1, stride, n, f.filterParams, false)
+					}
+				}
+				if mby > 0 {
+					d.filterEdge(pix, i, stride, 1, n, f.filterParams, true)
+				}
+				if f.inner {
+					for y := 4; y < n; y += 4 {
+						d.filterEdge(pix, i+y*stride, stride, 1, n, f.filterParams, false)
+					}
+				}
+			}
+		}
+	}
+}
+
+// filterEdge filters the n pixels of an edge that starts at i. step goes
+// across the edge and next along it.
+func (d *vp8Decoder) filterEdge(pix []uint8, i int, step int, next int, n int, f filterParams, mbEdge bool) {
+	limit := f.limit
+	if mbEdge {
+		limit += 4
+	}
+	limit = 2*limit + 1
+	for range n {
+		p1, p0 := int32(pix[i-2*step]), int32(pix[i-step])
+		q0, q1 := int32(pix[i]), int32(pix[i+step])
+		if 4*abs32(p0-q0)+abs32(p1-q1) > int32(limit) {
+			i += next
+			continue
+		}
+		if d.simpleFilter {
+			filterCommon(pix, i, step, true)
+			i += next
+			continue
+		}
+
+		p3, p2 := int32(pix[i-4*step]), int32(pix[i-3*step])
+		q2, q3 := int32(pix[i+2*step]), int32(pix[i+3*step])
+		il := int32(f.ilevel)
+		if abs32(p3-p2) > il || abs32(p2-p1) > il || abs32(p1-p0) > il ||
+			abs32(q3-q2) > il || abs32(q2-q1) > il || abs32(q1-q0) > il {
+			i += next
+			continue
+		}
+		hev := int32(f.hevThresh)
+		switch {
+		case abs32(p1-p0) > hev || abs32(q1-q0) > hev:
+			filterCommon(pix, i, step, true)
+		case mbEdge:
+			// Adjust three pixels on each side of the edge.
+			a := clampS8(3*(q0-p0) + clampS8(p1-q1))
+			a1 := (27*a + 63) >> 7
+			a2 := (18*a + 63) >> 7
+			a3 := (9*a + 63) >> 7
+			pix[i-3*step] = clampU8(p2 + a3)
+			pix[i-2*step] = clampU8(p1 + a2)
+			pix[i-step] = clampU8(p0 + a1)
+			pix[i] = clampU8(q0 - a1)
+			pix[i+step] = clampU8(q1 - a2)
+			pix[i+2*step] = clampU8(q2 - a3)
+		default:
+			a1 := filterCommon(pix, i, step, false)
+			a3 := (a1 + 1) >> 1
+			pix[i-2*step] = clampU8(p1 + a3)
+			pix[i+step] = clampU8(q1 - a3)
+		}
+		i += next
+	}
+}
+
+// filterCommon adjusts the two pixels next to the edge and returns the
+// adjustment of the first pixel after it. outer adds the outer pixels to
+// the filter value.
+func filterCommon(pix []uint8, i int, step int, outer bool) int32 {
+	p1, p0 := int32(pix[i-2*step]), int32(pix[i-step])
+	q0, q1 := int32(pix[i]), int32(pix[i+step])
+	a := 3 * (q0 - p0)
+	if outer {
+		a += clampS8(p1 - q1)
+	}
+	a1 := min(max((a+4)>>3, -16), 15)
+	a2 := min(max((a+3)>>3, -16), 15)
+	pix[i-step] = clampU8(p0 + a2)
+	pix[i] = clampU8(q0 - a1)
+	return a1
+}
+
+func clampS8(v int32) int32 {
+	return min(max(v, -128), 127)
+}
+
+func clampU8(v int32) uint8 {
+	return uint8(min(max(v, 0), 255))
+}
+
+// image converts the planes with the integer BT.601 conversion of libwebp.
+func (d *vp8Decoder) image() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, d.width, d.height))
+	for y := range d.height {
+		row := img.Pix[y*img.Stride:]
+		luma := d.planes[0][y*d.stride[0]:]
+		u := d.planes[1][y/2*d.stride[1]:]
+		v := d.planes[2][y/2*d.stride[2]:]
+		for x := range d.width {
+			l := multHi(int32(luma[x]), 19077)
+			cb, cr := int32(u[x/2]), int32(v[x/2])
+			row[4*x+0] = yuvClip(l + multHi(cr, 26149) - 14234)
+			row[4*x+1] = yuvClip(l - multHi(cb, 6419) - multHi(cr, 13320) + 8708)
+			row[4*x+2] = yuvClip(l + multHi(cb, 33050) - 17685)
+			row[4*x+3] = 0xff
+		}
+	}
+	return img
+}
+
+func multHi(v int32, coeff int32) int32 {
+	return v * coeff >> 8
+}
+
+// yuvClip drops the 6 fractional bits of a converted value.
+func yuvClip(v int32) uint8 {
+	return uint8(min(max(v>>6, 0), 255))
+}
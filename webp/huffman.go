@@ -0,0 +1,236 @@
+package webp
+
+import (
+	"container/heap"
+	"math/bits"
+	"slices"
+)
+
+// codeLengthOrder is the order of the code length code lengths.
+var codeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// prefixCode holds the canonical code of every symbol, bit reversed for the
+// least significant bit first writer.
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint16
+}
+
+func newPrefixCode(lengths []uint8) prefixCode {
+	var count [16]int
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [16]int
+	code := 0
+	for l := 1; l < 16; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+
+	codes := make([]uint16, len(lengths))
+	for s, l := range lengths {
+		if l != 0 {
+			codes[s] = uint16(bits.Reverse16(uint16(next[l])) >> (16 - l))
+			next[l]++
+		}
+	}
+	return prefixCode{lengths: lengths, codes: codes}
+}
+
+func (c prefixCode) write(w *bitWriter, s int) {
+	w.write(uint32(c.codes[s]), uint(c.lengths[s]))
+}
+
+// writePrefixCode writes the code of histogram and returns it. One or two
+// symbols below 256 use the short form.
+func writePrefixCode(w *bitWriter, histogram []uint32) prefixCode {
+	var used []int
+	for s, n := range histogram {
+		if n > 0 {
+			used = append(used, s)
+		}
+	}
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		if len(used) == 0 {
+			used = []int{0}
+		}
+		lengths := make([]uint8, len(histogram))
+		w.write(1, 1)
+		w.write(uint32(len(used)-1), 1)
+		if used[0] <= 1 {
+			w.write(0, 1)
+			w.write(uint32(used[0]), 1)
+		} else {
+			w.write(1, 1)
+			w.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			w.write(uint32(used[1]), 8)
+			lengths[used[0]], lengths[used[1]] = 1, 1
+		}
+		return newPrefixCode(lengths)
+	}
+
+	lengths := codeLengths(histogram, 15)
+	tokens := codeLengthTokens(lengths)
+	clHistogram := make([]uint32, len(codeLengthOrder))
+	for _, t := range tokens {
+		clHistogram[t.code]++
+	}
+	clLengths := codeLengths(clHistogram, 7)
+	n := len(codeLengthOrder)
+	for n > 4 && clLengths[codeLengthOrder[n-1]] == 0 {
+		n--
+	}
+
+	w.write(0, 1)
+	w.write(uint32(n-4), 4)
+	for _, s := range codeLengthOrder[:n] {
+		w.write(uint32(clLengths[s]), 3)
+	}
+	w.write(0, 1) // all symbols have a length
+	clCode := newPrefixCode(clLengths)
+	for _, t := range tokens {
+		clCode.write(w, t.code)
+		w.write(uint32(t.extra), t.extraBits)
+	}
+	return newPrefixCode(lengths)
+}
+
+type codeLengthToken struct {
+	code      int
+	extra     int
+	extraBits uint
+}
+
+// codeLengthTokens run length codes lengths. 16 repeats the previous non-zero
+// length, 17 and 18 write runs of zeros.
+func codeLengthTokens(lengths []uint8) []codeLengthToken {
+	var tokens []codeLengthToken
+	prev := uint8(8)
+	for i := 0; i < len(lengths); {
+		l := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == l {
+			run++
+		}
+		i += run
+
+		if l == 0 {
+			for run >= 11 {
+				n := min(run, 138)
+				tokens = append(tokens, codeLengthToken{18, n - 11, 7})
+				run -= n
+			}
+			if run >= 3 {
+				tokens = append(tokens, codeLengthToken{17, run - 3, 3})
+				run = 0
+			}
+		} else {
+			if l != prev {
+				tokens = append(tokens, codeLengthToken{code: int(l)})
+				prev = l
+				run--
+			}
+			for run >= 3 {
+				n := min(run, 6)
+				tokens = append(tokens, codeLengthToken{16, n - 3, 2})
+				run -= n
+			}
+		}
+		for range run {
+			tokens = append(tokens, codeLengthToken{code: int(l)})
+		}
+	}
+	return tokens
+}
+
+// codeLengths returns Huffman code lengths of at most maxLength bits. At
+// least two symbols get a length so that the code is complete.
+func codeLengths(histogram []uint32, maxLength int) []uint8 {
+	var symbols []int
+	for s, n := range histogram {
+		if n > 0 {
+			symbols = append(symbols, s)
+		}
+	}
+	switch {
+	case len(symbols) == 0:
+		symbols = []int{0, 1}
+	case len(symbols) == 1 && symbols[0] == 0:
+		symbols = append(symbols, 1)
+	case len(symbols) == 1:
+		symbols = []int{0, symbols[0]}
+	}
+
+	weights := make([]uint32, len(symbols))
+	for i, s := range symbols {
+		weights[i] = max(histogram[s], 1)
+	}
+	lengths := make([]uint8, len(histogram))
+	for {
+		depths := huffmanDepths(weights)
+		if slices.Max(depths) <= maxLength {
+			for i, s := range symbols {
+				lengths[s] = uint8(depths[i])
+			}
+			return lengths
+		}
+		// Flatten the distribution until the tree is shallow enough.
+		for i := range weights {
+			weights[i] = weights[i]/2 + 1
+		}
+	}
+}
+
+type huffmanNode struct {
+	weight uint64
+	id     int
+}
+
+type huffmanHeap []huffmanNode
+
+func (h huffmanHeap) Len() int { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool {
+	if h[i].weight != h[j].weight {
+		return h[i].weight < h[j].weight
+	}
+	return h[i].id < h[j].id
+}
+func (h huffmanHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x any)   { *h = append(*h, x.(huffmanNode)) }
+func (h *huffmanHeap) Pop() any {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// huffmanDepths returns the depth of every leaf of a Huffman tree over
+// weights.
+func huffmanDepths(weights []uint32) []int {
+	n := len(weights)
+	parent := make([]int, 2*n-1)
+	h := make(huffmanHeap, n)
+	for i, w := range weights {
+		h[i] = huffmanNode{uint64(w), i}
+	}
+	heap.Init(&h)
+	for next := n; h.Len() > 1; next++ {
+		a := heap.Pop(&h).(huffmanNode)
+		b := heap.Pop(&h).(huffmanNode)
+		parent[a.id], parent[b.id] = next, next
+		heap.Push(&h, huffmanNode{a.weight + b.weight, next})
+	}
+
+	depths := make([]int, n)
+	root := 2*n - 2
+	for i := range depths {
+		for j := i; j != root; j = parent[j] {
+			depths[i]++
+		}
+	}
+	return depths
+}
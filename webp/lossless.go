@@ -0,0 +1,412 @@
+package webp
+
+import (
+	"image"
+	"math/bits"
+)
+
+// The lossless encoder applies the subtract green and predictor transforms
+// and codes the result with LZ77 and one group of prefix codes, see RFC 9649.
+
+const (
+	transformPredictor     = 0
+	transformCrossColor    = 1
+	transformSubtractGreen = 2
+	transformColorIndexing = 3
+
+	// predictorBits is the log2 of the predictor tile size.
+	predictorBits = 4
+
+	numLiteralCodes = 256
+	numLengthCodes  = 24
+	numDistCodes    = 40
+	maxMatchLength  = 4096
+	// maxMatchDist keeps the distance codes below 40 prefix codes.
+	maxMatchDist = 1<<20 - 120
+
+	hashBits       = 16
+	maxChainLength = 64
+)
+
+// bitWriter writes the least significant bit first.
+type bitWriter struct {
+	buf   []byte
+	bits  uint64
+	nbits uint
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	w.bits |= uint64(v) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits >>= 8
+		w.nbits -= 8
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits, w.nbits = 0, 0
+	}
+	return w.buf
+}
+
+// encodeLossless returns the VP8L chunk of img.
+func encodeLossless(img *image.NRGBA) []byte {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	argb := make([]uint32, 0, width*height)
+	for y := range height {
+		row := img.Pix[y*img.Stride:]
+		for x := range width {
+			p := row[4*x : 4*x+4]
+			argb = append(argb, uint32(p[3])<<24|uint32(p[0])<<16|uint32(p[1])<<8|uint32(p[2]))
+		}
+	}
+
+	var w bitWriter
+	w.write(0x2f, 8)
+	w.write(uint32(width-1), 14)
+	w.write(uint32(height-1), 14)
+	if opaque(img) {
+		w.write(0, 1)
+	} else {
+		w.write(1, 1)
+	}
+	w.write(0, 3) // version
+	writeImageStream(&w, argb, width, height)
+	return w.bytes()
+}
+
+// encodeAlpha returns the ALPH chunk of img: the alpha values in the green
+// channel of a lossless image stream without header.
+func encodeAlpha(img *image.NRGBA) []byte {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	argb := make([]uint32, 0, width*height)
+	for y := range height {
+		row := img.Pix[y*img.Stride:]
+		for x := range width {
+			argb = append(argb, 0xff000000|uint32(row[4*x+3])<<8)
+		}
+	}
+
+	var w bitWriter
+	w.write(1, 8) // no preprocessing or filtering, lossless compression
+	writeImageStream(&w, argb, width, height)
+	return w.bytes()
+}
+
+func writeImageStream(w *bitWriter, argb []uint32, width int, height int) {
+	w.write(1, 1)
+	w.write(transformSubtractGreen, 2)
+	for i, p := range argb {
+		g := p >> 8 & 0xff
+		argb[i] = p&0xff00ff00 | (p&0x00ff0000-g<<16)&0x00ff0000 | (p-g)&0xff
+	}
+
+	w.write(1, 1)
+	w.write(transformPredictor, 2)
+	w.write(predictorBits-2, 3)
+	modes, tilesX := predict(argb, width, height)
+	writeEntropyImage(w, modes, tilesX, false)
+
+	w.write(0, 1)
+	writeEntropyImage(w, argb, width, true)
+}
+
+// predict replaces argb with the residuals of the best predictor of every
+// tile and returns the predictor image.
+func predict(argb []uint32, width int, height int) ([]uint32, int) {
+	const tile = 1 << predictorBits
+	tilesX := (width + tile - 1) / tile
+	tilesY := (height + tile - 1) / tile
+	modes := make([]uint32, tilesX*tilesY)
+	residuals := make([]uint32, len(argb))
+
+	for ty := range tilesY {
+		for tx := range tilesX {
+			bestMode, bestCost := 0, -1
+			for mode := range 14 {
+				cost := 0
+				for y := ty * tile; y < min(ty*tile+tile, height); y++ {
+					for x := tx * tile; x < min(tx*tile+tile, width); x++ {
+						r := subPixels(argb[y*width+x], predictPixel(argb, width, x, y, mode))
+						cost += residualCost(r)
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					bestMode, bestCost = mode, cost
+				}
+			}
+			modes[ty*tilesX+tx] = 0xff000000 | uint32(bestMode)<<8
+			for y := ty * tile; y < min(ty*tile+tile, height); y++ {
+				for x := tx * tile; x < min(tx*tile+tile, width); x++ {
+					residuals[y*width+x] = subPixels(argb[y*width+x], predictPixel(argb, width, x, y, bestMode))
+				}
+			}
+		}
+	}
+	copy(argb, residuals)
+	return modes, tilesX
+}
+
+func residualCost(p uint32) int {
+	cost := 0
+	for shift := 0; shift < 32; shift += 8 {
+		v := int(p >> shift & 0xff)
+		cost += min(v, 256-v)
+	}
+	return cost
+}
+
+// predictPixel returns the prediction of mode for the pixel at x, y. The
+// first row and column have fixed predictors.
+func predictPixel(argb []uint32, width int, x int, y int, mode int) uint32 {
+	i := y*width + x
+	switch {
+	case x == 0 && y == 0:
+		return 0xff000000
+	case y == 0:
+		return argb[i-1]
+	case x == 0:
+		return argb[i-width]
+	}
+
+	// The top right pixel of the last column is the first pixel of the
+	// current row.
+	l, t, tl, tr := argb[i-1], argb[i-width], argb[i-width-1], argb[i-width+1]
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return l
+	case 2:
+		return t
+	case 3:
+		return tr
+	case 4:
+		return tl
+	case 5:
+		return average2(average2(l, tr), t)
+	case 6:
+		return average2(l, tl)
+	case 7:
+		return average2(l, t)
+	case 8:
+		return average2(tl, t)
+	case 9:
+		return average2(t, tr)
+	case 10:
+		return average2(average2(l, tl), average2(t, tr))
+	case 11:
+		return selectPixel(l, t, tl)
+	case 12:
+		return clampAddSubtractFull(l, t, tl)
+	default:
+		return clampAddSubtractHalf(average2(l, t), tl)
+	}
+}
+
+func average2(a uint32, b uint32) uint32 {
+	return ((a^b)&0xfefefefe)>>1 + a&b
+}
+
+func subPixels(a uint32, b uint32) uint32 {
+	alphaGreen := 0x00ff00ff + a&0xff00ff00 - b&0xff00ff00
+	redBlue := 0xff00ff00 + a&0x00ff00ff - b&0x00ff00ff
+	return alphaGreen&0xff00ff00 | redBlue&0x00ff00ff
+}
+
+func channel(p uint32, shift int) int {
+	return int(p >> shift & 0xff)
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func clamp255(v int) uint32 {
+	return uint32(min(max(v, 0), 255))
+}
+
+func selectPixel(l uint32, t uint32, tl uint32) uint32 {
+	pl, pt := 0, 0
+	for shift := 0; shift < 32; shift += 8 {
+		p := channel(l, shift) + channel(t, shift) - channel(tl, shift)
+		pl += abs(p - channel(l, shift))
+		pt += abs(p - channel(t, shift))
+	}
+	if pl < pt {
+		return l
+	}
+	return t
+}
+
+func clampAddSubtractFull(a uint32, b uint32, c uint32) uint32 {
+	var p uint32
+	for shift := 0; shift < 32; shift += 8 {
+		p |= clamp255(channel(a, shift)+channel(b, shift)-channel(c, shift)) << shift
+	}
+	return p
+}
+
+func clampAddSubtractHalf(a uint32, b uint32) uint32 {
+	var p uint32
+	for shift := 0; shift < 32; shift += 8 {
+		p |= clamp255(channel(a, shift)+(channel(a, shift)-channel(b, shift))/2) << shift
+	}
+	return p
+}
+
+// symbol is a literal pixel or, with a length, a backward reference.
+type symbol struct {
+	argb   uint32
+	length int
+	dist   int
+}
+
+// writeEntropyImage codes argb with one group of prefix codes. Only the main
+// image has the meta prefix bit.
+func writeEntropyImage(w *bitWriter, argb []uint32, width int, main bool) {
+	w.write(0, 1) // no color cache
+	if main {
+		w.write(0, 1) // no meta prefix codes
+	}
+
+	symbols := backwardReferences(argb, width)
+	var (
+		green = make([]uint32, numLiteralCodes+numLengthCodes)
+		red   = make([]uint32, numLiteralCodes)
+		blue  = make([]uint32, numLiteralCodes)
+		alpha = make([]uint32, numLiteralCodes)
+		dist  = make([]uint32, numDistCodes)
+	)
+	for i, s := range symbols {
+		if s.length == 0 {
+			green[s.argb>>8&0xff]++
+			red[s.argb>>16&0xff]++
+			blue[s.argb&0xff]++
+			alpha[s.argb>>24]++
+			continue
+		}
+		code, _, _ := prefixEncode(s.length)
+		green[numLiteralCodes+code]++
+		symbols[i].dist = distanceCode(s.dist, width)
+		code, _, _ = prefixEncode(symbols[i].dist)
+		dist[code]++
+	}
+
+	greenCode := writePrefixCode(w, green)
+	redCode := writePrefixCode(w, red)
+	blueCode := writePrefixCode(w, blue)
+	alphaCode := writePrefixCode(w, alpha)
+	distCode := writePrefixCode(w, dist)
+
+	for _, s := range symbols {
+		if s.length == 0 {
+			greenCode.write(w, int(s.argb>>8&0xff))
+			redCode.write(w, int(s.argb>>16&0xff))
+			blueCode.write(w, int(s.argb&0xff))
+			alphaCode.write(w, int(s.argb>>24))
+			continue
+		}
+		code, n, extra := prefixEncode(s.length)
+		greenCode.write(w, numLiteralCodes+code)
+		w.write(uint32(extra), n)
+		code, n, extra = prefixEncode(s.dist)
+		distCode.write(w, code)
+		w.write(uint32(extra), n)
+	}
+}
+
+// prefixEncode splits a length or distance code into the prefix symbol and
+// its extra bits.
+func prefixEncode(v int) (int, uint, int) {
+	v--
+	if v < 4 {
+		return v, 0, 0
+	}
+	high := bits.Len(uint(v)) - 1
+	n := uint(high - 1)
+	return 2*high + (v>>n)&1, n, v & (1<<n - 1)
+}
+
+// distanceCode maps the pixel above and the pixel to the left to their short
+// codes. Other distances come after the 120 neighbourhood codes.
+func distanceCode(dist int, width int) int {
+	switch dist {
+	case width:
+		return 1
+	case 1:
+		return 2
+	}
+	return dist + 120
+}
+
+// backwardReferences greedily finds repeated pixel runs with hash chains.
+func backwardReferences(argb []uint32, width int) []symbol {
+	n := len(argb)
+	head := make([]int32, 1<<hashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, n)
+	hash := func(i int) uint32 {
+		h := argb[i]*0x1e35a7bd ^ argb[i+1]*0x9e3779b1 ^ argb[i+2]*0x85ebca6b
+		return h >> (32 - hashBits)
+	}
+	insert := func(i int) {
+		if i+2 < n {
+			h := hash(i)
+			prev[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+	matchLength := func(i int, j int) int {
+		limit := min(n-i, maxMatchLength)
+		l := 0
+		for l < limit && argb[i+l] == argb[j+l] {
+			l++
+		}
+		return l
+	}
+
+	symbols := make([]symbol, 0, n)
+	for i := 0; i < n; {
+		bestLength, bestDist := 0, 0
+		for _, dist := range []int{1, width} {
+			if dist <= i {
+				if l := matchLength(i, i-dist); l > bestLength {
+					bestLength, bestDist = l, dist
+				}
+			}
+		}
+		if i+2 < n {
+			j := head[hash(i)]
+			for steps := 0; j >= 0 && steps < maxChainLength && i-int(j) <= maxMatchDist; steps++ {
+				// Near references cost fewer extra bits, so they win ties.
+				if l := matchLength(i, int(j)); l > bestLength {
+					bestLength, bestDist = l, i-int(j)
+				}
+				j = prev[j]
+			}
+		}
+
+		if bestLength < 3 {
+			symbols = append(symbols, symbol{argb: argb[i]})
+			insert(i)
+			i++
+			continue
+		}
+		symbols = append(symbols, symbol{length: bestLength, dist: bestDist})
+		for k := range bestLength {
+			insert(i + k)
+		}
+		i += bestLength
+	}
+	return symbols
+}
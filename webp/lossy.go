@@ -0,0 +1,843 @@
+package webp
+
+import (
+	"encoding/binary"
+	"image"
+	"math"
+)
+
+// The lossy encoder writes key frames as specified in RFC 6386. Every
+// macroblock uses one of the four 16x16 luma and 8x8 chroma predictors, the
+// token probabilities are updated when that saves bits.
+
+const (
+	predDC = iota
+	predTM
+	predVE
+	predHE
+	predRD
+	predVR
+	predLD
+	predVL
+	predHD
+	predHU
+)
+
+const (
+	planeYAfterY2 = iota
+	planeY2
+	planeUV
+	planeYWithDC
+)
+
+var (
+	// bands maps coefficient positions to probability bands.
+	bands = [17]uint8{0, 1, 2, 3, 6, 4, 5, 6, 6, 6, 6, 6, 6, 6, 6, 7, 0}
+	// zigzag maps token positions to raster positions of a 4x4 block.
+	zigzag = [16]uint8{0, 1, 4, 8, 5, 2, 3, 6, 9, 12, 13, 10, 7, 11, 14, 15}
+	// catProbs are the probabilities of the extra bits of the categories 3
+	// to 6.
+	catProbs = [4][]uint8{
+		{173, 148, 140},
+		{176, 155, 140, 135},
+		{180, 157, 141, 134, 130},
+		{254, 254, 243, 230, 196, 177, 153, 140, 133, 130, 129},
+	}
+)
+
+// maxLevel is the largest quantized coefficient a token can hold.
+const maxLevel = 2048
+
+// coeffBlock is a 4x4 block of quantized coefficients in token order.
+type coeffBlock struct {
+	plane  uint8
+	ctx    uint8
+	first  int
+	levels [16]int32
+}
+
+func (b *coeffBlock) nonZero() uint8 {
+	for _, l := range b.levels[b.first:] {
+		if l != 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+type quantizer struct {
+	y1, y2, uv [2]int32 // DC and AC step sizes
+}
+
+func newQuantizer(q int) quantizer {
+	return quantizer{
+		y1: [2]int32{dcQuant[q], acQuant[q]},
+		y2: [2]int32{dcQuant[q] * 2, max(acQuant[q]*155/100, 8)},
+		uv: [2]int32{dcQuant[min(q, 117)], acQuant[q]},
+	}
+}
+
+// quantize returns the level of c and its reconstructed value. AC levels are
+// rounded towards zero a bit more, which saves bits on small coefficients.
+func quantize(c int32, step int32, ac bool) (int32, int32) {
+	bias := step / 2
+	if ac {
+		bias = step * 3 / 8
+	}
+	level := (abs32(c) + bias) / step
+	level = min(level, maxLevel)
+	if c < 0 {
+		level = -level
+	}
+	return level, level * step
+}
+
+func abs32(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+type vp8Encoder struct {
+	width, height int
+	mbw, mbh      int
+	q             int
+	quant         quantizer
+
+	// Source and reconstructed planes, padded to whole macroblocks.
+	src, rec [3][]uint8
+	stride   [3]int
+
+	yModes, uvModes []uint8
+	skips           []bool
+	blocks          []coeffBlock
+
+	// Non-zero flags of the blocks above and left of the macroblock: 4 luma,
+	// 2 U, 2 V and the Y2 block.
+	topNZ  [][9]uint8
+	leftNZ [9]uint8
+}
+
+// encodeLossy returns the VP8 chunk of img.
+func encodeLossy(img *image.NRGBA, quality int) []byte {
+	e := &vp8Encoder{
+		width:  img.Rect.Dx(),
+		height: img.Rect.Dy(),
+	}
+	e.mbw = (e.width + 15) / 16
+	e.mbh = (e.height + 15) / 16
+	e.q = (100 - quality) * 127 / 100
+	e.quant = newQuantizer(e.q)
+	e.stride = [3]int{16 * e.mbw, 8 * e.mbw, 8 * e.mbw}
+	for p := range 3 {
+		rows := 16 * e.mbh
+		if p > 0 {
+			rows = 8 * e.mbh
+		}
+		e.src[p] = make([]uint8, e.stride[p]*rows)
+		e.rec[p] = make([]uint8, e.stride[p]*rows)
+	}
+	e.convert(img)
+	e.topNZ = make([][9]uint8, e.mbw)
+
+	for mby := range e.mbh {
+		e.leftNZ = [9]uint8{}
+		for mbx := range e.mbw {
+			e.encodeMacroblock(mbx, mby)
+		}
+	}
+	return e.frame()
+}
+
+// convert fills the source planes with the limited range BT.601 values that
+// libwebp uses. Transparent pixels take the mean color of the visible pixels
+// of their macroblock, which keeps sharp edges out of the transforms.
+func (e *vp8Encoder) convert(img *image.NRGBA) {
+	rgb := make([][3]int32, 16*e.mbw*16*e.mbh)
+	w := 16 * e.mbw
+	for mby := range e.mbh {
+		for mbx := range e.mbw {
+			var sum [3]int32
+			var n int32
+			for y := 16 * mby; y < min(16*mby+16, e.height); y++ {
+				for x := 16 * mbx; x < min(16*mbx+16, e.width); x++ {
+					p := img.Pix[y*img.Stride+4*x:]
+					if p[3] != 0 {
+						sum[0] += int32(p[0])
+						sum[1] += int32(p[1])
+						sum[2] += int32(p[2])
+						n++
+					}
+				}
+			}
+			if n > 0 {
+				sum[0], sum[1], sum[2] = sum[0]/n, sum[1]/n, sum[2]/n
+			}
+			for y := 16 * mby; y < 16*mby+16; y++ {
+				for x := 16 * mbx; x < 16*mbx+16; x++ {
+					// The padding repeats the last row and column.
+					sx, sy := min(x, e.width-1), min(y, e.height-1)
+					p := img.Pix[sy*img.Stride+4*sx:]
+					if p[3] == 0 {
+						rgb[y*w+x] = sum
+					} else {
+						rgb[y*w+x] = [3]int32{int32(p[0]), int32(p[1]), int32(p[2])}
+					}
+				}
+			}
+		}
+	}
+
+	for y := range 16 * e.mbh {
+		for x := range w {
+			c := rgb[y*w+x]
+			e.src[0][y*e.stride[0]+x] = uint8((16839*c[0] + 33059*c[1] + 6420*c[2] + 16<<16 + 1<<15) >> 16)
+		}
+	}
+	for y := range 8 * e.mbh {
+		for x := range 8 * e.mbw {
+			var r, g, b int32
+			for _, c := range [][3]int32{rgb[2*y*w+2*x], rgb[2*y*w+2*x+1], rgb[(2*y+1)*w+2*x], rgb[(2*y+1)*w+2*x+1]} {
+				r, g, b = r+c[0], g+c[1], b+c[2]
+			}
+			e.src[1][y*e.stride[1]+x] = uint8((-9719*r - 19081*g + 28800*b + 128<<18 + 1<<17) >> 18)
+			e.src[2][y*e.stride[2]+x] = uint8((28800*r - 24116*g - 4684*b + 128<<18 + 1<<17) >> 18)
+		}
+	}
+}
+
+// edges returns the reconstructed pixels above, left of and above left of
+// the n x n block at x0, y0 of a plane, with the values the decoder uses
+// outside of the image.
+func edges(rec []uint8, stride int, x0 int, y0 int, n int) ([]int32, []int32, int32) {
+	top := make([]int32, n)
+	left := make([]int32, n)
+	for i := range n {
+		top[i], left[i] = 127, 129
+		if y0 > 0 {
+			top[i] = int32(rec[(y0-1)*stride+x0+i])
+		}
+		if x0 > 0 {
+			left[i] = int32(rec[(y0+i)*stride+x0-1])
+		}
+	}
+	var corner int32
+	switch {
+	case y0 == 0:
+		corner = 127
+	case x0 == 0:
+		corner = 129
+	default:
+		corner = int32(rec[(y0-1)*stride+x0-1])
+	}
+	return top, left, corner
+}
+
+// predictBlock returns the n x n prediction of mode.
+func predictBlock(mode int, top []int32, left []int32, corner int32, hasTop bool, hasLeft bool) []int32 {
+	n := len(top)
+	pred := make([]int32, n*n)
+	var dc int32
+	if mode == predDC {
+		var sum int32
+		switch {
+		case hasTop && hasLeft:
+			for i := range n {
+				sum += top[i] + left[i]
+			}
+			dc = (sum + int32(n)) / int32(2*n)
+		case hasTop:
+			for i := range n {
+				sum += top[i]
+			}
+			dc = (sum + int32(n/2)) / int32(n)
+		case hasLeft:
+			for i := range n {
+				sum += left[i]
+			}
+			dc = (sum + int32(n/2)) / int32(n)
+		default:
+			dc = 128
+		}
+	}
+	for y := range n {
+		for x := range n {
+			var v int32
+			switch mode {
+			case predDC:
+				v = dc
+			case predTM:
+				v = min(max(left[y]+top[x]-corner, 0), 255)
+			case predVE:
+				v = top[x]
+			case predHE:
+				v = left[y]
+			}
+			pred[y*n+x] = v
+		}
+	}
+	return pred
+}
+
+// choosePrediction returns the mode with the smallest squared error over the
+// planes and its predictions.
+func (e *vp8Encoder) choosePrediction(planes []int, x0 int, y0 int, n int) (int, [][]int32) {
+	bestMode, bestErr := 0, int64(-1)
+	var best [][]int32
+	for mode := range 4 {
+		var preds [][]int32
+		var sse int64
+		for _, p := range planes {
+			top, left, corner := edges(e.rec[p], e.stride[p], x0, y0, n)
+			pred := predictBlock(mode, top, left, corner, y0 > 0, x0 > 0)
+			for y := range n {
+				for x := range n {
+					d := int64(e.src[p][(y0+y)*e.stride[p]+x0+x]) - int64(pred[y*n+x])
+					sse += d * d
+				}
+			}
+			preds = append(preds, pred)
+		}
+		if bestErr < 0 || sse < bestErr {
+			bestMode, bestErr, best = mode, sse, preds
+		}
+	}
+	return bestMode, best
+}
+
+func (e *vp8Encoder) encodeMacroblock(mbx int, mby int) {
+	x0, y0 := 16*mbx, 16*mby
+	yMode, yPred := e.choosePrediction([]int{0}, x0, y0, 16)
+	uvMode, uvPred := e.choosePrediction([]int{1, 2}, x0/2, y0/2, 8)
+
+	// Luma: the DC coefficients of the 16 blocks go through the WHT.
+	var coeffs [16][16]int32
+	var dcs [16]int32
+	for n := range 16 {
+		bx, by := 4*(n%4), 4*(n/4)
+		var residual [16]int32
+		for y := range 4 {
+			for x := range 4 {
+				residual[4*y+x] = int32(e.src[0][(y0+by+y)*e.stride[0]+x0+bx+x]) - yPred[0][(by+y)*16+bx+x]
+			}
+		}
+		coeffs[n] = forwardDCT(residual)
+		dcs[n] = coeffs[n][0]
+	}
+	y2 := coeffBlock{plane: planeY2}
+	var y2Rec [16]int32
+	for i, c := range forwardWHT(dcs) {
+		level, rec := quantize(c, e.quant.y2[min(i, 1)], i > 0)
+		y2.levels[inverseZigzag[i]] = level
+		y2Rec[i] = rec
+	}
+	dcRec := inverseWHT(y2Rec)
+
+	var yBlocks [16]coeffBlock
+	for n := range 16 {
+		b := coeffBlock{plane: planeYAfterY2, first: 1}
+		rec := [16]int32{dcRec[n]}
+		for i := 1; i < 16; i++ {
+			level, r := quantize(coeffs[n][i], e.quant.y1[1], true)
+			b.levels[inverseZigzag[i]] = level
+			rec[i] = r
+		}
+		yBlocks[n] = b
+		bx, by := 4*(n%4), 4*(n/4)
+		e.reconstruct(0, x0+bx, y0+by, yPred[0], 16, bx, by, rec)
+	}
+
+	var uvBlocks [8]coeffBlock
+	for p := 1; p <= 2; p++ {
+		for n := range 4 {
+			bx, by := 4*(n%2), 4*(n/2)
+			var residual [16]int32
+			for y := range 4 {
+				for x := range 4 {
+					residual[4*y+x] = int32(e.src[p][(y0/2+by+y)*e.stride[p]+x0/2+bx+x]) - uvPred[p-1][(by+y)*8+bx+x]
+				}
+			}
+			b := coeffBlock{plane: planeUV}
+			var rec [16]int32
+			for i, c := range forwardDCT(residual) {
+				level, r := quantize(c, e.quant.uv[min(i, 1)], i > 0)
+				b.levels[inverseZigzag[i]] = level
+				rec[i] = r
+			}
+			uvBlocks[4*(p-1)+n] = b
+			e.reconstruct(p, x0/2+bx, y0/2+by, uvPred[p-1], 8, bx, by, rec)
+		}
+	}
+
+	e.yModes = append(e.yModes, uint8(yMode))
+	e.uvModes = append(e.uvModes, uint8(uvMode))
+	skip := y2.nonZero() == 0
+	for _, b := range yBlocks {
+		skip = skip && b.nonZero() == 0
+	}
+	for _, b := range uvBlocks {
+		skip = skip && b.nonZero() == 0
+	}
+	e.skips = append(e.skips, skip)
+	if skip {
+		e.topNZ[mbx] = [9]uint8{}
+		e.leftNZ = [9]uint8{}
+		return
+	}
+
+	// The contexts count the non-zero neighbours, in the order the decoder
+	// reads the blocks.
+	top, left := &e.topNZ[mbx], &e.leftNZ
+	y2.ctx = top[8] + left[8]
+	top[8] = y2.nonZero()
+	left[8] = top[8]
+	e.blocks = append(e.blocks, y2)
+	for n, b := range yBlocks {
+		x, y := n%4, n/4
+		b.ctx = top[x] + left[y]
+		top[x] = b.nonZero()
+		left[y] = top[x]
+		e.blocks = append(e.blocks, b)
+	}
+	for n, b := range uvBlocks {
+		// U uses the entries 4 and 5, V 6 and 7.
+		x, y := 4+2*(n/4)+n%2, 4+2*(n/4)+(n%4)/2
+		b.ctx = top[x] + left[y]
+		top[x] = b.nonZero()
+		left[y] = top[x]
+		e.blocks = append(e.blocks, b)
+	}
+}
+
+// reconstruct adds the inverse transform of coeffs to the prediction of the
+// 4x4 block at bx, by of an n x n prediction and stores it at x0, y0.
+func (e *vp8Encoder) reconstruct(p int, x0 int, y0 int, pred []int32, n int, bx int, by int, coeffs [16]int32) {
+	residual := inverseDCT(coeffs)
+	for y := range 4 {
+		for x := range 4 {
+			v := pred[(by+y)*n+bx+x] + residual[4*y+x]
+			e.rec[p][(y0+y)*e.stride[p]+x0+x] = uint8(min(max(v, 0), 255))
+		}
+	}
+}
+
+var inverseZigzag = func() [16]uint8 {
+	var inv [16]uint8
+	for i, z := range zigzag {
+		inv[z] = uint8(i)
+	}
+	return inv
+}()
+
+// forwardDCT transforms a block of residuals. The scaling matches
+// inverseDCT, a constant block v gives the DC coefficient 8v.
+func forwardDCT(in [16]int32) [16]int32 {
+	var tmp, out [16]int32
+	for i := range 4 {
+		r := in[4*i : 4*i+4]
+		a := (r[0] + r[3]) * 8
+		b := (r[1] + r[2]) * 8
+		c := (r[1] - r[2]) * 8
+		d := (r[0] - r[3]) * 8
+		tmp[4*i+0] = a + b
+		tmp[4*i+2] = a - b
+		tmp[4*i+1] = (c*2217 + d*5352 + 14500) >> 12
+		tmp[4*i+3] = (d*2217 - c*5352 + 7500) >> 12
+	}
+	for i := range 4 {
+		a := tmp[i] + tmp[12+i]
+		b := tmp[4+i] + tmp[8+i]
+		c := tmp[4+i] - tmp[8+i]
+		d := tmp[i] - tmp[12+i]
+		out[i] = (a + b + 7) >> 4
+		out[8+i] = (a - b + 7) >> 4
+		out[4+i] = (c*2217 + d*5352 + 12000) >> 16
+		if d != 0 {
+			out[4+i]++
+		}
+		out[12+i] = (d*2217 - c*5352 + 51000) >> 16
+	}
+	return out
+}
+
+// inverseDCT is the inverse transform of the decoder, section 14.3.
+func inverseDCT(in [16]int32) [16]int32 {
+	const (
+		c1 = 85627 // 65536 * cos(pi/8) * sqrt(2)
+		c2 = 35468 // 65536 * sin(pi/8) * sqrt(2)
+	)
+	var tmp [4][4]int32
+	for i := range 4 {
+		a := in[i] + in[8+i]
+		b := in[i] - in[8+i]
+		c := (in[4+i]*c2)>>16 - (in[12+i]*c1)>>16
+		d := (in[4+i]*c1)>>16 + (in[12+i]*c2)>>16
+		tmp[i] = [4]int32{a + d, b + c, b - c, a - d}
+	}
+	var out [16]int32
+	for j := range 4 {
+		dc := tmp[0][j] + 4
+		a := dc + tmp[2][j]
+		b := dc - tmp[2][j]
+		c := (tmp[1][j]*c2)>>16 - (tmp[3][j]*c1)>>16
+		d := (tmp[1][j]*c1)>>16 + (tmp[3][j]*c2)>>16
+		out[4*j+0] = (a + d) >> 3
+		out[4*j+1] = (b + c) >> 3
+		out[4*j+2] = (b - c) >> 3
+		out[4*j+3] = (a - d) >> 3
+	}
+	return out
+}
+
+// hadamard4 multiplies a vector with the symmetric 4x4 Hadamard matrix of
+// the WHT.
+func hadamard4(a int32, b int32, c int32, d int32) (int32, int32, int32, int32) {
+	return a + b + c + d, a + b - c - d, a - b - c + d, a - b + c - d
+}
+
+// forwardWHT transforms the luma DC coefficients. The decoder divides by 8
+// and the matrix squares to 4, so the forward transform divides by 2.
+func forwardWHT(in [16]int32) [16]int32 {
+	var tmp, out [16]int32
+	for i := range 4 {
+		tmp[i], tmp[4+i], tmp[8+i], tmp[12+i] = hadamard4(in[i], in[4+i], in[8+i], in[12+i])
+	}
+	for i := range 4 {
+		a, b, c, d := hadamard4(tmp[4*i], tmp[4*i+1], tmp[4*i+2], tmp[4*i+3])
+		for j, v := range [4]int32{a, b, c, d} {
+			// Round half away from zero.
+			if v < 0 {
+				v--
+			} else {
+				v++
+			}
+			out[4*i+j] = v / 2
+		}
+	}
+	return out
+}
+
+// inverseWHT is the inverse transform of the decoder, section 14.3.
+func inverseWHT(in [16]int32) [16]int32 {
+	var tmp, out [16]int32
+	for i := range 4 {
+		a0 := in[i] + in[12+i]
+		a1 := in[4+i] + in[8+i]
+		a2 := in[4+i] - in[8+i]
+		a3 := in[i] - in[12+i]
+		tmp[i] = a0 + a1
+		tmp[8+i] = a0 - a1
+		tmp[4+i] = a3 + a2
+		tmp[12+i] = a3 - a2
+	}
+	for i := range 4 {
+		dc := tmp[4*i] + 3
+		a0 := dc + tmp[4*i+3]
+		a1 := tmp[4*i+1] + tmp[4*i+2]
+		a2 := tmp[4*i+1] - tmp[4*i+2]
+		a3 := dc - tmp[4*i+3]
+		out[4*i+0] = (a0 + a1) >> 3
+		out[4*i+1] = (a3 + a2) >> 3
+		out[4*i+2] = (a0 - a1) >> 3
+		out[4*i+3] = (a3 - a2) >> 3
+	}
+	return out
+}
+
+// tokenWriter writes the tokens of blocks, or only counts the branches of
+// the token probabilities if bw is nil.
+type tokenWriter struct {
+	bw     *boolWriter
+	probs  *[4][8][3][11]uint8
+	counts *[4][8][3][11][2]uint32
+}
+
+func (t *tokenWriter) put(plane uint8, band uint8, ctx uint8, i int, bit bool) {
+	if t.bw == nil {
+		t.counts[plane][band][ctx][i][btoi(bit)]++
+		return
+	}
+	t.bw.put(bit, t.probs[plane][band][ctx][i])
+}
+
+func (t *tokenWriter) putRaw(bit bool, prob uint8) {
+	if t.bw != nil {
+		t.bw.put(bit, prob)
+	}
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// block writes the tokens of b, section 13.
+func (t *tokenWriter) block(b *coeffBlock) {
+	last := -1
+	for i := b.first; i < 16; i++ {
+		if b.levels[i] != 0 {
+			last = i
+		}
+	}
+	n := b.first
+	band, ctx := bands[n], b.ctx
+	t.put(b.plane, band, ctx, 0, last >= 0)
+	if last < 0 {
+		return
+	}
+	for n < 16 {
+		level := b.levels[n]
+		v := abs32(level)
+		n++
+		if v == 0 {
+			t.put(b.plane, band, ctx, 1, false)
+			band, ctx = bands[n], 0
+			continue
+		}
+		t.put(b.plane, band, ctx, 1, true)
+		if v == 1 {
+			t.put(b.plane, band, ctx, 2, false)
+			band, ctx = bands[n], 1
+		} else {
+			t.put(b.plane, band, ctx, 2, true)
+			switch {
+			case v <= 4:
+				t.put(b.plane, band, ctx, 3, false)
+				t.put(b.plane, band, ctx, 4, v > 2)
+				if v > 2 {
+					t.put(b.plane, band, ctx, 5, v == 4)
+				}
+			case v <= 10:
+				t.put(b.plane, band, ctx, 3, true)
+				t.put(b.plane, band, ctx, 6, false)
+				t.put(b.plane, band, ctx, 7, v > 6)
+				if v <= 6 {
+					t.putRaw(v == 6, 159)
+				} else {
+					t.putRaw((v-7)&2 != 0, 165)
+					t.putRaw((v-7)&1 != 0, 145)
+				}
+			default:
+				t.put(b.plane, band, ctx, 3, true)
+				t.put(b.plane, band, ctx, 6, true)
+				cat := 0
+				for cat < 3 && v >= 3+(8<<(cat+1)) {
+					cat++
+				}
+				t.put(b.plane, band, ctx, 8, cat >= 2)
+				t.put(b.plane, band, ctx, 9+cat/2, cat&1 != 0)
+				extra := v - (3 + 8<<cat)
+				probs := catProbs[cat]
+				for i, p := range probs {
+					t.putRaw(extra>>(len(probs)-1-i)&1 != 0, p)
+				}
+			}
+			band, ctx = bands[n], 2
+		}
+		t.putRaw(level < 0, 128)
+		if n == 16 {
+			return
+		}
+		t.put(b.plane, band, ctx, 0, n <= last)
+		if n > last {
+			return
+		}
+	}
+}
+
+// frame returns the VP8 key frame of the encoded macroblocks.
+func (e *vp8Encoder) frame() []byte {
+	// Count the branches with the default probabilities and update the ones
+	// where the new probability and its update cost less.
+	probs := defaultCoeffProbs
+	var counts [4][8][3][11][2]uint32
+	counter := tokenWriter{probs: &probs, counts: &counts}
+	for i := range e.blocks {
+		counter.block(&e.blocks[i])
+	}
+
+	var first boolWriter
+	first.putLiteral(0, 1) // color space
+	first.putLiteral(0, 1) // clamping type
+	first.putLiteral(0, 1) // no segmentation
+	first.putLiteral(0, 1) // normal loop filter
+	first.putLiteral(uint32(e.q/4), 6)
+	first.putLiteral(0, 3) // sharpness
+	first.putLiteral(0, 1) // no loop filter deltas
+	first.putLiteral(0, 2) // one token partition
+	first.putLiteral(uint32(e.q), 7)
+	for range 5 {
+		first.putLiteral(0, 1) // no quantizer deltas
+	}
+	first.putLiteral(0, 1) // do not keep the probabilities
+	for i := range probs {
+		for j := range probs[i] {
+			for k := range probs[i][j] {
+				for l := range probs[i][j][k] {
+					updateProb := coeffUpdateProbs[i][j][k][l]
+					c := counts[i][j][k][l]
+					p := probs[i][j][k][l]
+					newP := uint8(255)
+					if c[0]+c[1] > 0 {
+						newP = uint8(min(max((uint64(c[0])*256+uint64(c[0]+c[1])/2)/uint64(c[0]+c[1]), 1), 255))
+					}
+					oldCost := branchCost(c, p) + bitCost(false, updateProb)
+					newCost := branchCost(c, newP) + bitCost(true, updateProb) + 8
+					if newCost < oldCost {
+						first.put(true, updateProb)
+						first.putLiteral(uint32(newP), 8)
+						probs[i][j][k][l] = newP
+					} else {
+						first.put(false, updateProb)
+					}
+				}
+			}
+		}
+	}
+
+	skipped := 0
+	for _, skip := range e.skips {
+		if skip {
+			skipped++
+		}
+	}
+	skipProb := uint8(0)
+	if skipped > 0 {
+		skipProb = uint8(min(max((len(e.skips)-skipped)*256/len(e.skips), 1), 254))
+		first.putLiteral(1, 1)
+		first.putLiteral(uint32(skipProb), 8)
+	} else {
+		first.putLiteral(0, 1)
+	}
+
+	for i := range e.skips {
+		if skipProb != 0 {
+			first.put(e.skips[i], skipProb)
+		}
+		first.put(true, 145) // 16x16 luma prediction
+		switch e.yModes[i] {
+		case predDC:
+			first.put(false, 156)
+			first.put(false, 163)
+		case predVE:
+			first.put(false, 156)
+			first.put(true, 163)
+		case predHE:
+			first.put(true, 156)
+			first.put(false, 128)
+		case predTM:
+			first.put(true, 156)
+			first.put(true, 128)
+		}
+		switch e.uvModes[i] {
+		case predDC:
+			first.put(false, 142)
+		case predVE:
+			first.put(true, 142)
+			first.put(false, 114)
+		case predHE:
+			first.put(true, 142)
+			first.put(true, 114)
+			first.put(false, 183)
+		case predTM:
+			first.put(true, 142)
+			first.put(true, 114)
+			first.put(true, 183)
+		}
+	}
+
+	var tokens boolWriter
+	writer := tokenWriter{bw: &tokens, probs: &probs}
+	for i := range e.blocks {
+		writer.block(&e.blocks[i])
+	}
+
+	firstPartition := first.bytes()
+	out := make([]byte, 10, 10+len(firstPartition)+len(tokens.buf)+4)
+	tag := uint32(len(firstPartition))<<5 | 1<<4 // key frame, version 0, shown
+	out[0], out[1], out[2] = byte(tag), byte(tag>>8), byte(tag>>16)
+	out[3], out[4], out[5] = 0x9d, 0x01, 0x2a
+	binary.LittleEndian.PutUint16(out[6:], uint16(e.width))
+	binary.LittleEndian.PutUint16(out[8:], uint16(e.height))
+	out = append(out, firstPartition...)
+	return append(out, tokens.bytes()...)
+}
+
+// bitCost returns the bits bit costs with the probability prob of false.
+func bitCost(bit bool, prob uint8) float64 {
+	p := float64(prob) / 256
+	if bit {
+		p = 1 - p
+	}
+	return -math.Log2(p)
+}
+
+func branchCost(c [2]uint32, prob uint8) float64 {
+	return float64(c[0])*bitCost(false, prob) + float64(c[1])*bitCost(true, prob)
+}
+
+// boolWriter is the boolean entropy encoder of section 7.
+type boolWriter struct {
+	buf      []byte
+	rng      uint32
+	bottom   uint32
+	bitCount int
+}
+
+func (w *boolWriter) put(bit bool, prob uint8) {
+	if w.rng == 0 {
+		w.rng, w.bitCount = 255, 24
+	}
+	split := 1 + (w.rng-1)*uint32(prob)>>8
+	if bit {
+		w.bottom += split
+		w.rng -= split
+	} else {
+		w.rng = split
+	}
+	for w.rng < 128 {
+		w.rng <<= 1
+		if w.bottom&(1<<31) != 0 {
+			w.carry()
+		}
+		w.bottom <<= 1
+		w.bitCount--
+		if w.bitCount == 0 {
+			w.buf = append(w.buf, byte(w.bottom>>24))
+			w.bottom &= 1<<24 - 1
+			w.bitCount = 8
+		}
+	}
+}
+
+// carry propagates a carry into the bytes already written.
+func (w *boolWriter) carry() {
+	i := len(w.buf) - 1
+	for ; i >= 0 && w.buf[i] == 0xff; i-- {
+		w.buf[i] = 0
+	}
+	if i >= 0 {
+		w.buf[i]++
+	}
+}
+
+// putLiteral writes the n bits of v, most significant bit first.
+func (w *boolWriter) putLiteral(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.put(v>>i&1 != 0, 128)
+	}
+}
+
+func (w *boolWriter) bytes() []byte {
+	if w.rng == 0 {
+		w.rng, w.bitCount = 255, 24
+	}
+	// Pad with enough bits to push out the bottom value.
+	for range 32 {
+		w.put(false, 128)
+	}
+	return w.buf
+}
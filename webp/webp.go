@@ -0,0 +1,145 @@
+// Package webp encodes and decodes images in the WebP format without cgo.
+// Quality 100 writes lossless VP8L files. Lower qualities write lossy VP8
+// files with the alpha channel stored losslessly in an ALPH chunk. Decode
+// reads both kinds of still images and registers the format with the image
+// package.
+package webp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+)
+
+// MaxSize is the largest width and height of a WebP image.
+const MaxSize = 1<<14 - 1
+
+// Options are the encoding options. Quality goes from 0 to 100, 100 being
+// lossless.
+type Options struct {
+	Quality int
+}
+
+// Encode writes m to w. A nil o encodes losslessly.
+func Encode(w io.Writer, m image.Image, o *Options) error {
+	quality := 100
+	if o != nil {
+		quality = o.Quality
+	}
+	if quality < 0 || quality > 100 {
+		return fmt.Errorf("webp: quality %d is not between 0 and 100", quality)
+	}
+
+	b := m.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 || b.Dx() > MaxSize || b.Dy() > MaxSize {
+		return fmt.Errorf("webp: invalid image size %dx%d", b.Dx(), b.Dy())
+	}
+	img, ok := m.(*image.NRGBA)
+	if !ok || img.Rect.Min != (image.Point{}) {
+		img = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(img, img.Rect, m, b.Min, draw.Src)
+	}
+
+	var chunks []chunk
+	if quality == 100 {
+		chunks = append(chunks, chunk{"VP8L", encodeLossless(img)})
+	} else {
+		if !opaque(img) {
+			header := image.Point{b.Dx() - 1, b.Dy() - 1}
+			vp8x := make([]byte, 10)
+			vp8x[0] = 0x10 // alpha
+			putUint24(vp8x[4:], header.X)
+			putUint24(vp8x[7:], header.Y)
+			chunks = append(chunks, chunk{"VP8X", vp8x}, chunk{"ALPH", encodeAlpha(img)})
+		}
+		chunks = append(chunks, chunk{"VP8 ", encodeLossy(img, quality)})
+	}
+	return writeRIFF(w, chunks)
+}
+
+type chunk struct {
+	id   string
+	data []byte
+}
+
+func writeRIFF(w io.Writer, chunks []chunk) error {
+	size := 4
+	for _, c := range chunks {
+		size += 8 + len(c.data) + len(c.data)&1
+	}
+	out := make([]byte, 0, 8+size)
+	out = append(out, "RIFF"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(size))
+	out = append(out, "WEBP"...)
+	for _, c := range chunks {
+		out = append(out, c.id...)
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(c.data)))
+		out = append(out, c.data...)
+		if len(c.data)&1 != 0 {
+			out = append(out, 0)
+		}
+	}
+	_, err := w.Write(out)
+	return err
+}
+
+func putUint24(b []byte, v int) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
+
+func opaque(img *image.NRGBA) bool {
+	for y := range img.Rect.Dy() {
+		row := img.Pix[y*img.Stride : y*img.Stride+4*img.Rect.Dx()]
+		for i := 3; i < len(row); i += 4 {
+			if row[i] != 0xff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+var errFormat = errors.New("webp: invalid format")
+
+// DecodeConfig returns the size of a WebP image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	var header [30]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return image.Config{}, errFormat
+		}
+		return image.Config{}, err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:12]) != "WEBP" {
+		return image.Config{}, errFormat
+	}
+	data := header[20:]
+	var width, height int
+	switch string(header[12:16]) {
+	case "VP8 ":
+		if data[3] != 0x9d || data[4] != 0x01 || data[5] != 0x2a {
+			return image.Config{}, errFormat
+		}
+		width = int(binary.LittleEndian.Uint16(data[6:]) & 0x3fff)
+		height = int(binary.LittleEndian.Uint16(data[8:]) & 0x3fff)
+	case "VP8L":
+		if data[0] != 0x2f {
+			return image.Config{}, errFormat
+		}
+		bits := binary.LittleEndian.Uint32(data[1:])
+		width = int(bits&0x3fff) + 1
+		height = int(bits>>14&0x3fff) + 1
+	case "VP8X":
+		width = (int(data[4]) | int(data[5])<<8 | int(data[6])<<16) + 1
+		height = (int(data[7]) | int(data[8])<<8 | int(data[9])<<16) + 1
+	default:
+		return image.Config{}, errFormat
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: width, Height: height}, nil
+}
@@ -0,0 +1,193 @@
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func testImage(width int, height int, alpha bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			a := uint8(0xff)
+			if alpha && (x+y)%3 == 0 {
+				a = uint8(x * 7)
+			}
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 4), uint8(y * 3), uint8(x ^ y), a})
+		}
+	}
+	return img
+}
+
+// chunkIDs returns the chunks of a RIFF file after checking its size.
+func chunkIDs(t *testing.T, data []byte) []string {
+	t.Helper()
+	if string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		t.Fatalf("missing RIFF header: % x", data[:12])
+	}
+	if size := binary.LittleEndian.Uint32(data[4:]); int(size) != len(data)-8 {
+		t.Fatalf("RIFF size %d, file has %d bytes", size, len(data))
+	}
+	var ids []string
+	for rest := data[12:]; len(rest) > 0; {
+		size := int(binary.LittleEndian.Uint32(rest[4:]))
+		ids = append(ids, string(rest[:4]))
+		rest = rest[8+size+size&1:]
+	}
+	return ids
+}
+
+func TestEncode(t *testing.T) {
+	for _, tc := range []struct {
+		width, height int
+		alpha         bool
+		quality       int
+		chunks        []string
+		// meanError is the largest mean absolute error of the color
+		// channels after decoding. Alpha is always lossless.
+		meanError float64
+	}{
+		{1, 1, false, 100, []string{"VP8L"}, 0},
+		{37, 20, true, 100, []string{"VP8L"}, 0},
+		{1, 1, false, 75, []string{"VP8 "}, 4},
+		{37, 20, false, 0, []string{"VP8 "}, 7},
+		{37, 20, false, 75, []string{"VP8 "}, 4},
+		{37, 20, true, 90, []string{"VP8X", "ALPH", "VP8 "}, 3},
+	} {
+		img := testImage(tc.width, tc.height, tc.alpha)
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, &Options{Quality: tc.quality}); err != nil {
+			t.Fatal(err)
+		}
+		if got := chunkIDs(t, buf.Bytes()); !slices.Equal(got, tc.chunks) {
+			t.Errorf("%+v: chunks %q", tc, got)
+		}
+		config, err := DecodeConfig(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Width != tc.width || config.Height != tc.height {
+			t.Errorf("%+v: size %dx%d", tc, config.Width, config.Height)
+		}
+
+		m, format, err := image.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, ok := m.(*image.NRGBA)
+		if format != "webp" || !ok || decoded.Rect != img.Rect {
+			t.Fatalf("%+v: decoded %s %T %v", tc, format, m, m.Bounds())
+		}
+		sum := 0
+		for i, v := range decoded.Pix {
+			d := abs(int(v) - int(img.Pix[i]))
+			if i%4 == 3 && d != 0 {
+				t.Fatalf("%+v: alpha of pixel %d is %d, want %d", tc, i/4, v, img.Pix[i])
+			}
+			sum += d
+		}
+		mean := float64(sum) / float64(3*tc.width*tc.height)
+		if mean > tc.meanError || tc.quality == 100 && sum != 0 {
+			t.Errorf("%+v: mean error %.2f", tc, mean)
+		}
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, testImage(4, 4, false), &Options{Quality: 101}); err == nil {
+		t.Error("quality 101 was accepted")
+	}
+	if err := Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 0, 3)), nil); err == nil {
+		t.Error("empty image was accepted")
+	}
+	if _, err := DecodeConfig(bytes.NewReader([]byte("RIFF\x00\x00\x00\x00WEBP"))); err == nil {
+		t.Error("truncated file was accepted")
+	}
+	for _, quality := range []int{100, 50} {
+		buf.Reset()
+		// Cut the only chunk in half.
+		Encode(&buf, testImage(16, 16, false), &Options{Quality: quality})
+		file := buf.Bytes()[:20+(buf.Len()-20)/2]
+		binary.LittleEndian.PutUint32(file[4:], uint32(len(file)-8))
+		binary.LittleEndian.PutUint32(file[16:], uint32(len(file)-20))
+		if _, err := Decode(bytes.NewReader(file)); err == nil {
+			t.Errorf("quality %d: truncated file was decoded", quality)
+		}
+	}
+}
+
+func TestCodeLengths(t *testing.T) {
+	// Fibonacci weights make the deepest possible Huffman tree.
+	histogram := make([]uint32, 40)
+	a, b := uint32(1), uint32(1)
+	for i := range histogram {
+		histogram[i] = a
+		a, b = b, a+b
+	}
+	for _, maxLength := range []int{7, 15} {
+		lengths := codeLengths(histogram, maxLength)
+		kraft := 0
+		for _, l := range lengths {
+			if l == 0 || int(l) > maxLength {
+				t.Fatalf("max %d: length %d", maxLength, l)
+			}
+			kraft += 1 << (maxLength - int(l))
+		}
+		if kraft != 1<<maxLength {
+			t.Errorf("max %d: incomplete code", maxLength)
+		}
+	}
+}
+
+func TestBoolWriter(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	bits := make([]bool, 10000)
+	probs := make([]uint8, len(bits))
+	for i := range bits {
+		probs[i] = uint8(1 + rng.Intn(255))
+		bits[i] = rng.Intn(256) >= int(probs[i])
+	}
+
+	var w boolWriter
+	for i, bit := range bits {
+		w.put(bit, probs[i])
+	}
+	r := newBoolReader(w.bytes())
+	for i, bit := range bits {
+		if r.get(probs[i]) != bit {
+			t.Fatalf("bit %d differs", i)
+		}
+	}
+}
+
+func FuzzDecodeConfig(f *testing.F) {
+	for _, quality := range []int{100, 50} {
+		var buf bytes.Buffer
+		Encode(&buf, testImage(5, 3, true), &Options{Quality: quality})
+		f.Add(buf.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, file []byte) {
+		DecodeConfig(bytes.NewReader(file))
+	})
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, quality := range []int{100, 50} {
+		var buf bytes.Buffer
+		Encode(&buf, testImage(5, 3, true), &Options{Quality: quality})
+		f.Add(buf.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, file []byte) {
+		config, err := DecodeConfig(bytes.NewReader(file))
+		if err != nil || config.Width*config.Height > 1<<20 {
+			return
+		}
+		Decode(bytes.NewReader(file))
+	})
+}